@@ -0,0 +1,101 @@
+package blogging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/perrito666/chat2world/config"
+	"github.com/perrito666/chat2world/im"
+)
+
+// AccountsFlow lets users see which platforms they are connected to, with /accounts, and disconnect from them, with
+// /disconnect <platform>. Each command is answered right away, the flow finishes as soon as it starts.
+type AccountsFlow struct {
+	platforms map[config.AvailableBloggingPlatform]AuthedPlatform
+	logger    *slog.Logger
+}
+
+var _ im.Flow = (*AccountsFlow)(nil)
+
+// NewAccountsFlow creates an AccountsFlow for the platforms of a user.
+func NewAccountsFlow(platforms map[config.AvailableBloggingPlatform]AuthedPlatform) *AccountsFlow {
+	return &AccountsFlow{
+		platforms: platforms,
+		logger:    slog.Default(),
+	}
+}
+
+// StartCommandParser implements im.Flow and will do a simple split.
+func (a *AccountsFlow) StartCommandParser(s string) (string, []string, error) {
+	parts := strings.Fields(s)
+	if len(parts) < 1 {
+		return "", nil, im.ErrNotACommand
+	}
+	return parts[0], parts[1:], nil
+}
+
+// Start implements im.Flow, it answers /accounts or /disconnect and finishes.
+func (a *AccountsFlow) Start(ctx context.Context, message *im.Message, messenger im.Messenger) error {
+	command, args, err := message.AsCommand(a.StartCommandParser)
+	if err != nil {
+		return fmt.Errorf("parsing accounts command (%s): %w", message.Text, err)
+	}
+	var response string
+	switch command {
+	case "/disconnect":
+		response = a.disconnect(message.UserID, args)
+	default:
+		response = a.describe(message.UserID)
+	}
+	if err := messenger.SendMessage(ctx, message.Reply(response)); err != nil {
+		return fmt.Errorf("messenger send message err: %w", err)
+	}
+	return im.ErrFlowFinished
+}
+
+// HandleMessage implements im.Flow, the flow is over once started so there is nothing left to handle.
+func (a *AccountsFlow) HandleMessage(context.Context, *im.Message, im.Messenger) error {
+	return im.ErrFlowFinished
+}
+
+// describe lists the platforms of the user and whether they are connected to each.
+func (a *AccountsFlow) describe(userID uint64) string {
+	if len(a.platforms) == 0 {
+		return "No platforms available."
+	}
+	var sb strings.Builder
+	sb.WriteString("Accounts:\n")
+	for _, pname := range sortedNames(a.platforms) {
+		status := "not connected"
+		if a.platforms[pname].IsAuthorized(UserID(userID)) {
+			status = "connected"
+		}
+		fmt.Fprintf(&sb, "  %s: %s\n", pname, status)
+	}
+	sb.WriteString("Use /disconnect <platform> to forget an account.")
+	return sb.String()
+}
+
+// disconnect forgets what the platform named in args stores for the user.
+func (a *AccountsFlow) disconnect(userID uint64, args []string) string {
+	if len(args) != 1 {
+		return "Usage: /disconnect <platform>, see /accounts for your platforms."
+	}
+	pname := config.AvailableBloggingPlatform(args[0])
+	platform, ok := a.platforms[pname]
+	if !ok {
+		return fmt.Sprintf("Unknown platform %s, see /accounts for your platforms.", pname)
+	}
+	forgetter, ok := platform.(Forgetter)
+	if !ok {
+		return fmt.Sprintf("%s can't be disconnected.", pname)
+	}
+	if err := forgetter.Forget(UserID(userID)); err != nil {
+		a.logger.Error("disconnecting", "user_id", userID, "platform", pname, "err", err)
+		return fmt.Sprintf("Could not disconnect %s: %v", pname, err)
+	}
+	a.logger.Info("disconnected", "user_id", userID, "platform", pname)
+	return fmt.Sprintf("Disconnected from %s, what was stored to post there was deleted.", pname)
+}
@@ -0,0 +1,72 @@
+package blogging
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/perrito666/chat2world/config"
+	"github.com/perrito666/chat2world/im"
+)
+
+// forgettingPlatform is a fakePlatform that is authorized until it is told to forget the user.
+type forgettingPlatform struct {
+	fakePlatform
+	forgotten bool
+}
+
+func (f *forgettingPlatform) IsAuthorized(UserID) bool {
+	return !f.forgotten
+}
+
+func (f *forgettingPlatform) Forget(UserID) error {
+	f.forgotten = true
+	return nil
+}
+
+// runAccounts starts an AccountsFlow for platforms with text and returns its answer.
+func runAccounts(t *testing.T, platforms map[config.AvailableBloggingPlatform]AuthedPlatform, text string) string {
+	t.Helper()
+	messenger := &recordingMessenger{}
+	err := NewAccountsFlow(platforms).Start(context.Background(), &im.Message{UserID: testUser, Text: text}, messenger)
+	if !errors.Is(err, im.ErrFlowFinished) {
+		t.Fatalf("Start(%q) = %v, want ErrFlowFinished", text, err)
+	}
+	return messenger.last()
+}
+
+func TestAccountsListsTheConnectionOfEachPlatform(t *testing.T) {
+	platforms := map[config.AvailableBloggingPlatform]AuthedPlatform{
+		config.MBPMastodon: &forgettingPlatform{},
+		config.MBPBsky:     &forgettingPlatform{forgotten: true},
+	}
+	want := "Accounts:\n  bluesky: not connected\n  mastodon: connected\n" +
+		"Use /disconnect <platform> to forget an account."
+	if got := runAccounts(t, platforms, "/accounts"); got != want {
+		t.Errorf("/accounts answered %q, want %q", got, want)
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	mastodon := &forgettingPlatform{}
+	platforms := map[config.AvailableBloggingPlatform]AuthedPlatform{
+		config.MBPMastodon: mastodon,
+		// fakePlatform can't forget.
+		config.MBPBsky: &fakePlatform{},
+	}
+	for _, tc := range []struct {
+		text, want string
+	}{
+		{"/disconnect", "Usage: /disconnect <platform>, see /accounts for your platforms."},
+		{"/disconnect myspace", "Unknown platform myspace, see /accounts for your platforms."},
+		{"/disconnect bluesky", "bluesky can't be disconnected."},
+		{"/disconnect mastodon", "Disconnected from mastodon, what was stored to post there was deleted."},
+	} {
+		if got := runAccounts(t, platforms, tc.text); got != tc.want {
+			t.Errorf("%s answered %q, want %q", tc.text, got, tc.want)
+		}
+	}
+	if !mastodon.forgotten {
+		t.Error("mastodon did not forget the user")
+	}
+}
@@ -0,0 +1,45 @@
+package blogging
+
+import (
+	"context"
+	"strings"
+
+	"github.com/perrito666/chat2world/im"
+)
+
+// AltTextProvider describes images, i.e. with a vision model, it is asked for the alt text of the images users send
+// without a caption. chat2world ships none, see WithAltTextProvider.
+type AltTextProvider interface {
+	Describe(ctx context.Context, image []byte) (string, error)
+}
+
+// WithAltTextProvider makes the flow fill the alt text of images sent without a caption with what provider describes,
+// without one they have no alt text until set with /alt.
+func WithAltTextProvider(provider AltTextProvider) PostingFlowOption {
+	return func(p *PostingFlow) {
+		p.altTexts = provider
+	}
+}
+
+// describeUncaptioned returns the alt text of each of images, their caption or, for the ones without it, what the
+// AltTextProvider describes. Failing to describe one leaves it without alt text, the user can still set it.
+func (p *PostingFlow) describeUncaptioned(ctx context.Context, userID uint64, images []*im.Image) (altTexts []string,
+	described []int) {
+	altTexts = make([]string, len(images))
+	for i, img := range images {
+		altTexts[i] = img.Caption
+		if p.altTexts == nil || strings.TrimSpace(img.Caption) != "" {
+			continue
+		}
+		altText, err := p.altTexts.Describe(ctx, img.Data)
+		if err != nil {
+			p.logger.Warn("describing image", "user_id", userID, "image", i, "err", err)
+			continue
+		}
+		if altText = strings.TrimSpace(altText); altText != "" {
+			altTexts[i] = altText
+			described = append(described, i)
+		}
+	}
+	return altTexts, described
+}
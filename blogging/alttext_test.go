@@ -0,0 +1,54 @@
+package blogging
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/perrito666/chat2world/config"
+	"github.com/perrito666/chat2world/im"
+)
+
+// stubDescriber describes every image as "a picture of " followed by its data, failing on images of "noise".
+type stubDescriber struct {
+	asked int
+}
+
+func (s *stubDescriber) Describe(_ context.Context, image []byte) (string, error) {
+	s.asked++
+	if string(image) == "noise" {
+		return "", errors.New("nothing to see")
+	}
+	return "a picture of " + string(image), nil
+}
+
+func TestUncaptionedImagesAreDescribed(t *testing.T) {
+	describer := &stubDescriber{}
+	p := NewPostingFlow(map[config.AvailableBloggingPlatform]AuthedPlatform{config.MBPMastodon: &fakePlatform{}}, nil,
+		WithAltTextProvider(describer), WithLogger(discardLogger))
+	messenger := &recordingMessenger{}
+	say(t, p, messenger, "/new")
+	if err := p.HandleMessage(context.Background(), &im.Message{UserID: testUser, Images: []*im.Image{
+		{Data: []byte("cat")},
+		{Data: []byte("dog"), Caption: "my dog"},
+		{Data: []byte("noise")},
+	}}, messenger); err != nil {
+		t.Fatalf("adding images: %v", err)
+	}
+
+	var altTexts []string
+	for _, img := range p.posts[testUser].Images {
+		altTexts = append(altTexts, img.AltText)
+	}
+	// captions win and images that could not be described are left for /alt.
+	if want := []string{"a picture of cat", "my dog", ""}; strings.Join(altTexts, "|") != strings.Join(want, "|") {
+		t.Errorf("alt texts = %q, want %q", altTexts, want)
+	}
+	if describer.asked != 2 {
+		t.Errorf("asked for %d descriptions, want 2, the captioned image needs none", describer.asked)
+	}
+	if !strings.Contains(messenger.last(), "Alt text was generated for 1 images without caption") {
+		t.Errorf("answered %q, want it to say alt text was generated", messenger.last())
+	}
+}
@@ -24,3 +24,26 @@ func NewAuthorization() *Authorization {
 func (a *Authorization) RegisterAuthorizationMechanism(name string, auth Authorizer) {
 	a.registeredAuthorizationMechanisms[name] = auth
 }
+
+// AuthNotifier tells the user something about an authorization outside of its questions and answers, i.e. that it
+// finished on its own once the user authorized us in the browser.
+type AuthNotifier func(text string)
+
+// authNotifierCtx is the context key under which the AuthNotifier of an authorization travels.
+type authNotifierCtx struct{}
+
+// WithAuthNotifier returns a context that lets the authorizations started with it reach the user through notify.
+func WithAuthNotifier(ctx context.Context, notify AuthNotifier) context.Context {
+	return context.WithValue(ctx, authNotifierCtx{}, notify)
+}
+
+// NotifyAuth tells the user text through the AuthNotifier of ctx, it returns false if there is none, authorizations
+// then have to say it through their channel.
+func NotifyAuth(ctx context.Context, text string) bool {
+	notify, ok := ctx.Value(authNotifierCtx{}).(AuthNotifier)
+	if !ok {
+		return false
+	}
+	notify(text)
+	return true
+}
@@ -3,7 +3,8 @@ package blogging
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"slices"
 	"strings"
 
 	"github.com/perrito666/chat2world/im"
@@ -14,6 +15,25 @@ import (
 type AuthorizerFlow struct {
 	authorizer        Authorizer
 	authorizationChan chan string
+	// cancel ends the ongoing authorization, if any.
+	cancel context.CancelFunc
+	// cancelCommands abandon the authorization when sent during it.
+	cancelCommands []string
+	logger         *slog.Logger
+}
+
+// DefaultAuthCancelCommands are the commands that abandon an authorization unless WithCancelCommands says otherwise.
+var DefaultAuthCancelCommands = []string{"/cancel"}
+
+// AuthorizerFlowOption configures optional settings of an AuthorizerFlow.
+type AuthorizerFlowOption func(*AuthorizerFlow)
+
+// WithCancelCommands sets the commands that abandon the authorization when sent during it, instead of
+// DefaultAuthCancelCommands.
+func WithCancelCommands(commands ...string) AuthorizerFlowOption {
+	return func(a *AuthorizerFlow) {
+		a.cancelCommands = commands
+	}
 }
 
 // StartCommandParser implements im.Flow and will do a simple split.
@@ -25,45 +45,105 @@ func (a *AuthorizerFlow) StartCommandParser(s string) (string, []string, error)
 	return parts[0], parts[1:], nil
 }
 
-// Start implements im.Flow and will start the authorization flow for the chat that sent the message.
+// Start implements im.Flow and will start the authorization flow for the chat that sent the message. The authorization
+// lives as long as ctx, the scheduler cancels it when the flow is finished or abandoned (i.e. /cancel or idleness),
+// which ends the authorization and closes its channel.
 func (a *AuthorizerFlow) Start(ctx context.Context, message *im.Message, messenger im.Messenger) error {
+	if a.cancel != nil {
+		// a restart abandons the previous authorization.
+		a.cancel()
+	}
+	ctx, a.cancel = context.WithCancel(ctx)
+	// what the authorization says on its own, after we stopped waiting for it, goes straight to the chat.
+	ctx = WithAuthNotifier(ctx, func(text string) {
+		if err := messenger.SendMessage(ctx, message.Reply(text)); err != nil {
+			a.logger.Error("sending authorization notice", "im", messenger.Name(), "user_id", message.UserID,
+				"err", err)
+		}
+	})
 	authorization, err := a.authorizer.StartAuthorization(ctx, UserID(message.UserID), nil)
 	if err != nil {
+		a.cancel()
 		return fmt.Errorf("starting authorization: %w", err)
 	}
-	log.Printf("telegram authorizer: started authorization for chat: %d", message.UserID)
+	a.logger.Info("authorization started", "im", messenger.Name(), "user_id", message.UserID)
 	a.authorizationChan = authorization
-	// we invoke handle message because the flow begins with us responding to a message
-	return a.HandleMessage(ctx, message, messenger)
+	// authorizations begin by asking something, we wait for it so the next message of the user is its answer.
+	return a.relayNext(ctx, message, messenger)
 }
 
 // HandleMessage will handle messages during the authorization flow, messages will be sent and received through the
-// authorization channel, the flow will finish when the channel is closed. The flow begins with us listening for the
-// first message on the channel. If the message we are handling is not empty AND the channel is not closed, we send the
-// message to the chat before anything. If the channel is closed, we return an error to indicate the flow is finished.
+// authorization channel, the flow will finish when the channel is closed. Start already relayed the first question,
+// so the message we are handling is an answer: if it is not empty AND the channel is not closed we send it through the
+// channel and relay what the authorization says next. If the channel is closed, we return an error to indicate the
+// flow is finished.
 func (a *AuthorizerFlow) HandleMessage(ctx context.Context, message *im.Message, messenger im.Messenger) error {
 	if a.authorizationChan == nil {
 		return fmt.Errorf("no authorization channel")
 	}
+	if a.isCancelCommand(message) {
+		return a.abandon(ctx, message, messenger)
+	}
+	// the authorization might have ended (i.e. failed) since we last heard from it, sending to it would panic.
+	select {
+	case msg, ok := <-a.authorizationChan:
+		if !ok {
+			a.logger.Info("authorization already finished", "im", messenger.Name(), "user_id", message.UserID)
+			return im.ErrFlowFinished
+		}
+		if err := messenger.SendMessage(ctx, message.Reply(msg)); err != nil {
+			return fmt.Errorf("sending message: %w", err)
+		}
+		return nil
+	default:
+	}
 	// extract the message to be sent through the channel if not a command
-	if !message.IsCommand() && !message.IsEmpty() {
-		log.Printf("%s authorizer: sending message from chat ID %d for user %d: %s", messenger.Name(), message.ChatID, message.UserID, message.Text)
+	// button presses and edits are not answers.
+	if !message.IsCommand() && message.Text != "" && !message.Edited {
+		// the text is not logged, answers are often passwords.
+		a.logger.Debug("answering authorization", "im", messenger.Name(), "user_id", message.UserID)
 		select {
 		case a.authorizationChan <- message.Text:
 		case <-ctx.Done():
 			return nil
 		}
 	}
-	log.Printf("%s authorizer: handling message from chat ID %d for user %d: %s", messenger.Name(), message.ChatID, message.UserID, message.Text)
 
+	return a.relayNext(ctx, message, messenger)
+}
+
+// isCancelCommand tells if message is one of the commands that abandon the authorization.
+func (a *AuthorizerFlow) isCancelCommand(message *im.Message) bool {
+	if !message.IsCommand() || message.Edited {
+		return false
+	}
+	command, _, err := message.AsCommand(a.StartCommandParser)
+	return err == nil && slices.Contains(a.cancelCommands, command)
+}
+
+// abandon cancels the authorization, waits for it to close its channel and tells the user.
+func (a *AuthorizerFlow) abandon(ctx context.Context, message *im.Message, messenger im.Messenger) error {
+	a.cancel()
+	// whatever the authorization had left to say is dropped, the user is not answering it.
+	for range a.authorizationChan {
+	}
+	a.logger.Info("authorization canceled", "im", messenger.Name(), "user_id", message.UserID)
+	if err := messenger.SendMessage(ctx, message.Reply("Authorization canceled.")); err != nil {
+		return fmt.Errorf("sending message: %w", err)
+	}
+	return im.ErrFlowFinished
+}
+
+// relayNext waits for what the authorization says next and sends it to the chat, the flow is finished if instead the
+// authorization ends.
+func (a *AuthorizerFlow) relayNext(ctx context.Context, message *im.Message, messenger im.Messenger) error {
 	select {
 	case msg, ok := <-a.authorizationChan:
 		if !ok {
-			log.Printf("%s authorizer: finished authorization for chat ID %d for user %d", messenger.Name(), message.ChatID, message.UserID)
+			a.logger.Info("authorization finished", "im", messenger.Name(), "user_id", message.UserID)
 			return im.ErrFlowFinished
 		}
-		err := messenger.SendMessage(ctx, message.Reply(msg))
-		if err != nil {
+		if err := messenger.SendMessage(ctx, message.Reply(msg)); err != nil {
 			return fmt.Errorf("sending message: %w", err)
 		}
 		return nil
@@ -74,8 +154,14 @@ func (a *AuthorizerFlow) HandleMessage(ctx context.Context, message *im.Message,
 
 var _ im.Flow = &AuthorizerFlow{}
 
-func NewAuthorizerFlow(authorizer Authorizer) *AuthorizerFlow {
-	return &AuthorizerFlow{
-		authorizer: authorizer,
+func NewAuthorizerFlow(authorizer Authorizer, opts ...AuthorizerFlowOption) *AuthorizerFlow {
+	a := &AuthorizerFlow{
+		authorizer:     authorizer,
+		cancelCommands: DefaultAuthCancelCommands,
+		logger:         slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(a)
 	}
+	return a
 }
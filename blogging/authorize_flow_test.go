@@ -0,0 +1,113 @@
+package blogging
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/perrito666/chat2world/im"
+)
+
+// handleWithin has scheduler handle a message with text from testUser, failing the test if it takes more than a
+// second, as it does when the flow and the authorization wait on each other.
+func handleWithin(t *testing.T, scheduler *im.FlowScheduler, messenger im.Messenger, text string) {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() {
+		done <- scheduler.HandleMessage(context.Background(), &im.Message{UserID: testUser, Text: text}, messenger)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("handling %q: %v", text, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("handling %q never finished", text)
+	}
+}
+
+// authScheduler returns a scheduler running the authorization of platform on /auth.
+func authScheduler(t *testing.T, platform Authorizer) *im.FlowScheduler {
+	t.Helper()
+	scheduler := im.NewScheduler()
+	if err := scheduler.RegisterFlow(NewAuthorizerFlow(platform), "auth", []string{"/auth"}); err != nil {
+		t.Fatalf("RegisterFlow: %v", err)
+	}
+	return scheduler
+}
+
+func TestAuthorizationStartsWithItsQuestion(t *testing.T) {
+	platform := &passwordPlatform{password: "hunter2"}
+	scheduler := authScheduler(t, platform)
+	messenger := &recordingMessenger{}
+
+	handleWithin(t, scheduler, messenger, "/auth")
+	if want := "What is the password?"; messenger.last() != want {
+		t.Fatalf("/auth answered %q, want the first question %q", messenger.last(), want)
+	}
+	handleWithin(t, scheduler, messenger, "hunter2")
+	if !platform.IsAuthorized(testUser) {
+		t.Error("IsAuthorized() = false, want the answer to have reached the authorization")
+	}
+	// the authorization is over, messages go nowhere near it.
+	handleWithin(t, scheduler, messenger, "hunter3")
+	if !platform.IsAuthorized(testUser) {
+		t.Error("a message after the authorization reached it")
+	}
+}
+
+// patientPlatform is a platform whose authorization asks a question and waits for its context to be done, closing
+// stopped once it is over.
+type patientPlatform struct {
+	fakePlatform
+	stopped chan struct{}
+}
+
+func (f *patientPlatform) StartAuthorization(ctx context.Context, _ UserID, _ map[string]string) (chan string, error) {
+	comms := make(chan string)
+	go func() {
+		defer close(f.stopped)
+		defer close(comms)
+		select {
+		case comms <- "What is the password?":
+		case <-ctx.Done():
+			return
+		}
+		<-ctx.Done()
+	}()
+	return comms, nil
+}
+
+func TestCancelAbandonsTheAuthorization(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		opts    []AuthorizerFlowOption
+		command string
+	}{
+		{"default", nil, "/cancel"},
+		{"custom", []AuthorizerFlowOption{WithCancelCommands("/stop")}, "/stop"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			platform := &patientPlatform{stopped: make(chan struct{})}
+			flow := NewAuthorizerFlow(platform, tc.opts...)
+			messenger := &recordingMessenger{}
+			ctx := context.Background()
+			if err := flow.Start(ctx, &im.Message{UserID: testUser, Text: "/auth"}, messenger); err != nil {
+				t.Fatalf("Start: %v", err)
+			}
+			err := flow.HandleMessage(ctx, &im.Message{UserID: testUser, Text: tc.command}, messenger)
+			if !errors.Is(err, im.ErrFlowFinished) {
+				t.Errorf("%s: err = %v, want ErrFlowFinished", tc.command, err)
+			}
+			if want := "Authorization canceled."; messenger.last() != want {
+				t.Errorf("%s answered %q, want %q", tc.command, messenger.last(), want)
+			}
+			select {
+			case <-platform.stopped:
+			case <-time.After(time.Second):
+				t.Fatal("the authorization is still running after canceling it")
+			}
+		})
+	}
+}
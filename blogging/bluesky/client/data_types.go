@@ -17,11 +17,17 @@ type CreateSessionResponse struct {
 type ATProtoType string
 
 const (
-	BlobType         ATProtoType = "blob"
-	PostRecordType   ATProtoType = "app.bsky.feed.post"
-	EmbedImagesType  ATProtoType = "app.bsky.embed.images"
-	FacetMentionType ATProtoType = "app.bsky.richtext.facet#mention"
-	FacetLinkType    ATProtoType = "app.bsky.richtext.facet#link"
+	BlobType          ATProtoType = "blob"
+	PostRecordType    ATProtoType = "app.bsky.feed.post"
+	EmbedImagesType   ATProtoType = "app.bsky.embed.images"
+	EmbedExternalType ATProtoType = "app.bsky.embed.external"
+	EmbedVideoType    ATProtoType = "app.bsky.embed.video"
+	EmbedRecordType   ATProtoType = "app.bsky.embed.record"
+	// EmbedRecordWithMediaType is a quote that also carries images or a video.
+	EmbedRecordWithMediaType ATProtoType = "app.bsky.embed.recordWithMedia"
+	FacetMentionType         ATProtoType = "app.bsky.richtext.facet#mention"
+	FacetLinkType            ATProtoType = "app.bsky.richtext.facet#link"
+	FacetTagType             ATProtoType = "app.bsky.richtext.facet#tag"
 )
 
 // {"blob":{"$type":"blob","ref":{"$link":"bafkreiepxzhesdi2637rtdgmkm4jdsnixpi5bbpp5gz2fq64ebwzrltoau"},"mimeType":"image/jpeg","size":115022}}
@@ -51,18 +57,64 @@ type EmbedAspectRatio struct {
 type EmbedImage struct {
 	Alt         string              `json:"alt"`
 	Image       ImageUploadResponse `json:"image"`
-	AspectRatio EmbedAspectRatio    `json:"aspectRatio"`
+	AspectRatio *EmbedAspectRatio   `json:"aspectRatio,omitempty"`
 }
 
-// PostEmbed defines the structure for embedding images in a Bluesky post.
+// ExternalEmbed defines a link card, the thumbnail is optional.
+type ExternalEmbed struct {
+	Uri         string               `json:"uri"`
+	Title       string               `json:"title"`
+	Description string               `json:"description"`
+	Thumb       *ImageUploadResponse `json:"thumb,omitempty"`
+}
+
+// PostEmbed defines the structure for embedding images, a video, a link card or a quoted post in a Bluesky post, Alt
+// and AspectRatio are only used by videos, Record by quotes and Media by quotes that also carry images or a video.
 type PostEmbed struct {
-	Type   ATProtoType  `json:"$type"`
-	Images []EmbedImage `json:"images"`
+	Type        ATProtoType          `json:"$type"`
+	Images      []EmbedImage         `json:"images,omitempty"`
+	External    *ExternalEmbed       `json:"external,omitempty"`
+	Video       *ImageUploadResponse `json:"video,omitempty"`
+	Alt         string               `json:"alt,omitempty"`
+	AspectRatio *EmbedAspectRatio    `json:"aspectRatio,omitempty"`
+	Record      *QuotedRecord        `json:"record,omitempty"`
+	Media       *PostEmbed           `json:"media,omitempty"`
+}
+
+// QuotedRecord is the record of a quote embed, for app.bsky.embed.record it is the reference to the quoted post itself
+// (Uri and Cid), for app.bsky.embed.recordWithMedia it is an app.bsky.embed.record (Type and Record).
+type QuotedRecord struct {
+	Type   ATProtoType `json:"$type,omitempty"`
+	Uri    string      `json:"uri,omitempty"`
+	Cid    string      `json:"cid,omitempty"`
+	Record *ReplyRef   `json:"record,omitempty"`
+}
+
+// quoteEmbed returns the embed quoting the post quoted along media, which can be nil.
+func quoteEmbed(quoted *ReplyRef, media *PostEmbed) *PostEmbed {
+	if media == nil {
+		return &PostEmbed{
+			Type:   EmbedRecordType,
+			Record: &QuotedRecord{Uri: quoted.Uri, Cid: quoted.Cid},
+		}
+	}
+	return &PostEmbed{
+		Type:   EmbedRecordWithMediaType,
+		Record: &QuotedRecord{Type: EmbedRecordType, Record: quoted},
+		Media:  media,
+	}
+}
+
+// ReplyRef is a strong reference (URI and CID) to a post, as used to build replies.
+type ReplyRef struct {
+	Uri string `json:"uri"`
+	Cid string `json:"cid"`
 }
 
+// Reply holds the references to the thread root and the direct parent of a reply post.
 type Reply struct {
-	Root   *CreateRecordResponse `json:"root,omitempty"`
-	Parent *CreateRecordResponse `json:"parent,omitempty"`
+	Root   *ReplyRef `json:"root,omitempty"`
+	Parent *ReplyRef `json:"parent,omitempty"`
 }
 
 // PostRecord defines the inner record for a Bluesky post.
@@ -71,7 +123,7 @@ type PostRecord struct {
 	Text      string      `json:"text"`
 	CreatedAt string      `json:"createdAt"`
 	Embed     *PostEmbed  `json:"embed,omitempty"`
-	Langs     []string    `json:"langs"` //tbd in a decent way
+	Langs     []string    `json:"langs,omitempty"` // empty lets bluesky guess
 	Facets    []Facet     `json:"facets,omitempty"`
 	Reply     *Reply      `json:"reply,omitempty"`
 }
@@ -90,3 +142,30 @@ type CreateRecordResponse struct {
 	Uri string `json:"uri"`
 	Cid string `json:"cid"`
 }
+
+// EmbedImageView is the hydrated version of an EmbedImage, as returned by the app view, with CDN URLs for the image.
+type EmbedImageView struct {
+	Thumb       string            `json:"thumb"`
+	Fullsize    string            `json:"fullsize"`
+	Alt         string            `json:"alt"`
+	AspectRatio *EmbedAspectRatio `json:"aspectRatio,omitempty"`
+}
+
+// EmbedView is the hydrated version of a PostEmbed.
+type EmbedView struct {
+	Type   ATProtoType      `json:"$type"`
+	Images []EmbedImageView `json:"images,omitempty"`
+}
+
+// PostView is a post as returned by app.bsky.feed.getPosts, the record plus hydrated embeds.
+type PostView struct {
+	Uri    string     `json:"uri"`
+	Cid    string     `json:"cid"`
+	Record PostRecord `json:"record"`
+	Embed  *EmbedView `json:"embed,omitempty"`
+}
+
+// GetPostsResponse represents the response from app.bsky.feed.getPosts.
+type GetPostsResponse struct {
+	Posts []PostView `json:"posts"`
+}
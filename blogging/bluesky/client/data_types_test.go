@@ -0,0 +1,40 @@
+package bluesky
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestQuoteEmbed(t *testing.T) {
+	quoted := &ReplyRef{Uri: "at://did:plc:other/app.bsky.feed.post/3kabc", Cid: "bafyquoted"}
+
+	embed := quoteEmbed(quoted, nil)
+	got, err := json.Marshal(embed)
+	if err != nil {
+		t.Fatalf("marshaling: %v", err)
+	}
+	want := `{"$type":"app.bsky.embed.record","record":{"uri":"at://did:plc:other/app.bsky.feed.post/3kabc",` +
+		`"cid":"bafyquoted"}}`
+	if string(got) != want {
+		t.Errorf("quote without media = %s, want %s", got, want)
+	}
+
+	images := &PostEmbed{Type: EmbedImagesType, Images: []EmbedImage{{Alt: "a cat",
+		Image: ImageUploadResponse{Type: BlobType, Ref: Ref{Link: "bafkcat"}, MimeType: "image/jpeg", Size: 3}}}}
+	embed = quoteEmbed(quoted, images)
+	if embed.Type != EmbedRecordWithMediaType || embed.Media != images {
+		t.Fatalf("quote with images = %+v, want an app.bsky.embed.recordWithMedia carrying them", embed)
+	}
+	if embed.Record == nil || embed.Record.Type != EmbedRecordType || embed.Record.Record != quoted {
+		t.Errorf("record = %+v, want an app.bsky.embed.record of the quoted post", embed.Record)
+	}
+	got, err = json.Marshal(embed.Record)
+	if err != nil {
+		t.Fatalf("marshaling: %v", err)
+	}
+	want = `{"$type":"app.bsky.embed.record","record":{"uri":"at://did:plc:other/app.bsky.feed.post/3kabc",` +
+		`"cid":"bafyquoted"}}`
+	if string(got) != want {
+		t.Errorf("record of the quote with images = %s, want %s", got, want)
+	}
+}
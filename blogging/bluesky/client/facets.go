@@ -1,12 +1,12 @@
 package bluesky
 
 import (
-	"encoding/json"
-	"fmt"
-	"io"
-	"log"
-	"net/http"
+	"context"
+	"log/slog"
 	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 // This is a straight translation from the example python in https://docs.bsky.app/docs/advanced-guides/posts#mentions-and-links
@@ -36,6 +36,7 @@ type Feature struct {
 	Type ATProtoType `json:"$type"`         // e.g. "app.bsky.richtext.facet#mention" or "#link"
 	Did  string      `json:"did,omitempty"` // for mentions
 	URI  string      `json:"uri,omitempty"` // for links
+	Tag  string      `json:"tag,omitempty"` // for hashtags, without the #
 }
 
 // Facet represents a facet with an index and a set of features.
@@ -60,6 +61,47 @@ var mentionRegex = regexp.MustCompile(
 var urlRegex = regexp.MustCompile(
 	`(?:^|[^A-Za-z0-9_])(https?://(?:www\.)?[-a-zA-Z0-9@:%._\+~#=]{1,256}\.[A-Za-z0-9()]{1,6}\b(?:[-a-zA-Z0-9()@:%_\+.~#?&//=]*[-a-zA-Z0-9@%_\+~#//=])?)`)
 
+// TagSpan represents a hashtag found in the text, Start and End cover the # too.
+type TagSpan struct {
+	Start int
+	End   int
+	Tag   string
+}
+
+// The hashtag regex follows the one in the bluesky reference implementation, minus the lookahead RE2 lacks (which is
+// done in parseHashtags): a # (or full width ＃) preceded by the beginning of the string or a space and followed by
+// anything but spaces and invisible separators.
+var hashtagRegex = regexp.MustCompile(`(?:^|\s)([#＃][^\s\x{00AD}\x{2060}\x{200A}-\x{200D}\x{20E2}]+)`)
+
+// trailingPunctuationRegex matches the punctuation at the end of a tag, which belongs to the sentence, not the tag.
+var trailingPunctuationRegex = regexp.MustCompile(`\p{P}+$`)
+
+// maxTagLength is the longest tag, in characters, bluesky accepts.
+const maxTagLength = 64
+
+// parseHashtags scans the given text and returns a slice of TagSpan, tags made only of digits (#1) or longer than
+// maxTagLength are not tags, and the keycap emoji (#️⃣) is not one either.
+func parseHashtags(text string) []TagSpan {
+	var spans []TagSpan
+	for _, m := range hashtagRegex.FindAllStringSubmatchIndex(text, -1) {
+		grpStart, grpEnd := m[2], m[3]
+		_, hashSize := utf8.DecodeRuneInString(text[grpStart:])
+		tag := trailingPunctuationRegex.ReplaceAllString(text[grpStart+hashSize:grpEnd], "")
+		if tag == "" || strings.HasPrefix(tag, "\ufe0f") || utf8.RuneCountInString(tag) > maxTagLength {
+			continue
+		}
+		if strings.IndexFunc(tag, func(r rune) bool { return !unicode.IsDigit(r) && !unicode.IsPunct(r) }) == -1 {
+			continue
+		}
+		spans = append(spans, TagSpan{
+			Start: grpStart,
+			End:   grpStart + hashSize + len(tag),
+			Tag:   tag,
+		})
+	}
+	return spans
+}
+
 // parseMentions scans the given text and returns a slice of MentionSpan.
 // It converts the text to a byte slice and uses the compiled regex.
 // Note: The returned Start and End indices refer to byte positions.
@@ -110,38 +152,22 @@ func parseURLs(text string) []URLSpan {
 	return spans
 }
 
+// HandleResolver resolves a handle into a DID.
+type HandleResolver func(ctx context.Context, handle string) (string, error)
+
 // ParseFacets parses the text for mentions and URLs and builds facet data.
-// It takes a second parameter, pdsURL, which is the base URL of the PDS service
-// used to resolve handles into DIDs.
-// For each mention, it makes an HTTP GET request to resolve the handle.
-// If the response status is 400, the mention is skipped.
-func ParseFacets(text string, pdsURL string) ([]Facet, error) {
+// Mentioned handles are resolved into DIDs with resolve, mentions that can not be resolved are skipped (and left as
+// plain text).
+func ParseFacets(ctx context.Context, text string, resolve HandleResolver) ([]Facet, error) {
 	var facets []Facet
 
 	// Process mentions.
 	mentions := parseMentions(text)
 	for _, m := range mentions {
-		// Build the URL for handle resolution.
-		resolveURL := fmt.Sprintf("%s/xrpc/com.atproto.identity.resolveHandle?handle=%s", pdsURL, m.Handle)
-		resp, err := http.Get(resolveURL)
+		did, err := resolve(ctx, m.Handle)
 		if err != nil {
 			// Skip this mention on error.
-			log.Printf("Error resolving handle %s: %v", m.Handle, err)
-			continue
-		}
-		body, err := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		if err != nil {
-			log.Printf("Error reading response for handle %s: %v", m.Handle, err)
-			continue
-		}
-		if resp.StatusCode == http.StatusBadRequest {
-			// Skip unresolved handles.
-			continue
-		}
-		var resolveResp ResolveHandleResponse
-		if err := json.Unmarshal(body, &resolveResp); err != nil {
-			log.Printf("Error unmarshaling response for handle %s: %v", m.Handle, err)
+			slog.Warn("resolving handle, leaving the mention as text", "handle", m.Handle, "err", err)
 			continue
 		}
 		// Create a facet for this mention.
@@ -153,7 +179,7 @@ func ParseFacets(text string, pdsURL string) ([]Facet, error) {
 			Features: []Feature{
 				{
 					Type: FacetMentionType,
-					Did:  resolveResp.Did,
+					Did:  did,
 				},
 			},
 		}
@@ -178,5 +204,73 @@ func ParseFacets(text string, pdsURL string) ([]Facet, error) {
 		facets = append(facets, facet)
 	}
 
-	return facets, nil
+	// Process hashtags, skipping anything that is part of a URL (a fragment, i.e.).
+	for _, t := range parseHashtags(text) {
+		insideURL := false
+		for _, u := range urls {
+			if t.Start < u.End && t.End > u.Start {
+				insideURL = true
+				break
+			}
+		}
+		if insideURL {
+			continue
+		}
+		facets = append(facets, Facet{
+			Index: Index{
+				ByteStart: t.Start,
+				ByteEnd:   t.End,
+			},
+			Features: []Feature{
+				{
+					Type: FacetTagType,
+					Tag:  t.Tag,
+				},
+			},
+		})
+	}
+
+	// The regexes are UTF-8 aware and the offsets come from the capture groups (not the whole match, which includes
+	// the preceding character), so spans should always be exact, but a span that is off by even a byte renders broken
+	// on clients, so anything that does not cover exactly what it claims to is dropped rather than sent.
+	valid := facets[:0]
+	for _, f := range facets {
+		if !validFacetSpan(text, f) {
+			slog.Warn("dropping facet with invalid span", "start", f.Index.ByteStart, "end", f.Index.ByteEnd,
+				"features", f.Features)
+			continue
+		}
+		valid = append(valid, f)
+	}
+	return valid, nil
+}
+
+// validFacetSpan returns true if the facet's byte span lies within text, starts and ends at character boundaries and
+// covers exactly the mention, link or tag it describes.
+func validFacetSpan(text string, f Facet) bool {
+	start, end := f.Index.ByteStart, f.Index.ByteEnd
+	if start < 0 || end > len(text) || start >= end {
+		return false
+	}
+	if !utf8.RuneStart(text[start]) || (end < len(text) && !utf8.RuneStart(text[end])) {
+		return false
+	}
+	span := text[start:end]
+	for _, feature := range f.Features {
+		switch feature.Type {
+		case FacetMentionType:
+			if !strings.HasPrefix(span, "@") {
+				return false
+			}
+		case FacetLinkType:
+			if span != feature.URI {
+				return false
+			}
+		case FacetTagType:
+			if _, hashSize := utf8.DecodeRuneInString(span); span[hashSize:] != feature.Tag {
+				return false
+			}
+		}
+	}
+	return true
 }
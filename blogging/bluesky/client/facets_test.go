@@ -0,0 +1,94 @@
+package bluesky
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// fakeResolver resolves every handle to a DID made of it, but those ending in .invalid which do not exist.
+func fakeResolver(_ context.Context, handle string) (string, error) {
+	if strings.HasSuffix(handle, ".invalid") {
+		return "", ErrHandleNotFound
+	}
+	return "did:plc:" + handle, nil
+}
+
+// wantFacet is a facet expected at a byte span, covering text.
+type wantFacet struct {
+	start, end int
+	kind       ATProtoType
+	text       string
+}
+
+// checkFacets parses text and checks the facets are exactly want, in any order.
+func checkFacets(t *testing.T, text string, want []wantFacet) {
+	t.Helper()
+	facets, err := ParseFacets(context.Background(), text, fakeResolver)
+	if err != nil {
+		t.Fatalf("ParseFacets(%q): %v", text, err)
+	}
+	if len(facets) != len(want) {
+		t.Fatalf("ParseFacets(%q) = %+v, want %d facets", text, facets, len(want))
+	}
+	for _, w := range want {
+		found := false
+		for _, f := range facets {
+			if f.Index.ByteStart == w.start && f.Index.ByteEnd == w.end && f.Features[0].Type == w.kind {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("ParseFacets(%q) = %+v, want a %s facet [%d, %d)", text, facets, w.kind, w.start, w.end)
+			continue
+		}
+		if got := text[w.start:w.end]; got != w.text {
+			t.Errorf("the %s facet [%d, %d) covers %q, want %q", w.kind, w.start, w.end, got, w.text)
+		}
+	}
+}
+
+func TestParseFacetsMixed(t *testing.T) {
+	text := "hi @alice.bsky.social see https://example.com/a#frag #golang and @nobody.invalid #1"
+	checkFacets(t, text, []wantFacet{
+		{3, 21, FacetMentionType, "@alice.bsky.social"},
+		{26, 52, FacetLinkType, "https://example.com/a#frag"},
+		{53, 60, FacetTagType, "#golang"},
+	})
+	facets, _ := ParseFacets(context.Background(), text, fakeResolver)
+	for _, f := range facets {
+		switch f.Features[0].Type {
+		case FacetMentionType:
+			if f.Features[0].Did != "did:plc:alice.bsky.social" {
+				t.Errorf("mention DID = %q", f.Features[0].Did)
+			}
+		case FacetLinkType:
+			if f.Features[0].URI != "https://example.com/a#frag" {
+				t.Errorf("link URI = %q", f.Features[0].URI)
+			}
+		case FacetTagType:
+			if f.Features[0].Tag != "golang" {
+				t.Errorf("tag = %q, want golang", f.Features[0].Tag)
+			}
+		}
+	}
+}
+
+func TestParseHashtagsTrailingPunctuation(t *testing.T) {
+	checkFacets(t, "ending a sentence with #golang. and #go!", []wantFacet{
+		{23, 30, FacetTagType, "#golang"},
+		{36, 39, FacetTagType, "#go"},
+	})
+}
+
+func TestParseFacetsMultibyteOffsets(t *testing.T) {
+	// offsets are in bytes: "é" and "ñ" are 2, "🎉" 4 and the family emoji 18.
+	text := "café @alice.bsky.social 🎉https://example.com ñ@bob.bsky.social 👨‍👩‍👧 #año"
+	checkFacets(t, text, []wantFacet{
+		{6, 24, FacetMentionType, "@alice.bsky.social"},
+		{29, 48, FacetLinkType, "https://example.com"},
+		{51, 67, FacetMentionType, "@bob.bsky.social"},
+		{87, 92, FacetTagType, "#año"},
+	})
+}
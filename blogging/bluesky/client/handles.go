@@ -0,0 +1,94 @@
+package bluesky
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// handleCacheTTL is how long a resolved handle is trusted, handles rarely change owner but they can.
+const handleCacheTTL = time.Hour
+
+// ErrHandleNotFound is returned when a handle does not resolve to any DID.
+var ErrHandleNotFound = errors.New("handle not found")
+
+// cachedDid is a resolved handle and when it stops being trusted.
+type cachedDid struct {
+	did     string
+	expires time.Time
+}
+
+// handleCache maps handles to their DIDs, it is safe for concurrent use.
+type handleCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedDid
+}
+
+func (hc *handleCache) get(handle string, now time.Time) (string, bool) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	entry, ok := hc.entries[handle]
+	if !ok || now.After(entry.expires) {
+		return "", false
+	}
+	return entry.did, true
+}
+
+func (hc *handleCache) set(handle, did string, now time.Time) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if hc.entries == nil {
+		hc.entries = make(map[string]cachedDid)
+	}
+	hc.entries[handle] = cachedDid{did: did, expires: now.Add(handleCacheTTL)}
+}
+
+// clear forgets every resolved handle.
+func (hc *handleCache) clear() {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.entries = nil
+}
+
+// ResolveHandle returns the DID for handle, resolutions are cached for a while so mentioning the same accounts again
+// does not hit the network. It returns ErrHandleNotFound if the handle does not exist.
+func (client *Client) ResolveHandle(ctx context.Context, handle string) (string, error) {
+	handle = strings.ToLower(strings.TrimPrefix(handle, "@"))
+	if did, ok := client.handles.get(handle, time.Now()); ok {
+		return did, nil
+	}
+
+	resolveURL := fmt.Sprintf("%s/xrpc/com.atproto.identity.resolveHandle?handle=%s", client.Server, url.QueryEscape(handle))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, resolveURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("creating resolve handle request: %w", err)
+	}
+	resp, err := client.HttpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("executing resolve handle request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("reading resolve handle response: %w", err)
+	}
+	if resp.StatusCode == http.StatusBadRequest {
+		return "", fmt.Errorf("%s: %w", handle, ErrHandleNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("resolve handle returned non-OK status (%d): %s", resp.StatusCode, string(body))
+	}
+	var resolveResp ResolveHandleResponse
+	if err := json.Unmarshal(body, &resolveResp); err != nil {
+		return "", fmt.Errorf("unmarshaling resolve handle response: %w", err)
+	}
+	client.handles.set(handle, resolveResp.Did, time.Now())
+	return resolveResp.Did, nil
+}
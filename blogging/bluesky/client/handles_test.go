@@ -0,0 +1,46 @@
+package bluesky
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResolveHandleIsCached(t *testing.T) {
+	var calls atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /xrpc/com.atproto.identity.resolveHandle", func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		fmt.Fprintf(w, `{"did":"did:plc:%s"}`, r.URL.Query().Get("handle"))
+	})
+	client := newTestClient(t, mux)
+	ctx := context.Background()
+
+	for _, handle := range []string{"alice.bsky.social", "@Alice.bsky.social"} {
+		did, err := client.ResolveHandle(ctx, handle)
+		if err != nil {
+			t.Fatalf("ResolveHandle(%q): %v", handle, err)
+		}
+		if did != "did:plc:alice.bsky.social" {
+			t.Errorf("ResolveHandle(%q) = %q, want did:plc:alice.bsky.social", handle, did)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("the handle was resolved %d times, want once", n)
+	}
+}
+
+func TestHandleCacheExpires(t *testing.T) {
+	var cache handleCache
+	now := time.Now()
+	cache.set("alice.bsky.social", "did:plc:alice", now)
+	if did, ok := cache.get("alice.bsky.social", now.Add(handleCacheTTL-time.Second)); !ok || did != "did:plc:alice" {
+		t.Errorf("get() = %q, %t before expiring, want did:plc:alice", did, ok)
+	}
+	if _, ok := cache.get("alice.bsky.social", now.Add(handleCacheTTL+time.Second)); ok {
+		t.Error("the handle is still cached after expiring")
+	}
+}
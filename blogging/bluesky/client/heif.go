@@ -0,0 +1,90 @@
+package bluesky
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+)
+
+// There is no pure Go HEIF decoder we can rely on, but all we need from phone photos (HEIC) are their dimensions for
+// the aspect ratio, and those are in the "ispe" (image spatial extents) property of the meta box, so this registers a
+// format that can only DecodeConfig.
+
+// heifBrands are the ftyp major brands used by HEIF/HEIC images.
+var heifBrands = []string{"heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1"}
+
+// errHEIFDecodeUnsupported is returned when trying to fully decode a HEIF image.
+var errHEIFDecodeUnsupported = errors.New("heif: decoding pixels is not supported, only dimensions")
+
+// errHEIFNoDimensions is returned when a HEIF image has no ispe property.
+var errHEIFNoDimensions = errors.New("heif: no image spatial extents found")
+
+func init() {
+	for _, brand := range heifBrands {
+		image.RegisterFormat("heif", "????ftyp"+brand, decodeHEIF, decodeHEIFConfig)
+	}
+}
+
+// decodeHEIF is only here to satisfy image.RegisterFormat.
+func decodeHEIF(io.Reader) (image.Image, error) {
+	return nil, errHEIFDecodeUnsupported
+}
+
+// decodeHEIFConfig returns the dimensions of the largest image described in the HEIF file, which is the primary one
+// (the others being thumbnails or grid tiles).
+func decodeHEIFConfig(r io.Reader) (image.Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	width, height := 0, 0
+	walkHEIFBoxes(data, func(width2, height2 int) {
+		if width2*height2 > width*height {
+			width, height = width2, height2
+		}
+	})
+	if width == 0 || height == 0 {
+		return image.Config{}, errHEIFNoDimensions
+	}
+	return image.Config{Width: width, Height: height}, nil
+}
+
+// walkHEIFBoxes goes through the ISO BMFF boxes in data, descending into the ones that lead to the item properties,
+// and calls found with the dimensions in each ispe box.
+func walkHEIFBoxes(data []byte, found func(width, height int)) {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		boxType := string(data[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return
+		}
+		body := data[header:size]
+		switch boxType {
+		case "meta":
+			// meta is a full box, version and flags come before its children.
+			if len(body) >= 4 {
+				walkHEIFBoxes(body[4:], found)
+			}
+		case "iprp", "ipco":
+			walkHEIFBoxes(body, found)
+		case "ispe":
+			// full box header, then width and height.
+			if len(body) >= 12 {
+				found(int(binary.BigEndian.Uint32(body[4:])), int(binary.BigEndian.Uint32(body[8:])))
+			}
+		}
+		data = data[size:]
+	}
+}
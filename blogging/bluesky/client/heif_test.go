@@ -0,0 +1,54 @@
+package bluesky
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// isoBox returns an ISO BMFF box of type boxType holding body.
+func isoBox(boxType string, body ...[]byte) []byte {
+	var data []byte
+	for _, b := range body {
+		data = append(data, b...)
+	}
+	box := binary.BigEndian.AppendUint32(nil, uint32(8+len(data)))
+	return append(append(box, boxType...), data...)
+}
+
+// ispe returns an image spatial extents property.
+func ispe(width, height int) []byte {
+	body := make([]byte, 12)
+	binary.BigEndian.PutUint32(body[4:], uint32(width))
+	binary.BigEndian.PutUint32(body[8:], uint32(height))
+	return isoBox("ispe", body)
+}
+
+// heicHeader returns the boxes of a HEIC image up to its properties, a thumbnail and the primary image, which is enough
+// to tell its dimensions.
+func heicHeader(width, height int) []byte {
+	ftyp := isoBox("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+	meta := isoBox("meta", make([]byte, 4), isoBox("iprp", isoBox("ipco", ispe(320, 240), ispe(width, height))))
+	return append(ftyp, meta...)
+}
+
+func TestNewPostableImageHEICDimensions(t *testing.T) {
+	img, err := NewPostableImage(heicHeader(4032, 3024), "alt")
+	if err != nil {
+		t.Fatalf("NewPostableImage: %v", err)
+	}
+	if img.Width != 4032 || img.Height != 3024 {
+		t.Errorf("dimensions = %dx%d, want those of the primary image, 4032x3024", img.Width, img.Height)
+	}
+	if img.MimeType != "image/heic" {
+		t.Errorf("MimeType = %q, want image/heic", img.MimeType)
+	}
+}
+
+func TestHEIFWithoutDimensions(t *testing.T) {
+	ftyp := isoBox("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+	if _, err := decodeHEIFConfig(bytes.NewReader(ftyp)); !errors.Is(err, errHEIFNoDimensions) {
+		t.Errorf("decodeHEIFConfig() err = %v, want errHEIFNoDimensions", err)
+	}
+}
@@ -0,0 +1,49 @@
+package bluesky
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNotAJWT is returned when a token that should be a JWT can't be read as one.
+var ErrNotAJWT = errors.New("not a JWT")
+
+// ErrNoSession is returned when asking about the session of a client that has none.
+var ErrNoSession = errors.New("no session")
+
+// jwtExpiry returns when token expires, read from its exp claim. The signature is not checked, only bluesky can, this
+// is just to know when to refresh it.
+func jwtExpiry(token string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("%d parts instead of 3: %w", len(parts), ErrNotAJWT)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("decoding payload (%v): %w", err, ErrNotAJWT)
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return time.Time{}, fmt.Errorf("reading claims (%v): %w", err, ErrNotAJWT)
+	}
+	if claims.Exp == 0 {
+		return time.Time{}, fmt.Errorf("no exp claim: %w", ErrNotAJWT)
+	}
+	return time.Unix(claims.Exp, 0), nil
+}
+
+// SessionExpiry returns when the access token of the session expires, it is refreshed before that as long as the
+// session refresher runs.
+func (client *Client) SessionExpiry() (time.Time, error) {
+	token := client.accessToken()
+	if token == "" {
+		return time.Time{}, ErrNoSession
+	}
+	return jwtExpiry(token)
+}
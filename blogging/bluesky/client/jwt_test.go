@@ -0,0 +1,58 @@
+package bluesky
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testJWT returns an unsigned JWT whose payload is claims.
+func testJWT(claims string) string {
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(`{"alg":"ES256K","typ":"at+jwt"}`)) + "." + enc.EncodeToString([]byte(claims)) +
+		".c2lnbmF0dXJl"
+}
+
+func TestJWTExpiry(t *testing.T) {
+	exp := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	got, err := jwtExpiry(testJWT(fmt.Sprintf(`{"scope":"com.atproto.access","sub":"did:plc:me","exp":%d}`, exp.Unix())))
+	if err != nil {
+		t.Fatalf("jwtExpiry: %v", err)
+	}
+	if !got.Equal(exp) {
+		t.Errorf("jwtExpiry() = %s, want %s", got, exp)
+	}
+
+	for name, token := range map[string]string{
+		"not a JWT":        "access-token",
+		"payload not JSON": testJWT("not json"),
+		"no exp":           testJWT(`{"sub":"did:plc:me"}`),
+		"broken base64":    "eyJhbGciOiJFUzI1NksifQ.!!!.c2ln",
+	} {
+		if _, err := jwtExpiry(token); !errors.Is(err, ErrNotAJWT) {
+			t.Errorf("%s: jwtExpiry() err = %v, want ErrNotAJWT", name, err)
+		}
+	}
+}
+
+func TestNextRefreshIsAheadOfExpiry(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+	client := NewClient()
+	client.AccessJwt = testJWT(fmt.Sprintf(`{"exp":%d}`, now.Add(30*time.Minute).Unix()))
+	if got, want := client.nextRefresh(now, time.Hour), 30*time.Minute-refreshAhead; got != want {
+		t.Errorf("nextRefresh() = %s, want %s, refreshAhead before the expiry", got, want)
+	}
+
+	// a token about to expire is refreshed right away, but not in a tight loop.
+	client.AccessJwt = testJWT(fmt.Sprintf(`{"exp":%d}`, now.Add(time.Second).Unix()))
+	if got := client.nextRefresh(now, time.Hour); got != refreshMinBackoff {
+		t.Errorf("nextRefresh() = %s, want %s", got, refreshMinBackoff)
+	}
+
+	client.AccessJwt = "opaque-token"
+	if got := client.nextRefresh(now, time.Hour); got != time.Hour {
+		t.Errorf("nextRefresh() = %s with an unreadable token, want the fallback %s", got, time.Hour)
+	}
+}
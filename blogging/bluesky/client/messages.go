@@ -11,20 +11,29 @@ import (
 	_ "image/jpeg" // register JPEG format
 	_ "image/png"  // register PNG format
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
+
+	_ "golang.org/x/image/webp" // register WebP format
+
+	"github.com/perrito666/chat2world/blogging/parallel"
+	"github.com/perrito666/chat2world/blogging/ratelimit"
 )
 
 // This is mostly documentation and chatGPT, take it with several grains of salt.
 
-// baseURL is the Bluesky server we are targeting.
-const baseURL = "https://bsky.social"
+// DefaultServer is the Bluesky server (PDS) of clients created without WithServer.
+const DefaultServer = "https://bsky.social"
 
 // Client holds authentication details and an HTTP client.
 type Client struct {
-	HttpClient  *http.Client
+	HttpClient *http.Client
+	// mu guards the session, from AccessJwt to appPassword: concurrent requests (i.e. parallel blob uploads) read it
+	// while refreshes and Logout write it.
+	mu          sync.Mutex
 	AccessJwt   string
 	RefreshJwt  string
 	Did         string
@@ -32,41 +41,201 @@ type Client struct {
 	isAthorized bool
 	username    string
 	appPassword string
+	// previousAccessJwt is the access token replaced by the last refresh, requests sent with it are still ours.
+	previousAccessJwt string
+	// refreshMu makes refreshing single flight, refresh tokens are single use so a second refresh with the same one
+	// fails and unauthorizes the client.
+	refreshMu sync.Mutex
+	// stopRefresher cancels the context of the running session refresher, nil if none runs, it is guarded by mu and
+	// ensures a single session refresher per client.
+	stopRefresher *context.CancelFunc
+	// handles caches resolved handles, see ResolveHandle.
+	handles handleCache
+	// Retry is used for blob uploads and record creation.
+	Retry RetryPolicy
+	// Server is the base URL of the user's PDS, i.e. https://bsky.social.
+	Server string
+	// timeout bounds each request, see WithTimeout.
+	timeout time.Duration
+	// OnSessionChange, if set, is called with the new session every time we authenticate or refresh it, refresh
+	// tokens are single use so whoever persists the session needs the latest one.
+	OnSessionChange func(Session)
+	logger          *slog.Logger
+}
+
+// Session holds what is needed to resume a bluesky session without the user's password.
+type Session struct {
+	AccessJwt  string `json:"access_jwt"`
+	RefreshJwt string `json:"refresh_jwt"`
+	Did        string `json:"did"`
+	Handle     string `json:"handle"`
+}
+
+// Session returns the client's current session.
+func (client *Client) Session() Session {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return Session{
+		AccessJwt:  client.AccessJwt,
+		RefreshJwt: client.RefreshJwt,
+		Did:        client.Did,
+		Handle:     client.Handle,
+	}
+}
+
+// sessionChanged tells OnSessionChange, if set, about the current session.
+func (client *Client) sessionChanged() {
+	if client.OnSessionChange != nil {
+		client.OnSessionChange(client.Session())
+	}
+}
+
+// ResumeSession picks up a previously stored session by refreshing it, which also tells us whether it is still valid,
+// if it is not (i.e. the refresh token expired) an error is returned and the caller should AuthenticateBluesky.
+func (client *Client) ResumeSession(ctx context.Context, session Session) error {
+	client.mu.Lock()
+	client.AccessJwt = session.AccessJwt
+	client.RefreshJwt = session.RefreshJwt
+	client.Did = session.Did
+	client.Handle = session.Handle
+	client.mu.Unlock()
+	if err := client.RefreshSession(ctx); err != nil {
+		return fmt.Errorf("resuming session: %w", err)
+	}
+	go client.StartSessionRefresher(ctx, 10*time.Minute)
+	return nil
+}
+
+// repo returns the identifier of the user's repository, the DID which, unlike the handle, never changes.
+func (client *Client) repo() string {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.Did != "" {
+		return client.Did
+	}
+	return client.Handle
+}
+
+// accessToken returns the access token of the session, empty if there is none.
+func (client *Client) accessToken() string {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.AccessJwt
+}
+
+// handle returns the user's handle, for logging.
+func (client *Client) handle() string {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.Handle
+}
+
+// authorize sets the access token of the session as the credentials of req.
+func (client *Client) authorize(req *http.Request) {
+	req.Header.Set("Authorization", "Bearer "+client.accessToken())
+}
+
+// DefaultRequestsPerSecond and DefaultBurst are the request rate of clients created without WithLimiter, well under
+// the 3000 requests per 5 minutes bluesky allows.
+const (
+	DefaultRequestsPerSecond = 5
+	DefaultBurst             = 10
+)
+
+// DefaultTimeout bounds every request of clients created without WithTimeout, so a server that hangs does not hang
+// whoever is waiting on us.
+const DefaultTimeout = 30 * time.Second
+
+// ClientOption configures optional settings of a Client.
+type ClientOption func(*Client)
+
+// WithLimiter makes the client's requests go through l, i.e. to share it among clients.
+func WithLimiter(l *ratelimit.Limiter) ClientOption {
+	return func(client *Client) {
+		client.HttpClient = ratelimit.NewHTTPClient(l)
+	}
+}
+
+// WithTimeout bounds each request of the client to d, including reading its response, video uploads get longer.
+func WithTimeout(d time.Duration) ClientOption {
+	return func(client *Client) {
+		client.timeout = d
+	}
+}
+
+// WithServer makes the client talk to the PDS at server (a base URL like https://pds.example.com) instead of
+// DefaultServer, an empty server is ignored.
+func WithServer(server string) ClientOption {
+	return func(client *Client) {
+		if server != "" {
+			client.Server = strings.TrimRight(server, "/")
+		}
+	}
 }
 
-// NewClient creates a new Bluesky client with the default HTTP client.
-func NewClient() *Client {
-	return &Client{
-		HttpClient: http.DefaultClient,
+// WithLogger sets where the client logs, it defaults to slog.Default().
+func WithLogger(logger *slog.Logger) ClientOption {
+	return func(client *Client) {
+		client.logger = logger
 	}
 }
 
+// NewClient creates a new Bluesky client, its requests are rate limited to DefaultRequestsPerSecond unless
+// WithLimiter says otherwise and time out after DefaultTimeout unless WithTimeout says otherwise.
+func NewClient(opts ...ClientOption) *Client {
+	client := &Client{
+		HttpClient: ratelimit.NewHTTPClient(ratelimit.NewLimiter(DefaultRequestsPerSecond, DefaultBurst)),
+		Retry:      DefaultRetryPolicy,
+		Server:     DefaultServer,
+		timeout:    DefaultTimeout,
+		logger:     slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(client)
+	}
+	client.HttpClient.Timeout = client.timeout
+	return client
+}
+
 // RefreshSession refreshes the Bluesky session using the current refresh token.
-// It sends a POST request to the refresh endpoint and updates the client's tokens.
-func (client *Client) RefreshSession() (err error) {
+// As per com.atproto.server.refreshSession, the refresh token (not the access one) goes in the Authorization header
+// and the request has no body. It updates the client's tokens.
+func (client *Client) RefreshSession(ctx context.Context) error {
+	client.refreshMu.Lock()
+	defer client.refreshMu.Unlock()
+	return client.refreshSession(ctx)
+}
+
+// refreshStale refreshes the session unless it changed since stale was its access token, i.e. because a concurrent
+// request was rejected too and refreshed it first, in which case the caller can just use the new access token.
+func (client *Client) refreshStale(ctx context.Context, stale string) error {
+	client.refreshMu.Lock()
+	defer client.refreshMu.Unlock()
+	if client.accessToken() != stale {
+		return nil
+	}
+	return client.refreshSession(ctx)
+}
+
+// refreshSession does the work of RefreshSession, refreshMu must be held.
+func (client *Client) refreshSession(ctx context.Context) (err error) {
+	client.mu.Lock()
+	refreshJwt := client.RefreshJwt
+	client.mu.Unlock()
 	defer func() {
 		if err != nil {
+			client.mu.Lock()
 			client.isAthorized = false
+			client.mu.Unlock()
 		}
 	}()
-	// Construct the request payload. The API expects the refresh token in a field,
-	// here we assume it is named "refresh".
-	reqPayload := map[string]string{
-		"refresh": client.RefreshJwt,
-	}
-	jsonBody, err := json.Marshal(reqPayload)
-	if err != nil {
-		return fmt.Errorf("failed to marshal refresh request body: %w", err)
-	}
 
-	url := baseURL + "/xrpc/com.atproto.server.refreshSession"
-	req, err := http.NewRequest("POST", url, bytes.NewReader(jsonBody))
+	url := client.Server + "/xrpc/com.atproto.server.refreshSession"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create refresh request: %w", err)
 	}
-	req.Header.Set("Content-Type", "application/json")
-	// Optionally include the current access token in the header.
-	req.Header.Set("Authorization", "Bearer "+client.AccessJwt)
+	req.Header.Set("Authorization", "Bearer "+refreshJwt)
 
 	resp, err := client.HttpClient.Do(req)
 	if err != nil {
@@ -82,41 +251,118 @@ func (client *Client) RefreshSession() (err error) {
 		return fmt.Errorf("refresh request returned non-OK status: %s", string(body))
 	}
 
-	// Assume the response JSON contains new accessJwt and refreshJwt fields.
-	var refreshResp struct {
-		AccessJwt  string `json:"accessJwt"`
-		RefreshJwt string `json:"refreshJwt"`
-	}
+	// The response has the same shape as the createSession one.
+	var refreshResp CreateSessionResponse
 	if err := json.Unmarshal(body, &refreshResp); err != nil {
 		return fmt.Errorf("failed to unmarshal refresh response: %w", err)
 	}
 
-	// Update the client with the new tokens.
+	client.mu.Lock()
+	// the session might have been dropped or replaced by a new login while we waited, that one wins.
+	if client.RefreshJwt != refreshJwt {
+		client.mu.Unlock()
+		return nil
+	}
+	// Update the client with the new tokens, a refreshed session is a valid one even if the previous refresh failed.
+	client.isAthorized = true
+	client.previousAccessJwt = client.AccessJwt
 	client.AccessJwt = refreshResp.AccessJwt
 	client.RefreshJwt = refreshResp.RefreshJwt
+	if refreshResp.Did != "" {
+		client.Did = refreshResp.Did
+	}
+	if refreshResp.Handle != "" {
+		client.Handle = refreshResp.Handle
+	}
+	client.mu.Unlock()
+	client.sessionChanged()
 	return nil
 }
 
-// StartSessionRefresher starts a goroutine that periodically refreshes the session
-// using the provided interval. The refresher will run until a signal is sent on stopChan.
+// Backoff bounds for retrying a failed session refresh.
+const (
+	refreshMinBackoff = 5 * time.Second
+	refreshMaxBackoff = 5 * time.Minute
+)
+
+// refreshAhead is how long before the access token expires the session is refreshed, so no request (i.e. the upload
+// of a video) goes out with a token about to expire.
+const refreshAhead = 5 * time.Minute
+
+// nextRefresh returns how long after now the session should be refreshed: refreshAhead before the access token
+// expires or, if its expiry can't be read, after fallback.
+func (client *Client) nextRefresh(now time.Time, fallback time.Duration) time.Duration {
+	expiry, err := client.SessionExpiry()
+	if err != nil {
+		client.logger.Debug("access token expiry unknown, refreshing after a fixed interval", "interval", fallback,
+			"err", err)
+		return fallback
+	}
+	return max(expiry.Sub(now)-refreshAhead, refreshMinBackoff)
+}
+
+// StartSessionRefresher refreshes the session shortly before the access token expires (see refreshAhead), or every
+// interval if its expiry can't be read, if refreshing fails it tries to re-authenticate and, if that fails too,
+// retries with exponential backoff (capped at the interval). Failing is no reason to stop, it runs until the context
+// is canceled or the client logs out and only one refresher runs per client, calling it while one is running does
+// nothing.
 func (client *Client) StartSessionRefresher(ctx context.Context, interval time.Duration) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+	client.mu.Lock()
+	if client.stopRefresher != nil {
+		client.mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	stop := &cancel
+	client.stopRefresher = stop
+	client.mu.Unlock()
+	defer func() {
+		cancel()
+		client.mu.Lock()
+		// Logout might have stopped us already and a new login started another refresher.
+		if client.stopRefresher == stop {
+			client.stopRefresher = nil
+		}
+		client.mu.Unlock()
+	}()
 
+	// the refresher goes through the same single flight refresh as the requests that find the token expired, stale is
+	// the access token it means to replace, if a request refreshed it in the meantime there is nothing left to do.
+	stale := client.accessToken()
+	timer := time.NewTimer(client.nextRefresh(time.Now(), interval))
+	defer timer.Stop()
+	backoff := min(refreshMinBackoff, interval)
 	for {
 		select {
-		case <-ticker.C:
-			if err := client.RefreshSession(); err != nil {
-				log.Printf("Failed to refresh session: %v", err)
+		case <-timer.C:
+			client.mu.Lock()
+			loggedOut, username, appPassword := client.RefreshJwt == "", client.username, client.appPassword
+			client.mu.Unlock()
+			// a failed refresh unauthorizes the client but leaves the refresh token, only logging out drops it.
+			if loggedOut {
+				client.logger.Debug("logged out, stopping session refresher", "handle", client.handle())
+				return
+			}
+			err := client.refreshStale(ctx, stale)
+			if err != nil {
+				client.logger.Warn("refreshing session", "handle", client.handle(), "err", err)
 				// If the refresh fails, attempt to re-authenticate.
-				err = client.AuthenticateBluesky(ctx, client.username, client.appPassword)
+				err = client.AuthenticateBluesky(ctx, username, appPassword)
 				if err != nil {
-					log.Printf("Failed to re-authenticate: %v", err)
+					client.logger.Error("re-authenticating", "handle", client.handle(), "err", err)
 				}
-				return
 			}
+			if err == nil {
+				backoff = min(refreshMinBackoff, interval)
+				stale = client.accessToken()
+				timer.Reset(client.nextRefresh(time.Now(), interval))
+				continue
+			}
+			client.logger.Info("retrying session refresh", "handle", client.handle(), "backoff", backoff)
+			timer.Reset(backoff)
+			backoff = min(backoff*2, refreshMaxBackoff, interval)
 		case <-ctx.Done():
-			log.Println("Stopping bsky session refresher")
+			client.logger.Debug("stopping session refresher", "handle", client.handle())
 			return
 		}
 	}
@@ -124,16 +370,36 @@ func (client *Client) StartSessionRefresher(ctx context.Context, interval time.D
 
 // IsAuthorized returns true if the client is authorized to make requests.
 func (client *Client) IsAuthorized() bool {
+	client.mu.Lock()
+	defer client.mu.Unlock()
 	return client.isAthorized
 }
 
+// Logout drops the session and the credentials of the client, it is no longer authorized until authenticated again
+// and its session refresher stops.
+func (client *Client) Logout() {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	// stop the refresher first, so it does not re-authenticate with the credentials we are about to drop.
+	if client.stopRefresher != nil {
+		(*client.stopRefresher)()
+		client.stopRefresher = nil
+	}
+	client.isAthorized = false
+	client.username = ""
+	client.appPassword = ""
+	client.AccessJwt = ""
+	client.RefreshJwt = ""
+	client.Did = ""
+	client.Handle = ""
+	client.previousAccessJwt = ""
+}
+
 // AuthenticateBluesky logs in to Bluesky using the provided identifier (handle)
 // and app password. On success, it returns a Client with the access tokens.
 // According to the official Bluesky Get Started docs (https://docs.bsky.app/docs/get-started),
 // you must call the com.atproto.server.createSession endpoint.
 func (client *Client) AuthenticateBluesky(ctx context.Context, identifier, password string) error {
-	client.username = identifier
-	client.appPassword = password
 	reqBody := CreateSessionRequest{
 		Identifier: identifier,
 		Password:   password,
@@ -143,8 +409,13 @@ func (client *Client) AuthenticateBluesky(ctx context.Context, identifier, passw
 		return fmt.Errorf("marshaling session request body: %w", err)
 	}
 
-	url := baseURL + "/xrpc/com.atproto.server.createSession"
-	resp, err := http.Post(url, "application/json", bytes.NewReader(jsonBody))
+	url := client.Server + "/xrpc/com.atproto.server.createSession"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jsonBody))
+	if err != nil {
+		return fmt.Errorf("creating session request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.HttpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("POSTing request to create session: %w", err)
 	}
@@ -164,12 +435,26 @@ func (client *Client) AuthenticateBluesky(ctx context.Context, identifier, passw
 		return fmt.Errorf("unmarshaling session response: %w", err)
 	}
 
+	// a new session is a good moment to stop trusting what we resolved so far.
+	client.handles.clear()
+	client.mu.Lock()
+	// the refresher re-authenticating with a context Logout canceled must not bring the session back.
+	if err := ctx.Err(); err != nil {
+		client.mu.Unlock()
+		return fmt.Errorf("authenticating: %w", err)
+	}
+	client.username = identifier
+	client.appPassword = password
 	client.isAthorized = true
+	client.previousAccessJwt = client.AccessJwt
 	client.AccessJwt = sessionResp.AccessJwt
 	client.RefreshJwt = sessionResp.RefreshJwt
 	client.Did = sessionResp.Did
 	client.Handle = sessionResp.Handle
+	client.mu.Unlock()
+	client.sessionChanged()
 
+	// this is a no-op if a refresher is already running, i.e. when re-authenticating from it.
 	go client.StartSessionRefresher(ctx, 10*time.Minute)
 	return nil
 }
@@ -188,18 +473,18 @@ func (client *Client) AuthenticateBluesky(ctx context.Context, identifier, passw
 // UploadImageBlob uploads imageData (e.g. JPEG bytes) to Bluesky's blob storage.
 // The MIME type should be provided (e.g. "image/jpeg").
 // It returns the blob reference that can be used in a post embed.
-func (client *Client) UploadImageBlob(imageData []byte, mimeType string) (*ImageUploadResponse, error) {
-	url := baseURL + "/xrpc/com.atproto.repo.uploadBlob"
-	req, err := http.NewRequest("POST", url, bytes.NewReader(imageData))
+func (client *Client) UploadImageBlob(ctx context.Context, imageData []byte, mimeType string) (*ImageUploadResponse, error) {
+	url := client.Server + "/xrpc/com.atproto.repo.uploadBlob"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(imageData))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create upload blob request: %w", err)
 	}
 	// Set the MIME type of the image.
 	req.Header.Set("Content-Type", mimeType)
 	// Use the authenticated access token.
-	req.Header.Set("Authorization", "Bearer "+client.AccessJwt)
+	client.authorize(req)
 
-	resp, err := client.HttpClient.Do(req)
+	resp, err := client.doWithRetry(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute upload blob request: %w", err)
 	}
@@ -209,7 +494,7 @@ func (client *Client) UploadImageBlob(imageData []byte, mimeType string) (*Image
 	if err != nil {
 		return nil, fmt.Errorf("failed to read upload blob response: %w", err)
 	}
-	fmt.Printf("upload blob response: %s\n", string(body))
+	client.logger.Debug("upload blob response", "status", resp.StatusCode, "body", string(body))
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("upload blob returned non-OK status: %s", string(body))
 	}
@@ -244,18 +529,25 @@ type PostableImage struct {
 	MimeType string
 }
 
+// fillImageMeta determines the MIME type and, when the format is one we can read, the dimensions of the image. Not
+// knowing the dimensions is not an error, the post just goes without aspect ratio.
 func (postableImage *PostableImage) fillImageMeta() error {
 	// Determine the MIME type using a sample of the byte slice.
-	mimeType := http.DetectContentType(postableImage.ImageRaw)
+	postableImage.MimeType = http.DetectContentType(postableImage.ImageRaw)
 
 	// Use image.DecodeConfig to efficiently get the image dimensions.
-	cfg, _, err := image.DecodeConfig(bytes.NewReader(postableImage.ImageRaw))
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(postableImage.ImageRaw))
 	if err != nil {
-		return fmt.Errorf("decoding image config: %w", err)
+		slog.Warn("could not determine image dimensions, posting without aspect ratio", "mime_type",
+			postableImage.MimeType, "err", err)
+		return nil
+	}
+	if format == "heif" {
+		// http.DetectContentType does not know about HEIF.
+		postableImage.MimeType = "image/heic"
 	}
 	postableImage.Width = cfg.Width
 	postableImage.Height = cfg.Height
-	postableImage.MimeType = mimeType
 
 	return nil
 }
@@ -273,6 +565,12 @@ func NewPostableImage(imageRaw []byte, altText string) (*PostableImage, error) {
 	return pi, nil
 }
 
+// PostURLFromATURI converts the at:// URI of a post into its https://bsky.app link, it returns an empty string if the
+// URI is not a post one.
+func PostURLFromATURI(atURI string) string {
+	return atURIToHTTPSBsky(atURI)
+}
+
 // atURIToHTTPSBsky converts an at:// URI to an HTTPS Bluesky link.
 func atURIToHTTPSBsky(atURI string) string {
 	// at://<DID>/<COLLECTION>/<RKEY>
@@ -280,14 +578,14 @@ func atURIToHTTPSBsky(atURI string) string {
 	atURINoSchema := strings.TrimPrefix(atURI, "at://") // url.Parse does not like DIDs
 	parts := strings.Split(atURINoSchema, "/")
 	if len(parts) < 3 {
-		log.Printf("invalid URI: %s", atURI)
+		slog.Warn("invalid URI", "uri", atURI)
 		return ""
 	}
 	did := parts[0]
 	collection := parts[1]
 	rkey := parts[2]
 	if collection != "app.bsky.feed.post" {
-		log.Printf("unsupported collection: %s", collection)
+		slog.Warn("unsupported collection", "collection", collection)
 		return ""
 	}
 	return fmt.Sprintf("https://bsky.app/profile/%s/post/%s", did, rkey)
@@ -325,110 +623,264 @@ func splitTextIntoBSKyPalatableChunks(text string) []string {
 // For details on the expected JSON structure, see the Bluesky API reference https://docs.bsky.app/docs/tutorials/creating-a-post
 // It tries to return the URL to the bluesky post.
 func (client *Client) PostToBluesky(text string, images []*PostableImage, lang []string) (string, error) {
-	var embeds []EmbedImage
-	if lang == nil {
-		lang = []string{"en"} // not a sane default, my default for this example.
+	return client.PostToBlueskyReply(context.Background(), nil, text, images, lang)
+}
+
+// replyForParent builds the reply references for a post answering parent, the thread root is the parent's own root
+// when the parent is itself a reply, otherwise the parent is the root.
+func (client *Client) replyForParent(ctx context.Context, parent *ReplyRef) (*Reply, error) {
+	view, err := client.GetPost(ctx, parent.Uri)
+	if err != nil {
+		return nil, fmt.Errorf("getting parent post: %w", err)
 	}
-	for _, img := range images {
-		uploadResp, err := client.UploadImageBlob(img.ImageRaw, img.MimeType)
-		if err != nil {
-			return "", fmt.Errorf("failed to upload image: %w", err)
-		}
-		embed := EmbedImage{
+	if parent.Cid == "" {
+		parent.Cid = view.Cid
+	}
+	root := parent
+	if view.Record.Reply != nil && view.Record.Reply.Root != nil {
+		root = view.Record.Reply.Root
+	}
+	return &Reply{
+		Root:   root,
+		Parent: parent,
+	}, nil
+}
 
-			Alt: img.AltText,
-			Image: ImageUploadResponse{
-				Type:     BlobType,
-				Ref:      Ref{Link: uploadResp.Ref.Link},
-				MimeType: img.MimeType,
-				Size:     len(img.ImageRaw),
-			},
-			AspectRatio: EmbedAspectRatio{
-				Width:  img.Width,
-				Height: img.Height,
-			},
-		}
-		embeds = append(embeds, embed)
+// PostToBlueskyReply works like PostToBluesky but, when parent is not nil, the post is created as a reply to it.
+// If the parent CID is not known it is fetched along with the parent record.
+func (client *Client) PostToBlueskyReply(ctx context.Context, parent *ReplyRef, text string, images []*PostableImage, lang []string) (string, error) {
+	return client.PostThreadToBluesky(ctx, parent, splitTextIntoBSKyPalatableChunks(text), images, lang)
+}
+
+// PostThreadToBluesky posts each of the already split chunks as a thread, each chunk replying to the previous one,
+// images are attached to the first one. If parent is not nil the whole thread answers it.
+// It returns the URL of the first post of the thread.
+func (client *Client) PostThreadToBluesky(ctx context.Context, parent *ReplyRef, chunks []string, images []*PostableImage, lang []string) (string, error) {
+	records, err := client.PostThreadRecords(ctx, parent, chunks, images, nil, lang)
+	if err != nil {
+		return "", err
 	}
-	chunks := splitTextIntoBSKyPalatableChunks(text)
-	var root *CreateRecordResponse
-	var parent *CreateRecordResponse
-	var postResps []CreateRecordResponse
-	for i, chunk := range chunks {
-		facets, err := ParseFacets(chunk, baseURL)
+	return atURIToHTTPSBsky(records[0].Uri), nil
+}
+
+// PostThreadRecords works like PostThreadToBluesky but returns the references to every post of the thread, in order.
+// If quote is not nil the first post quotes it.
+func (client *Client) PostThreadRecords(ctx context.Context, parent *ReplyRef, chunks []string, images []*PostableImage, quote *ReplyRef, lang []string) ([]CreateRecordResponse, error) {
+	// images are uploaded concurrently, the embeds keep their order so each alt text stays with its image.
+	embeds, err := parallel.Map(ctx, images, parallel.DefaultWorkers,
+		func(ctx context.Context, i int, img *PostableImage) (EmbedImage, error) {
+			uploadResp, err := client.UploadImageBlob(ctx, img.ImageRaw, img.MimeType)
+			if err != nil {
+				return EmbedImage{}, fmt.Errorf("failed to upload image %d: %w", i, err)
+			}
+			embed := EmbedImage{
+				Alt: img.AltText,
+				Image: ImageUploadResponse{
+					Type:     BlobType,
+					Ref:      Ref{Link: uploadResp.Ref.Link},
+					MimeType: img.MimeType,
+					Size:     len(img.ImageRaw),
+				},
+			}
+			if img.Width > 0 && img.Height > 0 {
+				embed.AspectRatio = &EmbedAspectRatio{
+					Width:  img.Width,
+					Height: img.Height,
+				}
+			}
+			return embed, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	var media *PostEmbed
+	if len(embeds) > 0 {
+		media = &PostEmbed{Type: EmbedImagesType, Images: embeds}
+	}
+	return client.postThread(ctx, parent, chunks, media, quote, lang)
+}
+
+// PostVideoThreadRecords works like PostThreadRecords but the first post carries video instead of images.
+func (client *Client) PostVideoThreadRecords(ctx context.Context, parent *ReplyRef, chunks []string, video *PostableVideo, quote *ReplyRef, lang []string) ([]CreateRecordResponse, error) {
+	blob, err := client.UploadVideo(ctx, video)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload video: %w", err)
+	}
+	return client.postThread(ctx, parent, chunks, videoEmbed(video, *blob), quote, lang)
+}
+
+// postThread creates a post per chunk, each replying to the previous one, with the already uploaded media, if any, and
+// the quote of quote, if not nil, in the first one.
+func (client *Client) postThread(ctx context.Context, parent *ReplyRef, chunks []string, media *PostEmbed, quote *ReplyRef, lang []string) ([]CreateRecordResponse, error) {
+	if quote != nil {
+		media = quoteEmbed(quote, media)
+	}
+	var reply *Reply
+	if parent != nil {
+		var err error
+		reply, err = client.replyForParent(ctx, parent)
 		if err != nil {
-			log.Printf("failed to parse facets: %v", err)
+			return nil, fmt.Errorf("resolving reply references: %w", err)
 		}
-		record := PostRecord{
-			Type:      PostRecordType,
-			Text:      chunk,
-			CreatedAt: time.Now().UTC().Format(time.RFC3339),
-			Langs:     lang,
-		}
-		// adding embeds only to the first chunk, it seems free but would distract from reading
-		if len(embeds) > 0 && i == 0 {
-			record.Embed = &PostEmbed{
-				Type:   EmbedImagesType,
-				Images: embeds,
+	}
+	// A post with a single link and no media gets a link card for it, on whichever chunk the link ended.
+	var external *ExternalEmbed
+	externalChunk := -1
+	if media == nil {
+		linkCount := 0
+		for i, chunk := range chunks {
+			if urls := parseURLs(chunk); len(urls) > 0 {
+				linkCount += len(urls)
+				externalChunk = i
 			}
 		}
-		if len(facets) > 0 {
-			record.Facets = facets
-		}
-		if parent != nil || root != nil {
-			record.Reply = &Reply{
-				Root:   root,
-				Parent: parent,
+		if linkCount == 1 {
+			var err error
+			external, err = client.FetchExternalEmbed(ctx, parseURLs(chunks[externalChunk])[0].URL)
+			if err != nil {
+				client.logger.Warn("building link card, posting without it", "handle", client.handle(), "err", err)
 			}
 		}
+	}
+	var postResps []CreateRecordResponse
+	for i, chunk := range chunks {
+		record := client.chunkRecord(ctx, i, chunk, lang, media, external, externalChunk)
+		if reply != nil {
+			record.Reply = reply
+		}
 		recordReq := CreateRecordRequest{
-			// Use the handle (username) as the repo identifier.
-			Repo:       client.Handle,
+			Repo:       client.repo(),
 			Collection: "app.bsky.feed.post",
 			Record:     record,
 		}
 		jsonBody, err := json.Marshal(recordReq)
 		if err != nil {
-			return "", fmt.Errorf("failed to marshal post request: %w", err)
+			return nil, fmt.Errorf("failed to marshal post request: %w", err)
 		}
 
-		url := baseURL + "/xrpc/com.atproto.repo.createRecord"
-		req, err := http.NewRequest("POST", url, bytes.NewReader(jsonBody))
+		url := client.Server + "/xrpc/com.atproto.repo.createRecord"
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jsonBody))
 		if err != nil {
-			return "", fmt.Errorf("failed to create new post request: %w", err)
+			return nil, fmt.Errorf("failed to create new post request: %w", err)
 		}
 		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Authorization", "Bearer "+client.AccessJwt)
+		client.authorize(req)
 
-		resp, err := client.HttpClient.Do(req)
+		resp, err := client.doWithRetry(req)
 		if err != nil {
-			return "", fmt.Errorf("failed to execute post request: %w", err)
+			return nil, fmt.Errorf("failed to execute post request: %w", err)
 		}
-		defer resp.Body.Close()
-
+		// closed right away, not deferred, the connection is needed for the next part of the thread.
 		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
 		if err != nil {
-			return "", fmt.Errorf("failed to read post response body: %w", err)
+			return nil, fmt.Errorf("failed to read post response body: %w", err)
 		}
 		if resp.StatusCode != http.StatusOK {
-			jsonBody, _ := json.MarshalIndent(recordReq, "", "  ")
-			log.Printf("sending post body: %s", string(jsonBody))
-			return "", fmt.Errorf("post request returned non-OK status: %s", string(body))
+			// the part tells which post of the thread failed, what users write stays out of the logs.
+			client.logger.Error("creating record failed", "handle", client.handle(), "part", i, "status", resp.StatusCode)
+			return nil, fmt.Errorf("post request returned non-OK status: %s", string(body))
 		}
 
 		var postResp CreateRecordResponse
 		err = json.Unmarshal(body, &postResp)
 		if err != nil {
-			return "", fmt.Errorf("failed to unmarshal post response: %w", err)
-		}
-		if root == nil {
-			root = &postResp
+			return nil, fmt.Errorf("failed to unmarshal post response: %w", err)
 		}
-		if parent == nil {
-			parent = &postResp
+		// every following chunk answers the one we just posted, within the same thread.
+		posted := &ReplyRef{Uri: postResp.Uri, Cid: postResp.Cid}
+		if reply == nil {
+			reply = &Reply{Root: posted}
 		}
+		reply = &Reply{Root: reply.Root, Parent: posted}
 		postResps = append(postResps, postResp)
 	}
-	// FIXME: Modify all to return several URLs
-	return atURIToHTTPSBsky(postResps[0].Uri), nil
+	return postResps, nil
+}
+
+// chunkRecord builds the record for the i-th chunk of a thread, media only goes in the first one and the link card, if
+// any, in externalChunk.
+func (client *Client) chunkRecord(ctx context.Context, i int, chunk string, lang []string, media *PostEmbed,
+	external *ExternalEmbed, externalChunk int) PostRecord {
+	facets, err := ParseFacets(ctx, chunk, client.ResolveHandle)
+	if err != nil {
+		client.logger.Warn("parsing facets", "handle", client.handle(), "err", err)
+	}
+	record := PostRecord{
+		Type:      PostRecordType,
+		Text:      chunk,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Langs:     lang,
+	}
+	// adding embeds only to the first chunk, it seems free but would distract from reading
+	if media != nil && i == 0 {
+		record.Embed = media
+	}
+	if external != nil && i == externalChunk {
+		record.Embed = &PostEmbed{
+			Type:     EmbedExternalType,
+			External: external,
+		}
+	}
+	if len(facets) > 0 {
+		record.Facets = facets
+	}
+	return record
+}
+
+// PreviewThreadRecords builds the records PostThreadRecords would create, without creating them. Images are not
+// uploaded, so their blob references are empty, and link cards only carry the link, the rest is fetched when posting.
+// Mentions are still resolved, that is a read.
+func (client *Client) PreviewThreadRecords(ctx context.Context, chunks []string, images []*PostableImage, quote *ReplyRef, lang []string) []PostRecord {
+	var embeds []EmbedImage
+	for _, img := range images {
+		embed := EmbedImage{
+			Alt:   img.AltText,
+			Image: ImageUploadResponse{Type: BlobType, MimeType: img.MimeType, Size: len(img.ImageRaw)},
+		}
+		if img.Width > 0 && img.Height > 0 {
+			embed.AspectRatio = &EmbedAspectRatio{Width: img.Width, Height: img.Height}
+		}
+		embeds = append(embeds, embed)
+	}
+	var media *PostEmbed
+	if len(embeds) > 0 {
+		media = &PostEmbed{Type: EmbedImagesType, Images: embeds}
+	}
+	return client.previewThread(ctx, chunks, media, quote, lang)
+}
+
+// PreviewVideoThreadRecords works like PreviewThreadRecords for a post carrying video, which is not uploaded either.
+func (client *Client) PreviewVideoThreadRecords(ctx context.Context, chunks []string, video *PostableVideo, quote *ReplyRef, lang []string) []PostRecord {
+	return client.previewThread(ctx, chunks, videoEmbed(video, ImageUploadResponse{
+		Type:     BlobType,
+		MimeType: video.MimeType,
+		Size:     len(video.VideoRaw),
+	}), quote, lang)
+}
+
+// previewThread builds the records postThread would create with media and quote.
+func (client *Client) previewThread(ctx context.Context, chunks []string, media *PostEmbed, quote *ReplyRef, lang []string) []PostRecord {
+	if quote != nil {
+		media = quoteEmbed(quote, media)
+	}
+	var external *ExternalEmbed
+	externalChunk := -1
+	if media == nil {
+		var links []string
+		for i, chunk := range chunks {
+			for _, u := range parseURLs(chunk) {
+				links = append(links, u.URL)
+				externalChunk = i
+			}
+		}
+		if len(links) == 1 {
+			external = &ExternalEmbed{Uri: links[0]}
+		}
+	}
+	records := make([]PostRecord, len(chunks))
+	for i, chunk := range chunks {
+		records[i] = client.chunkRecord(ctx, i, chunk, lang, media, external, externalChunk)
+	}
+	return records
 }
@@ -0,0 +1,295 @@
+package bluesky
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client talking to a test server handling requests with handler, retrying without delays.
+func newTestClient(t *testing.T, handler http.Handler) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	client := NewClient(WithServer(server.URL), WithTimeout(5*time.Second))
+	client.Retry = RetryPolicy{Attempts: 3}
+	return client
+}
+
+// fakePDS is a PDS that stores every blob and creates every record it is sent.
+type fakePDS struct {
+	mu      sync.Mutex
+	blobs   int
+	records []PostRecord
+}
+
+func (f *fakePDS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.URL.Path {
+	case "/xrpc/com.atproto.repo.uploadBlob":
+		f.blobs++
+		data, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, `{"blob":{"$type":"blob","ref":{"$link":"blob%d"},"mimeType":%q,"size":%d}}`, f.blobs,
+			r.Header.Get("Content-Type"), len(data))
+	case "/xrpc/com.atproto.repo.createRecord":
+		var req CreateRecordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.records = append(f.records, req.Record)
+		n := len(f.records)
+		fmt.Fprintf(w, `{"uri":"at://did:plc:me/app.bsky.feed.post/%d","cid":"cid%d"}`, n, n)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// created returns the records created, in order.
+func (f *fakePDS) created() []PostRecord {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.records
+}
+
+// webpHeader returns the start of a lossless WebP image of the given dimensions, enough to tell them.
+func webpHeader(width, height int) []byte {
+	vp8l := make([]byte, 5, 6)
+	vp8l[0] = 0x2f
+	binary.LittleEndian.PutUint32(vp8l[1:], uint32(width-1)|uint32(height-1)<<14)
+	vp8l = append(vp8l, 0) // chunks are padded to an even size.
+	data := []byte("RIFF\x00\x00\x00\x00WEBPVP8L\x05\x00\x00\x00")
+	data = append(data, vp8l...)
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+	return data
+}
+
+func TestNewPostableImageWebPDimensions(t *testing.T) {
+	img, err := NewPostableImage(webpHeader(300, 200), "alt")
+	if err != nil {
+		t.Fatalf("NewPostableImage: %v", err)
+	}
+	if img.Width != 300 || img.Height != 200 {
+		t.Errorf("dimensions = %dx%d, want 300x200", img.Width, img.Height)
+	}
+	if img.MimeType != "image/webp" {
+		t.Errorf("MimeType = %q, want image/webp", img.MimeType)
+	}
+}
+
+func TestUndecodableImagePostsWithoutAspectRatio(t *testing.T) {
+	img, err := NewPostableImage([]byte("not an image at all"), "alt")
+	if err != nil {
+		t.Fatalf("NewPostableImage: %v", err)
+	}
+	if img.Width != 0 || img.Height != 0 {
+		t.Errorf("dimensions = %dx%d, want none", img.Width, img.Height)
+	}
+	pds := &fakePDS{}
+	client := newTestClient(t, pds)
+	if _, err := client.PostThreadRecords(context.Background(), nil, []string{"hello"}, []*PostableImage{img}, nil,
+		nil); err != nil {
+		t.Fatalf("PostThreadRecords: %v", err)
+	}
+	records := pds.created()
+	if len(records) != 1 || records[0].Embed == nil || len(records[0].Embed.Images) != 1 {
+		t.Fatalf("records = %+v, want one with an image", records)
+	}
+	if ratio := records[0].Embed.Images[0].AspectRatio; ratio != nil {
+		t.Errorf("aspect ratio = %+v, want none", ratio)
+	}
+}
+
+func TestRefreshSessionSendsTheRefreshToken(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /xrpc/com.atproto.server.refreshSession", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer refresh-1" {
+			t.Errorf("Authorization = %q, want the refresh token", got)
+		}
+		if body, _ := io.ReadAll(r.Body); len(body) != 0 {
+			t.Errorf("body = %q, want none", body)
+		}
+		_, _ = io.WriteString(w, `{"accessJwt":"access-2","refreshJwt":"refresh-2","did":"did:plc:me","handle":"me.bsky.social"}`)
+	})
+	client := newTestClient(t, mux)
+	client.AccessJwt, client.RefreshJwt = "access-1", "refresh-1"
+
+	if err := client.RefreshSession(context.Background()); err != nil {
+		t.Fatalf("RefreshSession: %v", err)
+	}
+	if client.AccessJwt != "access-2" || client.RefreshJwt != "refresh-2" {
+		t.Errorf("tokens = %q, %q, want the refreshed ones", client.AccessJwt, client.RefreshJwt)
+	}
+	if client.Did != "did:plc:me" || client.Handle != "me.bsky.social" {
+		t.Errorf("did, handle = %q, %q, want those of the response", client.Did, client.Handle)
+	}
+}
+
+func TestRefreshSessionFailureUnauthorizes(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"ExpiredToken"}`, http.StatusBadRequest)
+	}))
+	client.isAthorized = true
+	client.RefreshJwt = "refresh-1"
+	if err := client.RefreshSession(context.Background()); err == nil {
+		t.Fatal("RefreshSession succeeded with a rejected token")
+	}
+	if client.IsAuthorized() {
+		t.Error("the client is still authorized after failing to refresh")
+	}
+}
+
+func TestSessionRefresherSurvivesFailures(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+	refreshed := make(chan int, 10)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /xrpc/com.atproto.server.refreshSession", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		n := attempts
+		mu.Unlock()
+		if n <= 2 {
+			http.Error(w, `{"error":"InternalServerError"}`, http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, `{"accessJwt":"access-%d","refreshJwt":"refresh-%d"}`, n, n)
+		select {
+		case refreshed <- n:
+		default:
+		}
+	})
+	mux.HandleFunc("POST /xrpc/com.atproto.server.createSession", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"AuthenticationRequired"}`, http.StatusUnauthorized)
+	})
+	client := newTestClient(t, mux)
+	client.isAthorized = true
+	client.AccessJwt, client.RefreshJwt = "access-0", "refresh-0"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		client.StartSessionRefresher(ctx, 10*time.Millisecond)
+	}()
+	// the third attempt works and the refresher keeps going after it.
+	for _, want := range []int{3, 4} {
+		select {
+		case n := <-refreshed:
+			if n != want {
+				t.Errorf("refresh %d worked, want %d", n, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no refresh %d, the refresher stopped", want)
+		}
+	}
+	cancel()
+	<-stopped
+}
+
+func TestLogoutStopsTheSessionRefresher(t *testing.T) {
+	client := newTestClient(t, http.NotFoundHandler())
+	client.isAthorized = true
+	client.AccessJwt, client.RefreshJwt, client.username, client.appPassword = "access-0", "refresh-0", "me", "secret"
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		client.StartSessionRefresher(context.Background(), time.Hour)
+	}()
+	// wait for the refresher to start, logging out before it does leaves nothing to stop.
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		client.mu.Lock()
+		running := client.stopRefresher != nil
+		client.mu.Unlock()
+		if running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the session refresher did not start")
+		}
+	}
+	client.Logout()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the session refresher kept running after Logout")
+	}
+	if session := client.Session(); session != (Session{}) || client.IsAuthorized() {
+		t.Errorf("session = %+v, authorized = %t, want none after Logout", session, client.IsAuthorized())
+	}
+}
+
+func TestRequestsGoToTheConfiguredServer(t *testing.T) {
+	var paths []string
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		_, _ = io.WriteString(w, `{"accessJwt":"access","refreshJwt":"refresh","did":"did:plc:me","handle":"me.example.com"}`)
+	}))
+	defer server.Close()
+	// the trailing slash must not end up doubled in the paths.
+	client := NewClient(WithServer(server.URL + "/"))
+	if client.Server != server.URL {
+		t.Errorf("Server = %q, want %q", client.Server, server.URL)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := client.AuthenticateBluesky(ctx, "me.example.com", "app-password"); err != nil {
+		t.Fatalf("AuthenticateBluesky: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(paths) != 1 || paths[0] != "POST /xrpc/com.atproto.server.createSession" {
+		t.Errorf("requests = %q, want a createSession", paths)
+	}
+}
+
+func TestDefaultServer(t *testing.T) {
+	if client := NewClient(WithServer("")); client.Server != DefaultServer {
+		t.Errorf("Server = %q, want %q", client.Server, DefaultServer)
+	}
+}
+
+// hangingServer answers no request, it holds each until the client gives up.
+var hangingServer = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	// the server only notices the client is gone once the body was read.
+	_, _ = io.Copy(io.Discard, r.Body)
+	<-r.Context().Done()
+})
+
+func TestRequestsGiveUpOnServersThatHang(t *testing.T) {
+	client := newTestClient(t, hangingServer)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := client.AuthenticateBluesky(ctx, "me.bsky.social", "app-password")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("AuthenticateBluesky() = %v, want the deadline of the context exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("gave up after %s, want it to stop with the context", elapsed)
+	}
+
+	// without a deadline of their own, requests are bounded by the timeout of the client.
+	server := httptest.NewServer(hangingServer)
+	defer server.Close()
+	client = NewClient(WithServer(server.URL), WithTimeout(50*time.Millisecond))
+	client.Retry = RetryPolicy{Attempts: 1}
+	var timeout interface{ Timeout() bool }
+	if _, err := client.ResolveHandle(context.Background(), "me.bsky.social"); !errors.As(err, &timeout) ||
+		!timeout.Timeout() {
+		t.Errorf("ResolveHandle() = %v, want a timeout", err)
+	}
+}
@@ -0,0 +1,173 @@
+package bluesky
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// maxLinkPageSize is how much of a linked page we read looking for OpenGraph tags, they live in the head so there is
+// no need to go further.
+const maxLinkPageSize = 512 << 10
+
+// maxThumbnailSize is the largest thumbnail we download for a link card, bluesky rejects bigger blobs anyway.
+const maxThumbnailSize = 1000000
+
+// openGraph holds the bits of the OpenGraph metadata of a page we use to build a link card.
+type openGraph struct {
+	Title       string
+	Description string
+	Image       string
+}
+
+// parseOpenGraph extracts the OpenGraph title, description and image from an HTML page, falling back to the <title>
+// and description meta tags when the page has no OpenGraph ones.
+func parseOpenGraph(r io.Reader) openGraph {
+	var og openGraph
+	var title, description string
+	inTitle := false
+	tokenizer := html.NewTokenizer(r)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if og.Title == "" {
+				og.Title = strings.TrimSpace(title)
+			}
+			if og.Description == "" {
+				og.Description = description
+			}
+			return og
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := tokenizer.TagName()
+			switch string(name) {
+			case "title":
+				inTitle = true
+			case "meta":
+				var property, content string
+				for hasAttr {
+					var key, val []byte
+					key, val, hasAttr = tokenizer.TagAttr()
+					switch string(key) {
+					case "property", "name":
+						property = strings.ToLower(string(val))
+					case "content":
+						content = string(val)
+					}
+				}
+				switch property {
+				case "og:title":
+					og.Title = content
+				case "og:description":
+					og.Description = content
+				case "og:image":
+					og.Image = content
+				case "description":
+					description = content
+				}
+			}
+		case html.TextToken:
+			if inTitle {
+				title += string(tokenizer.Text())
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			switch string(name) {
+			case "title":
+				inTitle = false
+			case "head":
+				// everything we care about is in the head.
+				if og.Title == "" {
+					og.Title = strings.TrimSpace(title)
+				}
+				if og.Description == "" {
+					og.Description = description
+				}
+				return og
+			}
+		}
+	}
+}
+
+// getLimited GETs the given URL and returns at most limit bytes of the body, it fails if the body is bigger.
+func (client *Client) getLimited(ctx context.Context, target string, limit int64) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("creating request: %w", err)
+	}
+	resp, err := client.HttpClient.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("executing request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("GET %s returned non-OK status: %s", target, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("reading response: %w", err)
+	}
+	if int64(len(body)) > limit {
+		return nil, "", fmt.Errorf("GET %s: response larger than %d bytes", target, limit)
+	}
+	return body, resp.Header.Get("Content-Type"), nil
+}
+
+// FetchExternalEmbed builds a link card for link out of its OpenGraph metadata, the thumbnail, if any, is uploaded as
+// a blob. A thumbnail that can not be fetched or is too big is simply left out.
+func (client *Client) FetchExternalEmbed(ctx context.Context, link string) (*ExternalEmbed, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating link request: %w", err)
+	}
+	resp, err := client.HttpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching link: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching link returned non-OK status: %s", resp.Status)
+	}
+	if !strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		return nil, fmt.Errorf("link is not an HTML page: %s", resp.Header.Get("Content-Type"))
+	}
+	og := parseOpenGraph(io.LimitReader(resp.Body, maxLinkPageSize))
+	if og.Title == "" && og.Description == "" {
+		return nil, fmt.Errorf("link has no title nor description")
+	}
+
+	external := &ExternalEmbed{
+		Uri:         link,
+		Title:       og.Title,
+		Description: og.Description,
+	}
+	if og.Image == "" {
+		return external, nil
+	}
+	// og:image can be relative to the page.
+	base, err := url.Parse(link)
+	if err != nil {
+		return external, nil
+	}
+	imageURL, err := base.Parse(og.Image)
+	if err != nil {
+		return external, nil
+	}
+	thumb, mimeType, err := client.getLimited(ctx, imageURL.String(), maxThumbnailSize)
+	if err != nil {
+		return external, nil
+	}
+	if !strings.HasPrefix(mimeType, "image/") {
+		mimeType = http.DetectContentType(thumb)
+	}
+	uploadResp, err := client.UploadImageBlob(ctx, thumb, mimeType)
+	if err != nil {
+		return external, nil
+	}
+	external.Thumb = uploadResp
+	return external, nil
+}
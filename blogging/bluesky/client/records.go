@@ -0,0 +1,127 @@
+package bluesky
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrNotAPostURL is returned when a URL does not look like a bluesky post URL.
+var ErrNotAPostURL = errors.New("not a bluesky post URL")
+
+// resolveActorDid returns the DID for an actor, which can be either a DID already or a handle.
+func (client *Client) resolveActorDid(ctx context.Context, actor string) (string, error) {
+	if strings.HasPrefix(actor, "did:") {
+		return actor, nil
+	}
+	return client.ResolveHandle(ctx, actor)
+}
+
+// ATURIFromPostURL converts a https://bsky.app/profile/<handle or DID>/post/<rkey> URL into the at:// URI of the
+// post, resolving the handle into a DID if needed.
+func (client *Client) ATURIFromPostURL(ctx context.Context, postURL string) (string, error) {
+	u, err := url.Parse(postURL)
+	if err != nil {
+		return "", fmt.Errorf("parsing post URL: %w", err)
+	}
+	// /profile/<actor>/post/<rkey>
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if u.Host != "bsky.app" || len(parts) != 4 || parts[0] != "profile" || parts[2] != "post" {
+		return "", fmt.Errorf("%s: %w", postURL, ErrNotAPostURL)
+	}
+	did, err := client.resolveActorDid(ctx, parts[1])
+	if err != nil {
+		return "", fmt.Errorf("resolving %s: %w", parts[1], err)
+	}
+	return fmt.Sprintf("at://%s/%s/%s", did, PostRecordType, parts[3]), nil
+}
+
+// PostRefFromURL resolves a https://bsky.app post URL into a strong reference to the post, i.e. to quote it.
+func (client *Client) PostRefFromURL(ctx context.Context, postURL string) (*ReplyRef, error) {
+	atURI, err := client.ATURIFromPostURL(ctx, postURL)
+	if err != nil {
+		return nil, err
+	}
+	view, err := client.GetPost(ctx, atURI)
+	if err != nil {
+		return nil, fmt.Errorf("getting post: %w", err)
+	}
+	return &ReplyRef{Uri: view.Uri, Cid: view.Cid}, nil
+}
+
+// GetPost fetches the post with the given at:// URI through app.bsky.feed.getPosts.
+func (client *Client) GetPost(ctx context.Context, atURI string) (*PostView, error) {
+	getURL := fmt.Sprintf("%s/xrpc/app.bsky.feed.getPosts?uris=%s", client.Server, url.QueryEscape(atURI))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, getURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating get posts request: %w", err)
+	}
+	client.authorize(req)
+
+	resp, err := client.HttpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("executing get posts request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading get posts response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get posts returned non-OK status (%d): %s", resp.StatusCode, string(body))
+	}
+	var postsResp GetPostsResponse
+	if err := json.Unmarshal(body, &postsResp); err != nil {
+		return nil, fmt.Errorf("unmarshaling get posts response: %w", err)
+	}
+	if len(postsResp.Posts) == 0 {
+		return nil, fmt.Errorf("no post found for %s", atURI)
+	}
+	return &postsResp.Posts[0], nil
+}
+
+// DeleteRecordRequest defines the request for deleting a record.
+type DeleteRecordRequest struct {
+	Repo       string `json:"repo"`
+	Collection string `json:"collection"`
+	Rkey       string `json:"rkey"`
+}
+
+// DeletePost deletes the post with the given at:// URI, which must belong to the authenticated user.
+func (client *Client) DeletePost(ctx context.Context, atURI string) error {
+	// at://<DID>/<COLLECTION>/<RKEY>
+	parts := strings.Split(strings.TrimPrefix(atURI, "at://"), "/")
+	if len(parts) != 3 || parts[1] != string(PostRecordType) {
+		return fmt.Errorf("%s: %w", atURI, ErrNotAPostURL)
+	}
+	jsonBody, err := json.Marshal(&DeleteRecordRequest{
+		Repo:       parts[0],
+		Collection: parts[1],
+		Rkey:       parts[2],
+	})
+	if err != nil {
+		return fmt.Errorf("marshaling delete record request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, client.Server+"/xrpc/com.atproto.repo.deleteRecord", bytes.NewReader(jsonBody))
+	if err != nil {
+		return fmt.Errorf("creating delete record request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client.authorize(req)
+	resp, err := client.HttpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("executing delete record request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("delete record returned non-OK status (%d): %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
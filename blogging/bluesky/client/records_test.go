@@ -0,0 +1,57 @@
+package bluesky
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestDeletePost(t *testing.T) {
+	var deleted []DeleteRecordRequest
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /xrpc/com.atproto.repo.deleteRecord", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer access" {
+			t.Errorf("Authorization = %q, want the access token", got)
+		}
+		var req DeleteRecordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		deleted = append(deleted, req)
+	})
+	client := newTestClient(t, mux)
+	client.AccessJwt = "access"
+	ctx := context.Background()
+
+	// what undo gets is the bsky.app URL, which is turned back into the at:// URI.
+	atURI, err := client.ATURIFromPostURL(ctx, "https://bsky.app/profile/did:plc:me/post/3kabc")
+	if err != nil {
+		t.Fatalf("ATURIFromPostURL: %v", err)
+	}
+	if err := client.DeletePost(ctx, atURI); err != nil {
+		t.Fatalf("DeletePost: %v", err)
+	}
+	want := DeleteRecordRequest{Repo: "did:plc:me", Collection: "app.bsky.feed.post", Rkey: "3kabc"}
+	if len(deleted) != 1 || deleted[0] != want {
+		t.Errorf("deleted %+v, want %+v", deleted, want)
+	}
+
+	if err := client.DeletePost(ctx, "at://did:plc:me/app.bsky.feed.like/3kabc"); !errors.Is(err, ErrNotAPostURL) {
+		t.Errorf("deleting a like: err = %v, want ErrNotAPostURL", err)
+	}
+	if len(deleted) != 1 {
+		t.Errorf("deleteRecord called for something that is not a post: %+v", deleted[1:])
+	}
+}
+
+func TestDeletePostFailure(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"InvalidRequest"}`, http.StatusBadRequest)
+	}))
+	if err := client.DeletePost(context.Background(), "at://did:plc:me/app.bsky.feed.post/3kabc"); err == nil {
+		t.Error("DeletePost succeeded with the PDS refusing it")
+	}
+}
@@ -0,0 +1,156 @@
+package bluesky
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy tells how requests failing with a transient error (network errors, 429 and 5xx responses) are retried.
+// The delay doubles after each attempt, starting at BaseDelay and capped at MaxDelay, a Retry-After header in a 429
+// response takes precedence (also capped at MaxDelay).
+type RetryPolicy struct {
+	// Attempts is the total number of tries, anything under 1 means a single one.
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultRetryPolicy is the RetryPolicy of clients created with NewClient.
+var DefaultRetryPolicy = RetryPolicy{Attempts: 3, BaseDelay: 500 * time.Millisecond, MaxDelay: 10 * time.Second}
+
+// delay returns how long to wait before the given retry (0 based), resp is the failed response, if any.
+func (rp RetryPolicy) delay(retry int, resp *http.Response) time.Duration {
+	d := rp.BaseDelay << retry
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		if ra, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			d = ra
+		}
+	}
+	if rp.MaxDelay > 0 && d > rp.MaxDelay {
+		d = rp.MaxDelay
+	}
+	return d
+}
+
+// parseRetryAfter reads a Retry-After header, which is either a number of seconds or an HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
+
+// retryable tells if a response is worth trying again.
+func retryable(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+}
+
+// expiredToken tells if a response, with status and body, rejected the access token for being expired, bluesky says
+// so with a 400 and an ExpiredToken error, other PDSs might just answer 401.
+func expiredToken(status int, body []byte) bool {
+	switch status {
+	case http.StatusUnauthorized:
+		return true
+	case http.StatusBadRequest:
+		var xrpcErr struct {
+			Error string `json:"error"`
+		}
+		return json.Unmarshal(body, &xrpcErr) == nil && xrpcErr.Error == "ExpiredToken"
+	}
+	return false
+}
+
+// refreshIfExpired tells if resp rejected the access token req was sent with for being expired and the session could
+// be refreshed, req then carries the new token. Otherwise resp is left as it was, to be handled like any other.
+// Requests rejected together refresh the session once, the rest wait for it and retry with the new token.
+func (client *Client) refreshIfExpired(req *http.Request, resp *http.Response) bool {
+	sent, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok || !client.issued(sent) ||
+		(resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusBadRequest) {
+		return false
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil || !expiredToken(resp.StatusCode, body) {
+		return false
+	}
+	if err := client.refreshStale(req.Context(), sent); err != nil {
+		client.logger.Warn("refreshing expired session", "handle", client.handle(), "err", err)
+		return false
+	}
+	current := client.accessToken()
+	if current == "" || current == sent {
+		return false
+	}
+	client.logger.Info("session expired mid request, refreshed it to retry", "handle", client.handle())
+	req.Header.Set("Authorization", "Bearer "+current)
+	return true
+}
+
+// issued tells if token is the access token of the session or the one it replaced, as opposed to i.e. a service
+// auth token, which refreshing the session does nothing for.
+func (client *Client) issued(token string) bool {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return token != "" && (token == client.AccessJwt || token == client.previousAccessJwt)
+}
+
+// doWithRetry sends req following the client's RetryPolicy, the body is rewound between attempts so req must have
+// GetBody set if it has a body (http.NewRequest does that for bytes and strings readers). If the access token expired
+// the session is refreshed, once, and req sent again with the new one without counting it as an attempt.
+func (client *Client) doWithRetry(req *http.Request) (*http.Response, error) {
+	return client.doWithRetryOn(client.HttpClient, req)
+}
+
+// doWithRetryOn works like doWithRetry but sends req with httpClient, i.e. one with a longer timeout.
+func (client *Client) doWithRetryOn(httpClient *http.Client, req *http.Request) (*http.Response, error) {
+	attempts := max(client.Retry.Attempts, 1)
+	sent, refreshed := false, false
+	for retry := 0; ; retry++ {
+		if sent && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("rewinding request body: %w", err)
+			}
+			req.Body = body
+		}
+		sent = true
+		resp, err := httpClient.Do(req)
+		if err == nil && !refreshed && client.refreshIfExpired(req, resp) {
+			refreshed = true
+			retry--
+			continue
+		}
+		last := retry+1 >= attempts
+		if err == nil && (!retryable(resp) || last) {
+			return resp, nil
+		}
+		if err != nil && (last || req.Context().Err() != nil) {
+			return nil, err
+		}
+		wait := client.Retry.delay(retry, resp)
+		if resp != nil {
+			// drain it so the connection can be reused.
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+	}
+}
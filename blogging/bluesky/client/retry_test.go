@@ -0,0 +1,247 @@
+package bluesky
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/perrito666/chat2world/blogging/parallel"
+)
+
+// flakyHandler fails the first failures requests with status and passes the rest to next.
+type flakyHandler struct {
+	mu       sync.Mutex
+	failures int
+	status   int
+	bodies   []string
+	next     http.Handler
+}
+
+func (f *flakyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	body, _ := io.ReadAll(r.Body)
+	f.bodies = append(f.bodies, string(body))
+	fail := len(f.bodies) <= f.failures
+	f.mu.Unlock()
+	if fail {
+		http.Error(w, `{"error":"Unavailable"}`, f.status)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	f.next.ServeHTTP(w, r)
+}
+
+func TestRetryAfterATransientFailure(t *testing.T) {
+	pds := &fakePDS{}
+	flaky := &flakyHandler{failures: 1, status: http.StatusServiceUnavailable, next: pds}
+	client := newTestClient(t, flaky)
+
+	if _, err := client.PostThreadRecords(context.Background(), nil, []string{"hello"}, nil, nil, nil); err != nil {
+		t.Fatalf("PostThreadRecords: %v", err)
+	}
+	if len(flaky.bodies) != 2 || flaky.bodies[0] != flaky.bodies[1] || flaky.bodies[0] == "" {
+		t.Errorf("requests = %q, want the same one twice", flaky.bodies)
+	}
+	if records := pds.created(); len(records) != 1 || records[0].Text != "hello" {
+		t.Errorf("records = %+v, want the post once", records)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	flaky := &flakyHandler{failures: 10, status: http.StatusBadGateway, next: &fakePDS{}}
+	client := newTestClient(t, flaky)
+
+	if _, err := client.PostThreadRecords(context.Background(), nil, []string{"hello"}, nil, nil, nil); err == nil {
+		t.Fatal("PostThreadRecords succeeded with every attempt failing")
+	}
+	if len(flaky.bodies) != client.Retry.Attempts {
+		t.Errorf("%d requests, want %d attempts", len(flaky.bodies), client.Retry.Attempts)
+	}
+}
+
+func TestRetryDoesNotRepeatClientErrors(t *testing.T) {
+	flaky := &flakyHandler{failures: 10, status: http.StatusBadRequest, next: &fakePDS{}}
+	client := newTestClient(t, flaky)
+	if _, err := client.PostThreadRecords(context.Background(), nil, []string{"hello"}, nil, nil, nil); err == nil {
+		t.Fatal("PostThreadRecords succeeded with the PDS refusing the post")
+	}
+	if len(flaky.bodies) != 1 {
+		t.Errorf("%d requests, want a single one", len(flaky.bodies))
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	rp := RetryPolicy{Attempts: 5, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	for retry, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		if got := rp.delay(retry, nil); got != want {
+			t.Errorf("delay(%d) = %s, want %s", retry, got, want)
+		}
+	}
+	limited := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"3"}}}
+	if got := rp.delay(0, limited); got != 3*time.Second {
+		t.Errorf("delay() with Retry-After: 3 = %s, want 3s", got)
+	}
+	limited.Header.Set("Retry-After", "60")
+	if got := rp.delay(0, limited); got != rp.MaxDelay {
+		t.Errorf("delay() with Retry-After: 60 = %s, want it capped at %s", got, rp.MaxDelay)
+	}
+}
+
+// expiringPDS is a fakePDS whose session is refreshed at the refreshSession endpoint, records created and blobs
+// uploaded with any token but the refreshed one are rejected as expired. Like bluesky's, its refresh token can only be used once.
+type expiringPDS struct {
+	fakePDS
+	mu        sync.Mutex
+	refreshes int
+	// refreshFails makes every refresh fail.
+	refreshFails bool
+	// rejected counts the records and blobs rejected for the expired token.
+	rejected int
+	// together, if set, holds the rejections until that many requests were rejected, so they all fail at once.
+	together int
+	released chan struct{}
+}
+
+func (f *expiringPDS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/xrpc/com.atproto.server.refreshSession":
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.refreshFails || r.Header.Get("Authorization") != "Bearer refresh-1" {
+			http.Error(w, `{"error":"ExpiredToken"}`, http.StatusBadRequest)
+			return
+		}
+		f.refreshes++
+		_, _ = io.WriteString(w, `{"accessJwt":"access-2","refreshJwt":"refresh-2","did":"did:plc:me"}`)
+	case "/xrpc/com.atproto.repo.createRecord", "/xrpc/com.atproto.repo.uploadBlob":
+		if r.Header.Get("Authorization") != "Bearer access-2" {
+			f.reject(w)
+			return
+		}
+		f.fakePDS.ServeHTTP(w, r)
+	default:
+		f.fakePDS.ServeHTTP(w, r)
+	}
+}
+
+// reject answers that the token expired, once together requests were rejected if it is set.
+func (f *expiringPDS) reject(w http.ResponseWriter) {
+	f.mu.Lock()
+	if f.released == nil {
+		f.released = make(chan struct{})
+	}
+	f.rejected++
+	if f.rejected == f.together {
+		close(f.released)
+	}
+	released, wait := f.released, f.rejected <= f.together
+	f.mu.Unlock()
+	if wait {
+		select {
+		case <-released:
+		case <-time.After(5 * time.Second):
+		}
+	}
+	http.Error(w, `{"error":"ExpiredToken","message":"Token has expired"}`, http.StatusUnauthorized)
+}
+
+// counts returns how many requests were rejected and how many times the session was refreshed.
+func (f *expiringPDS) counts() (rejected, refreshes int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rejected, f.refreshes
+}
+
+func TestExpiredTokenIsRefreshedAndThePostRetried(t *testing.T) {
+	pds := &expiringPDS{}
+	client := newTestClient(t, pds)
+	client.AccessJwt, client.RefreshJwt, client.Did = "access-1", "refresh-1", "did:plc:me"
+
+	responses, err := client.PostThreadRecords(context.Background(), nil, []string{"hello"}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("PostThreadRecords: %v", err)
+	}
+	if rejected, refreshes := pds.counts(); len(responses) != 1 || rejected != 1 || refreshes != 1 {
+		t.Errorf("%d posts, %d rejected, %d refreshes, want the post rejected once, refreshed and retried",
+			len(responses), rejected, refreshes)
+	}
+	if records := pds.created(); len(records) != 1 || records[0].Text != "hello" {
+		t.Errorf("created %+v, want the post once", records)
+	}
+	if client.AccessJwt != "access-2" || client.RefreshJwt != "refresh-2" {
+		t.Errorf("tokens = %q, %q, want the refreshed ones", client.AccessJwt, client.RefreshJwt)
+	}
+}
+
+func TestFailedRefreshFailsThePost(t *testing.T) {
+	pds := &expiringPDS{refreshFails: true}
+	client := newTestClient(t, pds)
+	client.AccessJwt, client.RefreshJwt, client.Did = "access-1", "refresh-1", "did:plc:me"
+
+	if _, err := client.PostThreadRecords(context.Background(), nil, []string{"hello"}, nil, nil, nil); err == nil {
+		t.Fatal("PostThreadRecords succeeded with an expired session that could not be refreshed")
+	}
+	if rejected, _ := pds.counts(); rejected != 1 || len(pds.created()) != 0 {
+		t.Errorf("%d rejected, %d created, want a single attempt", rejected, len(pds.created()))
+	}
+}
+
+func TestRequestsRejectedTogetherRefreshTheSessionOnce(t *testing.T) {
+	const posts = 8
+	pds := &expiringPDS{together: posts}
+	client := newTestClient(t, pds)
+	client.AccessJwt, client.RefreshJwt, client.Did = "access-1", "refresh-1", "did:plc:me"
+
+	var wg sync.WaitGroup
+	errs := make(chan error, posts)
+	for range posts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.PostThreadRecords(context.Background(), nil, []string{"hello"}, nil, nil, nil)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("PostThreadRecords: %v", err)
+		}
+	}
+	if rejected, refreshes := pds.counts(); rejected != posts || refreshes != 1 {
+		t.Errorf("%d rejected, %d refreshes, want all %d rejected and a single refresh", rejected, refreshes, posts)
+	}
+	if records := pds.created(); len(records) != posts {
+		t.Errorf("created %d posts, want %d", len(records), posts)
+	}
+	if session := client.Session(); session.AccessJwt != "access-2" || session.RefreshJwt != "refresh-2" {
+		t.Errorf("session = %+v, want the refreshed one", session)
+	}
+}
+
+func TestParallelUploadsRejectedTogetherRefreshTheSessionOnce(t *testing.T) {
+	pds := &expiringPDS{together: parallel.DefaultWorkers}
+	client := newTestClient(t, pds)
+	client.AccessJwt, client.RefreshJwt, client.Did = "access-1", "refresh-1", "did:plc:me"
+	images := make([]*PostableImage, parallel.DefaultWorkers)
+	for i := range images {
+		images[i] = &PostableImage{ImageRaw: []byte("image"), MimeType: "image/png", AltText: "alt"}
+	}
+
+	if _, err := client.PostThreadRecords(context.Background(), nil, []string{"hello"}, images, nil, nil); err != nil {
+		t.Fatalf("PostThreadRecords: %v", err)
+	}
+	if rejected, refreshes := pds.counts(); rejected != len(images) || refreshes != 1 {
+		t.Errorf("%d rejected, %d refreshes, want all %d uploads rejected and a single refresh", rejected, refreshes,
+			len(images))
+	}
+	records := pds.created()
+	if len(records) != 1 || records[0].Embed == nil || len(records[0].Embed.Images) != len(images) {
+		t.Errorf("created %+v, want the post with its %d images", records, len(images))
+	}
+}
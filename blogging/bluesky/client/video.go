@@ -0,0 +1,263 @@
+package bluesky
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Videos are not uploaded to the PDS directly but to bluesky's video service, which transcodes them and stores the
+// result in the user's repository as a blob, see https://docs.bsky.app/docs/tutorials/video
+
+// DefaultVideoService is the service videos are uploaded to.
+const DefaultVideoService = "https://video.bsky.app"
+
+// DefaultPLCDirectory is where did:plc documents are resolved, to find the PDS of a user.
+const DefaultPLCDirectory = "https://plc.directory"
+
+// How often and for how long we wait for the video service to process an upload, and how long the upload itself can
+// take, videos are much larger than anything else we send.
+const (
+	videoJobPollInterval = 2 * time.Second
+	videoJobTimeout      = 5 * time.Minute
+	videoUploadTimeout   = 10 * time.Minute
+)
+
+// Video job states, anything else means it is still being processed.
+const (
+	videoJobCompleted = "JOB_STATE_COMPLETED"
+	videoJobFailed    = "JOB_STATE_FAILED"
+)
+
+// ErrVideoProcessing is returned when the video service fails to process a video or takes too long doing it.
+var ErrVideoProcessing = errors.New("video processing failed")
+
+// VideoJobStatus is the state of a video being processed by the video service.
+type VideoJobStatus struct {
+	JobId    string               `json:"jobId"`
+	Did      string               `json:"did"`
+	State    string               `json:"state"`
+	Progress int                  `json:"progress,omitempty"`
+	Blob     *ImageUploadResponse `json:"blob,omitempty"`
+	Error    string               `json:"error,omitempty"`
+	Message  string               `json:"message,omitempty"`
+}
+
+// getJobStatusResponse represents the response from app.bsky.video.getJobStatus.
+type getJobStatusResponse struct {
+	JobStatus VideoJobStatus `json:"jobStatus"`
+}
+
+// PostableVideo is a video ready to be uploaded along with what bluesky wants to know about it.
+type PostableVideo struct {
+	VideoRaw []byte
+	AltText  string
+	MimeType string
+	Width    int
+	Height   int
+}
+
+// didDocument is the part of a DID document we care about, the services, one of which is the PDS.
+type didDocument struct {
+	Service []struct {
+		ID              string `json:"id"`
+		Type            string `json:"type"`
+		ServiceEndpoint string `json:"serviceEndpoint"`
+	} `json:"service"`
+}
+
+// pdsHost returns the host of the PDS holding the user's repository, which is not necessarily the server we talk to
+// (i.e. bsky.social is an entryway to many PDSs). If the DID document can not be resolved the server host is used.
+func (client *Client) pdsHost(ctx context.Context) string {
+	fallback := client.Server
+	if u, err := url.Parse(client.Server); err == nil {
+		fallback = u.Host
+	}
+	did := client.Session().Did
+	var docURL string
+	switch {
+	case strings.HasPrefix(did, "did:plc:"):
+		docURL = DefaultPLCDirectory + "/" + did
+	case strings.HasPrefix(did, "did:web:"):
+		docURL = "https://" + strings.TrimPrefix(did, "did:web:") + "/.well-known/did.json"
+	default:
+		return fallback
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, docURL, nil)
+	if err != nil {
+		return fallback
+	}
+	resp, err := client.HttpClient.Do(req)
+	if err != nil {
+		return fallback
+	}
+	defer resp.Body.Close()
+	var doc didDocument
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&doc) != nil {
+		return fallback
+	}
+	for _, service := range doc.Service {
+		if service.ID == "#atproto_pds" {
+			if u, err := url.Parse(service.ServiceEndpoint); err == nil && u.Host != "" {
+				return u.Host
+			}
+		}
+	}
+	return fallback
+}
+
+// serviceAuth gets, through com.atproto.server.getServiceAuth, a token for another service to act on the user's
+// behalf, aud is the DID of the service that will use it and lxm the only method it can be used for.
+func (client *Client) serviceAuth(ctx context.Context, aud, lxm string, ttl time.Duration) (string, error) {
+	query := url.Values{}
+	query.Set("aud", aud)
+	query.Set("lxm", lxm)
+	query.Set("exp", fmt.Sprint(time.Now().Add(ttl).Unix()))
+	authURL := client.Server + "/xrpc/com.atproto.server.getServiceAuth?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, authURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("creating service auth request: %w", err)
+	}
+	client.authorize(req)
+	resp, err := client.doWithRetry(req)
+	if err != nil {
+		return "", fmt.Errorf("executing service auth request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("reading service auth response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("service auth returned non-OK status: %s", string(body))
+	}
+	var authResp struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(body, &authResp); err != nil {
+		return "", fmt.Errorf("unmarshaling service auth response: %w", err)
+	}
+	return authResp.Token, nil
+}
+
+// UploadVideo sends the video to the video service and waits for it to be processed, it returns the blob reference
+// that can be used in a post embed.
+func (client *Client) UploadVideo(ctx context.Context, video *PostableVideo) (*ImageUploadResponse, error) {
+	// the token is for the video service to upload the processed video to the user's PDS on their behalf.
+	token, err := client.serviceAuth(ctx, "did:web:"+client.pdsHost(ctx), "com.atproto.repo.uploadBlob",
+		30*time.Minute)
+	if err != nil {
+		return nil, fmt.Errorf("getting video upload token: %w", err)
+	}
+
+	name := make([]byte, 8)
+	if _, err := rand.Read(name); err != nil {
+		return nil, fmt.Errorf("naming video: %w", err)
+	}
+	query := url.Values{}
+	query.Set("did", client.Session().Did)
+	query.Set("name", hex.EncodeToString(name)+".mp4")
+	uploadURL := DefaultVideoService + "/xrpc/app.bsky.video.uploadVideo?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uploadURL, bytes.NewReader(video.VideoRaw))
+	if err != nil {
+		return nil, fmt.Errorf("creating video upload request: %w", err)
+	}
+	req.Header.Set("Content-Type", video.MimeType)
+	req.Header.Set("Authorization", "Bearer "+token)
+	uploadClient := *client.HttpClient
+	uploadClient.Timeout = max(uploadClient.Timeout, videoUploadTimeout)
+	resp, err := client.doWithRetryOn(&uploadClient, req)
+	if err != nil {
+		return nil, fmt.Errorf("executing video upload request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading video upload response: %w", err)
+	}
+	var job VideoJobStatus
+	if err := json.Unmarshal(body, &job); err != nil {
+		return nil, fmt.Errorf("video upload returned status %s: %s", resp.Status, string(body))
+	}
+	// a video that was already uploaded is refused with a conflict that still carries its job.
+	if resp.StatusCode != http.StatusOK && !(resp.StatusCode == http.StatusConflict && job.JobId != "") {
+		return nil, fmt.Errorf("video upload returned non-OK status: %s", string(body))
+	}
+	return client.waitForVideoJob(ctx, job)
+}
+
+// waitForVideoJob polls app.bsky.video.getJobStatus until the job is done and returns the blob of the processed video.
+func (client *Client) waitForVideoJob(ctx context.Context, job VideoJobStatus) (*ImageUploadResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, videoJobTimeout)
+	defer cancel()
+	ticker := time.NewTicker(videoJobPollInterval)
+	defer ticker.Stop()
+	for {
+		switch {
+		case job.State == videoJobCompleted && job.Blob != nil:
+			return job.Blob, nil
+		case job.State == videoJobFailed:
+			return nil, fmt.Errorf("job %s: %s %s: %w", job.JobId, job.Error, job.Message, ErrVideoProcessing)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("job %s still %s (%d%%): %w", job.JobId, job.State, job.Progress,
+				errors.Join(ErrVideoProcessing, ctx.Err()))
+		case <-ticker.C:
+		}
+		status, err := client.videoJobStatus(ctx, job.JobId)
+		if err != nil {
+			return nil, err
+		}
+		job = *status
+	}
+}
+
+// videoJobStatus fetches the status of a video processing job.
+func (client *Client) videoJobStatus(ctx context.Context, jobID string) (*VideoJobStatus, error) {
+	statusURL := DefaultVideoService + "/xrpc/app.bsky.video.getJobStatus?jobId=" + url.QueryEscape(jobID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, statusURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating video job status request: %w", err)
+	}
+	client.authorize(req)
+	resp, err := client.doWithRetry(req)
+	if err != nil {
+		return nil, fmt.Errorf("executing video job status request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading video job status response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("video job status returned non-OK status: %s", string(body))
+	}
+	var statusResp getJobStatusResponse
+	if err := json.Unmarshal(body, &statusResp); err != nil {
+		return nil, fmt.Errorf("unmarshaling video job status response: %w", err)
+	}
+	return &statusResp.JobStatus, nil
+}
+
+// videoEmbed builds the embed for an already uploaded video.
+func videoEmbed(video *PostableVideo, blob ImageUploadResponse) *PostEmbed {
+	embed := &PostEmbed{
+		Type:  EmbedVideoType,
+		Video: &blob,
+		Alt:   video.AltText,
+	}
+	if video.Width > 0 && video.Height > 0 {
+		embed.AspectRatio = &EmbedAspectRatio{Width: video.Width, Height: video.Height}
+	}
+	return embed
+}
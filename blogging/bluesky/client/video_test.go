@@ -0,0 +1,50 @@
+package bluesky
+
+import (
+	"context"
+	"testing"
+)
+
+func TestVideoEmbed(t *testing.T) {
+	video := &PostableVideo{VideoRaw: []byte("not really a video"), AltText: "a cat", MimeType: "video/mp4",
+		Width: 1280, Height: 720}
+	blob := ImageUploadResponse{Type: BlobType, Ref: Ref{Link: "bafkvideo"}, MimeType: "video/mp4", Size: 18}
+	embed := videoEmbed(video, blob)
+	if embed.Type != EmbedVideoType || embed.Video == nil || embed.Video.Ref.Link != "bafkvideo" {
+		t.Fatalf("embed = %+v, want an app.bsky.embed.video of the uploaded blob", embed)
+	}
+	if embed.Alt != "a cat" {
+		t.Errorf("Alt = %q, want the alt text of the video", embed.Alt)
+	}
+	if embed.AspectRatio == nil || embed.AspectRatio.Width != 1280 || embed.AspectRatio.Height != 720 {
+		t.Errorf("AspectRatio = %+v, want 1280x720", embed.AspectRatio)
+	}
+	if len(embed.Images) != 0 {
+		t.Errorf("Images = %+v, want none next to a video", embed.Images)
+	}
+
+	video.Width, video.Height = 0, 0
+	if ratio := videoEmbed(video, blob).AspectRatio; ratio != nil {
+		t.Errorf("AspectRatio = %+v, want none for a video of unknown dimensions", ratio)
+	}
+}
+
+func TestPreviewVideoThreadRecords(t *testing.T) {
+	client := NewClient()
+	video := &PostableVideo{VideoRaw: make([]byte, 100), AltText: "a cat", MimeType: "video/mp4"}
+	records := client.PreviewVideoThreadRecords(context.Background(), []string{"first", "second"}, video, nil,
+		[]string{"en"})
+	if len(records) != 2 {
+		t.Fatalf("%d records, want 2", len(records))
+	}
+	embed := records[0].Embed
+	if embed == nil || embed.Type != EmbedVideoType || embed.Video == nil {
+		t.Fatalf("first record embeds %+v, want the video", embed)
+	}
+	if embed.Video.MimeType != "video/mp4" || embed.Video.Size != 100 {
+		t.Errorf("video blob = %+v, want video/mp4 of 100 bytes", embed.Video)
+	}
+	if records[1].Embed != nil {
+		t.Errorf("second record embeds %+v, want the video only in the first", records[1].Embed)
+	}
+}
@@ -3,11 +3,17 @@ package bluesky
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/perrito666/chat2world/blogging"
 	"github.com/perrito666/chat2world/blogging/bluesky/client"
+	"github.com/perrito666/chat2world/blogging/ratelimit"
+	"github.com/perrito666/chat2world/config"
 	"github.com/perrito666/chat2world/secrets"
 )
 
@@ -15,11 +21,14 @@ import (
 type Config struct {
 	User        string `json:"user,omitempty"`
 	AppPassword string `json:"app_password,omitempty"`
+	// Server is the base URL of the user's PDS, empty means bsky.social.
+	Server string `json:"server,omitempty"`
 }
 
 func (c *Config) LoadFromPersistableDict(dict map[string]string) error {
 	c.User = dict["user"]
 	c.AppPassword = dict["app_password"]
+	c.Server = dict["server"]
 	return nil
 }
 
@@ -27,17 +36,46 @@ func (c *Config) DumpToPersistableDict() map[string]string {
 	return map[string]string{
 		"user":         c.User,
 		"app_password": c.AppPassword,
+		"server":       c.Server,
 	}
 }
 
+// server returns the PDS the config points to.
+func (c *Config) server() string {
+	if c.Server == "" {
+		return bluesky.DefaultServer
+	}
+	return c.Server
+}
+
+// normalizeServer turns what the user answered when asked for their server into a base URL, an empty answer or
+// "default" mean bsky.social.
+func normalizeServer(answer string) string {
+	answer = strings.TrimRight(strings.TrimSpace(answer), "/")
+	if answer == "" || strings.EqualFold(answer, "default") {
+		return ""
+	}
+	if !strings.Contains(answer, "://") {
+		answer = "https://" + answer
+	}
+	return answer
+}
+
 var _ blogging.ClientConfig = (*Config)(nil)
 
 // Client wraps a Mastodon client and provides a method to post.
 type Client struct {
-	store  *secrets.EncryptedStore
-	client *bluesky.Client
-	config *Config
-	userID blogging.UserID
+	// store keeps the session of the user, it can always be made again with the config.
+	store *secrets.EncryptedStore
+	// configs is where the config of each user is kept.
+	configs blogging.ConfigStore
+	client  *bluesky.Client
+	config  *Config
+	userID  blogging.UserID
+	// lastThread remembers every post of the last thread we sent, keyed by the URL we returned for it, so it can be
+	// deleted as a whole.
+	lastThread map[string][]string
+	logger     *slog.Logger
 }
 
 func (c *Client) Config(userID blogging.UserID) (blogging.ClientConfig, error) {
@@ -47,31 +85,87 @@ func (c *Client) Config(userID blogging.UserID) (blogging.ClientConfig, error) {
 	return c.config, nil
 }
 
+// ClientOption configures optional settings of a Client.
+type ClientOption func(*clientOptions)
+
+type clientOptions struct {
+	limiter *ratelimit.Limiter
+	logger  *slog.Logger
+	configs blogging.ConfigStore
+}
+
+// WithRateLimiter makes the client's requests go through l instead of a limiter of its own with the bluesky client
+// defaults.
+func WithRateLimiter(l *ratelimit.Limiter) ClientOption {
+	return func(o *clientOptions) {
+		o.limiter = l
+	}
+}
+
+// WithConfigStore makes the client keep the configs of users in configs instead of the encrypted store, sessions are
+// still kept in the encrypted store.
+func WithConfigStore(configs blogging.ConfigStore) ClientOption {
+	return func(o *clientOptions) {
+		o.configs = configs
+	}
+}
+
+// WithLogger sets where the client logs, it defaults to slog.Default().
+func WithLogger(logger *slog.Logger) ClientOption {
+	return func(o *clientOptions) {
+		o.logger = logger
+	}
+}
+
 // NewClient creates a new Mastodon client using the provided configuration.
-func NewClient(store *secrets.EncryptedStore) (*Client, error) {
-	return &Client{
-		store:  store,
-		client: bluesky.NewClient(),
-		config: &Config{},
-	}, nil
+func NewClient(store *secrets.EncryptedStore, opts ...ClientOption) (*Client, error) {
+	o := clientOptions{logger: slog.Default(), configs: blogging.NewEncryptedConfigStore(store)}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	logger := o.logger.With("platform", config.MBPBsky)
+	clientOpts := []bluesky.ClientOption{bluesky.WithLogger(logger)}
+	if o.limiter != nil {
+		clientOpts = append(clientOpts, bluesky.WithLimiter(o.limiter))
+	}
+	c := &Client{
+		store:   store,
+		configs: o.configs,
+		client:  bluesky.NewClient(clientOpts...),
+		config:  &Config{},
+		logger:  logger,
+	}
+	c.client.OnSessionChange = c.saveSession
+	return c, nil
 }
 
 var _ blogging.AuthedPlatform = (*Client)(nil)
 
 func (c *Client) IsAuthorized(id blogging.UserID) bool {
+	return c.IsAuthorizedContext(context.Background(), id)
+}
+
+// IsAuthorizedContext implements blogging.ContextAuthorizer, if the client has no session it resumes the stored one
+// or logs in with the user's app password, both give up once ctx is done and the session refresher they start stops
+// with it.
+func (c *Client) IsAuthorizedContext(ctx context.Context, id blogging.UserID) bool {
 	if c.userID == 0 {
 		c.userID = id
 	}
 	if c.config.User == "" || c.config.AppPassword == "" {
 		_, err := c.loadConfigIfExists(id)
 		if err != nil {
-			log.Printf("error loading config: %v", err)
+			c.logger.Error("loading config", "user_id", id, "err", err)
 		}
 	}
 	if !c.client.IsAuthorized() {
-		err := c.client.AuthenticateBluesky(context.Background(), c.config.User, c.config.AppPassword)
+		err := c.resumeSession(ctx)
 		if err != nil {
-			log.Printf("error authenticating: %v", err)
+			c.logger.Info("could not resume stored session, authenticating", "user_id", id, "err", err)
+			err = c.client.AuthenticateBluesky(ctx, c.config.User, c.config.AppPassword)
+		}
+		if err != nil {
+			c.logger.Error("authenticating", "user_id", id, "err", err)
 			return false
 		}
 	}
@@ -79,24 +173,104 @@ func (c *Client) IsAuthorized(id blogging.UserID) bool {
 	return c.client.IsAuthorized()
 }
 
-// loadConfigIfExists loads a config from a file if it exists.
+var _ blogging.ContextAuthorizer = (*Client)(nil)
+
+// sessionPath returns the name of the file holding the bluesky session of the user.
+func sessionPath(id blogging.UserID) string {
+	return fmt.Sprintf("%d.bsky.session.json", id)
+}
+
+// saveSession persists the session so a restart does not need to authenticate with the password again, it is
+// called by the bluesky client every time the session changes.
+func (c *Client) saveSession(session bluesky.Session) {
+	if c.userID == 0 {
+		return
+	}
+	f, err := c.store.OpenWriter(sessionPath(c.userID))
+	if err != nil {
+		c.logger.Error("opening session to write", "user_id", c.userID, "err", err)
+		return
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(session); err != nil {
+		c.logger.Error("writing session", "user_id", c.userID, "err", err)
+	}
+}
+
+// Forget implements blogging.Forgetter, the app password stays valid until the user revokes it in bluesky.
+func (c *Client) Forget(userID blogging.UserID) error {
+	c.client.Logout()
+	c.config = &Config{}
+	if err := c.store.Remove(sessionPath(userID)); err != nil {
+		return fmt.Errorf("removing session: %w", err)
+	}
+	if err := c.configs.Remove(userID, config.MBPBsky); err != nil {
+		return fmt.Errorf("removing config: %w", err)
+	}
+	c.logger.Info("config forgotten", "user_id", userID)
+	return nil
+}
+
+var _ blogging.Forgetter = (*Client)(nil)
+
+// SessionExpiry implements blogging.SessionExpirer, it is when the access token expires, the session is refreshed
+// before that.
+func (c *Client) SessionExpiry(userID blogging.UserID) (time.Time, error) {
+	return c.client.SessionExpiry()
+}
+
+var _ blogging.SessionExpirer = (*Client)(nil)
+
+// resumeSession loads the stored session of the user, if any, and resumes it.
+func (c *Client) resumeSession(ctx context.Context) error {
+	f, err := c.store.OpenReader(sessionPath(c.userID))
+	if err != nil {
+		return fmt.Errorf("opening stored session: %w", err)
+	}
+	var session bluesky.Session
+	err = json.NewDecoder(f).Decode(&session)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("reading stored session: %w", err)
+	}
+	return c.client.ResumeSession(ctx, session)
+}
+
+// loadConfigIfExists loads the config of the user from the ConfigStore if there is one.
 func (c *Client) loadConfigIfExists(id blogging.UserID) (*Config, error) {
 	cfg := &Config{}
-	f, err := c.store.OpenReader(fmt.Sprintf("%d.bsky.json", id))
-	if err != nil {
+	dict, err := c.configs.Load(id, config.MBPBsky)
+	if errors.Is(err, os.ErrNotExist) {
 		return cfg, nil
 	}
-	defer f.Close()
-	err = json.NewDecoder(f).Decode(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("loading configuration for bsky from disk: %w", err)
+		return nil, fmt.Errorf("loading configuration for bsky: %w", err)
+	}
+	if err := cfg.LoadFromPersistableDict(dict); err != nil {
+		return nil, fmt.Errorf("reading configuration for bsky: %w", err)
 	}
 	c.config = cfg
+	c.client.Server = cfg.server()
 
 	// FIXME: make an actual ctx get here
 	return cfg, nil
 }
 
+// ask sends question through comms and returns the answer, ok is false if the context was canceled.
+func ask(ctx context.Context, comms chan string, question string) (string, bool) {
+	select {
+	case comms <- question:
+	case <-ctx.Done():
+		return "", false
+	}
+	select {
+	case answer := <-comms:
+		return strings.TrimSpace(answer), true
+	case <-ctx.Done():
+		return "", false
+	}
+}
+
 func (c *Client) StartAuthorization(ctx context.Context, id blogging.UserID, cfgGeneric map[string]string) (chan string, error) {
 	commsChan := make(chan string)
 	if c.config.User == "" {
@@ -108,47 +282,35 @@ func (c *Client) StartAuthorization(ctx context.Context, id blogging.UserID, cfg
 	go func(id blogging.UserID, cfg *Config, comms chan string) {
 		defer close(comms)
 		if cfg.User == "" {
-			log.Printf("no user found in config, asking user")
-			select {
-			case comms <- "What is your Bluesky username?":
-			case <-ctx.Done():
+			c.logger.Debug("no server in config, asking user", "user_id", id)
+			server, ok := ask(ctx, comms,
+				"What is your Bluesky server? Answer default for bsky.social, otherwise your PDS address (i.e. pds.example.com).")
+			if !ok {
 				return
 			}
-			select {
-			case cfg.User = <-comms:
-			case <-ctx.Done():
+			cfg.Server = normalizeServer(server)
+			c.logger.Debug("no user in config, asking user", "user_id", id)
+			if cfg.User, ok = ask(ctx, comms, "What is your Bluesky username?"); !ok {
 				return
 			}
 		}
 		if cfg.AppPassword == "" {
-			log.Printf("no app password found in config, asking user")
-			select {
-			case comms <- "What is your Bluesky Application password?":
-			case <-ctx.Done():
-				return
-			}
-			select {
-			case cfg.AppPassword = <-comms:
-			case <-ctx.Done():
+			c.logger.Debug("no app password in config, asking user", "user_id", id)
+			password, ok := ask(ctx, comms, "What is your Bluesky Application password?")
+			if !ok {
 				return
 			}
+			cfg.AppPassword = password
 		}
+		c.client.Server = cfg.server()
 		err := c.client.AuthenticateBluesky(ctx, cfg.User, cfg.AppPassword)
 		if err != nil {
-			log.Printf("error authenticating: %v", err)
+			c.logger.Error("authenticating", "user_id", id, "err", err)
 			return
 		}
 		if cfg.User != "" && cfg.AppPassword != "" {
-			// create a file in the running folder named after the year, month, day, hour, minute, second.json
-			// and dump the cfg to it.
-			f, err := c.store.OpenWriter(fmt.Sprintf("%d.bsky.json", c.userID))
-			if err != nil {
-				log.Fatal(err)
-			}
-			defer f.Close()
-			err = json.NewEncoder(f).Encode(cfg)
-			if err != nil {
-				log.Fatal(err)
+			if err := c.configs.Save(c.userID, config.MBPBsky, cfg.DumpToPersistableDict()); err != nil {
+				c.logger.Error("writing config", "user_id", id, "err", err)
 			}
 		}
 	}(id, c.config, commsChan)
@@ -157,25 +319,171 @@ func (c *Client) StartAuthorization(ctx context.Context, id blogging.UserID, cfg
 
 var _ blogging.Platform = (*Client)(nil)
 
-func (c *Client) Post(ctx context.Context, userID blogging.UserID, post *blogging.MicroblogPost) (string, error) {
+func (c *Client) Post(ctx context.Context, userID blogging.UserID, post *blogging.MicroblogPost) (*blogging.PostResult, error) {
+	result := &blogging.PostResult{}
 	postImages := make([]*bluesky.PostableImage, len(post.Images))
-	var err error
-	for idx, img := range post.Images {
+	for idx, original := range post.Images {
+		img, err := original.NormalizeImage(blogging.PlatformImageSizeLimits[config.MBPBsky])
+		if err != nil {
+			return nil, fmt.Errorf("normalizing image %d: %w", idx, err)
+		}
+		if img != original {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("image %d was re-encoded to fit", idx+1))
+		}
 		postImages[idx], err = bluesky.NewPostableImage(img.Data, img.AltText)
 		if err != nil {
-			return "", fmt.Errorf("creating postable image: %w", err)
+			return nil, fmt.Errorf("creating postable image: %w", err)
 		}
 	}
 	var bskyURL string
-	var langs []string
-	if len(post.Langs) != 0 {
-		langs = post.Langs
+	post, quote, err := c.resolveQuote(ctx, post)
+	if err != nil {
+		return nil, err
+	}
+	// without languages (see blogging.UserSettings for the default ones) bluesky guesses.
+	chunks := post.ThreadChunks(blogging.PlatformTextLimits[config.MBPBsky])
+	var records []bluesky.CreateRecordResponse
+	if post.Video != nil {
+		if err := post.Video.CheckLimit(blogging.PlatformVideoLimits[config.MBPBsky]); err != nil {
+			return nil, fmt.Errorf("checking video: %w", err)
+		}
+		records, err = c.client.PostVideoThreadRecords(ctx, nil, chunks, postableVideo(post.Video), quote, post.Langs)
 	} else {
-		langs = []string{"en"}
+		records, err = c.client.PostThreadRecords(ctx, nil, chunks, postImages, quote, post.Langs)
 	}
-	bskyURL, err = c.client.PostToBluesky(post.Text, postImages, langs)
 	if err != nil {
-		return "", fmt.Errorf("posting to bluesky: %w", err)
+		return nil, fmt.Errorf("posting to bluesky: %w", err)
+	}
+	bskyURL = bluesky.PostURLFromATURI(records[0].Uri)
+	atURIs := make([]string, len(records))
+	for i, record := range records {
+		atURIs[i] = record.Uri
+	}
+	c.lastThread = map[string][]string{bskyURL: atURIs}
+	if post.Poll != nil {
+		result.Warnings = append(result.Warnings, "bluesky has no polls, the post went without it")
+	}
+	result.URL = bskyURL
+	result.ID = records[0].Uri
+	result.PostedAt = time.Now()
+	result.Parts = len(records)
+	return result, nil
+}
+
+// resolveQuote returns the reference to the post quoted by post, if it quotes a bluesky one, posts quoting other
+// platforms can't be embedded so they are returned with the link in their text instead.
+func (c *Client) resolveQuote(ctx context.Context, post *blogging.MicroblogPost) (*blogging.MicroblogPost, *bluesky.ReplyRef, error) {
+	if post.QuoteURL == "" {
+		return post, nil, nil
+	}
+	quote, err := c.client.PostRefFromURL(ctx, post.QuoteURL)
+	if errors.Is(err, bluesky.ErrNotAPostURL) {
+		return post.WithQuoteLink(), nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("resolving quoted post: %w", err)
+	}
+	return post, quote, nil
+}
+
+// postableVideo translates a post's video into what the bluesky client uploads.
+func postableVideo(video *blogging.BlogVideo) *bluesky.PostableVideo {
+	return &bluesky.PostableVideo{
+		VideoRaw: video.Data,
+		AltText:  video.AltText,
+		MimeType: video.MimeType,
+		Width:    video.Width,
+		Height:   video.Height,
+	}
+}
+
+var _ blogging.Previewer = (*Client)(nil)
+
+// Preview implements blogging.Previewer, it shows the records Post would create, images are normalized but not
+// uploaded so their blob references are empty.
+func (c *Client) Preview(ctx context.Context, userID blogging.UserID, post *blogging.MicroblogPost) (string, error) {
+	postImages := make([]*bluesky.PostableImage, len(post.Images))
+	for idx, original := range post.Images {
+		img, err := original.NormalizeImage(blogging.PlatformImageSizeLimits[config.MBPBsky])
+		if err != nil {
+			return "", fmt.Errorf("normalizing image %d: %w", idx, err)
+		}
+		postImages[idx], err = bluesky.NewPostableImage(img.Data, img.AltText)
+		if err != nil {
+			return "", fmt.Errorf("creating postable image: %w", err)
+		}
+	}
+	post, quote, err := c.resolveQuote(ctx, post)
+	if err != nil {
+		return "", err
+	}
+	chunks := post.ThreadChunks(blogging.PlatformTextLimits[config.MBPBsky])
+	var records []bluesky.PostRecord
+	if post.Video != nil {
+		if err := post.Video.CheckLimit(blogging.PlatformVideoLimits[config.MBPBsky]); err != nil {
+			return "", fmt.Errorf("checking video: %w", err)
+		}
+		records = c.client.PreviewVideoThreadRecords(ctx, chunks, postableVideo(post.Video), quote, post.Langs)
+	} else {
+		records = c.client.PreviewThreadRecords(ctx, chunks, postImages, quote, post.Langs)
+	}
+	out, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("encoding records: %w", err)
+	}
+	return string(out), nil
+}
+
+var _ blogging.Deleter = (*Client)(nil)
+
+// Delete implements blogging.Deleter, if postURL is the last thread we posted all of its posts are deleted, last
+// first, otherwise just the post it points to.
+func (c *Client) Delete(ctx context.Context, userID blogging.UserID, postURL string) error {
+	atURIs, ok := c.lastThread[postURL]
+	if !ok {
+		atURI, err := c.client.ATURIFromPostURL(ctx, postURL)
+		if err != nil {
+			return fmt.Errorf("resolving post URL: %w", err)
+		}
+		atURIs = []string{atURI}
+	}
+	for i := len(atURIs) - 1; i >= 0; i-- {
+		if err := c.client.DeletePost(ctx, atURIs[i]); err != nil {
+			return fmt.Errorf("deleting %s: %w", atURIs[i], err)
+		}
+	}
+	delete(c.lastThread, postURL)
+	return nil
+}
+
+var _ blogging.Fetcher = (*Client)(nil)
+
+// Fetch implements blogging.Fetcher, it retrieves a bsky.app post URL and rebuilds a MicroblogPost with its text and
+// images, downloading the full size version of each one along with its alt text.
+func (c *Client) Fetch(ctx context.Context, userID blogging.UserID, postURL string) (*blogging.MicroblogPost, error) {
+	atURI, err := c.client.ATURIFromPostURL(ctx, postURL)
+	if err != nil {
+		if errors.Is(err, bluesky.ErrNotAPostURL) {
+			return nil, fmt.Errorf("%s: %w", postURL, blogging.ErrPostNotFound)
+		}
+		return nil, fmt.Errorf("converting post URL: %w", err)
+	}
+	view, err := c.client.GetPost(ctx, atURI)
+	if err != nil {
+		return nil, fmt.Errorf("getting post: %w", err)
+	}
+	post := &blogging.MicroblogPost{
+		Text:  view.Record.Text,
+		Langs: view.Record.Langs,
+	}
+	if view.Embed != nil {
+		for idx, img := range view.Embed.Images {
+			data, err := blogging.DownloadMedia(ctx, img.Fullsize)
+			if err != nil {
+				return nil, fmt.Errorf("downloading image %d: %w", idx, err)
+			}
+			post.AddImage(blogging.NewBlogImage(data, img.Alt))
+		}
 	}
-	return bskyURL, nil
+	return post, nil
 }
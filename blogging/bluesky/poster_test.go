@@ -0,0 +1,207 @@
+package bluesky
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/perrito666/chat2world/blogging"
+	bluesky "github.com/perrito666/chat2world/blogging/bluesky/client"
+	"github.com/perrito666/chat2world/config"
+	"github.com/perrito666/chat2world/secrets"
+)
+
+// newTestStore returns a store keeping its files in memory, with a fast key derivation.
+func newTestStore() *secrets.EncryptedStore {
+	return &secrets.EncryptedStore{
+		Password: "hunter2",
+		Params:   secrets.ScryptParams{N: 1024, R: 8, P: 1},
+		Backend:  &secrets.MemoryBackend{},
+	}
+}
+
+func TestStoredSessionIsResumedWithoutThePassword(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /xrpc/com.atproto.server.refreshSession", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer stored-refresh" {
+			t.Errorf("Authorization = %q, want the stored refresh token", got)
+		}
+		_, _ = io.WriteString(w, `{"accessJwt":"access-2","refreshJwt":"refresh-2","did":"did:plc:me","handle":"me.bsky.social"}`)
+	})
+	mux.HandleFunc("POST /xrpc/com.atproto.server.createSession", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("authenticated with the password, want the stored session resumed")
+		http.Error(w, `{"error":"AuthenticationRequired"}`, http.StatusUnauthorized)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	store := newTestStore()
+	const userID blogging.UserID = 1
+	cfg := &Config{User: "me.bsky.social", AppPassword: "app-password", Server: server.URL}
+	if err := blogging.NewEncryptedConfigStore(store).Save(userID, config.MBPBsky, cfg.DumpToPersistableDict()); err != nil {
+		t.Fatalf("saving config: %v", err)
+	}
+	w, err := store.OpenWriter(sessionPath(userID))
+	if err != nil {
+		t.Fatalf("OpenWriter: %v", err)
+	}
+	session := bluesky.Session{AccessJwt: "stored-access", RefreshJwt: "stored-refresh", Did: "did:plc:me"}
+	if err := json.NewEncoder(w).Encode(session); err != nil {
+		t.Fatalf("writing session: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("closing session: %v", err)
+	}
+
+	// a client made after a restart only has what is in the store.
+	c, err := NewClient(store)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if !c.IsAuthorized(userID) {
+		t.Fatal("IsAuthorized() = false, want the stored session resumed")
+	}
+	if got := c.client.Session(); got.AccessJwt != "access-2" || got.RefreshJwt != "refresh-2" {
+		t.Errorf("session = %+v, want the refreshed tokens", got)
+	}
+}
+
+func TestTagsAreFacetTagged(t *testing.T) {
+	post := &blogging.MicroblogPost{Text: "writing some #golang today", Tags: []string{"golang", "fediverse"}}
+	text := post.RenderFor(config.MBPBsky)
+	facets, err := bluesky.ParseFacets(context.Background(), text, nil)
+	if err != nil {
+		t.Fatalf("ParseFacets: %v", err)
+	}
+	var tags []string
+	for _, f := range facets {
+		if f.Features[0].Type == bluesky.FacetTagType {
+			tags = append(tags, f.Features[0].Tag)
+		}
+	}
+	if want := []string{"golang", "fediverse"}; !slices.Equal(tags, want) {
+		t.Errorf("tag facets of %q = %q, want %q", text, tags, want)
+	}
+}
+
+// memConfigStore is a blogging.ConfigStore keeping the configs in memory.
+type memConfigStore struct {
+	mu      sync.Mutex
+	configs map[string]map[string]string
+}
+
+var _ blogging.ConfigStore = (*memConfigStore)(nil)
+
+// key returns where the config of userID for platform is kept.
+func (*memConfigStore) key(userID blogging.UserID, platform config.AvailableBloggingPlatform) string {
+	return fmt.Sprintf("%d/%s", userID, platform)
+}
+
+func (s *memConfigStore) Load(userID blogging.UserID, platform config.AvailableBloggingPlatform) (map[string]string,
+	error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cfg, ok := s.configs[s.key(userID, platform)]
+	if !ok {
+		return nil, fmt.Errorf("loading %s config: %w", platform, os.ErrNotExist)
+	}
+	return maps.Clone(cfg), nil
+}
+
+func (s *memConfigStore) Save(userID blogging.UserID, platform config.AvailableBloggingPlatform,
+	cfg map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.configs == nil {
+		s.configs = map[string]map[string]string{}
+	}
+	s.configs[s.key(userID, platform)] = maps.Clone(cfg)
+	return nil
+}
+
+func (s *memConfigStore) Remove(userID blogging.UserID, platform config.AvailableBloggingPlatform) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.configs, s.key(userID, platform))
+	return nil
+}
+
+func TestConfigsAreKeptInTheConfigStore(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /xrpc/com.atproto.server.createSession", func(w http.ResponseWriter, r *http.Request) {
+		var login struct{ Identifier, Password string }
+		if err := json.NewDecoder(r.Body).Decode(&login); err != nil || login.Password != "app-password" {
+			t.Errorf("logged in with %+v, %v, want the app password of the stored config", login, err)
+		}
+		_, _ = io.WriteString(w, `{"accessJwt":"access","refreshJwt":"refresh","did":"did:plc:me","handle":"me.bsky.social"}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	const userID blogging.UserID = 1
+	configs := &memConfigStore{}
+	cfg := &Config{User: "me.bsky.social", AppPassword: "app-password", Server: server.URL}
+	if err := configs.Save(userID, config.MBPBsky, cfg.DumpToPersistableDict()); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	store := newTestStore()
+	c, err := NewClient(store, WithConfigStore(configs))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if !c.IsAuthorized(userID) {
+		t.Fatal("IsAuthorized() = false, want the config loaded from the config store")
+	}
+	if _, err := blogging.NewEncryptedConfigStore(store).Load(userID, config.MBPBsky); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("loading from the encrypted store: err = %v, want no config written there", err)
+	}
+
+	if err := c.Forget(userID); err != nil {
+		t.Fatalf("Forget: %v", err)
+	}
+	if _, err := configs.Load(userID, config.MBPBsky); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("loading after Forget: err = %v, want the config removed from the config store", err)
+	}
+}
+
+func TestCancelingTheAuthorizationEndsIt(t *testing.T) {
+	c, err := NewClient(newTestStore())
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	comms, err := c.StartAuthorization(ctx, 1, nil)
+	if err != nil {
+		t.Fatalf("StartAuthorization: %v", err)
+	}
+	for _, answer := range []string{"default", "me.bsky.social"} {
+		<-comms
+		comms <- answer
+	}
+	if question := <-comms; !strings.Contains(question, "Application password") {
+		t.Fatalf("third question = %q, want the app password", question)
+	}
+
+	// the user walks away while being asked for the password.
+	cancel()
+	select {
+	case message, open := <-comms:
+		if open {
+			t.Errorf("got %q after canceling, want the authorization to end", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the authorization is still running after canceling it")
+	}
+}
@@ -0,0 +1,119 @@
+package blogging
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/perrito666/chat2world/config"
+	"github.com/perrito666/chat2world/secrets"
+)
+
+// ConfigStore keeps what platforms need to post as each user (i.e. their tokens), platforms load and save it through
+// a ConfigStore instead of opening files so it can live elsewhere, like a database or a bucket, when the disk of the
+// host does not outlive it.
+type ConfigStore interface {
+	// Load returns the config of userID for platform, an error wrapping os.ErrNotExist if there is none.
+	Load(userID UserID, platform config.AvailableBloggingPlatform) (map[string]string, error)
+	// Save replaces the config of userID for platform with cfg.
+	Save(userID UserID, platform config.AvailableBloggingPlatform, cfg map[string]string) error
+	// Remove deletes the config of userID for platform, removing one that does not exist is not an error.
+	Remove(userID UserID, platform config.AvailableBloggingPlatform) error
+}
+
+// EncryptedConfigStore is the default ConfigStore, it keeps each config as a JSON file in the encrypted store, under
+// the same names older versions wrote them.
+type EncryptedConfigStore struct {
+	store *secrets.EncryptedStore
+}
+
+var _ ConfigStore = (*EncryptedConfigStore)(nil)
+
+// NewEncryptedConfigStore creates an EncryptedConfigStore writing to store.
+func NewEncryptedConfigStore(store *secrets.EncryptedStore) *EncryptedConfigStore {
+	return &EncryptedConfigStore{store: store}
+}
+
+// configPath returns the name of the file holding the config of a user for platform, mastodon came first so its
+// config is the only one without the platform in the name.
+func configPath(userID UserID, platform config.AvailableBloggingPlatform) string {
+	kind, account := platform.Kind(), platform.Account()
+	switch {
+	case kind == config.MBPMastodon && account == "":
+		return fmt.Sprintf("%d.json", userID)
+	case kind == config.MBPBsky && account == "":
+		return fmt.Sprintf("%d.bsky.json", userID)
+	case account != "":
+		return fmt.Sprintf("%d.%s.%s.json", userID, kind, account)
+	}
+	return fmt.Sprintf("%d.%s.json", userID, kind)
+}
+
+// Load implements ConfigStore, configs written in the clear by older versions are rewritten encrypted.
+func (s *EncryptedConfigStore) Load(userID UserID, platform config.AvailableBloggingPlatform) (map[string]string, error) {
+	path := configPath(userID, platform)
+	f, err := s.store.OpenReader(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("loading %s config: %w", platform, err)
+	}
+	if err == nil {
+		var cfg map[string]string
+		err = json.NewDecoder(f).Decode(&cfg)
+		f.Close()
+		if err == nil {
+			return cfg, nil
+		}
+	}
+	return s.migratePlaintext(userID, platform)
+}
+
+// migratePlaintext returns the config of a file that could not be decrypted if it is a plaintext one, after saving it
+// encrypted.
+func (s *EncryptedConfigStore) migratePlaintext(userID UserID,
+	platform config.AvailableBloggingPlatform) (map[string]string, error) {
+	path := configPath(userID, platform)
+	r, err := s.store.OpenUnencrypted(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	var cfg map[string]string
+	if err := json.Unmarshal(data, &cfg); err != nil || len(cfg) == 0 {
+		return nil, fmt.Errorf("config %s is neither encrypted nor a plaintext config", path)
+	}
+	if err := s.Save(userID, platform, cfg); err != nil {
+		return nil, fmt.Errorf("encrypting plaintext config: %w", err)
+	}
+	return cfg, nil
+}
+
+// Save implements ConfigStore.
+func (s *EncryptedConfigStore) Save(userID UserID, platform config.AvailableBloggingPlatform,
+	cfg map[string]string) error {
+	f, err := s.store.OpenWriter(configPath(userID, platform))
+	if err != nil {
+		return fmt.Errorf("opening %s config to write: %w", platform, err)
+	}
+	err = json.NewEncoder(f).Encode(cfg)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("writing %s config: %w", platform, err)
+	}
+	return nil
+}
+
+// Remove implements ConfigStore.
+func (s *EncryptedConfigStore) Remove(userID UserID, platform config.AvailableBloggingPlatform) error {
+	if err := s.store.Remove(configPath(userID, platform)); err != nil {
+		return fmt.Errorf("removing %s config: %w", platform, err)
+	}
+	return nil
+}
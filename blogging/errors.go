@@ -3,3 +3,43 @@ package blogging
 import "errors"
 
 var ErrClientNotFound = errors.New("client not found")
+
+// ErrPostNotFound is returned by a Fetcher when the given URL does not point to a post it can retrieve.
+var ErrPostNotFound = errors.New("post not found")
+
+// ErrMediaTooLarge is returned when downloading media that exceeds the allowed size.
+var ErrMediaTooLarge = errors.New("media too large")
+
+// ErrImageIndexOutOfRange is returned when referring to an image the post does not have.
+var ErrImageIndexOutOfRange = errors.New("image index out of range")
+
+// ErrUnknownSegment is returned when referring to a message that added no text to the post.
+var ErrUnknownSegment = errors.New("message added no text to the post")
+
+// ErrImageTooLarge is returned when an image can not be made to fit the size a platform accepts.
+var ErrImageTooLarge = errors.New("image too large")
+
+// ErrCannotScheduleNatively is returned by a NativeScheduler for posts it can not hold server side (i.e. threads).
+var ErrCannotScheduleNatively = errors.New("post can not be scheduled natively")
+
+// ErrVideoTooLarge is returned when a video is bigger than what a platform accepts.
+var ErrVideoTooLarge = errors.New("video too large")
+
+// ErrVideoTooLong is returned when a video lasts longer than what a platform accepts.
+var ErrVideoTooLong = errors.New("video too long")
+
+// ErrMixedMedia is returned when adding media a post can not hold along what it already has, posts carry either
+// images or a single video.
+var ErrMixedMedia = errors.New("posts carry either images or a single video")
+
+// ErrInvalidPoll is returned for polls platforms would not take, i.e. with too few options.
+var ErrInvalidPoll = errors.New("invalid poll")
+
+// ErrNotAuthorized is returned when posting for a user that is not authorized on a platform and can't be authorized.
+var ErrNotAuthorized = errors.New("not authorized")
+
+// ErrUnknownOverflowPolicy is returned when parsing an OverflowPolicy that does not exist.
+var ErrUnknownOverflowPolicy = errors.New("unknown overflow policy")
+
+// ErrTextTooLong is returned, with OverflowReject, for posts too long for a platform.
+var ErrTextTooLong = errors.New("text too long")
@@ -0,0 +1,64 @@
+package blogging
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/perrito666/chat2world/im"
+	"github.com/perrito666/chat2world/secrets"
+)
+
+// ActiveFlowStore is an im.FlowStore keeping the active Flows of each user in the encrypted store.
+type ActiveFlowStore struct {
+	store *secrets.EncryptedStore
+}
+
+var _ im.FlowStore = (*ActiveFlowStore)(nil)
+
+// NewActiveFlowStore creates an ActiveFlowStore writing to store.
+func NewActiveFlowStore(store *secrets.EncryptedStore) *ActiveFlowStore {
+	return &ActiveFlowStore{store: store}
+}
+
+// activeFlowsPath returns the name of the file holding the active Flows of a user.
+func activeFlowsPath(userID uint64) string {
+	return fmt.Sprintf("%d.flows.json", userID)
+}
+
+// LoadActiveFlows implements im.FlowStore, users without a file have no active Flows.
+func (s *ActiveFlowStore) LoadActiveFlows(userID uint64) ([]string, error) {
+	f, err := s.store.OpenReader(activeFlowsPath(userID))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("opening active flows: %w", err)
+	}
+	defer f.Close()
+	var flows []string
+	if err := json.NewDecoder(f).Decode(&flows); err != nil {
+		return nil, fmt.Errorf("reading active flows: %w", err)
+	}
+	return flows, nil
+}
+
+// SaveActiveFlows implements im.FlowStore, the file is removed when no Flow is active.
+func (s *ActiveFlowStore) SaveActiveFlows(userID uint64, flows []string) error {
+	if len(flows) == 0 {
+		return s.store.Remove(activeFlowsPath(userID))
+	}
+	f, err := s.store.OpenWriter(activeFlowsPath(userID))
+	if err != nil {
+		return fmt.Errorf("opening active flows to write: %w", err)
+	}
+	err = json.NewEncoder(f).Encode(flows)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("writing active flows: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,476 @@
+package hugo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/perrito666/chat2world/blogging"
+	"github.com/perrito666/chat2world/config"
+	"github.com/perrito666/chat2world/secrets"
+)
+
+const (
+	// DefaultContentDir is where posts are written, relative to the repository.
+	DefaultContentDir = "content/posts"
+	// DefaultStaticDir is where images are written, relative to the repository, hugo serves static/ at the site root.
+	DefaultStaticDir = "static/images"
+
+	FrontMatterTOML = "toml"
+	FrontMatterYAML = "yaml"
+)
+
+// Config holds the configuration for writing posts into a hugo site repository.
+type Config struct {
+	RepoPath    string `json:"repo_path,omitempty"`
+	ContentDir  string `json:"content_dir,omitempty"`
+	StaticDir   string `json:"static_dir,omitempty"`
+	Author      string `json:"author,omitempty"`
+	FrontMatter string `json:"front_matter,omitempty"` // either toml or yaml
+	GitCommit   bool   `json:"git_commit,omitempty"`
+	GitPush     bool   `json:"git_push,omitempty"`
+}
+
+func (c *Config) LoadFromPersistableDict(dict map[string]string) error {
+	c.RepoPath = dict["repo_path"]
+	c.ContentDir = dict["content_dir"]
+	c.StaticDir = dict["static_dir"]
+	c.Author = dict["author"]
+	c.FrontMatter = dict["front_matter"]
+	c.GitCommit = dict["git_commit"] == "true"
+	c.GitPush = dict["git_push"] == "true"
+	return nil
+}
+
+func (c *Config) DumpToPersistableDict() map[string]string {
+	return map[string]string{
+		"repo_path":    c.RepoPath,
+		"content_dir":  c.ContentDir,
+		"static_dir":   c.StaticDir,
+		"author":       c.Author,
+		"front_matter": c.FrontMatter,
+		"git_commit":   strconv.FormatBool(c.GitCommit),
+		"git_push":     strconv.FormatBool(c.GitPush),
+	}
+}
+
+// withDefaults fills in the optional settings that were left empty.
+func (c *Config) withDefaults() {
+	if c.ContentDir == "" {
+		c.ContentDir = DefaultContentDir
+	}
+	if c.StaticDir == "" {
+		c.StaticDir = DefaultStaticDir
+	}
+	if c.FrontMatter != FrontMatterYAML {
+		c.FrontMatter = FrontMatterTOML
+	}
+}
+
+var _ blogging.ClientConfig = (*Config)(nil)
+
+// Client writes posts as markdown files into a hugo site and, optionally, commits and pushes them.
+type Client struct {
+	store  *secrets.EncryptedStore
+	config *Config
+	userID blogging.UserID
+	logger *slog.Logger
+}
+
+func (c *Client) Config(userID blogging.UserID) (blogging.ClientConfig, error) {
+	if c.config == nil {
+		return nil, blogging.ErrClientNotFound
+	}
+	return c.config, nil
+}
+
+// ClientOption configures optional settings of a Client.
+type ClientOption func(*Client)
+
+// WithLogger sets where the client logs, it defaults to slog.Default().
+func WithLogger(logger *slog.Logger) ClientOption {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// NewClient creates a new hugo client, its configuration is loaded from store when first needed.
+func NewClient(store *secrets.EncryptedStore, opts ...ClientOption) (*Client, error) {
+	c := &Client{
+		store:  store,
+		config: &Config{},
+		logger: slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.logger = c.logger.With("platform", config.BPHugo)
+	return c, nil
+}
+
+var _ blogging.AuthedPlatform = (*Client)(nil)
+
+// configPath returns the name of the file holding the hugo configuration of a user.
+func configPath(id blogging.UserID) string {
+	return fmt.Sprintf("%d.hugo.json", id)
+}
+
+// IsAuthorized returns true if the user told us where the site repository is and it exists.
+func (c *Client) IsAuthorized(id blogging.UserID) bool {
+	if c.userID == 0 {
+		c.userID = id
+	}
+	if c.config.RepoPath == "" {
+		if err := c.loadConfigIfExists(id); err != nil {
+			c.logger.Error("loading config", "user_id", id, "err", err)
+			return false
+		}
+	}
+	if c.config.RepoPath == "" {
+		return false
+	}
+	info, err := os.Stat(c.config.RepoPath)
+	return err == nil && info.IsDir()
+}
+
+// Forget implements blogging.Forgetter.
+func (c *Client) Forget(userID blogging.UserID) error {
+	if err := c.store.Remove(configPath(userID)); err != nil {
+		return fmt.Errorf("removing config: %w", err)
+	}
+	c.config = &Config{}
+	c.logger.Info("config forgotten", "user_id", userID)
+	return nil
+}
+
+var _ blogging.Forgetter = (*Client)(nil)
+
+// loadConfigIfExists loads a config from a file if it exists.
+func (c *Client) loadConfigIfExists(id blogging.UserID) error {
+	f, err := c.store.OpenReader(configPath(id))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	cfg := &Config{}
+	if err := json.NewDecoder(f).Decode(cfg); err != nil {
+		return fmt.Errorf("loading configuration for hugo from disk: %w", err)
+	}
+	cfg.withDefaults()
+	c.config = cfg
+	return nil
+}
+
+// ask sends question through comms and returns the answer, ok is false if the context was canceled.
+func ask(ctx context.Context, comms chan string, question string) (string, bool) {
+	select {
+	case comms <- question:
+	case <-ctx.Done():
+		return "", false
+	}
+	select {
+	case answer := <-comms:
+		return strings.TrimSpace(answer), true
+	case <-ctx.Done():
+		return "", false
+	}
+}
+
+// StartAuthorization asks the user for the site repository and author name, the rest of the settings can be given in
+// cfgGeneric (see Config.LoadFromPersistableDict) and otherwise take their defaults.
+func (c *Client) StartAuthorization(ctx context.Context, id blogging.UserID, cfgGeneric map[string]string) (chan string, error) {
+	if c.userID == 0 {
+		c.userID = id
+	}
+	cfg := &Config{}
+	if cfgGeneric != nil {
+		if err := cfg.LoadFromPersistableDict(cfgGeneric); err != nil {
+			return nil, fmt.Errorf("loading config: %w", err)
+		}
+	}
+	commsChan := make(chan string)
+	go func(id blogging.UserID, cfg *Config, comms chan string) {
+		defer close(comms)
+		var ok bool
+		for cfg.RepoPath == "" {
+			if cfg.RepoPath, ok = ask(ctx, comms, "What is the path of your hugo site repository?"); !ok {
+				return
+			}
+			if info, err := os.Stat(cfg.RepoPath); err != nil || !info.IsDir() {
+				c.logger.Info("repository path is not a directory", "user_id", id, "path", cfg.RepoPath, "err", err)
+				cfg.RepoPath = ""
+			}
+		}
+		if cfg.Author == "" {
+			if cfg.Author, ok = ask(ctx, comms, "What author name should the posts have?"); !ok {
+				return
+			}
+		}
+		if cfgGeneric == nil {
+			answer, ok := ask(ctx, comms, "Should posts be committed to git? (no/commit/push)")
+			if !ok {
+				return
+			}
+			switch strings.ToLower(answer) {
+			case "push":
+				cfg.GitCommit, cfg.GitPush = true, true
+			case "commit", "yes":
+				cfg.GitCommit = true
+			}
+		}
+		cfg.withDefaults()
+
+		f, err := c.store.OpenWriter(configPath(id))
+		if err != nil {
+			c.logger.Error("opening config to write", "user_id", id, "err", err)
+			return
+		}
+		defer f.Close()
+		if err := json.NewEncoder(f).Encode(cfg); err != nil {
+			c.logger.Error("writing config", "user_id", id, "err", err)
+			return
+		}
+		c.config = cfg
+	}(id, cfg, commsChan)
+	return commsChan, nil
+}
+
+var _ blogging.Platform = (*Client)(nil)
+
+// hashtagRegex finds hashtags, which become the tags of the post.
+var hashtagRegex = regexp.MustCompile(`(?:^|\s)#(\w+)`)
+
+// maxTitleLen is how many characters of the first line of the post we use as title.
+const maxTitleLen = 70
+
+// postTitle returns a title for the post, its first line shortened if needed.
+func postTitle(text string) string {
+	title, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	title = strings.TrimSpace(title)
+	if runes := []rune(title); len(runes) > maxTitleLen {
+		title = strings.TrimSpace(string(runes[:maxTitleLen])) + "…"
+	}
+	return title
+}
+
+// slugify turns s into something usable as a file name and URL.
+func slugify(s string) string {
+	var sb strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			sb.WriteRune(r)
+			dash = false
+			continue
+		}
+		if !dash && sb.Len() > 0 {
+			sb.WriteRune('-')
+			dash = true
+		}
+	}
+	return strings.Trim(sb.String(), "-")
+}
+
+// postTags returns the tags of the post followed by the hashtags of its text, without repetitions.
+func postTags(post *blogging.MicroblogPost) []string {
+	var tags []string
+	seen := map[string]bool{}
+	candidates := slices.Clone(post.Tags)
+	for _, m := range hashtagRegex.FindAllStringSubmatch(post.Text, -1) {
+		candidates = append(candidates, m[1])
+	}
+	for _, tag := range candidates {
+		tag = strings.ToLower(tag)
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// quoteList renders a list of strings in a format both TOML and YAML understand.
+func quoteList(items []string) string {
+	quoted := make([]string, len(items))
+	for i, item := range items {
+		quoted[i] = strconv.Quote(item)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// frontMatter renders the front matter of a post in the configured format, strconv.Quote output is a valid basic
+// string for TOML and a valid double-quoted one for YAML.
+func (c *Config) frontMatter(title, author string, date time.Time, tags []string) string {
+	sep, assign := "+++", " = "
+	if c.FrontMatter == FrontMatterYAML {
+		sep, assign = "---", ": "
+	}
+	var sb strings.Builder
+	sb.WriteString(sep + "\n")
+	sb.WriteString("title" + assign + strconv.Quote(title) + "\n")
+	sb.WriteString("date" + assign + date.Format(time.RFC3339) + "\n")
+	sb.WriteString("draft" + assign + "false\n")
+	sb.WriteString("tags" + assign + quoteList(tags) + "\n")
+	if author != "" {
+		sb.WriteString("author" + assign + strconv.Quote(author) + "\n")
+	}
+	sb.WriteString(sep + "\n")
+	return sb.String()
+}
+
+// imageExtension returns the file extension for the image data.
+func imageExtension(data []byte) string {
+	switch mimeType := http.DetectContentType(data); mimeType {
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	default:
+		return ".jpg"
+	}
+}
+
+// git runs a git command in the site repository.
+func (c *Client) git(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", c.config.RepoPath}, args...)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// renderedImage is an image of the post and the name it gets in the static directory.
+type renderedImage struct {
+	name string
+	data []byte
+}
+
+// renderedPost is a post as it would be written to the site.
+type renderedPost struct {
+	title    string
+	slug     string
+	postFile string
+	body     string
+	images   []renderedImage
+}
+
+// render builds the markdown file for post and names its images, without writing anything.
+func (c *Client) render(post *blogging.MicroblogPost, now time.Time) *renderedPost {
+	post = post.WithQuoteLink()
+	r := &renderedPost{title: postTitle(post.Text)}
+	r.slug = now.Format("2006-01-02-150405")
+	if titleSlug := slugify(r.title); titleSlug != "" {
+		r.slug += "-" + titleSlug
+	}
+
+	var body strings.Builder
+	body.WriteString(c.config.frontMatter(r.title, c.config.Author, now, postTags(post)))
+	body.WriteString("\n" + strings.TrimSpace(post.Text) + "\n")
+	if len(post.Images) > 0 {
+		body.WriteString("\n")
+	}
+	for idx, img := range post.Images {
+		name := fmt.Sprintf("%s-%d%s", r.slug, idx+1, imageExtension(img.Data))
+		r.images = append(r.images, renderedImage{name: name, data: img.Data})
+		// static/ is served at the root of the site.
+		link := path.Join("/", strings.TrimPrefix(filepath.ToSlash(c.config.StaticDir), "static"), name)
+		fmt.Fprintf(&body, "![%s](%s)\n", strings.ReplaceAll(img.AltText, "]", "\\]"), link)
+	}
+	r.body = body.String()
+	r.postFile = filepath.Join(c.config.ContentDir, r.slug+".md")
+	return r
+}
+
+var _ blogging.Previewer = (*Client)(nil)
+
+// Preview implements blogging.Previewer, it shows the file Post would write and where its images would go.
+func (c *Client) Preview(ctx context.Context, userID blogging.UserID, post *blogging.MicroblogPost) (string, error) {
+	if !c.IsAuthorized(userID) {
+		return "", fmt.Errorf("hugo site not configured, use /hugo_auth: %w", blogging.ErrClientNotFound)
+	}
+	r := c.render(post, time.Now())
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s:\n%s", r.postFile, r.body)
+	for _, img := range r.images {
+		fmt.Fprintf(&sb, "%s (%d bytes)\n", filepath.Join(c.config.StaticDir, img.name), len(img.data))
+	}
+	switch {
+	case c.config.GitPush:
+		sb.WriteString("then committed and pushed")
+	case c.config.GitCommit:
+		sb.WriteString("then committed")
+	}
+	return strings.TrimRight(sb.String(), "\n"), nil
+}
+
+// Post writes the post as a markdown file in the content directory of the site, its images go to the static
+// directory and are linked from the post. If configured the files are then committed and pushed. The URL of the result
+// is the path of the post within the repository.
+func (c *Client) Post(ctx context.Context, userID blogging.UserID, post *blogging.MicroblogPost) (*blogging.PostResult, error) {
+	if !c.IsAuthorized(userID) {
+		return nil, fmt.Errorf("hugo site not configured, use /hugo_auth: %w", blogging.ErrClientNotFound)
+	}
+	now := time.Now()
+	r := c.render(post, now)
+
+	var files []string
+	imagesDir := filepath.Join(c.config.RepoPath, c.config.StaticDir)
+	if len(r.images) > 0 {
+		if err := os.MkdirAll(imagesDir, 0755); err != nil {
+			return nil, fmt.Errorf("creating images directory: %w", err)
+		}
+	}
+	for idx, img := range r.images {
+		if err := os.WriteFile(filepath.Join(imagesDir, img.name), img.data, 0644); err != nil {
+			return nil, fmt.Errorf("writing image %d: %w", idx, err)
+		}
+		files = append(files, filepath.Join(c.config.StaticDir, img.name))
+	}
+
+	contentDir := filepath.Join(c.config.RepoPath, c.config.ContentDir)
+	if err := os.MkdirAll(contentDir, 0755); err != nil {
+		return nil, fmt.Errorf("creating content directory: %w", err)
+	}
+	postFile := r.postFile
+	if err := os.WriteFile(filepath.Join(c.config.RepoPath, postFile), []byte(r.body), 0644); err != nil {
+		return nil, fmt.Errorf("writing post: %w", err)
+	}
+	files = append(files, postFile)
+
+	if c.config.GitCommit || c.config.GitPush {
+		if err := c.git(ctx, append([]string{"add", "--"}, files...)...); err != nil {
+			return nil, fmt.Errorf("post written to %s but not committed: %w", postFile, err)
+		}
+		if err := c.git(ctx, "commit", "-m", "Add post: "+r.title); err != nil {
+			return nil, fmt.Errorf("post written to %s but not committed: %w", postFile, err)
+		}
+	}
+	if c.config.GitPush {
+		if err := c.git(ctx, "push"); err != nil {
+			return nil, fmt.Errorf("post %s committed but not pushed: %w", postFile, err)
+		}
+	}
+	result := &blogging.PostResult{URL: postFile, ID: r.slug, PostedAt: now, Parts: 1}
+	if post.Video != nil {
+		result.Warnings = append(result.Warnings, "videos are not supported, the post went without it")
+	}
+	if post.Poll != nil {
+		result.Warnings = append(result.Warnings, "polls are not supported, the post went without it")
+	}
+	return result, nil
+}
@@ -0,0 +1,101 @@
+package hugo
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/perrito666/chat2world/blogging"
+	"github.com/perrito666/chat2world/secrets"
+)
+
+// pngHeader is enough of a PNG to be told apart as one.
+var pngHeader = []byte("\x89PNG\r\n\x1a\n")
+
+// newTestClient returns a client writing to a new git repository in a temporary directory.
+func newTestClient(t *testing.T, cfg Config) *Client {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	// the commits must not depend on the configuration of whoever runs the tests.
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+
+	cfg.RepoPath = t.TempDir()
+	if out, err := exec.Command("git", "init", "-q", cfg.RepoPath).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v: %s", err, out)
+	}
+	cfg.withDefaults()
+	store := &secrets.EncryptedStore{Password: "test", Params: secrets.ScryptParams{N: 1024, R: 8, P: 1},
+		Backend: &secrets.MemoryBackend{}}
+	c, err := NewClient(store)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	c.config = &cfg
+	return c
+}
+
+func TestPostWritesAndCommitsMarkdown(t *testing.T) {
+	c := newTestClient(t, Config{Author: "Me", GitCommit: true})
+	post := &blogging.MicroblogPost{Text: "Hello world\nposted from the chat #golang", Tags: []string{"test"}}
+	post.AddImage(blogging.NewBlogImage(pngHeader, "a gopher"))
+
+	result, err := c.Post(context.Background(), 1, post)
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	if dir := filepath.Dir(result.URL); dir != DefaultContentDir {
+		t.Errorf("post written to %s, want it in %s", result.URL, DefaultContentDir)
+	}
+	content, err := os.ReadFile(filepath.Join(c.config.RepoPath, result.URL))
+	if err != nil {
+		t.Fatalf("reading the post: %v", err)
+	}
+	for _, want := range []string{
+		`title = "Hello world"`,
+		`tags = ["test", "golang"]`,
+		`author = "Me"`,
+		"Hello world\nposted from the chat #golang\n",
+		"![a gopher](/images/" + result.ID + "-1.png)",
+	} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("the post does not contain %q:\n%s", want, content)
+		}
+	}
+	image, err := os.ReadFile(filepath.Join(c.config.RepoPath, DefaultStaticDir, result.ID+"-1.png"))
+	if err != nil || string(image) != string(pngHeader) {
+		t.Errorf("image = %q, %v, want the one posted", image, err)
+	}
+
+	out, err := exec.Command("git", "-C", c.config.RepoPath, "log", "--name-only", "--format=%s").CombinedOutput()
+	if err != nil {
+		t.Fatalf("git log: %v: %s", err, out)
+	}
+	if log := string(out); !strings.Contains(log, "Add post: Hello world") || !strings.Contains(log, result.URL) {
+		t.Errorf("git log = %q, want a commit adding %s", log, result.URL)
+	}
+}
+
+func TestPostYAMLFrontMatter(t *testing.T) {
+	c := newTestClient(t, Config{FrontMatter: FrontMatterYAML})
+	result, err := c.Post(context.Background(), 1, &blogging.MicroblogPost{Text: "short"})
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(c.config.RepoPath, result.URL))
+	if err != nil {
+		t.Fatalf("reading the post: %v", err)
+	}
+	if !strings.HasPrefix(string(content), "---\ntitle: \"short\"\n") {
+		t.Errorf("the post does not start with YAML front matter:\n%s", content)
+	}
+}
@@ -0,0 +1,65 @@
+package blogging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	_ "image/png" // register PNG format
+
+	_ "golang.org/x/image/webp" // register WebP format
+
+	"github.com/perrito666/chat2world/config"
+)
+
+// PlatformImageSizeLimits holds the largest image, in bytes, each platform accepts.
+var PlatformImageSizeLimits = map[config.AvailableBloggingPlatform]int{
+	config.MBPMastodon: 16 << 20,
+	config.MBPBsky:     1000000,
+}
+
+// jpegQualities are the qualities we try, in order, when re-encoding an image that is too large.
+var jpegQualities = []int{90, 80, 70, 60, 50, 40, 30, 20}
+
+// isAnimatedGIF returns true if data is a GIF with more than one frame.
+func isAnimatedGIF(data []byte) bool {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	return err == nil && len(g.Image) > 1
+}
+
+// NormalizeImage returns an image no larger than maxBytes, if the image already fits it is returned as is, otherwise
+// a copy is re-encoded as JPEG with decreasing quality, keeping its dimensions, until it fits. Animated GIFs are
+// returned untouched since re-encoding would lose the animation. It fails with ErrImageTooLarge if not even the lowest
+// quality fits.
+func (i *BlogImage) NormalizeImage(maxBytes int) (*BlogImage, error) {
+	if maxBytes <= 0 || len(i.Data) <= maxBytes {
+		return i, nil
+	}
+	if isAnimatedGIF(i.Data) {
+		return i, nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(i.Data))
+	if err != nil {
+		return nil, fmt.Errorf("decoding image to shrink it: %w", err)
+	}
+	// JPEG has no transparency, flatten it on white rather than the black it would otherwise become.
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+
+	var buf bytes.Buffer
+	for _, quality := range jpegQualities {
+		buf.Reset()
+		if err := jpeg.Encode(&buf, flat, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, fmt.Errorf("encoding image as JPEG: %w", err)
+		}
+		if buf.Len() <= maxBytes {
+			return NewBlogImage(bytes.Clone(buf.Bytes()), i.AltText), nil
+		}
+	}
+	return nil, fmt.Errorf("%d bytes at quality %d, limit is %d: %w",
+		buf.Len(), jpegQualities[len(jpegQualities)-1], maxBytes, ErrImageTooLarge)
+}
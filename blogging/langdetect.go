@@ -0,0 +1,148 @@
+package blogging
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Language detection compares the character trigrams of a text with those of a sample of each known language, it
+// is crude but needs no service and is good enough to tell the platforms what a short post is written in.
+
+// languageSamples are small texts in each language we can detect, their trigrams are the language profiles.
+var languageSamples = map[string]string{
+	"en": `I just got back from a long walk in the park and the weather was great, so I think I will go out again
+tomorrow if it does not rain. Have you ever wondered why people post what they had for breakfast? This is what
+the internet is for, sharing the small things of the day with the people you know and the ones you do not.
+The new release is out with a few fixes and some features that were asked for, thanks to everyone who helped
+with the code, the reviews and the testing. What do you think about it, would you use something like this?`,
+	"es": `Acabo de volver de una larga caminata por el parque y el clima estaba muy lindo, así que creo que mañana
+voy a salir de nuevo si no llueve. ¿Alguna vez te preguntaste por qué la gente publica lo que desayunó? Para eso
+es internet, para compartir las pequeñas cosas del día con la gente que conocés y con la que no. Ya salió la nueva
+versión con algunos arreglos y las funciones que se pidieron, gracias a todos los que ayudaron con el código, las
+revisiones y las pruebas. ¿Qué les parece, usarían algo así? Estoy muy contento con cómo quedó todo esto.`,
+	"pt": `Acabei de voltar de uma longa caminhada no parque e o tempo estava ótimo, então acho que vou sair de novo
+amanhã se não chover. Você já se perguntou por que as pessoas publicam o que comeram no café da manhã? É para isso
+que serve a internet, para compartilhar as pequenas coisas do dia com as pessoas que você conhece e com as que não.
+A nova versão saiu com algumas correções e as funções que foram pedidas, obrigado a todos que ajudaram com o código,
+as revisões e os testes. O que vocês acham, usariam algo assim? Estou muito feliz com como ficou.`,
+	"fr": `Je viens de rentrer d'une longue promenade dans le parc et il faisait très beau, alors je pense que je vais
+ressortir demain s'il ne pleut pas. Vous êtes-vous déjà demandé pourquoi les gens publient ce qu'ils ont mangé au
+petit déjeuner? C'est à ça que sert internet, partager les petites choses de la journée avec les gens que l'on
+connaît et les autres. La nouvelle version est sortie avec quelques corrections et les fonctions qui avaient été
+demandées, merci à tous ceux qui ont aidé avec le code, les relectures et les tests. Qu'en pensez-vous?`,
+	"de": `Ich bin gerade von einem langen Spaziergang im Park zurückgekommen und das Wetter war wunderbar, also werde
+ich morgen wieder rausgehen, wenn es nicht regnet. Hast du dich schon einmal gefragt, warum die Leute posten, was
+sie zum Frühstück hatten? Dafür ist das Internet da, um die kleinen Dinge des Tages mit den Menschen zu teilen, die
+man kennt, und mit denen, die man nicht kennt. Die neue Version ist mit einigen Korrekturen und den gewünschten
+Funktionen erschienen, danke an alle, die beim Code, den Reviews und den Tests geholfen haben. Was denkt ihr?`,
+	"it": `Sono appena tornato da una lunga passeggiata nel parco e il tempo era bellissimo, quindi penso che domani
+uscirò di nuovo se non piove. Ti sei mai chiesto perché la gente pubblica quello che ha mangiato a colazione? A questo
+serve internet, a condividere le piccole cose della giornata con le persone che conosci e con quelle che non conosci.
+La nuova versione è uscita con alcune correzioni e le funzioni che erano state richieste, grazie a tutti quelli che
+hanno aiutato con il codice, le revisioni e i test. Che ne pensate, usereste qualcosa del genere?`,
+}
+
+// MinLanguageConfidence is the confidence under which DetectLanguage results should not be trusted.
+const MinLanguageConfidence = 0.1
+
+// mixedLanguageRatio is how close, relative to the best, another language must score to be reported too.
+const mixedLanguageRatio = 0.9
+
+type trigramProfile map[string]float64
+
+var languageProfiles = func() map[string]trigramProfile {
+	profiles := make(map[string]trigramProfile, len(languageSamples))
+	for lang, sample := range languageSamples {
+		profiles[lang] = trigrams(sample)
+	}
+	return profiles
+}()
+
+// trigrams returns the normalized trigram frequencies of the words of text, links, mentions and hashtags are left out
+// as they say nothing about the language.
+func trigrams(text string) trigramProfile {
+	profile := trigramProfile{}
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		if strings.Contains(word, "://") || strings.HasPrefix(word, "@") || strings.HasPrefix(word, "#") {
+			continue
+		}
+		letters := strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) {
+				return r
+			}
+			return -1
+		}, word)
+		if letters == "" {
+			continue
+		}
+		runes := []rune(" " + letters + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			profile[string(runes[i:i+3])]++
+		}
+	}
+	var norm float64
+	for _, count := range profile {
+		norm += count * count
+	}
+	norm = math.Sqrt(norm)
+	for gram := range profile {
+		profile[gram] /= norm
+	}
+	return profile
+}
+
+// DetectLanguage guesses the language of text among the ones we have samples for. It returns the language, or two
+// of them if the text looks mixed, and a confidence between 0 and 1, anything under MinLanguageConfidence (i.e.
+// very short texts) is a guess.
+func DetectLanguage(text string) ([]string, float64) {
+	profile := trigrams(text)
+	if len(profile) == 0 {
+		return nil, 0
+	}
+	type score struct {
+		lang  string
+		score float64
+	}
+	scores := make([]score, 0, len(languageProfiles))
+	for lang, langProfile := range languageProfiles {
+		var s float64
+		for gram, weight := range profile {
+			s += weight * langProfile[gram]
+		}
+		scores = append(scores, score{lang: lang, score: s})
+	}
+	sort.Slice(scores, func(i, j int) bool { return scores[i].score > scores[j].score })
+	best, second := scores[0], scores[1]
+	if best.score == 0 {
+		return nil, 0
+	}
+	// how far ahead the best is, scaled by how well it matches at all.
+	confidence := (best.score - second.score) / best.score * math.Min(1, best.score*2)
+	if second.score >= best.score*mixedLanguageRatio {
+		return []string{best.lang, second.lang}, best.score
+	}
+	return []string{best.lang}, confidence
+}
+
+// resolveLangs sets the languages of a post the user gave none for, detected from its text if the user didn't turn
+// detection off and it is confident enough, the default languages of the user otherwise.
+func (p *PostingFlow) resolveLangs(userID uint64, post *MicroblogPost) {
+	if len(post.Langs) > 0 {
+		return
+	}
+	p.postsMutex.Lock()
+	settings := p.userSettings(userID)
+	skip, defaults := settings.SkipLangDetection, slices.Clone(settings.DefaultLangs)
+	p.postsMutex.Unlock()
+	if !skip {
+		langs, confidence := DetectLanguage(post.Text)
+		if len(langs) > 0 && confidence >= MinLanguageConfidence {
+			post.Langs = langs
+			return
+		}
+	}
+	post.Langs = defaults
+}
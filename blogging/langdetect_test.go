@@ -0,0 +1,63 @@
+package blogging
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/perrito666/chat2world/config"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	for _, tc := range []struct {
+		text string
+		want []string
+	}{
+		{"Hoy salí a caminar por el parque y estuvo muy lindo", []string{"es"}},
+		{"I went for a walk in the park today and it was great", []string{"en"}},
+		{"¿Qué les parece la nueva versión? #golang https://example.com/the-new-release", []string{"es"}},
+	} {
+		langs, confidence := DetectLanguage(tc.text)
+		if !slices.Equal(langs, tc.want) {
+			t.Errorf("DetectLanguage(%q) = %q, want %q", tc.text, langs, tc.want)
+		}
+		if confidence < MinLanguageConfidence {
+			t.Errorf("DetectLanguage(%q) confidence = %v, want at least %v", tc.text, confidence, MinLanguageConfidence)
+		}
+	}
+}
+
+func TestDetectLanguageMixed(t *testing.T) {
+	langs, _ := DetectLanguage("the weather was great today así que mañana voy a salir de nuevo")
+	if !slices.Contains(langs, "es") || !slices.Contains(langs, "en") {
+		t.Errorf("DetectLanguage() = %q, want both es and en", langs)
+	}
+}
+
+func TestDetectLanguageWithoutWords(t *testing.T) {
+	if langs, confidence := DetectLanguage("👍 https://example.com @someone"); langs != nil || confidence != 0 {
+		t.Errorf("DetectLanguage() = %q, %v, want nothing", langs, confidence)
+	}
+}
+
+func TestExplicitLangsWinOverDetection(t *testing.T) {
+	platform := &fakePlatform{}
+	p := NewPostingFlow(map[config.AvailableBloggingPlatform]AuthedPlatform{config.MBPMastodon: platform}, nil)
+	messenger := &recordingMessenger{}
+	say(t, p, messenger, "/new langs=en")
+	say(t, p, messenger, "Hoy salí a caminar por el parque y estuvo muy lindo")
+	say(t, p, messenger, "/send")
+	say(t, p, messenger, "/new")
+	say(t, p, messenger, "Hoy salí a caminar por el parque y estuvo muy lindo")
+	say(t, p, messenger, "/send")
+
+	posted := platform.posted()
+	if len(posted) != 2 {
+		t.Fatalf("%d posts sent, want 2: %s", len(posted), messenger.all())
+	}
+	if !slices.Equal(posted[0].Langs, []string{"en"}) {
+		t.Errorf("posted with langs=en as %q, want [en]", posted[0].Langs)
+	}
+	if !slices.Equal(posted[1].Langs, []string{"es"}) {
+		t.Errorf("posted without langs= as %q, want it detected, [es]", posted[1].Langs)
+	}
+}
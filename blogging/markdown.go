@@ -0,0 +1,177 @@
+package blogging
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/perrito666/chat2world/config"
+)
+
+// markdownPlatforms are the platforms that take markdown as typed, the rest show it raw so it is converted to plain
+// text for them.
+var markdownPlatforms = []config.AvailableBloggingPlatform{config.BPHugo}
+
+var (
+	// markdownCodeRegex finds code spans, whatever is in them is left alone.
+	markdownCodeRegex = regexp.MustCompile("`([^`\n]+)`")
+	// markdownLinkRegex finds [text](url) links.
+	markdownLinkRegex = regexp.MustCompile(`\[([^\]\n]+)\]\((https?://[^)\s]+)\)`)
+	// markdownEscapeRegex finds markers escaped with a backslash, which stand for themselves.
+	markdownEscapeRegex = regexp.MustCompile("\\\\[*_~`]")
+	// codePlaceholderRegex finds what markdownToPlain puts where code spans and escaped markers were.
+	codePlaceholderRegex = regexp.MustCompile("\x00[0-9]+\x00")
+	// markdownHeadingRegex finds the # of headings, hashtags have no space after the # so they don't match.
+	markdownHeadingRegex = regexp.MustCompile(`(?m)^#{1,6}[ \t]+`)
+)
+
+// RenderFor returns the text of the post as it should be sent to platform: as typed for platforms that take markdown
+// and converted to plain text for the rest, with links written as "text (url)" so the URL is still linked. Platforms
+// that take tags as hashtags get the Tags missing from the text at its end.
+func (b *MicroblogPost) RenderFor(platform config.AvailableBloggingPlatform) string {
+	text := b.Text
+	if !slices.Contains(markdownPlatforms, platform.Kind()) {
+		text = markdownToPlain(text)
+	}
+	if slices.Contains(hashtagPlatforms, platform.Kind()) {
+		text = appendHashtags(text, b.Tags)
+	}
+	return text
+}
+
+// renderedFor returns the post as it should be sent to platform, b itself if rendering changes nothing.
+func (b *MicroblogPost) renderedFor(platform config.AvailableBloggingPlatform) *MicroblogPost {
+	text := b.RenderFor(platform)
+	if text == b.Text {
+		return b
+	}
+	rendered := *b
+	rendered.Text = text
+	return &rendered
+}
+
+// markdownToPlain converts the markdown in s to plain text, code spans and URLs are kept as they are and escaped
+// markers lose their backslash.
+func markdownToPlain(s string) string {
+	// escaped markers and code spans are set aside while converting, so markers around them (i.e. **`code`**) still
+	// pair up and escaped ones pair with nothing.
+	var literals []string
+	setAside := func(literal string) string {
+		literals = append(literals, literal)
+		return fmt.Sprintf("\x00%d\x00", len(literals)-1)
+	}
+	restore := func(s, escape string) string {
+		return codePlaceholderRegex.ReplaceAllStringFunc(s, func(placeholder string) string {
+			i, err := strconv.Atoi(strings.Trim(placeholder, "\x00"))
+			if err != nil || i >= len(literals) {
+				return placeholder
+			}
+			return escape + literals[i]
+		})
+	}
+	s = markdownEscapeRegex.ReplaceAllStringFunc(s, func(escaped string) string {
+		return setAside(escaped[1:])
+	})
+	s = markdownCodeRegex.ReplaceAllStringFunc(s, func(code string) string {
+		// backslashes mean nothing in code, it keeps them.
+		return setAside(restore(markdownCodeRegex.FindStringSubmatch(code)[1], `\`))
+	})
+	return restore(plainOutsideCode(s), "")
+}
+
+// plainOutsideCode converts the markdown of s, which has its code spans set aside.
+func plainOutsideCode(s string) string {
+	s = markdownLinkRegex.ReplaceAllStringFunc(s, func(link string) string {
+		parts := markdownLinkRegex.FindStringSubmatch(link)
+		if parts[1] == parts[2] {
+			return parts[2]
+		}
+		return parts[1] + " (" + parts[2] + ")"
+	})
+	// URLs are left alone, underscores and asterisks in them are not emphasis.
+	var sb strings.Builder
+	last := 0
+	for _, url := range urlRegex.FindAllStringIndex(s, -1) {
+		sb.WriteString(stripEmphasis(s[last:url[0]]))
+		sb.WriteString(s[url[0]:url[1]])
+		last = url[1]
+	}
+	sb.WriteString(stripEmphasis(s[last:]))
+	return sb.String()
+}
+
+// Emphasis markers, bold and strikethrough go first so **_both_** loses all of them.
+var (
+	strongMarkers   = []string{"**", "__", "~~"}
+	emphasisMarkers = []string{"*", "_"}
+)
+
+// stripEmphasis removes headings, bold, italic and strikethrough markers from s, which has no code spans or URLs.
+func stripEmphasis(s string) string {
+	s = markdownHeadingRegex.ReplaceAllString(s, "")
+	return stripMarkers(stripMarkers(s, strongMarkers), emphasisMarkers)
+}
+
+// stripMarkers removes the given markers from around the spans of s they emphasize. The characters next to a span are
+// looked at but not consumed, so spans right next to each other (i.e. *one* *two*) are all found.
+func stripMarkers(s string, markers []string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		if inner, n, ok := emphasisAt(s, i, markers); ok {
+			sb.WriteString(inner)
+			i += n
+			continue
+		}
+		sb.WriteByte(s[i])
+		i++
+	}
+	return sb.String()
+}
+
+// emphasisAt tells if one of markers opens a span at s[i] and closes it on the same line, returning the text between
+// them and how long the span is, markers included. Single markers and __ only count between non word characters, so
+// snake_case and multiplications like 2*3*4 are left alone, and a single word between __ is taken for a name like
+// __init__ rather than bold.
+func emphasisAt(s string, i int, markers []string) (string, int, bool) {
+	for _, marker := range markers {
+		if !strings.HasPrefix(s[i:], marker) {
+			continue
+		}
+		start := i + len(marker)
+		end := strings.IndexAny(s[start:], marker[:1]+"\n")
+		if end <= 0 || !strings.HasPrefix(s[start+end:], marker) {
+			continue
+		}
+		inner, after := s[start:start+end], start+end+len(marker)
+		if marker == "*" || marker == "_" || marker == "__" {
+			before, _ := utf8.DecodeLastRuneInString(s[:i])
+			next, _ := utf8.DecodeRuneInString(s[after:])
+			if !markerBoundary(before, marker) || !markerBoundary(next, marker) {
+				continue
+			}
+		}
+		if len(marker) == 1 && strings.TrimSpace(inner) != inner {
+			continue
+		}
+		if marker == "__" && strings.IndexFunc(inner, notWordRune) < 0 {
+			continue
+		}
+		return inner, after - i, true
+	}
+	return "", 0, false
+}
+
+// markerBoundary tells if r, the character before or after a span (utf8.RuneError at either end of the text), lets
+// marker emphasize it.
+func markerBoundary(r rune, marker string) bool {
+	return r == utf8.RuneError || (notWordRune(r) && !strings.ContainsRune(marker, r))
+}
+
+// notWordRune tells if r is not part of a word, as in snake_case or 2*3.
+func notWordRune(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+}
@@ -0,0 +1,50 @@
+package blogging
+
+import (
+	"testing"
+
+	"github.com/perrito666/chat2world/config"
+)
+
+func TestRenderFor(t *testing.T) {
+	for _, tc := range []struct {
+		name, text string
+		// plain is what platforms without markdown get, platforms with it get text as typed.
+		plain string
+	}{
+		{"link", "read [my post](https://example.com/post) now", "read my post (https://example.com/post) now"},
+		{"link to itself", "[https://example.com](https://example.com)", "https://example.com"},
+		{"bold", "this is **important** and __this too__", "this is important and this too"},
+		{"italic", "*really* and _truly_", "really and truly"},
+		{"strikethrough", "~~wrong~~ right", "wrong right"},
+		{"code span", "run `go test ./... -run *_test` first", "run go test ./... -run *_test first"},
+		{"bold around code", "**use `make`**", "use make"},
+		{"heading but not hashtag", "# Title\n#golang is fun", "Title\n#golang is fun"},
+		{"snake_case and products", "some_snake_case and 2*3*4", "some_snake_case and 2*3*4"},
+		{"adjacent italics", "*one* *two* and _a_ _b_", "one two and a b"},
+		{"adjacent bold", "**one** **two** __three four__ __five six__", "one two three four five six"},
+		{"identifiers with underscores", "call __init__ or __str__, not my_var_", "call __init__ or __str__, not my_var_"},
+		{"escaped markers", "2\\*3\\*4 is \\_not\\_ \\~\\~struck\\~\\~ or \\`code\\`", "2*3*4 is _not_ ~~struck~~ or `code`"},
+		{"escapes in code", "`a\\*b`", "a\\*b"},
+		{"URL with markers", "https://example.com/a_b_c/*x* **bold**", "https://example.com/a_b_c/*x* bold"},
+	} {
+		if got := (&MicroblogPost{Text: tc.text}).RenderFor(config.MBPMastodon); got != tc.plain {
+			t.Errorf("%s: RenderFor(mastodon) = %q, want %q", tc.name, got, tc.plain)
+		}
+		if got := (&MicroblogPost{Text: tc.text}).RenderFor(config.BPHugo); got != tc.text {
+			t.Errorf("%s: RenderFor(hugo) = %q, want it as typed", tc.name, got)
+		}
+	}
+}
+
+func TestRenderedForKeepsUnchangedPosts(t *testing.T) {
+	post := &MicroblogPost{Text: "nothing to render", Langs: []string{"en"}}
+	if post.renderedFor(config.MBPBsky) != post {
+		t.Error("renderedFor() copied a post rendering changes nothing in")
+	}
+	post.Text = "**bold**"
+	rendered := post.renderedFor(config.MBPBsky)
+	if rendered == post || rendered.Text != "bold" || post.Text != "**bold**" {
+		t.Errorf("renderedFor() = %q, post %q, want a rendered copy and the post untouched", rendered.Text, post.Text)
+	}
+}
@@ -0,0 +1,92 @@
+package mastodon
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// CallbackPath is where Callbacks are usually mounted, under the public URL of the bot.
+const CallbackPath = "/oauth/mastodon/callback"
+
+// oobRedirectURI is the redirect of clients without Callbacks, the instance shows the code for the user to paste it in
+// the chat.
+const oobRedirectURI = "urn:ietf:wg:oauth:2.0:oob"
+
+// Callbacks is the http.Handler instances redirect users to once they authorize us, it hands the code to the
+// authorization waiting for it, so users don't have to copy and paste it. A single Callbacks serves the clients of
+// every user, tell them with WithCallbacks.
+type Callbacks struct {
+	redirectURI string
+
+	mu sync.Mutex
+	// pending are the authorizations waiting for their code, by the state they sent users with.
+	pending map[string]chan string
+}
+
+var _ http.Handler = (*Callbacks)(nil)
+
+// NewCallbacks creates Callbacks for redirectURI, the public URL they are served at, i.e.
+// https://bot.example.com/oauth/mastodon/callback.
+func NewCallbacks(redirectURI string) *Callbacks {
+	return &Callbacks{
+		redirectURI: redirectURI,
+		pending:     make(map[string]chan string),
+	}
+}
+
+// expect returns a state to send the user to authorize us with and where its code arrives, forget must be called
+// once the code is no longer awaited.
+func (cb *Callbacks) expect() (state string, codes <-chan string, forget func(), err error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, nil, fmt.Errorf("generating state: %w", err)
+	}
+	state = hex.EncodeToString(raw)
+	// buffered so the handler never waits for the authorization.
+	ch := make(chan string, 1)
+	cb.mu.Lock()
+	cb.pending[state] = ch
+	cb.mu.Unlock()
+	forget = func() {
+		cb.mu.Lock()
+		delete(cb.pending, state)
+		cb.mu.Unlock()
+	}
+	return state, ch, forget, nil
+}
+
+// withState returns authURL asking the instance to send state back with the code.
+func withState(authURL *url.URL, state string) *url.URL {
+	withState := *authURL
+	query := withState.Query()
+	query.Set("state", state)
+	withState.RawQuery = query.Encode()
+	return &withState
+}
+
+// ServeHTTP implements http.Handler, it takes the code the instance redirected the user with to the authorization
+// that sent them, each state is good for a single code.
+func (cb *Callbacks) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	cb.mu.Lock()
+	codes, ok := cb.pending[state]
+	delete(cb.pending, state)
+	cb.mu.Unlock()
+	if !ok {
+		http.Error(w, "Unknown or expired authorization, start it again from the chat.", http.StatusBadRequest)
+		return
+	}
+	code := r.URL.Query().Get("code")
+	// an empty code, i.e. the user denied us, ends the authorization.
+	codes <- code
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if code == "" {
+		_, _ = w.Write([]byte("Authorization denied, you can go back to the chat.\n"))
+		return
+	}
+	_, _ = w.Write([]byte("Authorized, you can go back to the chat.\n"))
+}
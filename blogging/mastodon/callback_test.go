@@ -0,0 +1,103 @@
+package mastodon
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/perrito666/chat2world/blogging"
+)
+
+// tokenRequests returns the forms sent to exchange codes for tokens, in order.
+func (f *fakeInstance) tokenRequests() []url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var forms []url.Values
+	for _, r := range f.requests {
+		if r.method == http.MethodPost && r.path == "/oauth/token" {
+			forms = append(forms, r.form)
+		}
+	}
+	return forms
+}
+
+// callback returns the status and body callbacks answer a redirect with state and code.
+func callback(callbacks *Callbacks, state, code string) (int, string) {
+	rec := httptest.NewRecorder()
+	query := url.Values{"state": {state}, "code": {code}}
+	callbacks.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, CallbackPath+"?"+query.Encode(), nil))
+	return rec.Code, rec.Body.String()
+}
+
+func TestCallbackExchangesTheCodeForAToken(t *testing.T) {
+	instance := &fakeInstance{}
+	server := httptest.NewServer(instance)
+	defer server.Close()
+	const redirectURI = "https://bot.example.com" + CallbackPath
+	callbacks := NewCallbacks(redirectURI)
+	c, err := NewClient(newTestStore(), WithCallbacks(callbacks))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	notices := make(chan string, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ctx = blogging.WithAuthNotifier(ctx, func(text string) { notices <- text })
+	comms, err := c.StartAuthorization(ctx, 1, nil)
+	if err != nil {
+		t.Fatalf("StartAuthorization: %v", err)
+	}
+	<-comms
+	comms <- server.URL
+	prompt := <-comms
+	match := regexp.MustCompile(`[?&]state=(\w+)`).FindStringSubmatch(prompt)
+	if match == nil {
+		t.Fatalf("prompt %q does not send the user with a state", prompt)
+	}
+	// the user says something while authorizing us in the browser.
+	comms <- "done?"
+	if reminder := <-comms; !strings.HasPrefix(reminder, "Still waiting for you to authorize") {
+		t.Errorf("answered %q meanwhile, want a reminder", reminder)
+	}
+
+	if status, _ := callback(callbacks, "guessed", "mock-code"); status != http.StatusBadRequest {
+		t.Errorf("callback with an unknown state = %d, want 400", status)
+	}
+	if status, body := callback(callbacks, match[1], "mock-code"); status != http.StatusOK ||
+		!strings.HasPrefix(body, "Authorized") {
+		t.Errorf("callback = %d %q, want 200 telling the user it is done", status, body)
+	}
+	select {
+	case notice := <-notices:
+		if !strings.HasPrefix(notice, "Your mastodon account is connected") {
+			t.Errorf("notice = %q, want the account connected", notice)
+		}
+	case <-ctx.Done():
+		t.Fatal("the user was never told the authorization finished")
+	}
+	for range comms {
+	}
+
+	tokens := instance.tokenRequests()
+	if len(tokens) != 1 {
+		t.Fatalf("%d token requests, want 1", len(tokens))
+	}
+	if got := tokens[0].Get("code"); got != "mock-code" {
+		t.Errorf("exchanged code %q, want the one of the callback", got)
+	}
+	if got := tokens[0].Get("redirect_uri"); got != redirectURI {
+		t.Errorf("redirect_uri = %q, want %q", got, redirectURI)
+	}
+	if c.config.AccessToken != userToken {
+		t.Errorf("AccessToken = %q, want %q", c.config.AccessToken, userToken)
+	}
+	// each state is good for a single code.
+	if status, _ := callback(callbacks, match[1], "mock-code"); status != http.StatusBadRequest {
+		t.Errorf("callback with a used state = %d, want 400", status)
+	}
+}
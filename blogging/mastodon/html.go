@@ -0,0 +1,37 @@
+package mastodon
+
+import (
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// statusContentToText turns the HTML content of a status, as returned by the API, into the plain text the user
+// originally typed. Paragraphs become blank lines and <br> becomes a newline, links are flattened to their text which
+// for mastodon is the full URL split in (in)visible spans.
+func statusContentToText(content string) string {
+	var sb strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(content))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if tokenizer.Err() != io.EOF {
+				return content
+			}
+			return strings.TrimSpace(sb.String())
+		case html.TextToken:
+			sb.Write(tokenizer.Text())
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			if string(name) == "br" {
+				sb.WriteRune('\n')
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if string(name) == "p" {
+				sb.WriteString("\n\n")
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package mastodon
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/perrito666/chat2world/blogging"
+)
+
+// idempotencyKeyHeader is the header mastodon takes to create a status only once no matter how many times the same
+// request is sent, it remembers the keys for an hour.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// idempotencyKeyCtx is the context key under which the idempotency key of a request travels to idempotencyTransport,
+// go-mastodon has no way to set headers on a single request.
+type idempotencyKeyCtx struct{}
+
+// withIdempotencyKey returns a context whose POST requests carry key as their Idempotency-Key.
+func withIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyCtx{}, key)
+}
+
+// idempotencyKey returns the key of the part-th status of post sent to target in the send sendID (see
+// blogging.WithSendID), it only changes if the send, the post, the part, the target or when it is scheduled for do, so
+// retrying a send can't create the status twice but sending the post again (i.e. after /undo) does.
+func idempotencyKey(sendID, target string, post *blogging.MicroblogPost, part int, scheduledAt *time.Time) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%d\n", sendID, target, part)
+	if scheduledAt != nil {
+		fmt.Fprintf(h, "%d\n", scheduledAt.Unix())
+	}
+	// hashing can't fail, all the post holds encodes.
+	_ = json.NewEncoder(h).Encode(post)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotencyTransport adds the idempotency key of the request context, if any, to POST requests.
+type idempotencyTransport struct {
+	// Base is the RoundTripper doing the actual work, http.DefaultTransport if nil.
+	Base http.RoundTripper
+}
+
+var _ http.RoundTripper = (*idempotencyTransport)(nil)
+
+// RoundTrip implements http.RoundTripper.
+func (t *idempotencyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	key, ok := req.Context().Value(idempotencyKeyCtx{}).(string)
+	if !ok || req.Method != http.MethodPost {
+		return base.RoundTrip(req)
+	}
+	// RoundTrippers must not modify the request they are given.
+	req = req.Clone(req.Context())
+	req.Header.Set(idempotencyKeyHeader, key)
+	return base.RoundTrip(req)
+}
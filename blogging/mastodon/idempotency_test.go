@@ -0,0 +1,84 @@
+package mastodon
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/perrito666/chat2world/blogging"
+)
+
+// statusKeys returns the idempotency keys the statuses were created with, in order.
+func (f *fakeInstance) statusKeys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for _, r := range f.requests {
+		if r.method == http.MethodPost && r.path == "/api/v1/statuses" {
+			keys = append(keys, r.header.Get(idempotencyKeyHeader))
+		}
+	}
+	return keys
+}
+
+func TestRetriedPostsCarryTheSameIdempotencyKey(t *testing.T) {
+	instance := &fakeInstance{}
+	c := newTestClient(t, instance)
+	post := &blogging.MicroblogPost{Text: "only once, please"}
+
+	// a retry is the same post sent again within the same send.
+	ctx := blogging.WithSendID(context.Background(), "send-1")
+	for range 2 {
+		if _, err := c.Post(ctx, 1, post); err != nil {
+			t.Fatalf("Post: %v", err)
+		}
+	}
+	if _, err := c.Post(blogging.WithSendID(context.Background(), "send-2"), 1, post); err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+
+	keys := instance.statusKeys()
+	if len(keys) != 3 {
+		t.Fatalf("%d statuses posted, want 3", len(keys))
+	}
+	if keys[0] == "" || keys[0] != keys[1] {
+		t.Errorf("keys of the retry = %q, %q, want the same one so the instance posts it once", keys[0], keys[1])
+	}
+	if keys[2] == keys[0] {
+		t.Errorf("key of another send = %q, want a new one so the post goes out again", keys[2])
+	}
+}
+
+func TestIdempotencyKeyIsOnlySentWithPosts(t *testing.T) {
+	var got http.Header
+	base := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		got = req.Header
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+	})
+	client := &http.Client{Transport: &idempotencyTransport{Base: base}}
+	ctx := withIdempotencyKey(context.Background(), "key")
+	for method, want := range map[string]string{http.MethodPost: "key", http.MethodGet: ""} {
+		req, err := http.NewRequestWithContext(ctx, method, "https://example.com/api/v1/statuses", nil)
+		if err != nil {
+			t.Fatalf("NewRequest: %v", err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		resp.Body.Close()
+		if got.Get(idempotencyKeyHeader) != want {
+			t.Errorf("%s %s = %q, want %q", method, idempotencyKeyHeader, got.Get(idempotencyKeyHeader), want)
+		}
+		if req.Header.Get(idempotencyKeyHeader) != "" {
+			t.Errorf("%s: the request given was modified", method)
+		}
+	}
+}
+
+// roundTripperFunc is an http.RoundTripper made of a function.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
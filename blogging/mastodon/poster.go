@@ -2,14 +2,22 @@ package mastodon
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/mattn/go-mastodon"
 
 	"github.com/perrito666/chat2world/blogging" // update the module path accordingly
+	"github.com/perrito666/chat2world/blogging/parallel"
+	"github.com/perrito666/chat2world/blogging/ratelimit"
+	"github.com/perrito666/chat2world/config"
 	"github.com/perrito666/chat2world/secrets"
 )
 
@@ -31,9 +39,13 @@ type Config struct {
 
 func (c *Config) LoadFromPersistableDict(dict map[string]string) error {
 	c.Server = dict["server"]
+	c.AppID = mastodon.ID(dict["app_id"])
 	c.ClientID = dict["client_id"]
 	c.ClientSecret = dict["client_secret"]
-	c.AuthURL, _ = url.Parse(dict["auth_url"])
+	c.AuthURL = nil
+	if dict["auth_url"] != "" {
+		c.AuthURL, _ = url.Parse(dict["auth_url"])
+	}
 	c.AccessToken = dict["access_token"]
 	c.ClientName = dict["client_name"]
 	c.ClientWebsite = dict["client_website"]
@@ -42,26 +54,41 @@ func (c *Config) LoadFromPersistableDict(dict map[string]string) error {
 }
 
 func (c *Config) DumpToPersistableDict() map[string]string {
-	return map[string]string{
+	dict := map[string]string{
 		"server":         c.Server,
+		"app_id":         string(c.AppID),
 		"client_id":      c.ClientID,
 		"client_secret":  c.ClientSecret,
-		"auth_url":       c.AuthURL.String(),
 		"access_token":   c.AccessToken,
 		"client_name":    c.ClientName,
 		"client_website": c.ClientWebsite,
 		"client_server":  c.ClientServer,
 	}
+	if c.AuthURL != nil {
+		dict["auth_url"] = c.AuthURL.String()
+	}
+	return dict
 }
 
 var _ blogging.ClientConfig = (*Config)(nil)
 
 // Client wraps a Mastodon client and provides a method to post.
 type Client struct {
-	store  *secrets.EncryptedStore
-	client *mastodon.Client
-	config *Config
-	userID blogging.UserID
+	// configs is where the config of each user is kept.
+	configs blogging.ConfigStore
+	client  *mastodon.Client
+	config  *Config
+	userID  blogging.UserID
+	// account tells apart the mastodon accounts of a user, empty for the one of users with a single account.
+	account string
+	// lastThread remembers the IDs of every status of the last thread we sent, keyed by the URL we returned for it,
+	// so it can be deleted as a whole.
+	lastThread map[string][]mastodon.ID
+	// limiter paces every request to the instance.
+	limiter *ratelimit.Limiter
+	// callbacks, if any, take the authorization code instead of the user pasting it.
+	callbacks *Callbacks
+	logger    *slog.Logger
 }
 
 var _ blogging.Platform = &Client{}
@@ -73,14 +100,76 @@ func (c *Client) Config(userID blogging.UserID) (blogging.ClientConfig, error) {
 	return c.config, nil
 }
 
-// NewClient creates a new Mastodon client using the provided configuration.
-func NewClient(store *secrets.EncryptedStore) (*Client, error) {
-	return &Client{
-		store:  store,
-		client: mastodon.NewClient(&mastodon.Config{}),
-		config: baseConfig(),
-	}, nil
+// DefaultRequestsPerSecond and DefaultBurst are the request rate of clients created without WithRateLimiter, mastodon
+// allows 300 requests per 5 minutes by default.
+const (
+	DefaultRequestsPerSecond = 1
+	DefaultBurst             = 5
+)
+
+// ClientOption configures optional settings of a Client.
+type ClientOption func(*Client)
 
+// WithRateLimiter makes the client's requests go through l, i.e. to share it among clients.
+func WithRateLimiter(l *ratelimit.Limiter) ClientOption {
+	return func(c *Client) {
+		c.limiter = l
+	}
+}
+
+// WithAccount makes the client one of several mastodon accounts of the user, each keeps a config of its own.
+func WithAccount(account string) ClientOption {
+	return func(c *Client) {
+		c.account = account
+	}
+}
+
+// WithCallbacks makes the instance send users back to callbacks once they authorize us, instead of showing them a code
+// to paste in the chat, callbacks must be served at their redirect URI.
+func WithCallbacks(callbacks *Callbacks) ClientOption {
+	return func(c *Client) {
+		c.callbacks = callbacks
+	}
+}
+
+// WithConfigStore makes the client keep the configs of users in configs instead of the encrypted store.
+func WithConfigStore(configs blogging.ConfigStore) ClientOption {
+	return func(c *Client) {
+		c.configs = configs
+	}
+}
+
+// WithLogger sets where the client logs, it defaults to slog.Default().
+func WithLogger(logger *slog.Logger) ClientOption {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// NewClient creates a new Mastodon client using the provided configuration, its requests are rate limited to
+// DefaultRequestsPerSecond unless WithRateLimiter says otherwise.
+func NewClient(store *secrets.EncryptedStore, opts ...ClientOption) (*Client, error) {
+	c := &Client{
+		configs: blogging.NewEncryptedConfigStore(store),
+		config:  baseConfig(),
+		limiter: ratelimit.NewLimiter(DefaultRequestsPerSecond, DefaultBurst),
+		logger:  slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.logger = c.logger.With("platform", c.platform())
+	c.client = c.newMastodonClient(&mastodon.Config{})
+	return c, nil
+
+}
+
+// newMastodonClient returns a go-mastodon client for cfg whose requests go through the limiter and carry the
+// idempotency key of their context, if any.
+func (c *Client) newMastodonClient(cfg *mastodon.Config) *mastodon.Client {
+	mc := mastodon.NewClient(cfg)
+	mc.Transport = &idempotencyTransport{Base: &ratelimit.Transport{Limiter: c.limiter}}
+	return mc
 }
 
 const ClientName = "Chat2World"
@@ -100,24 +189,26 @@ func (c *Client) IsAuthorized(id blogging.UserID) bool {
 	if !c.config.loaded {
 		_, err := c.loadConfigIfExists(id)
 		if err != nil {
-			log.Printf("error loading config: %v", err)
+			c.logger.Error("loading config", "user_id", id, "err", err)
 			return false
 		}
 	}
-	log.Printf("loaded config for user: %d", c.userID)
+	c.logger.Debug("loaded config", "user_id", c.userID)
 	return c.config.loaded
 }
 
-// loadConfigIfExists loads a config from a file if it exists.
+// loadConfigIfExists loads the config of the user from the ConfigStore if there is one.
 func (c *Client) loadConfigIfExists(id blogging.UserID) (*Config, error) {
 	cfg := baseConfig()
-	f, err := c.store.OpenReader(fmt.Sprintf("%d.json", id))
-	if err != nil {
+	dict, err := c.configs.Load(id, c.platform())
+	if errors.Is(err, os.ErrNotExist) {
 		return cfg, nil
 	}
-	err = json.NewDecoder(f).Decode(cfg)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("loading config: %w", err)
+	}
+	if err := cfg.LoadFromPersistableDict(dict); err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
 	}
 	c.config = cfg
 	c.config.loaded = true
@@ -126,12 +217,31 @@ func (c *Client) loadConfigIfExists(id blogging.UserID) (*Config, error) {
 	return cfg, c.authorizeForLoadedConfig(context.Background())
 }
 
+// platform returns the name the config of the client is stored under, its account if it has one.
+func (c *Client) platform() config.AvailableBloggingPlatform {
+	return config.PlatformAccount(config.MBPMastodon, c.account)
+}
+
+// Forget implements blogging.Forgetter, the app registered with the instance stays, users can revoke it from their
+// account settings.
+func (c *Client) Forget(userID blogging.UserID) error {
+	if err := c.configs.Remove(userID, c.platform()); err != nil {
+		return fmt.Errorf("removing config: %w", err)
+	}
+	c.config = baseConfig()
+	c.client = c.newMastodonClient(&mastodon.Config{})
+	c.logger.Info("config forgotten", "user_id", userID)
+	return nil
+}
+
+var _ blogging.Forgetter = (*Client)(nil)
+
 func (c *Client) authorizeForLoadedConfig(ctx context.Context) error {
 	if c.config == nil || !c.config.loaded {
 		return fmt.Errorf("no config loaded")
 	}
 
-	c.client = mastodon.NewClient(&mastodon.Config{
+	c.client = c.newMastodonClient(&mastodon.Config{
 		Server:       c.config.Server,
 		ClientID:     c.config.ClientID,
 		ClientSecret: c.config.ClientSecret,
@@ -145,48 +255,83 @@ func (c *Client) authorizeForLoadedConfig(ctx context.Context) error {
 	return nil
 }
 
+// ask sends question through comms and returns the answer, ok is false if the context was canceled.
+func ask(ctx context.Context, comms chan string, question string) (string, bool) {
+	select {
+	case comms <- question:
+	case <-ctx.Done():
+		return "", false
+	}
+	select {
+	case answer := <-comms:
+		return strings.TrimSpace(answer), true
+	case <-ctx.Done():
+		return "", false
+	}
+}
+
 func (c *Client) StartAuthorization(ctx context.Context, id blogging.UserID, cfgGeneric map[string]string) (chan string, error) {
 	commsChan := make(chan string)
+	if c.userID == 0 {
+		c.userID = id
+	}
 	var cfg *Config
 	if !c.config.loaded {
 		var err error
 		cfg, err = c.loadConfigIfExists(id)
 		if err != nil {
-			log.Printf("error loading config: %v", err)
+			c.logger.Error("loading config", "user_id", id, "err", err)
 		}
 	}
 	go func(id blogging.UserID, cfg *Config, comms chan string) {
 		defer close(comms)
-		if cfg == nil {
-			cfg = baseConfig()
-		}
-		if cfg.Server == "" {
-			log.Printf("No server in config, asking user")
-			select {
-			case comms <- "What is the mastodon instance server URL?":
-			case <-ctx.Done():
+		// async is set once the code arrives through the callbacks, nobody is waiting on comms from then on.
+		var async bool
+		// fail tells the user why the authorization could not go on, the flow ends when comms is closed.
+		fail := func(err error) {
+			c.logger.Error("authorization failed", "user_id", id, "err", err)
+			if async && blogging.NotifyAuth(ctx, fmt.Sprintf("mastodon authorization failed: %v", err)) {
 				return
 			}
 			select {
-			case cfg.Server = <-comms:
+			case comms <- fmt.Sprintf("authorization failed: %v", err):
 			case <-ctx.Done():
-				return
+			}
+		}
+		if cfg == nil {
+			cfg = baseConfig()
+		}
+		if cfg.Server == "" {
+			c.logger.Debug("no server in config, asking user", "user_id", id)
+			question := "What is the mastodon instance server URL?"
+			for cfg.Server == "" {
+				answer, ok := ask(ctx, comms, question)
+				if !ok {
+					return
+				}
+				server, err := normalizeServer(ctx, answer)
+				if err != nil {
+					c.logger.Info("invalid server given", "user_id", id, "err", err)
+					question = fmt.Sprintf("That is not a mastodon server (%v), try again, i.e. mastodon.social.", err)
+					continue
+				}
+				cfg.Server = server
 			}
 
-			log.Printf("Server is %s", cfg.Server)
+			c.logger.Debug("server given", "user_id", id, "server", cfg.Server)
 		}
 		appConfig := &mastodon.AppConfig{
 			Server:       cfg.Server,
 			ClientName:   cfg.ClientName,
 			Scopes:       "read write follow",
 			Website:      cfg.ClientWebsite,
-			RedirectURIs: "urn:ietf:wg:oauth:2.0:oob",
+			RedirectURIs: c.redirectURI(),
 		}
 		var reauth = cfg.ClientID == "" || cfg.ClientSecret == ""
 
 		app, err := mastodon.RegisterApp(ctx, appConfig)
 		if err != nil {
-			log.Fatal(err)
+			fail(fmt.Errorf("registering app: %w", err))
 			return
 		}
 		cfg.AppID = app.ID
@@ -194,23 +339,37 @@ func (c *Client) StartAuthorization(ctx context.Context, id blogging.UserID, cfg
 		cfg.ClientSecret = app.ClientSecret
 		u, err := url.Parse(app.AuthURI)
 		if err != nil {
-			log.Fatal(err)
+			fail(fmt.Errorf("parsing authorization URL: %w", err))
+			return
 		}
 		cfg.AuthURL = u
 
 		if cfg.AccessToken == "" {
-			select {
-			case comms <- fmt.Sprintf("Open your browser to \n%s\n and copy/paste the given token\n", cfg.AuthURL):
-			case <-ctx.Done():
+			var code string
+			var ok bool
+			if c.callbacks == nil {
+				code, ok = ask(ctx, comms,
+					fmt.Sprintf("Open your browser to \n%s\n and copy/paste the given token\n", cfg.AuthURL))
+			} else {
+				code, ok, err = c.awaitCallback(ctx, comms, cfg.AuthURL)
+				if err != nil {
+					fail(err)
+					return
+				}
+				async = true
 			}
-			select {
-			case cfg.AccessToken = <-comms:
-			case <-ctx.Done():
+			if !ok {
+				return
+			}
+			if code == "" {
+				fail(errors.New("authorization denied"))
+				return
 			}
+			cfg.AccessToken = code
 			reauth = true
 		}
 
-		mc := mastodon.NewClient(&mastodon.Config{
+		mc := c.newMastodonClient(&mastodon.Config{
 			Server:       cfg.Server,
 			ClientID:     cfg.ClientID,
 			ClientSecret: cfg.ClientSecret,
@@ -220,79 +379,372 @@ func (c *Client) StartAuthorization(ctx context.Context, id blogging.UserID, cfg
 		// and will need to be persisted.
 		// Otherwise, you'll need to register and authenticate token again.
 		if reauth {
-			err = mc.AuthenticateToken(context.Background(), cfg.AccessToken, "urn:ietf:wg:oauth:2.0:oob")
+			err = mc.AuthenticateToken(ctx, cfg.AccessToken, c.redirectURI())
 			if err != nil {
-				log.Fatal(fmt.Errorf("authenticating client: %w", err))
+				fail(fmt.Errorf("authenticating client: %w", err))
 				return
 			}
 			cfg.AccessToken = mc.Config.AccessToken
 		}
 
-		verif, err := c.client.VerifyAppCredentials(ctx)
+		verif, err := mc.VerifyAppCredentials(ctx)
 		if err != nil {
-			log.Printf(fmt.Sprintf("verifying app credentials: %v", err))
+			c.logger.Error("verifying app credentials", "user_id", id, "err", err)
+		} else {
+			c.logger.Debug("verified app credentials", "user_id", id, "app", verif.Name)
 		}
-		log.Printf("verified app credentials: %+v", verif)
 
 		c.client = mc
 		cfg.loaded = true
 		c.config = cfg
-		log.Printf("Client authenticated for server %s with client ID %s", cfg.Server, cfg.ClientID)
+		c.logger.Info("client authenticated", "user_id", id, "server", cfg.Server, "client_id", cfg.ClientID)
 		if !reauth {
 			return
 		}
-		mapCfg := cfg.DumpToPersistableDict()
-		// create a file in the running folder named after the year, month, day, hour, minute, second.json
-		// and dump the cfg to it.
-		f, err := c.store.OpenWriter(fmt.Sprintf("%d.json", c.userID))
-		if err != nil {
-			log.Fatal(err)
+		// the config replaces any previous one of the user, loadConfigIfExists finds it under the same platform.
+		if err := c.configs.Save(id, c.platform(), cfg.DumpToPersistableDict()); err != nil {
+			fail(fmt.Errorf("saving config: %w", err))
+			return
 		}
-		defer f.Close()
-		err = json.NewEncoder(f).Encode(mapCfg)
-		if err != nil {
-			log.Fatal(err)
+		if async {
+			// the message that follows this one ends the authorization, it is not taken as anything else.
+			blogging.NotifyAuth(ctx, "Your mastodon account is connected, send anything to go on.")
 		}
 	}(id, cfg, commsChan)
 	return commsChan, nil
 }
 
+// redirectURI returns where the instance sends users once they authorize us, without callbacks it shows them the code
+// instead.
+func (c *Client) redirectURI() string {
+	if c.callbacks == nil {
+		return oobRedirectURI
+	}
+	return c.callbacks.redirectURI
+}
+
+// awaitCallback sends the user to authURL and waits for the code the callbacks get once they authorize us, whatever
+// the user says meanwhile is answered with a reminder. ok is false if ctx is done first.
+func (c *Client) awaitCallback(ctx context.Context, comms chan string, authURL *url.URL) (string, bool, error) {
+	state, codes, forget, err := c.callbacks.expect()
+	if err != nil {
+		return "", false, err
+	}
+	defer forget()
+	authURL = withState(authURL, state)
+	prompt := fmt.Sprintf("Open your browser to \n%s\n and authorize chat2world, I'll tell you once it is done.",
+		authURL)
+	select {
+	case comms <- prompt:
+	case <-ctx.Done():
+		return "", false, nil
+	}
+	for {
+		select {
+		case code := <-codes:
+			return code, true, nil
+		case <-comms:
+			select {
+			case comms <- "Still waiting for you to authorize chat2world at\n" + authURL.String():
+			case <-ctx.Done():
+				return "", false, nil
+			}
+		case <-ctx.Done():
+			return "", false, nil
+		}
+	}
+}
+
 // Post sends a MicroblogPost to Mastodon. It uploads any images (if present)
 // and then creates a new status (toot) with the given text and attachments.
-func (c *Client) Post(ctx context.Context, userID blogging.UserID, post *blogging.MicroblogPost) (string, error) {
-	var mediaIDs []mastodon.ID
+func (c *Client) Post(ctx context.Context, userID blogging.UserID, post *blogging.MicroblogPost) (*blogging.PostResult, error) {
+	return c.post(ctx, post, nil)
+}
+
+var _ blogging.Previewer = (*Client)(nil)
+
+// Preview implements blogging.Previewer, it lists the toots Post would send with their settings and images.
+func (c *Client) Preview(ctx context.Context, userID blogging.UserID, post *blogging.MicroblogPost) (string, error) {
+	post = post.WithQuoteLink()
+	var sb strings.Builder
+	chunks := post.ThreadChunks(blogging.PlatformTextLimits[config.MBPMastodon])
+	for i, chunk := range chunks {
+		fmt.Fprintf(&sb, "toot %d/%d", i+1, len(chunks))
+		if len(post.Langs) > 0 {
+			fmt.Fprintf(&sb, ", language %s", post.Langs[0])
+		}
+		if post.ContentWarning != "" {
+			fmt.Fprintf(&sb, ", content warning %q", post.ContentWarning)
+		}
+		fmt.Fprintf(&sb, ":\n%s\n", chunk)
+		if i > 0 {
+			continue
+		}
+		for idx, img := range post.Images {
+			normalized, err := img.NormalizeImage(blogging.PlatformImageSizeLimits[config.MBPMastodon])
+			if err != nil {
+				return "", fmt.Errorf("normalizing image %d: %w", idx, err)
+			}
+			fmt.Fprintf(&sb, "image %d: %d bytes, alt text %q\n", idx+1, len(normalized.Data), normalized.AltText)
+		}
+		if post.Video != nil {
+			if err := post.Video.CheckLimit(blogging.PlatformVideoLimits[config.MBPMastodon]); err != nil {
+				return "", fmt.Errorf("checking video: %w", err)
+			}
+			fmt.Fprintf(&sb, "video: %s, %d bytes, alt text %q\n", post.Video.MimeType, len(post.Video.Data),
+				post.Video.AltText)
+		}
+		if post.Poll != nil {
+			if len(post.Images) > 0 || post.Video != nil {
+				sb.WriteString("poll left out, mastodon takes either media or a poll\n")
+				continue
+			}
+			fmt.Fprintf(&sb, "poll, expires in %s, multiple choice %t: %s\n", post.Poll.ExpiresIn,
+				post.Poll.Multiple, strings.Join(post.Poll.Options, " / "))
+		}
+	}
+	return strings.TrimRight(sb.String(), "\n"), nil
+}
 
-	// Upload images (if any).
-	for idx, img := range post.Images {
-		// UploadMediaFromReader accepts an io.Reader; here we wrap the raw data.
+var _ blogging.NativeScheduler = (*Client)(nil)
+
+// PostAt implements blogging.NativeScheduler, the status is held by the instance until at (which mastodon wants at
+// least 5 minutes in the future) and the returned value is the scheduled status ID, there is no URL until then.
+// Scheduled statuses can't reply to each other so posts that need a thread are refused.
+func (c *Client) PostAt(ctx context.Context, userID blogging.UserID, post *blogging.MicroblogPost, at time.Time) (string, error) {
+	if len(post.ThreadChunks(blogging.PlatformTextLimits[config.MBPMastodon])) > 1 {
+		return "", fmt.Errorf("post needs a thread: %w", blogging.ErrCannotScheduleNatively)
+	}
+	result, err := c.post(ctx, post, &at)
+	if err != nil {
+		return "", err
+	}
+	return result.ID, nil
+}
+
+// CancelScheduled implements blogging.NativeScheduler, go-mastodon does not cover scheduled statuses so the request
+// is made by hand.
+func (c *Client) CancelScheduled(ctx context.Context, userID blogging.UserID, scheduledID string) error {
+	u, err := url.JoinPath(c.client.Config.Server, "/api/v1/scheduled_statuses", scheduledID)
+	if err != nil {
+		return fmt.Errorf("building scheduled status URL: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u, nil)
+	if err != nil {
+		return fmt.Errorf("creating scheduled status delete request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.client.Config.AccessToken)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("deleting scheduled status %s: %w", scheduledID, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("scheduled status %s: %w", scheduledID, blogging.ErrPostNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("deleting scheduled status %s: unexpected status %s", scheduledID, resp.Status)
+	}
+	return nil
+}
+
+// tootPoll translates the poll of a post, if any, into the one of a toot.
+func tootPoll(poll *blogging.Poll) *mastodon.TootPoll {
+	if poll == nil {
+		return nil
+	}
+	return &mastodon.TootPoll{
+		Options:          poll.Options,
+		ExpiresInSeconds: int64(poll.ExpiresIn / time.Second),
+		Multiple:         poll.Multiple,
+	}
+}
+
+// post does the work of Post and PostAt, if scheduledAt is not nil the status is scheduled for then and the result
+// only carries the scheduled status ID, there is no URL yet.
+func (c *Client) post(ctx context.Context, post *blogging.MicroblogPost, scheduledAt *time.Time) (*blogging.PostResult, error) {
+	result := &blogging.PostResult{}
+	// mastodon has no quote posts, the quoted post is linked instead.
+	post = post.WithQuoteLink()
+
+	// Upload images (if any), concurrently but keeping their order.
+	reencoded := make([]bool, len(post.Images))
+	mediaIDs, err := parallel.Map(ctx, post.Images, parallel.DefaultWorkers,
+		func(ctx context.Context, idx int, original *blogging.BlogImage) (mastodon.ID, error) {
+			img, err := original.NormalizeImage(blogging.PlatformImageSizeLimits[config.MBPMastodon])
+			if err != nil {
+				return "", fmt.Errorf("normalizing image %d: %w", idx, err)
+			}
+			reencoded[idx] = img != original
+			// UploadMediaFromReader accepts an io.Reader; here we wrap the raw data.
+			attachment, err := c.client.UploadMediaFromMedia(ctx, &mastodon.Media{
+				File:        img.Reader(),
+				Description: img.AltText,
+			})
+			if err != nil {
+				c.logger.Error("uploading image", "user_id", c.userID, "image", idx, "err", err)
+				return "", fmt.Errorf("failed to upload image %d: %w", idx, err)
+			}
+			return attachment.ID, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	for idx, wasReencoded := range reencoded {
+		if wasReencoded {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("image %d was re-encoded to fit", idx+1))
+		}
+	}
+	if post.Video != nil {
+		if err := post.Video.CheckLimit(blogging.PlatformVideoLimits[config.MBPMastodon]); err != nil {
+			return nil, fmt.Errorf("checking video: %w", err)
+		}
 		attachment, err := c.client.UploadMediaFromMedia(ctx, &mastodon.Media{
-			File:        img.Reader(),
-			Description: img.AltText,
+			File:        post.Video.Reader(),
+			Description: post.Video.AltText,
 		})
 		if err != nil {
-			log.Printf("failed to upload image %d: %v", idx, err)
-			return "", fmt.Errorf("failed to upload image %d: %w", idx, err)
+			c.logger.Error("uploading video", "user_id", c.userID, "err", err)
+			return nil, fmt.Errorf("failed to upload video: %w", err)
 		}
 		mediaIDs = append(mediaIDs, attachment.ID)
 	}
 
-	// Prepare the toot (status).
-	toot := &mastodon.Toot{
-		Status:   post.Text,
-		MediaIDs: mediaIDs,
-		// Optionally, you could set additional fields such as Visibility here.
+	if post.Poll != nil && len(mediaIDs) > 0 {
+		result.Warnings = append(result.Warnings, "the poll was left out, mastodon takes either media or a poll")
+		withoutPoll := *post
+		withoutPoll.Poll = nil
+		post = &withoutPoll
+	}
+
+	// Long posts are sent as a thread, each toot replying to the previous one, media goes in the first one.
+	var firstToot *mastodon.Status
+	var inReplyTo mastodon.ID
+	var statusIDs []mastodon.ID
+	for i, chunk := range post.ThreadChunks(blogging.PlatformTextLimits[config.MBPMastodon]) {
+		// Prepare the toot (status).
+		toot := &mastodon.Toot{
+			Status:      chunk,
+			InReplyToID: inReplyTo,
+			// Optionally, you could set additional fields such as Visibility here.
+		}
+		if i == 0 {
+			// the library only sends the poll of toots with nil, not just empty, media.
+			if len(mediaIDs) > 0 {
+				toot.MediaIDs = mediaIDs
+			}
+			toot.Poll = tootPoll(post.Poll)
+		}
+		// an empty spoiler text is not sent at all.
+		toot.SpoilerText = post.ContentWarning
+		if len(post.Langs) > 0 {
+			toot.Language = post.Langs[0]
+		}
+		toot.ScheduledAt = scheduledAt
+
+		// Post the toot, the same part of the same post is only created once however many times it is retried.
+		target := fmt.Sprintf("%s/%d/%s", c.config.Server, c.userID, c.account)
+		keyed := withIdempotencyKey(ctx, idempotencyKey(blogging.SendID(ctx), target, post, i, scheduledAt))
+		postedToot, err := c.client.PostStatus(keyed, toot)
+		if err != nil {
+			c.logger.Error("posting status", "user_id", c.userID, "part", i, "err", err)
+			return nil, fmt.Errorf("failed to post status %d: %w", i, err)
+		}
+		if firstToot == nil {
+			firstToot = postedToot
+		}
+		inReplyTo = postedToot.ID
+		statusIDs = append(statusIDs, postedToot.ID)
+	}
+
+	result.ID = string(firstToot.ID)
+	result.Parts = len(statusIDs)
+	if scheduledAt != nil {
+		// the response is a ScheduledStatus, only its ID is meaningful.
+		c.logger.Info("status scheduled", "user_id", c.userID, "scheduled_id", firstToot.ID, "at", scheduledAt)
+		return result, nil
+	}
+	c.logger.Info("status posted", "user_id", c.userID, "url", firstToot.URL, "parts", len(statusIDs))
+	c.lastThread = map[string][]mastodon.ID{firstToot.URL: statusIDs}
+	result.URL = firstToot.URL
+	result.PostedAt = firstToot.CreatedAt
+	return result, nil
+}
+
+// DeleteStatus deletes the status with the given ID, which must belong to the authenticated user.
+func (c *Client) DeleteStatus(ctx context.Context, id mastodon.ID) error {
+	if err := c.client.DeleteStatus(ctx, id); err != nil {
+		return fmt.Errorf("deleting status %s: %w", id, err)
+	}
+	return nil
+}
+
+// statusIDFromURL extracts the status ID from a status URL, which for mastodon is its last path element
+// (https://instance/@user/<id>).
+func statusIDFromURL(postURL string) (mastodon.ID, error) {
+	u, err := url.Parse(postURL)
+	if err != nil {
+		return "", fmt.Errorf("parsing status URL: %w", err)
+	}
+	id := path.Base(u.Path)
+	if id == "" || id == "/" || id == "." || strings.Trim(id, "0123456789") != "" {
+		return "", fmt.Errorf("%s: %w", postURL, blogging.ErrPostNotFound)
+	}
+	return mastodon.ID(id), nil
+}
+
+var _ blogging.Deleter = (*Client)(nil)
+
+// Delete implements blogging.Deleter, if postURL is the last thread we posted all of its statuses are deleted, last
+// first, otherwise just the status it points to.
+func (c *Client) Delete(ctx context.Context, userID blogging.UserID, postURL string) error {
+	ids, ok := c.lastThread[postURL]
+	if !ok {
+		id, err := statusIDFromURL(postURL)
+		if err != nil {
+			return err
+		}
+		ids = []mastodon.ID{id}
 	}
-	if len(post.Langs) > 0 {
-		toot.Language = post.Langs[0]
+	for i := len(ids) - 1; i >= 0; i-- {
+		if err := c.DeleteStatus(ctx, ids[i]); err != nil {
+			return err
+		}
 	}
+	delete(c.lastThread, postURL)
+	return nil
+}
+
+var _ blogging.Fetcher = (*Client)(nil)
 
-	// Post the toot.
-	postedToot, err := c.client.PostStatus(ctx, toot)
+// Fetch implements blogging.Fetcher, it resolves the status URL through the instance search (which will also fetch
+// remote statuses) and rebuilds a MicroblogPost with its text and image attachments.
+func (c *Client) Fetch(ctx context.Context, userID blogging.UserID, postURL string) (*blogging.MicroblogPost, error) {
+	results, err := c.client.Search(ctx, postURL, true)
 	if err != nil {
-		log.Printf("failed to post status: %v", err)
-		return "", fmt.Errorf("failed to post status: %w", err)
+		return nil, fmt.Errorf("searching for status: %w", err)
 	}
+	if len(results.Statuses) == 0 {
+		return nil, fmt.Errorf("%s: %w", postURL, blogging.ErrPostNotFound)
+	}
+	status := results.Statuses[0]
 
-	log.Printf("successfully posted status: %s", post.Text)
-	return postedToot.URL, nil
+	post := &blogging.MicroblogPost{
+		Text: statusContentToText(status.Content),
+	}
+	if status.Language != "" {
+		post.Langs = []string{status.Language}
+	}
+	for idx, attachment := range status.MediaAttachments {
+		if attachment.Type != "image" {
+			c.logger.Debug("skipping attachment", "user_id", userID, "attachment", idx, "type", attachment.Type)
+			continue
+		}
+		data, err := blogging.DownloadMedia(ctx, attachment.URL)
+		if err != nil {
+			return nil, fmt.Errorf("downloading attachment %d: %w", idx, err)
+		}
+		post.AddImage(blogging.NewBlogImage(data, attachment.Description))
+	}
+	return post, nil
 }
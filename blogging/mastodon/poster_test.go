@@ -0,0 +1,559 @@
+package mastodon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-mastodon"
+
+	"github.com/perrito666/chat2world/blogging"
+	"github.com/perrito666/chat2world/config"
+	"github.com/perrito666/chat2world/im"
+	"github.com/perrito666/chat2world/secrets"
+)
+
+// fakeRequest is a request received by a fakeInstance.
+type fakeRequest struct {
+	method string
+	path   string
+	form   url.Values
+	header http.Header
+}
+
+// userToken is the access token a fakeInstance hands out for any authorization code.
+const userToken = "user-access-token"
+
+// fakeInstance is a mastodon instance that creates every status it is sent.
+type fakeInstance struct {
+	mu       sync.Mutex
+	requests []fakeRequest
+	statuses int
+}
+
+func (f *fakeInstance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(1 << 20); err != nil && err != http.ErrNotMultipart {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, fakeRequest{method: r.Method, path: r.URL.Path, form: r.Form, header: r.Header})
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/statuses":
+		f.statuses++
+		id := fmt.Sprint(f.statuses)
+		fmt.Fprintf(w, `{"id":%q,"url":"https://example.com/@me/%s","created_at":"2026-01-02T03:04:05Z"}`, id, id)
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/media":
+		fmt.Fprint(w, `{"id":"media"}`)
+	case r.Method == http.MethodDelete:
+		fmt.Fprint(w, `{}`)
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/apps":
+		fmt.Fprint(w, `{"id":"app","client_id":"client-id","client_secret":"client-secret"}`)
+	case r.Method == http.MethodPost && r.URL.Path == "/oauth/token":
+		fmt.Fprintf(w, `{"access_token":%q}`, userToken)
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/apps/verify_credentials":
+		fmt.Fprintf(w, `{"name":%q}`, ClientName)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// posted returns the forms of the statuses created, in order.
+func (f *fakeInstance) posted() []url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var forms []url.Values
+	for _, r := range f.requests {
+		if r.method == http.MethodPost && r.path == "/api/v1/statuses" {
+			forms = append(forms, r.form)
+		}
+	}
+	return forms
+}
+
+// newTestStore returns a store keeping its files in memory, with a fast key derivation.
+func newTestStore() *secrets.EncryptedStore {
+	return &secrets.EncryptedStore{Password: "test", Params: secrets.ScryptParams{N: 1024, R: 8, P: 1},
+		Backend: &secrets.MemoryBackend{}}
+}
+
+// newTestClient returns a client of user 1 authorized with the instance served by handler.
+func newTestClient(t *testing.T, handler http.Handler) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	c, err := NewClient(newTestStore())
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	c.userID = 1
+	c.config.Server = server.URL
+	c.config.AccessToken = "token"
+	c.config.loaded = true
+	c.client = c.newMastodonClient(&mastodon.Config{Server: server.URL, AccessToken: "token"})
+	return c
+}
+
+func TestPostContentWarning(t *testing.T) {
+	instance := &fakeInstance{}
+	c := newTestClient(t, instance)
+	ctx := context.Background()
+
+	if _, err := c.Post(ctx, 1, &blogging.MicroblogPost{Text: "spoilers", ContentWarning: "movie ending"}); err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	if _, err := c.Post(ctx, 1, &blogging.MicroblogPost{Text: "no spoilers"}); err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	posted := instance.posted()
+	if len(posted) != 2 {
+		t.Fatalf("%d statuses posted, want 2", len(posted))
+	}
+	if got := posted[0].Get("spoiler_text"); got != "movie ending" {
+		t.Errorf("spoiler_text = %q, want %q", got, "movie ending")
+	}
+	if posted[0].Get("status") != "spoilers" {
+		t.Errorf("status = %q, want %q", posted[0].Get("status"), "spoilers")
+	}
+	if _, sent := posted[1]["spoiler_text"]; sent {
+		t.Errorf("spoiler_text sent for a post without content warning: %q", posted[1]["spoiler_text"])
+	}
+}
+
+// deleted returns the paths of the delete requests received, in order.
+func (f *fakeInstance) deleted() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var paths []string
+	for _, r := range f.requests {
+		if r.method == http.MethodDelete {
+			paths = append(paths, r.path)
+		}
+	}
+	return paths
+}
+
+func TestDeleteStatus(t *testing.T) {
+	instance := &fakeInstance{}
+	c := newTestClient(t, instance)
+	ctx := context.Background()
+
+	if _, err := c.Post(ctx, 1, &blogging.MicroblogPost{Text: "first"}); err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	result, err := c.Post(ctx, 1, &blogging.MicroblogPost{Text: "second"})
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	if err := c.Delete(ctx, 1, result.URL); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	// a status we did not just post is deleted by the ID in its URL.
+	if err := c.Delete(ctx, 1, "https://example.com/@me/1"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	want := []string{"/api/v1/statuses/2", "/api/v1/statuses/1"}
+	if got := instance.deleted(); !slices.Equal(got, want) {
+		t.Errorf("deleted %q, want %q", got, want)
+	}
+
+	if err := c.Delete(ctx, 1, "https://example.com/@me"); !errors.Is(err, blogging.ErrPostNotFound) {
+		t.Errorf("deleting a profile URL: err = %v, want ErrPostNotFound", err)
+	}
+}
+
+func TestPostAtSetsScheduledAt(t *testing.T) {
+	instance := &fakeInstance{}
+	c := newTestClient(t, instance)
+	at := time.Date(2026, 3, 4, 5, 6, 0, 0, time.FixedZone("ART", -3*60*60))
+
+	id, err := c.PostAt(context.Background(), 1, &blogging.MicroblogPost{Text: "later"}, at)
+	if err != nil {
+		t.Fatalf("PostAt: %v", err)
+	}
+	if id != "1" {
+		t.Errorf("PostAt() = %q, want the ID of the scheduled status", id)
+	}
+	posted := instance.posted()
+	if len(posted) != 1 {
+		t.Fatalf("%d statuses posted, want 1", len(posted))
+	}
+	got, err := time.Parse(time.RFC3339, posted[0].Get("scheduled_at"))
+	if err != nil || !got.Equal(at) {
+		t.Errorf("scheduled_at = %q, want %s", posted[0].Get("scheduled_at"), at.Format(time.RFC3339))
+	}
+
+	// posts sent right away carry none.
+	if _, err := c.Post(context.Background(), 1, &blogging.MicroblogPost{Text: "now"}); err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	if _, sent := instance.posted()[1]["scheduled_at"]; sent {
+		t.Error("scheduled_at sent for a post sent right away")
+	}
+}
+
+func TestPostAtRefusesThreads(t *testing.T) {
+	c := newTestClient(t, &fakeInstance{})
+	long := &blogging.MicroblogPost{Text: strings.Repeat("too long to be a single toot. ", 30)}
+	if _, err := c.PostAt(context.Background(), 1, long, time.Now().Add(time.Hour)); !errors.Is(err,
+		blogging.ErrCannotScheduleNatively) {
+		t.Errorf("PostAt() err = %v, want ErrCannotScheduleNatively", err)
+	}
+}
+
+// authorize goes through the authorization of user 1 with the instance at server, answering what it asks, and
+// returns every message it sent.
+func authorize(t *testing.T, c *Client, server string) []string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	comms, err := c.StartAuthorization(ctx, 1, nil)
+	if err != nil {
+		t.Fatalf("StartAuthorization: %v", err)
+	}
+	var messages []string
+	for message := range comms {
+		messages = append(messages, message)
+		switch {
+		case strings.Contains(message, "server URL"):
+			comms <- server
+		case strings.Contains(message, "Open your browser"):
+			comms <- "authorization-code"
+		}
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("authorization did not end, messages: %q", messages)
+	}
+	return messages
+}
+
+func TestAuthorizationStoresTheTokenEncrypted(t *testing.T) {
+	server := httptest.NewServer(&fakeInstance{})
+	defer server.Close()
+	store := newTestStore()
+	c, err := NewClient(store)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	for _, message := range authorize(t, c, server.URL) {
+		if strings.HasPrefix(message, "authorization failed") {
+			t.Fatalf("authorization failed: %q", message)
+		}
+	}
+	if c.config.AccessToken != userToken {
+		t.Fatalf("AccessToken = %q, want %q", c.config.AccessToken, userToken)
+	}
+
+	backend := store.Backend.(*secrets.MemoryBackend)
+	keys := backend.Keys()
+	if len(keys) == 0 {
+		t.Fatal("nothing was stored")
+	}
+	for _, key := range keys {
+		r, err := backend.OpenReaderAt(key)
+		if err != nil {
+			t.Fatalf("opening %q: %v", key, err)
+		}
+		raw, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("reading %q: %v", key, err)
+		}
+		if bytes.Contains(raw, []byte(userToken)) || bytes.Contains(raw, []byte("client-secret")) {
+			t.Errorf("%q holds the credentials in plaintext", key)
+		}
+	}
+}
+
+func TestFreshClientLoadsTheSavedConfig(t *testing.T) {
+	instance := &fakeInstance{}
+	server := httptest.NewServer(instance)
+	defer server.Close()
+	store := newTestStore()
+	c, err := NewClient(store)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	authorize(t, c, server.URL)
+
+	// a client made after a restart only has what is in the store.
+	fresh, err := NewClient(store)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if !fresh.IsAuthorized(1) {
+		t.Fatal("IsAuthorized() = false, want the saved config loaded")
+	}
+	if fresh.config.Server != server.URL || fresh.config.AccessToken != userToken {
+		t.Errorf("config = %+v, want the one saved", fresh.config)
+	}
+	if _, err := fresh.Post(context.Background(), 1, &blogging.MicroblogPost{Text: "after restart"}); err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	instance.mu.Lock()
+	defer instance.mu.Unlock()
+	last := instance.requests[len(instance.requests)-1]
+	if got := last.header.Get("Authorization"); got != "Bearer "+userToken {
+		t.Errorf("Authorization = %q, want the saved token", got)
+	}
+}
+
+func TestRegisterAppFailureIsReported(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/apps", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"down for maintenance"}`, http.StatusServiceUnavailable)
+	})
+	mux.Handle("/", &fakeInstance{})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	c, err := NewClient(newTestStore())
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	// getting here at all means the failure did not take the process down.
+	messages := authorize(t, c, server.URL)
+	if len(messages) == 0 || !strings.HasPrefix(messages[len(messages)-1], "authorization failed: registering app") {
+		t.Errorf("messages = %q, want the last one to tell registering the app failed", messages)
+	}
+	if c.IsAuthorized(1) {
+		t.Error("IsAuthorized() = true after a failed authorization")
+	}
+}
+
+func TestPostResult(t *testing.T) {
+	c := newTestClient(t, &fakeInstance{})
+	long := &blogging.MicroblogPost{Text: strings.Repeat("too long to be a single toot. ", 30)}
+	result, err := c.Post(context.Background(), 1, long)
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	if result.URL != "https://example.com/@me/1" || result.ID != "1" {
+		t.Errorf("URL, ID = %q, %q, want those of the first status", result.URL, result.ID)
+	}
+	if result.Parts < 2 {
+		t.Errorf("Parts = %d, want a thread", result.Parts)
+	}
+	if want := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC); !result.PostedAt.Equal(want) {
+		t.Errorf("PostedAt = %s, want %s", result.PostedAt, want)
+	}
+}
+
+// replies is a messenger keeping the text of what is sent through it.
+type replies struct {
+	texts []string
+}
+
+func (r *replies) SendMessage(_ context.Context, message *im.Message) error {
+	r.texts = append(r.texts, message.Text)
+	return nil
+}
+
+func (r *replies) Name() string {
+	return "test"
+}
+
+func TestDryRunMakesNoRequests(t *testing.T) {
+	instance := &fakeInstance{}
+	c := newTestClient(t, instance)
+	flow := blogging.NewPostingFlow(map[config.AvailableBloggingPlatform]blogging.AuthedPlatform{config.MBPMastodon: c}, nil)
+	messenger := &replies{}
+	for _, text := range []string{"/new langs=en", "checking my setup", "/send --dry-run", "/send dryrun=true"} {
+		if err := flow.HandleMessage(context.Background(), &im.Message{UserID: 1, Text: text}, messenger); err != nil {
+			t.Fatalf("handling %q: %v", text, err)
+		}
+	}
+
+	instance.mu.Lock()
+	requests := len(instance.requests)
+	instance.mu.Unlock()
+	if requests != 0 {
+		t.Errorf("the instance got %d requests in a dry run, want none", requests)
+	}
+	want := "toot 1/1, language en:\nchecking my setup"
+	for _, reply := range messenger.texts[len(messenger.texts)-2:] {
+		if !strings.HasPrefix(reply, "Dry run, nothing was sent.") || !strings.Contains(reply, want) {
+			t.Errorf("dry run answered %q, want what would be sent, %q", reply, want)
+		}
+	}
+}
+
+// uploaded returns the forms of the media uploaded, in order.
+func (f *fakeInstance) uploaded() []url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var forms []url.Values
+	for _, r := range f.requests {
+		if r.method == http.MethodPost && r.path == "/api/v1/media" {
+			forms = append(forms, r.form)
+		}
+	}
+	return forms
+}
+
+func TestPostVideo(t *testing.T) {
+	instance := &fakeInstance{}
+	c := newTestClient(t, instance)
+	post := &blogging.MicroblogPost{Text: "look at this"}
+	if err := post.AddVideo(&blogging.BlogVideo{Data: []byte("not really a video"), MimeType: "video/mp4",
+		Duration: 5 * time.Second, AltText: "a cat"}); err != nil {
+		t.Fatalf("AddVideo: %v", err)
+	}
+	if _, err := c.Post(context.Background(), 1, post); err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	uploaded := instance.uploaded()
+	if len(uploaded) != 1 {
+		t.Fatalf("%d media uploaded, want the video", len(uploaded))
+	}
+	if got := uploaded[0].Get("description"); got != "a cat" {
+		t.Errorf("description = %q, want the alt text of the video", got)
+	}
+	posted := instance.posted()
+	if len(posted) != 1 || !slices.Equal(posted[0]["media_ids[]"], []string{"media"}) {
+		t.Errorf("statuses = %v, want one carrying the video", posted)
+	}
+}
+
+func TestTootPoll(t *testing.T) {
+	if tootPoll(nil) != nil {
+		t.Error("tootPoll(nil) is not nil, want posts without a poll to send none")
+	}
+	poll := tootPoll(&blogging.Poll{Options: []string{"yes", "no"}, ExpiresIn: time.Hour, Multiple: true})
+	if !slices.Equal(poll.Options, []string{"yes", "no"}) || poll.ExpiresInSeconds != 3600 || !poll.Multiple {
+		t.Errorf("tootPoll() = %+v, want options [yes no], expiring in 3600 seconds, multiple", poll)
+	}
+}
+
+func TestPostPoll(t *testing.T) {
+	instance := &fakeInstance{}
+	c := newTestClient(t, instance)
+	post := &blogging.MicroblogPost{Text: "tabs or spaces?",
+		Poll: &blogging.Poll{Options: []string{"tabs", "spaces"}, ExpiresIn: 24 * time.Hour}}
+	if _, err := c.Post(context.Background(), 1, post); err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	posted := instance.posted()
+	if len(posted) != 1 {
+		t.Fatalf("%d statuses posted, want 1", len(posted))
+	}
+	if got := posted[0]["poll[options][]"]; !slices.Equal(got, []string{"tabs", "spaces"}) {
+		t.Errorf("poll options = %q, want [tabs spaces]", got)
+	}
+	if got := posted[0].Get("poll[expires_in]"); got != "86400" {
+		t.Errorf("poll expires in %q seconds, want 86400", got)
+	}
+}
+
+func TestAccountsAreAuthorizedIndependently(t *testing.T) {
+	personal := httptest.NewServer(&fakeInstance{})
+	defer personal.Close()
+	work := httptest.NewServer(&fakeInstance{})
+	defer work.Close()
+	store := newTestStore()
+
+	c, err := NewClient(store)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	authorize(t, c, personal.URL)
+	workClient, err := NewClient(store, WithAccount("work"))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if workClient.IsAuthorized(1) {
+		t.Fatal("the work account is authorized by authorizing the personal one")
+	}
+	authorize(t, workClient, work.URL)
+
+	keys := store.Backend.(*secrets.MemoryBackend).Keys()
+	slices.Sort(keys)
+	if want := []string{"1.json", "1.mastodon.work.json"}; !slices.Equal(keys, want) {
+		t.Errorf("stored %q, want %q", keys, want)
+	}
+	// after a restart each account has its own instance.
+	for account, want := range map[string]string{"": personal.URL, "work": work.URL} {
+		fresh, err := NewClient(store, WithAccount(account))
+		if err != nil {
+			t.Fatalf("NewClient: %v", err)
+		}
+		if !fresh.IsAuthorized(1) || fresh.config.Server != want {
+			t.Errorf("account %q is on %q, want %q", account, fresh.config.Server, want)
+		}
+	}
+}
+
+func TestForgetClearsTheSavedConfig(t *testing.T) {
+	server := httptest.NewServer(&fakeInstance{})
+	defer server.Close()
+	store := newTestStore()
+	c, err := NewClient(store)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	authorize(t, c, server.URL)
+
+	if err := c.Forget(1); err != nil {
+		t.Fatalf("Forget: %v", err)
+	}
+	if c.IsAuthorized(1) {
+		t.Error("IsAuthorized() = true after Forget")
+	}
+	if keys := store.Backend.(*secrets.MemoryBackend).Keys(); len(keys) != 0 {
+		t.Errorf("stored %q after Forget, want nothing", keys)
+	}
+	fresh, err := NewClient(store)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if fresh.IsAuthorized(1) {
+		t.Error("a client made after Forget is authorized")
+	}
+}
+
+func TestCancelingTheAuthorizationEndsIt(t *testing.T) {
+	server := httptest.NewServer(&fakeInstance{})
+	defer server.Close()
+	c, err := NewClient(newTestStore())
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	comms, err := c.StartAuthorization(ctx, 1, nil)
+	if err != nil {
+		t.Fatalf("StartAuthorization: %v", err)
+	}
+	if question := <-comms; !strings.Contains(question, "server URL") {
+		t.Fatalf("first question = %q, want the server", question)
+	}
+	comms <- server.URL
+	if question := <-comms; !strings.Contains(question, "Open your browser") {
+		t.Fatalf("second question = %q, want the authorization code", question)
+	}
+
+	// the user walks away while being asked for the code.
+	cancel()
+	select {
+	case message, open := <-comms:
+		if open {
+			t.Errorf("got %q after canceling, want the authorization to end", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the authorization is still running after canceling it")
+	}
+	if c.config.AccessToken != "" {
+		t.Error("an access token was stored without the user's code")
+	}
+}
@@ -0,0 +1,45 @@
+package mastodon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// lookupHost resolves the host of the servers users give, to tell them right away about a typo.
+var lookupHost = net.DefaultResolver.LookupHost
+
+// normalizeServer turns what the user answered when asked for their instance into its base URL: the scheme defaults
+// to https, paths and trailing slashes are dropped and handles (i.e. @me@mastodon.social) are taken for their
+// instance. The host must resolve.
+func normalizeServer(ctx context.Context, answer string) (string, error) {
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return "", errors.New("no server given")
+	}
+	given := answer
+	if !strings.Contains(answer, "://") {
+		if i := strings.LastIndex(answer, "@"); i >= 0 {
+			answer = answer[i+1:]
+		}
+		answer = "https://" + answer
+	}
+	u, err := url.Parse(answer)
+	if err != nil {
+		return "", fmt.Errorf("%q is not an address", given)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return "", fmt.Errorf("%q is not a web address", given)
+	}
+	host := u.Hostname()
+	if host == "" || strings.ContainsAny(host, " \t") {
+		return "", fmt.Errorf("%q has no server name", given)
+	}
+	if _, err := lookupHost(ctx, host); err != nil {
+		return "", fmt.Errorf("%s can not be found", host)
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
@@ -0,0 +1,41 @@
+package mastodon
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestNormalizeServer(t *testing.T) {
+	// only mastodon.social exists, no lookups leave the test.
+	resolver := lookupHost
+	t.Cleanup(func() { lookupHost = resolver })
+	lookupHost = func(_ context.Context, host string) ([]string, error) {
+		if host == "mastodon.social" {
+			return []string{"192.0.2.1"}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	ctx := context.Background()
+	for _, answer := range []string{
+		"mastodon.social",
+		" mastodon.social\n",
+		"https://mastodon.social/",
+		"https://mastodon.social/@me",
+		"@me@mastodon.social",
+		"me@mastodon.social",
+	} {
+		if got, err := normalizeServer(ctx, answer); err != nil || got != "https://mastodon.social" {
+			t.Errorf("normalizeServer(%q) = %q, %v, want https://mastodon.social", answer, got, err)
+		}
+	}
+	if got, err := normalizeServer(ctx, "http://mastodon.social:8080/"); err != nil || got != "http://mastodon.social:8080" {
+		t.Errorf("normalizeServer(http with port) = %q, %v, want the scheme and port kept", got, err)
+	}
+	for _, answer := range []string{"", "   ", "ftp://mastodon.social", "not a server", "https://", "mastodon.socail"} {
+		if got, err := normalizeServer(ctx, answer); err == nil {
+			t.Errorf("normalizeServer(%q) = %q, want an error", answer, got)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package blogging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// MaxDownloadedMediaSize is the largest media file we are willing to download when rebuilding a post from another
+// platform, it is well above what any of the supported platforms accept anyway.
+const MaxDownloadedMediaSize = 50 << 20
+
+// DownloadMedia fetches the media at mediaURL and returns its raw bytes, it fails with ErrMediaTooLarge if the
+// media exceeds MaxDownloadedMediaSize.
+func DownloadMedia(ctx context.Context, mediaURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, mediaURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating media request: %w", err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("GET media: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET media returned non-OK status: %s", res.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(res.Body, MaxDownloadedMediaSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading media: %w", err)
+	}
+	if len(data) > MaxDownloadedMediaSize {
+		return nil, fmt.Errorf("%s: %w", mediaURL, ErrMediaTooLarge)
+	}
+	return data, nil
+}
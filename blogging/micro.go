@@ -2,7 +2,10 @@ package blogging
 
 import (
 	"bytes"
+	"fmt"
 	"io"
+	"slices"
+	"strings"
 )
 
 // BlogImageRaw is a byte slice that represents an image as obtained from a im messenger, it is mostly intended
@@ -30,12 +33,130 @@ func NewBlogImage(data []byte, altText string) *BlogImage {
 
 // MicroblogPost holds the data for a Microblog post.
 type MicroblogPost struct {
-	Text   string       // Accumulated text content.
-	Images []*BlogImage // Telegram file IDs for images.
-	Langs  []string     // Languages of the post.
+	Text           string       `json:"text"`                      // Accumulated text content.
+	Images         []*BlogImage `json:"images,omitempty"`          // Telegram file IDs for images.
+	Video          *BlogVideo   `json:"video,omitempty"`           // A post has either images or a video.
+	Langs          []string     `json:"langs,omitempty"`           // Languages of the post.
+	ContentWarning string       `json:"content_warning,omitempty"` // Spoiler text, the body is hidden behind it where supported.
+	Poll           *Poll        `json:"poll,omitempty"`            // Only taken by the platforms that have polls.
+	// QuoteURL is the post this one quotes, platforms without quotes get the link at the end of the text instead.
+	QuoteURL string `json:"quote_url,omitempty"`
+	// Tags are hashtags for mastodon and bluesky (see RenderFor) and the tags of the post where platforms have them.
+	Tags []string `json:"tags,omitempty"`
+	// Segments maps the messages that added text to where that text is in Text, so edits to them can be applied.
+	Segments []TextSegment `json:"segments,omitempty"`
 }
 
-// AddImage adds an image to the post.
+// TextSegment is the span of the text of a post, in bytes [Start, End), that came from the message MsgID.
+type TextSegment struct {
+	MsgID uint64 `json:"msg_id"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// Separators AppendText can put between the texts of successive messages.
+const (
+	// LineSeparator puts the text of each message on a line of its own.
+	LineSeparator = "\n"
+	// ParagraphSeparator puts the text of each message in a paragraph of its own.
+	ParagraphSeparator = "\n\n"
+)
+
+// AppendText adds text, coming from the message msgID, at the end of the post after separator (see LineSeparator
+// and ParagraphSeparator). The blank lines text starts or ends with are dropped, so they don't pile up with the
+// separator, the ones within it are kept. It returns false, adding nothing, if text is blank.
+func (b *MicroblogPost) AppendText(msgID uint64, text, separator string) bool {
+	text = strings.TrimRight(strings.TrimLeft(text, "\n"), " \t\n")
+	if strings.TrimSpace(text) == "" {
+		return false
+	}
+	if len(b.Text) != 0 && !strings.HasSuffix(b.Text, separator) {
+		b.Text += separator
+	}
+	start := len(b.Text)
+	b.Text += text
+	b.Segments = append(b.Segments, TextSegment{MsgID: msgID, Start: start, End: len(b.Text)})
+	return true
+}
+
+// SetText replaces the whole text of the post with text, which comes from no message in particular, so edits to the
+// messages that added the previous text are no longer applied.
+func (b *MicroblogPost) SetText(text string) {
+	b.Text = text
+	b.Segments = nil
+}
+
+// Segment returns the span of Text that came from the message msgID.
+func (b *MicroblogPost) Segment(msgID uint64) (TextSegment, bool) {
+	for _, segment := range b.Segments {
+		if segment.MsgID == msgID {
+			return segment, true
+		}
+	}
+	return TextSegment{}, false
+}
+
+// ReplaceText replaces the text that came from the message msgID with text, i.e. when the message is edited, it
+// returns ErrUnknownSegment if that message added no text to the post.
+func (b *MicroblogPost) ReplaceText(msgID uint64, text string) error {
+	for i, segment := range b.Segments {
+		if segment.MsgID != msgID {
+			continue
+		}
+		if segment.Start < 0 || segment.End > len(b.Text) || segment.Start > segment.End {
+			return fmt.Errorf("message %d spans [%d, %d) of %d bytes: %w", msgID, segment.Start, segment.End,
+				len(b.Text), ErrUnknownSegment)
+		}
+		b.Text = b.Text[:segment.Start] + text + b.Text[segment.End:]
+		delta := len(text) - (segment.End - segment.Start)
+		b.Segments[i].End += delta
+		for j := range b.Segments {
+			if j != i && b.Segments[j].Start >= segment.End {
+				b.Segments[j].Start += delta
+				b.Segments[j].End += delta
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("message %d: %w", msgID, ErrUnknownSegment)
+}
+
+// WithQuoteLink returns the post as platforms without quotes should send it, a copy with QuoteURL at the end of the
+// text, or the post itself if it quotes nothing.
+func (b *MicroblogPost) WithQuoteLink() *MicroblogPost {
+	if b.QuoteURL == "" {
+		return b
+	}
+	linked := *b
+	linked.QuoteURL = ""
+	if strings.TrimSpace(linked.Text) != "" {
+		linked.Text = strings.TrimRight(linked.Text, " \n") + "\n\n"
+	}
+	linked.Text += b.QuoteURL
+	return &linked
+}
+
+// AddImage adds an image to the post, callers must check the post has no video, see AddVideo.
 func (b *MicroblogPost) AddImage(image *BlogImage) {
 	b.Images = append(b.Images, image)
 }
+
+// SetAltText replaces the alt text of the image at index (0 based), it returns ErrImageIndexOutOfRange if the post has
+// no such image.
+func (b *MicroblogPost) SetAltText(index int, altText string) error {
+	if index < 0 || index >= len(b.Images) {
+		return fmt.Errorf("image %d of %d: %w", index+1, len(b.Images), ErrImageIndexOutOfRange)
+	}
+	b.Images[index].AltText = altText
+	return nil
+}
+
+// RemoveImage drops the image at index (0 based), the ones after it move up, it returns ErrImageIndexOutOfRange if the
+// post has no such image.
+func (b *MicroblogPost) RemoveImage(index int) error {
+	if index < 0 || index >= len(b.Images) {
+		return fmt.Errorf("image %d of %d: %w", index+1, len(b.Images), ErrImageIndexOutOfRange)
+	}
+	b.Images = slices.Delete(b.Images, index, index+1)
+	return nil
+}
@@ -0,0 +1,56 @@
+package blogging
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSetAltTextIndexBounds(t *testing.T) {
+	post := &MicroblogPost{}
+	post.AddImage(NewBlogImage([]byte("first"), ""))
+	post.AddImage(NewBlogImage([]byte("second"), "was there"))
+
+	for _, index := range []int{-1, 2, 10} {
+		if err := post.SetAltText(index, "nope"); !errors.Is(err, ErrImageIndexOutOfRange) {
+			t.Errorf("SetAltText(%d) = %v, want ErrImageIndexOutOfRange", index, err)
+		}
+	}
+	if err := post.SetAltText(0, "a cat"); err != nil {
+		t.Fatalf("SetAltText(0): %v", err)
+	}
+	if err := post.SetAltText(1, "a dog"); err != nil {
+		t.Fatalf("SetAltText(1): %v", err)
+	}
+	if post.Images[0].AltText != "a cat" || post.Images[1].AltText != "a dog" {
+		t.Errorf("alt texts = %q, %q, want \"a cat\", \"a dog\"", post.Images[0].AltText, post.Images[1].AltText)
+	}
+}
+
+func TestAppendText(t *testing.T) {
+	post := &MicroblogPost{}
+	for i, text := range []string{
+		"first line",
+		"\n\n  indented, with trailing blanks  \n\n",
+		"   ",
+		"a list:\n- one\n\n- two",
+	} {
+		post.AppendText(uint64(i+1), text, LineSeparator)
+	}
+	want := "first line\n  indented, with trailing blanks\na list:\n- one\n\n- two"
+	if post.Text != want {
+		t.Errorf("Text = %q, want %q", post.Text, want)
+	}
+	if len(post.Segments) != 3 {
+		t.Fatalf("%d segments, want one per message with text", len(post.Segments))
+	}
+	if seg := post.Segments[1]; post.Text[seg.Start:seg.End] != "  indented, with trailing blanks" || seg.MsgID != 2 {
+		t.Errorf("segment of message 2 = %q, want its text", post.Text[seg.Start:seg.End])
+	}
+
+	paragraphs := &MicroblogPost{}
+	paragraphs.AppendText(1, "first paragraph\n", ParagraphSeparator)
+	paragraphs.AppendText(2, "second paragraph", ParagraphSeparator)
+	if want := "first paragraph\n\nsecond paragraph"; paragraphs.Text != want {
+		t.Errorf("Text = %q, want %q", paragraphs.Text, want)
+	}
+}
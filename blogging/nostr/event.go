@@ -0,0 +1,147 @@
+package nostr
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Event kinds we publish.
+const (
+	KindTextNote = 1
+	// KindHTTPAuth is the NIP-98 event used to authenticate against HTTP services, i.e. NIP-96 media hosts.
+	KindHTTPAuth = 27235
+)
+
+// ErrInvalidSignature is returned when an event's id or signature do not match its content.
+var ErrInvalidSignature = errors.New("invalid event signature")
+
+// Event is a nostr event as described in NIP-01.
+type Event struct {
+	ID        string     `json:"id"`
+	PubKey    string     `json:"pubkey"`
+	CreatedAt int64      `json:"created_at"`
+	Kind      int        `json:"kind"`
+	Tags      [][]string `json:"tags"`
+	Content   string     `json:"content"`
+	Sig       string     `json:"sig"`
+}
+
+func decodeHex(s string) ([]byte, error) {
+	return hex.DecodeString(s)
+}
+
+// hash returns the event id, the sha256 of its NIP-01 serialization: [0,pubkey,created_at,kind,tags,content] with no
+// spaces and strings written as appendNIP01String does.
+func (e *Event) hash() []byte {
+	b := []byte("[0,")
+	b = appendNIP01String(b, e.PubKey)
+	b = fmt.Appendf(b, ",%d,%d,[", e.CreatedAt, e.Kind)
+	for i, tag := range e.Tags {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, '[')
+		for j, value := range tag {
+			if j > 0 {
+				b = append(b, ',')
+			}
+			b = appendNIP01String(b, value)
+		}
+		b = append(b, ']')
+	}
+	b = append(b, "],"...)
+	b = appendNIP01String(b, e.Content)
+	b = append(b, ']')
+	sum := sha256.Sum256(b)
+	return sum[:]
+}
+
+// appendNIP01String appends s to b as a JSON string the way NIP-01 (and JSON.stringify, which most clients hash
+// with) wants it: only quotes, backslashes and control characters are escaped. encoding/json would escape <, > and &,
+// and U+2028 and U+2029 even without HTML escaping, giving another id.
+func appendNIP01String(b []byte, s string) []byte {
+	b = append(b, '"')
+	// invalid UTF-8 becomes U+FFFD, as with encoding/json.
+	for _, r := range s {
+		switch r {
+		case '"':
+			b = append(b, `\"`...)
+		case '\\':
+			b = append(b, `\\`...)
+		case '\n':
+			b = append(b, `\n`...)
+		case '\r':
+			b = append(b, `\r`...)
+		case '\t':
+			b = append(b, `\t`...)
+		case '\b':
+			b = append(b, `\b`...)
+		case '\f':
+			b = append(b, `\f`...)
+		default:
+			if r < 0x20 {
+				b = fmt.Appendf(b, `\u%04x`, r)
+				continue
+			}
+			b = utf8.AppendRune(b, r)
+		}
+	}
+	return append(b, '"')
+}
+
+// Sign fills in the public key, id and signature of the event using privateKey.
+func (e *Event) Sign(privateKey []byte) error {
+	pub, err := PublicKey(privateKey)
+	if err != nil {
+		return err
+	}
+	if e.Tags == nil {
+		e.Tags = [][]string{}
+	}
+	e.PubKey = hex.EncodeToString(pub)
+	id := e.hash()
+	aux := make([]byte, 32)
+	if _, err := rand.Read(aux); err != nil {
+		return fmt.Errorf("reading randomness: %w", err)
+	}
+	sig, err := signSchnorr(privateKey, id, aux)
+	if err != nil {
+		return fmt.Errorf("signing event: %w", err)
+	}
+	e.ID = hex.EncodeToString(id)
+	e.Sig = hex.EncodeToString(sig)
+	return nil
+}
+
+// Verify checks that the id and signature of the event match its content.
+func (e *Event) Verify() error {
+	id := e.hash()
+	if hex.EncodeToString(id) != e.ID {
+		return fmt.Errorf("id does not match the content: %w", ErrInvalidSignature)
+	}
+	pub, err := hex.DecodeString(e.PubKey)
+	if err != nil {
+		return fmt.Errorf("decoding public key: %w", err)
+	}
+	sig, err := hex.DecodeString(e.Sig)
+	if err != nil {
+		return fmt.Errorf("decoding signature: %w", err)
+	}
+	if !verifySchnorr(pub, id, sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// NoteID returns the NIP-19 note1... encoding of the event id.
+func (e *Event) NoteID() (string, error) {
+	id, err := hex.DecodeString(e.ID)
+	if err != nil {
+		return "", fmt.Errorf("decoding event id: %w", err)
+	}
+	return bech32Encode("note", id)
+}
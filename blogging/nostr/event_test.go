@@ -0,0 +1,87 @@
+package nostr
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/perrito666/chat2world/blogging"
+)
+
+// testKey is the private key of the first BIP-340 test vector.
+var testKey, _ = hex.DecodeString("0000000000000000000000000000000000000000000000000000000000000003")
+
+func TestPublicKey(t *testing.T) {
+	pub, err := PublicKey(testKey)
+	if err != nil {
+		t.Fatalf("PublicKey: %v", err)
+	}
+	if want := "f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9"; hex.EncodeToString(pub) != want {
+		t.Errorf("PublicKey() = %x, want %s", pub, want)
+	}
+	if _, err := PublicKey(make([]byte, 32)); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("PublicKey(zero) err = %v, want ErrInvalidKey", err)
+	}
+}
+
+func TestSignedEventVerifies(t *testing.T) {
+	post := &blogging.MicroblogPost{Text: "hello <nostr> & #Friends", Langs: []string{"en"}}
+	event := buildEvent(post, nil, nil, time.Unix(1700000000, 0))
+	if err := event.Sign(testKey); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if len(event.ID) != 64 || len(event.Sig) != 128 {
+		t.Errorf("id, sig = %q, %q, want 32 and 64 bytes in hex", event.ID, event.Sig)
+	}
+	if !strings.HasPrefix(event.PubKey, "f9308a01") {
+		t.Errorf("PubKey = %q, want the one of the key", event.PubKey)
+	}
+	if err := event.Verify(); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	// an id made up for other content, or a signature of another id, do not verify.
+	tampered := *event
+	tampered.Content = "goodbye"
+	if err := tampered.Verify(); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("verifying changed content: err = %v, want ErrInvalidSignature", err)
+	}
+	other := buildEvent(&blogging.MicroblogPost{Text: "another note"}, nil, nil, time.Unix(1700000000, 0))
+	if err := other.Sign(testKey); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	forged := *event
+	forged.Sig = other.Sig
+	if err := forged.Verify(); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("verifying a signature of another event: err = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestEventHash(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		event *Event
+		// serialized is the NIP-01 serialization of the event, its id is the sha256 of it.
+		serialized string
+	}{
+		{"HTML characters", &Event{PubKey: "ab", CreatedAt: 1, Kind: KindTextNote, Content: "<a> & <b>"},
+			`[0,"ab",1,1,[],"<a> & <b>"]`},
+		{"line and paragraph separators",
+			&Event{PubKey: "ab", CreatedAt: 1, Kind: KindTextNote, Content: "one\u2028two\u2029three"},
+			"[0,\"ab\",1,1,[],\"one\u2028two\u2029three\"]"},
+		{"escaped characters", &Event{PubKey: "ab", CreatedAt: 1700000000, Kind: KindTextNote,
+			Tags:    [][]string{{"t", "nostr"}, {"r", "https://example.com/?a=1&b=<2>"}},
+			Content: "say \"hi\"\n\tC:\\path\r\b\f\x01 done"},
+			`[0,"ab",1700000000,1,[["t","nostr"],["r","https://example.com/?a=1&b=<2>"]],` +
+				`"say \"hi\"\n\tC:\\path\r\b\f\u0001 done"]`},
+	} {
+		want := sha256.Sum256([]byte(tc.serialized))
+		if id := tc.event.hash(); !bytes.Equal(id, want[:]) {
+			t.Errorf("%s: hash() = %x, want the sha256 of %s, %x", tc.name, id, tc.serialized, want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package nostr
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxUploadResponse bounds the responses we read from media hosts.
+const maxUploadResponse = 1 << 20
+
+// nip96Info is the part of /.well-known/nostr/nip96.json we care about.
+type nip96Info struct {
+	APIURL         string `json:"api_url"`
+	DelegatedToURL string `json:"delegated_to_url"`
+}
+
+// nip96Response is the answer of a NIP-96 upload, the URL of the file is in the url tag of nip94_event.
+type nip96Response struct {
+	Status     string `json:"status"`
+	Message    string `json:"message"`
+	NIP94Event struct {
+		Tags [][]string `json:"tags"`
+	} `json:"nip94_event"`
+}
+
+// UploadedMedia is a file uploaded to a media host.
+type UploadedMedia struct {
+	URL      string
+	MimeType string
+}
+
+// MediaUploader uploads files to a NIP-96 media host, authenticating (NIP-98) with the user's key.
+type MediaUploader struct {
+	HTTPClient *http.Client
+	// Host is the base URL of the media host, i.e. https://nostr.build.
+	Host       string
+	privateKey []byte
+}
+
+// NewMediaUploader returns a MediaUploader for host that signs its requests with privateKey.
+func NewMediaUploader(host string, privateKey []byte) *MediaUploader {
+	return &MediaUploader{
+		HTTPClient: http.DefaultClient,
+		Host:       strings.TrimRight(host, "/"),
+		privateKey: privateKey,
+	}
+}
+
+// apiURL discovers where uploads go through the host's NIP-96 document, following a delegation if there is one.
+func (m *MediaUploader) apiURL(ctx context.Context) (string, error) {
+	host := m.Host
+	for range 2 {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, host+"/.well-known/nostr/nip96.json", nil)
+		if err != nil {
+			return "", fmt.Errorf("creating nip96 discovery request: %w", err)
+		}
+		resp, err := m.HTTPClient.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("fetching nip96 info of %s: %w", host, err)
+		}
+		var info nip96Info
+		err = json.NewDecoder(io.LimitReader(resp.Body, maxUploadResponse)).Decode(&info)
+		resp.Body.Close()
+		if err != nil {
+			return "", fmt.Errorf("decoding nip96 info of %s: %w", host, err)
+		}
+		if info.APIURL != "" {
+			return info.APIURL, nil
+		}
+		if info.DelegatedToURL == "" {
+			break
+		}
+		host = strings.TrimRight(info.DelegatedToURL, "/")
+	}
+	return "", fmt.Errorf("%s does not say where to upload media", m.Host)
+}
+
+// authHeader returns the NIP-98 Authorization header for a request to u with the given method and body.
+func (m *MediaUploader) authHeader(u, method string, body []byte) (string, error) {
+	payload := sha256.Sum256(body)
+	event := &Event{
+		CreatedAt: time.Now().Unix(),
+		Kind:      KindHTTPAuth,
+		Tags: [][]string{
+			{"u", u},
+			{"method", method},
+			{"payload", hex.EncodeToString(payload[:])},
+		},
+	}
+	if err := event.Sign(m.privateKey); err != nil {
+		return "", err
+	}
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return "", fmt.Errorf("encoding auth event: %w", err)
+	}
+	return "Nostr " + base64.StdEncoding.EncodeToString(encoded), nil
+}
+
+// Upload sends data to the media host and returns where it can be found.
+func (m *MediaUploader) Upload(ctx context.Context, data []byte, altText string) (*UploadedMedia, error) {
+	apiURL, err := m.apiURL(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mimeType := http.DetectContentType(data)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", "image")
+	if err != nil {
+		return nil, fmt.Errorf("creating upload form: %w", err)
+	}
+	if _, err := fw.Write(data); err != nil {
+		return nil, fmt.Errorf("writing upload form: %w", err)
+	}
+	_ = mw.WriteField("content_type", mimeType)
+	if altText != "" {
+		_ = mw.WriteField("alt", altText)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, fmt.Errorf("closing upload form: %w", err)
+	}
+
+	auth, err := m.authHeader(apiURL, http.MethodPost, body.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL, bytes.NewReader(body.Bytes()))
+	if err != nil {
+		return nil, fmt.Errorf("creating upload request: %w", err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", auth)
+	resp, err := m.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("uploading to %s: %w", apiURL, err)
+	}
+	defer resp.Body.Close()
+
+	var uploaded nip96Response
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxUploadResponse)).Decode(&uploaded); err != nil {
+		return nil, fmt.Errorf("decoding upload response (%s): %w", resp.Status, err)
+	}
+	if resp.StatusCode >= http.StatusBadRequest || uploaded.Status == "error" {
+		return nil, fmt.Errorf("upload failed (%s): %s", resp.Status, uploaded.Message)
+	}
+	media := &UploadedMedia{MimeType: mimeType}
+	for _, tag := range uploaded.NIP94Event.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "url":
+			media.URL = tag[1]
+		case "m":
+			media.MimeType = tag[1]
+		}
+	}
+	if media.URL == "" {
+		// a 202 means the host is still processing the file, we do not wait for it.
+		return nil, fmt.Errorf("upload response (%s) has no url: %s", resp.Status, uploaded.Message)
+	}
+	return media, nil
+}
@@ -0,0 +1,373 @@
+package nostr
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/perrito666/chat2world/blogging"
+	"github.com/perrito666/chat2world/config"
+	"github.com/perrito666/chat2world/secrets"
+)
+
+const (
+	// DefaultMediaHost is the NIP-96 host images are uploaded to unless the user picks another.
+	DefaultMediaHost = "https://nostr.build"
+	// noteURLPrefix is where we point people to see a note, njump renders any note without a nostr client.
+	noteURLPrefix = "https://njump.me/"
+)
+
+// DefaultRelays are the relays posts go to unless the user picks others.
+var DefaultRelays = []string{"wss://relay.damus.io", "wss://nos.lol", "wss://relay.primal.net"}
+
+// Config holds the key and relays of a nostr user.
+type Config struct {
+	// PrivateKey is the hex encoded private key, only ever stored encrypted.
+	PrivateKey string   `json:"private_key,omitempty"`
+	Relays     []string `json:"relays,omitempty"`
+	MediaHost  string   `json:"media_host,omitempty"`
+}
+
+func (c *Config) LoadFromPersistableDict(dict map[string]string) error {
+	c.PrivateKey = dict["private_key"]
+	c.Relays = strings.Fields(dict["relays"])
+	c.MediaHost = dict["media_host"]
+	return nil
+}
+
+func (c *Config) DumpToPersistableDict() map[string]string {
+	return map[string]string{
+		"private_key": c.PrivateKey,
+		"relays":      strings.Join(c.Relays, " "),
+		"media_host":  c.MediaHost,
+	}
+}
+
+var _ blogging.ClientConfig = (*Config)(nil)
+
+// Client signs posts with the user's key and publishes them to their relays.
+type Client struct {
+	store  *secrets.EncryptedStore
+	config *Config
+	userID blogging.UserID
+	logger *slog.Logger
+}
+
+func (c *Client) Config(userID blogging.UserID) (blogging.ClientConfig, error) {
+	if c.config == nil {
+		return nil, blogging.ErrClientNotFound
+	}
+	return c.config, nil
+}
+
+// ClientOption configures optional settings of a Client.
+type ClientOption func(*Client)
+
+// WithLogger sets where the client logs, it defaults to slog.Default().
+func WithLogger(logger *slog.Logger) ClientOption {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// NewClient creates a new nostr client, its configuration is loaded from store when first needed.
+func NewClient(store *secrets.EncryptedStore, opts ...ClientOption) (*Client, error) {
+	c := &Client{
+		store:  store,
+		config: &Config{},
+		logger: slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.logger = c.logger.With("platform", config.MBPNostr)
+	return c, nil
+}
+
+var _ blogging.AuthedPlatform = (*Client)(nil)
+
+// configPath returns the name of the file holding the nostr configuration of a user.
+func configPath(id blogging.UserID) string {
+	return fmt.Sprintf("%d.nostr.json", id)
+}
+
+// IsAuthorized returns true if we have a key and relays for the user.
+func (c *Client) IsAuthorized(id blogging.UserID) bool {
+	if c.userID == 0 {
+		c.userID = id
+	}
+	if c.config.PrivateKey == "" {
+		if err := c.loadConfigIfExists(id); err != nil {
+			c.logger.Error("loading config", "user_id", id, "err", err)
+			return false
+		}
+	}
+	return c.config.PrivateKey != "" && len(c.config.Relays) > 0
+}
+
+// Forget implements blogging.Forgetter.
+func (c *Client) Forget(userID blogging.UserID) error {
+	if err := c.store.Remove(configPath(userID)); err != nil {
+		return fmt.Errorf("removing config: %w", err)
+	}
+	c.config = &Config{}
+	c.logger.Info("config forgotten", "user_id", userID)
+	return nil
+}
+
+var _ blogging.Forgetter = (*Client)(nil)
+
+// loadConfigIfExists loads a config from a file if it exists.
+func (c *Client) loadConfigIfExists(id blogging.UserID) error {
+	f, err := c.store.OpenReader(configPath(id))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	cfg := &Config{}
+	if err := json.NewDecoder(f).Decode(cfg); err != nil {
+		return fmt.Errorf("loading configuration for nostr from disk: %w", err)
+	}
+	c.config = cfg
+	return nil
+}
+
+// ask sends question through comms and returns the answer, ok is false if the context was canceled.
+func ask(ctx context.Context, comms chan string, question string) (string, bool) {
+	select {
+	case comms <- question:
+	case <-ctx.Done():
+		return "", false
+	}
+	select {
+	case answer := <-comms:
+		return strings.TrimSpace(answer), true
+	case <-ctx.Done():
+		return "", false
+	}
+}
+
+// parseRelays reads a space (or comma) separated list of relay URLs, "default" means DefaultRelays.
+func parseRelays(answer string) ([]string, error) {
+	if strings.EqualFold(strings.TrimSpace(answer), "default") {
+		return DefaultRelays, nil
+	}
+	relays := strings.Fields(strings.ReplaceAll(answer, ",", " "))
+	for _, relay := range relays {
+		if !strings.HasPrefix(relay, "wss://") && !strings.HasPrefix(relay, "ws://") {
+			return nil, fmt.Errorf("%s is not a wss:// URL", relay)
+		}
+	}
+	if len(relays) == 0 {
+		return nil, errors.New("no relays given")
+	}
+	return relays, nil
+}
+
+// StartAuthorization asks the user for their private key, relays and media host.
+func (c *Client) StartAuthorization(ctx context.Context, id blogging.UserID, cfgGeneric map[string]string) (chan string, error) {
+	if c.userID == 0 {
+		c.userID = id
+	}
+	cfg := &Config{}
+	if cfgGeneric != nil {
+		if err := cfg.LoadFromPersistableDict(cfgGeneric); err != nil {
+			return nil, fmt.Errorf("loading config: %w", err)
+		}
+	}
+	commsChan := make(chan string)
+	go func(id blogging.UserID, cfg *Config, comms chan string) {
+		defer close(comms)
+		for cfg.PrivateKey == "" {
+			answer, ok := ask(ctx, comms, "What is your nostr private key (nsec1...)? It is stored encrypted, you might want to delete the message afterwards.")
+			if !ok {
+				return
+			}
+			key, err := DecodePrivateKey(answer)
+			if err == nil {
+				_, err = PublicKey(key)
+			}
+			if err != nil {
+				c.logger.Info("invalid key given", "user_id", id, "err", err)
+				continue
+			}
+			cfg.PrivateKey = hex.EncodeToString(key)
+		}
+		for len(cfg.Relays) == 0 {
+			answer, ok := ask(ctx, comms, fmt.Sprintf("Which relays should posts go to? Space separated wss:// URLs, or default for %s.", strings.Join(DefaultRelays, " ")))
+			if !ok {
+				return
+			}
+			relays, err := parseRelays(answer)
+			if err != nil {
+				c.logger.Info("invalid relays given", "user_id", id, "err", err)
+				continue
+			}
+			cfg.Relays = relays
+		}
+		if cfg.MediaHost == "" {
+			answer, ok := ask(ctx, comms, fmt.Sprintf("Which NIP-96 media host should images be uploaded to? Answer default for %s.", DefaultMediaHost))
+			if !ok {
+				return
+			}
+			cfg.MediaHost = strings.TrimRight(answer, "/")
+			if cfg.MediaHost == "" || strings.EqualFold(cfg.MediaHost, "default") {
+				cfg.MediaHost = DefaultMediaHost
+			} else if !strings.Contains(cfg.MediaHost, "://") {
+				cfg.MediaHost = "https://" + cfg.MediaHost
+			}
+		}
+
+		f, err := c.store.OpenWriter(configPath(id))
+		if err != nil {
+			c.logger.Error("opening config to write", "user_id", id, "err", err)
+			return
+		}
+		defer f.Close()
+		if err := json.NewEncoder(f).Encode(cfg); err != nil {
+			c.logger.Error("writing config", "user_id", id, "err", err)
+			return
+		}
+		c.config = cfg
+	}(id, cfg, commsChan)
+	return commsChan, nil
+}
+
+var _ blogging.Platform = (*Client)(nil)
+
+// hashtagRegex finds hashtags, which become t tags so the note shows up in hashtag feeds.
+var hashtagRegex = regexp.MustCompile(`(?:^|\s)#(\w+)`)
+
+// buildEvent turns the post, with its images already uploaded, into an unsigned text note.
+func buildEvent(post *blogging.MicroblogPost, media []*UploadedMedia, altTexts []string, now time.Time) *Event {
+	post = post.WithQuoteLink()
+	content := strings.TrimSpace(post.Text)
+	tags := [][]string{}
+	for i, m := range media {
+		content += "\n" + m.URL
+		// NIP-92 media metadata, clients use it for the alt text.
+		imeta := []string{"imeta", "url " + m.URL, "m " + m.MimeType}
+		if altTexts[i] != "" {
+			imeta = append(imeta, "alt "+altTexts[i])
+		}
+		tags = append(tags, imeta)
+	}
+	seen := map[string]bool{}
+	hashtags := slices.Clone(post.Tags)
+	for _, match := range hashtagRegex.FindAllStringSubmatch(post.Text, -1) {
+		hashtags = append(hashtags, match[1])
+	}
+	for _, tag := range hashtags {
+		tag = strings.ToLower(tag)
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, []string{"t", tag})
+		}
+	}
+	if post.ContentWarning != "" {
+		// NIP-36
+		tags = append(tags, []string{"content-warning", post.ContentWarning})
+	}
+	if len(post.Langs) > 0 {
+		// NIP-32 labels
+		tags = append(tags, []string{"L", "ISO-639-1"})
+		for _, lang := range post.Langs {
+			tags = append(tags, []string{"l", lang, "ISO-639-1"})
+		}
+	}
+	return &Event{
+		CreatedAt: now.Unix(),
+		Kind:      KindTextNote,
+		Tags:      tags,
+		Content:   strings.TrimSpace(content),
+	}
+}
+
+var _ blogging.Previewer = (*Client)(nil)
+
+// Preview implements blogging.Previewer, it shows the note Post would publish, unsigned, with placeholders where the
+// URLs of the (not uploaded) images would go.
+func (c *Client) Preview(ctx context.Context, userID blogging.UserID, post *blogging.MicroblogPost) (string, error) {
+	if !c.IsAuthorized(userID) {
+		return "", fmt.Errorf("nostr not configured, use /nostr_auth: %w", blogging.ErrClientNotFound)
+	}
+	media := make([]*UploadedMedia, len(post.Images))
+	altTexts := make([]string, len(post.Images))
+	for idx, img := range post.Images {
+		media[idx] = &UploadedMedia{
+			URL:      fmt.Sprintf("<image %d uploaded to %s>", idx+1, c.config.MediaHost),
+			MimeType: http.DetectContentType(img.Data),
+		}
+		altTexts[idx] = img.AltText
+	}
+	event := buildEvent(post, media, altTexts, time.Now())
+	out, err := json.MarshalIndent(event, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("encoding event: %w", err)
+	}
+	return fmt.Sprintf("%s\nto %s", out, strings.Join(c.config.Relays, ", ")), nil
+}
+
+// Post uploads the images of the post to the media host, signs a text note linking them and publishes it to every
+// relay of the user. It succeeds if at least one relay takes the note, the others are reported as warnings.
+func (c *Client) Post(ctx context.Context, userID blogging.UserID, post *blogging.MicroblogPost) (*blogging.PostResult, error) {
+	if !c.IsAuthorized(userID) {
+		return nil, fmt.Errorf("nostr not configured, use /nostr_auth: %w", blogging.ErrClientNotFound)
+	}
+	key, err := hex.DecodeString(c.config.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("decoding private key: %w", err)
+	}
+
+	media := make([]*UploadedMedia, len(post.Images))
+	altTexts := make([]string, len(post.Images))
+	if len(post.Images) > 0 {
+		uploader := NewMediaUploader(c.config.MediaHost, key)
+		for idx, img := range post.Images {
+			media[idx], err = uploader.Upload(ctx, img.Data, img.AltText)
+			if err != nil {
+				return nil, fmt.Errorf("uploading image %d: %w", idx, err)
+			}
+			altTexts[idx] = img.AltText
+		}
+	}
+
+	now := time.Now()
+	event := buildEvent(post, media, altTexts, now)
+	if err := event.Sign(key); err != nil {
+		return nil, err
+	}
+
+	result := &blogging.PostResult{ID: event.ID, PostedAt: now, Parts: 1}
+	if post.Video != nil {
+		result.Warnings = append(result.Warnings, "videos are not supported, the note went without it")
+	}
+	if post.Poll != nil {
+		result.Warnings = append(result.Warnings, "polls are not supported, the note went without it")
+	}
+	var relayErrs []error
+	for _, relay := range c.config.Relays {
+		if err := c.PublishToRelay(ctx, relay, event); err != nil {
+			c.logger.Warn("publishing to relay", "user_id", userID, "relay", relay, "err", err)
+			relayErrs = append(relayErrs, err)
+			result.Warnings = append(result.Warnings, err.Error())
+		}
+	}
+	if len(relayErrs) == len(c.config.Relays) {
+		return nil, fmt.Errorf("no relay took the note: %w", errors.Join(relayErrs...))
+	}
+	noteID, err := event.NoteID()
+	if err != nil {
+		return nil, err
+	}
+	result.URL = noteURLPrefix + noteID
+	return result, nil
+}
@@ -0,0 +1,75 @@
+package nostr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// relayTimeout bounds how long we wait for a relay to take an event.
+const relayTimeout = 15 * time.Second
+
+// ErrRejected is returned when a relay answers an event with OK false.
+var ErrRejected = errors.New("event rejected by relay")
+
+// PublishToRelay sends the event to the relay at relayURL (wss://...) and waits for it to confirm, as NIP-20 says,
+// that it took it.
+func (c *Client) PublishToRelay(ctx context.Context, relayURL string, event *Event) error {
+	ctx, cancel := context.WithTimeout(ctx, relayTimeout)
+	defer cancel()
+
+	config, err := websocket.NewConfig(relayURL, "https://github.com/perrito666/chat2world")
+	if err != nil {
+		return fmt.Errorf("configuring connection to %s: %w", relayURL, err)
+	}
+	ws, err := config.DialContext(ctx)
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %w", relayURL, err)
+	}
+	defer ws.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = ws.SetDeadline(deadline)
+	}
+
+	if err := websocket.JSON.Send(ws, []any{"EVENT", event}); err != nil {
+		return fmt.Errorf("sending event to %s: %w", relayURL, err)
+	}
+	for {
+		var msg []json.RawMessage
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			return fmt.Errorf("waiting for %s to confirm: %w", relayURL, err)
+		}
+		var label string
+		if len(msg) == 0 || json.Unmarshal(msg[0], &label) != nil {
+			continue
+		}
+		switch label {
+		case "OK":
+			var id, reason string
+			var accepted bool
+			if len(msg) < 3 || json.Unmarshal(msg[1], &id) != nil || json.Unmarshal(msg[2], &accepted) != nil {
+				return fmt.Errorf("malformed OK from %s", relayURL)
+			}
+			if id != event.ID {
+				continue
+			}
+			if len(msg) > 3 {
+				_ = json.Unmarshal(msg[3], &reason)
+			}
+			if !accepted {
+				return fmt.Errorf("%s: %s: %w", relayURL, reason, ErrRejected)
+			}
+			return nil
+		case "NOTICE":
+			var notice string
+			if len(msg) > 1 && json.Unmarshal(msg[1], &notice) == nil {
+				// relays tell us things this way, i.e. that we are rate limited, we keep waiting for the OK.
+				c.logger.Info("nostr relay notice", "relay", relayURL, "notice", notice)
+			}
+		}
+	}
+}
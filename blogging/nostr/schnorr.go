@@ -0,0 +1,63 @@
+package nostr
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+// nostr signs with BIP-340 Schnorr signatures over secp256k1, we leave the curve to btcec.
+
+// ErrInvalidKey is returned for private keys out of the curve's range.
+var ErrInvalidKey = errors.New("invalid private key")
+
+// privateKey validates a private key and returns it for btcec.
+func privateKey(key []byte) (*btcec.PrivateKey, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key is %d bytes long: %w", len(key), ErrInvalidKey)
+	}
+	var scalar btcec.ModNScalar
+	if overflow := scalar.SetByteSlice(key); overflow || scalar.IsZero() {
+		return nil, ErrInvalidKey
+	}
+	return btcec.PrivKeyFromScalar(&scalar), nil
+}
+
+// PublicKey returns the x-only public key of privateKey.
+func PublicKey(privateKeyBytes []byte) ([]byte, error) {
+	key, err := privateKey(privateKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+	return schnorr.SerializePubKey(key.PubKey()), nil
+}
+
+// signSchnorr signs the 32 bytes msg with privateKey as BIP-340 says, aux is the auxiliary randomness.
+func signSchnorr(privateKeyBytes, msg, aux []byte) ([]byte, error) {
+	key, err := privateKey(privateKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+	var auxData [32]byte
+	copy(auxData[:], aux)
+	sig, err := schnorr.Sign(key, msg, schnorr.CustomNonce(auxData))
+	if err != nil {
+		return nil, err
+	}
+	return sig.Serialize(), nil
+}
+
+// verifySchnorr checks a BIP-340 signature of msg by the x-only publicKey.
+func verifySchnorr(publicKey, msg, sig []byte) bool {
+	pub, err := schnorr.ParsePubKey(publicKey)
+	if err != nil {
+		return false
+	}
+	signature, err := schnorr.ParseSignature(sig)
+	if err != nil {
+		return false
+	}
+	return signature.Verify(msg, pub)
+}
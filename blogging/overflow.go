@@ -0,0 +1,118 @@
+package blogging
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/perrito666/chat2world/config"
+)
+
+// OverflowPolicy is what the posting flow does with posts too long for a platform.
+type OverflowPolicy string
+
+const (
+	// OverflowThread sends the post as a thread, the default.
+	OverflowThread OverflowPolicy = "thread"
+	// OverflowTruncate cuts the post short, with an ellipsis, see TruncateFor.
+	OverflowTruncate OverflowPolicy = "truncate"
+	// OverflowReject does not send the post to the platforms it is too long for.
+	OverflowReject OverflowPolicy = "reject"
+)
+
+// ParseOverflowPolicy returns the OverflowPolicy named s.
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch policy := OverflowPolicy(s); policy {
+	case OverflowThread, OverflowTruncate, OverflowReject:
+		return policy, nil
+	}
+	return "", fmt.Errorf("%q, want %s, %s or %s: %w", s, OverflowThread, OverflowTruncate, OverflowReject,
+		ErrUnknownOverflowPolicy)
+}
+
+// WithOverflowPolicy sets what is done with posts too long for a platform, it defaults to OverflowThread.
+func WithOverflowPolicy(policy OverflowPolicy) PostingFlowOption {
+	return func(p *PostingFlow) {
+		p.overflow = policy
+	}
+}
+
+// ellipsis marks where a truncated text was cut.
+const ellipsis = "…"
+
+// TruncateFor returns the post cut short, ending with an ellipsis, so it fits in a single post of platform, measured
+// as RemainingChars does, or the post itself if it already fits or platform has no limit. If link is not empty (i.e.
+// where the full text can be read) it goes after the ellipsis. The text is cut between words where possible, never
+// inside a character or a URL.
+func (b *MicroblogPost) TruncateFor(platform config.AvailableBloggingPlatform, link string) *MicroblogPost {
+	if remaining, ok := b.RemainingChars(platform); !ok || remaining >= 0 {
+		return b
+	}
+	suffix := ellipsis
+	if link != "" {
+		suffix += "\n\n" + link
+	}
+	truncated := *b
+	// the text of the segments is cut too, edits no longer apply.
+	truncated.Segments = nil
+	fits := func(cut int) bool {
+		truncated.Text = strings.TrimRight(b.Text[:cut], " \t\n") + suffix
+		remaining, _ := truncated.RemainingChars(platform)
+		return remaining >= 0
+	}
+
+	cuts := truncationCuts(b.Text)
+	// cuts are sorted and longer texts do not fit better, the longest one that fits is searched for.
+	lo, hi := 0, len(cuts)-1
+	best := -1
+	for lo <= hi {
+		mid := (lo + hi) / 2
+		if fits(cuts[mid]) {
+			best, lo = mid, mid+1
+		} else {
+			hi = mid - 1
+		}
+	}
+	// rendering (i.e. markdown cut in half) can make a shorter text longer, the cut found is checked to be sure.
+	for ; best >= 0 && !fits(cuts[best]); best-- {
+	}
+	cut := 0
+	if best >= 0 {
+		cut = cuts[best]
+	}
+	// a word cut in half goes whole, if it is not the only one.
+	if midWord := cut < len(b.Text) && !unicode.IsSpace(rune(b.Text[cut])); midWord {
+		if space := strings.LastIndexFunc(b.Text[:cut], unicode.IsSpace); space > 0 && fits(space) {
+			cut = space
+		}
+	}
+	fits(cut)
+	return &truncated
+}
+
+// truncationCuts returns, in order, the byte offsets text can be cut at: every character boundary not inside a URL.
+func truncationCuts(text string) []int {
+	urls := urlRegex.FindAllStringIndex(text, -1)
+	var cuts []int
+	for i := 0; i <= len(text); {
+		inURL := false
+		for _, url := range urls {
+			if i > url[0] && i < url[1] {
+				inURL = true
+				i = url[1]
+				break
+			}
+		}
+		if inURL {
+			continue
+		}
+		cuts = append(cuts, i)
+		if i == len(text) {
+			break
+		}
+		_, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+	}
+	return cuts
+}
@@ -0,0 +1,96 @@
+package blogging
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/perrito666/chat2world/config"
+)
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for _, policy := range []OverflowPolicy{OverflowThread, OverflowTruncate, OverflowReject} {
+		if got, err := ParseOverflowPolicy(string(policy)); err != nil || got != policy {
+			t.Errorf("ParseOverflowPolicy(%q) = %q, %v, want it back", policy, got, err)
+		}
+	}
+	if _, err := ParseOverflowPolicy("ignore"); !errors.Is(err, ErrUnknownOverflowPolicy) {
+		t.Errorf("ParseOverflowPolicy(ignore) err = %v, want ErrUnknownOverflowPolicy", err)
+	}
+}
+
+func TestTruncateFor(t *testing.T) {
+	short := &MicroblogPost{Text: "fits anywhere"}
+	if got := short.TruncateFor(config.MBPMastodon, ""); got != short {
+		t.Errorf("TruncateFor() of a post that fits = %q, want the post itself", got.Text)
+	}
+
+	// the URL would be cut in half by a cut at the limit.
+	text := strings.Repeat("word ", 98) + "https://example.com/" + strings.Repeat("a", 60) + " and the end"
+	post := &MicroblogPost{Text: text}
+	truncated := post.TruncateFor(config.MBPMastodon, "https://example.com/full")
+	if remaining, _ := truncated.RemainingChars(config.MBPMastodon); remaining < 0 {
+		t.Errorf("truncated post is %d characters over, want it to fit", -remaining)
+	}
+	body, found := strings.CutSuffix(truncated.Text, ellipsis+"\n\nhttps://example.com/full")
+	if !found {
+		t.Fatalf("truncated text %q does not end in an ellipsis and the link", truncated.Text)
+	}
+	if !strings.HasPrefix(text, body) || !strings.HasSuffix(body, "word") {
+		t.Errorf("truncated text is %q, want the text cut after a whole word, before the URL", body)
+	}
+	if post.Text != text {
+		t.Error("TruncateFor() changed the post")
+	}
+}
+
+func TestOverflowPolicies(t *testing.T) {
+	text := strings.Repeat("word ", 200)
+	for _, tc := range []struct {
+		policy OverflowPolicy
+		// check checks what mastodon got, if anything, and what the user was told.
+		check func(t *testing.T, posted []*MicroblogPost, told string)
+	}{
+		{OverflowThread, func(t *testing.T, posted []*MicroblogPost, _ string) {
+			if len(posted) != 1 || posted[0].Text != strings.TrimSpace(text) {
+				t.Errorf("posted %d posts, want the whole text, to be threaded", len(posted))
+			}
+		}},
+		{OverflowTruncate, func(t *testing.T, posted []*MicroblogPost, told string) {
+			if len(posted) != 1 {
+				t.Fatalf("posted %d posts, want 1", len(posted))
+			}
+			// the full text is in hugo, posted first.
+			if !strings.HasSuffix(posted[0].Text, ellipsis+"\n\nhttps://example.com/1") {
+				t.Errorf("posted %q, want it truncated linking to the full text", posted[0].Text)
+			}
+			if !strings.Contains(told, "Warning: the text was truncated to fit") {
+				t.Errorf("told %q, want a warning about the truncation", told)
+			}
+		}},
+		{OverflowReject, func(t *testing.T, posted []*MicroblogPost, told string) {
+			if len(posted) != 0 {
+				t.Errorf("posted %d posts, want it refused", len(posted))
+			}
+			if !strings.Contains(told, "Post Not sent to mastodon: 499 characters over: text too long") {
+				t.Errorf("told %q, want it to say the post was too long", told)
+			}
+		}},
+	} {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			mastodon, hugo := &fakePlatform{}, &fakePlatform{}
+			p := NewPostingFlow(map[config.AvailableBloggingPlatform]AuthedPlatform{
+				config.MBPMastodon: mastodon,
+				config.BPHugo:      hugo,
+			}, nil, WithOverflowPolicy(tc.policy), WithLogger(discardLogger))
+			messenger := &recordingMessenger{}
+			say(t, p, messenger, "/new")
+			say(t, p, messenger, text)
+			say(t, p, messenger, "/send")
+			if len(hugo.posted()) != 1 || hugo.posted()[0].Text != strings.TrimSpace(text) {
+				t.Errorf("hugo got %d posts, want the whole text", len(hugo.posted()))
+			}
+			tc.check(t, mastodon.posted(), messenger.all())
+		})
+	}
+}
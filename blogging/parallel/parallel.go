@@ -0,0 +1,61 @@
+// Package parallel runs the independent steps of publishing a post, like uploading its images, concurrently while
+// keeping their results in order.
+package parallel
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultWorkers is how many uploads platforms run at a time, posts carry at most four images so this sends them all
+// at once without flooding the servers.
+const DefaultWorkers = 4
+
+// Map calls fn for every item, with at most workers calls running at a time, and returns the results in the order of
+// items. When a call fails the context of the others is canceled, no new ones start and the first error is returned.
+func Map[T, R any](ctx context.Context, items []T, workers int, fn func(ctx context.Context, i int, item T) (R, error)) ([]R, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]R, len(items))
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+		// notStarted is why some items never started, if they did not.
+		notStarted error
+	)
+	sem := make(chan struct{}, max(workers, 1))
+	for i, item := range items {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if notStarted = ctx.Err(); notStarted != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			result, err := fn(ctx, i, item)
+			if err != nil {
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	// the parent context was canceled before every item started.
+	if notStarted != nil {
+		return nil, notStarted
+	}
+	return results, nil
+}
@@ -0,0 +1,77 @@
+package parallel
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMapKeepsTheOrder(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5, 6}
+	var running, most atomic.Int32
+	got, err := Map(context.Background(), items, 3, func(_ context.Context, i, item int) (int, error) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for old := most.Load(); n > old && !most.CompareAndSwap(old, n); old = most.Load() {
+		}
+		// the first items finish last.
+		time.Sleep(time.Duration(len(items)-i) * time.Millisecond)
+		return item * 10, nil
+	})
+	if err != nil {
+		t.Fatalf("Map: %v", err)
+	}
+	if want := []int{10, 20, 30, 40, 50, 60}; !slices.Equal(got, want) {
+		t.Errorf("Map() = %v, want %v", got, want)
+	}
+	if n := most.Load(); n > 3 {
+		t.Errorf("%d calls ran at a time, want at most 3", n)
+	}
+}
+
+func TestMapFailureCancelsTheRest(t *testing.T) {
+	errUpload := errors.New("upload failed")
+	var canceled, started atomic.Int32
+	_, err := Map(context.Background(), []string{"a", "b", "c", "d", "e"}, 3,
+		func(ctx context.Context, i int, item string) (string, error) {
+			started.Add(1)
+			if item == "b" {
+				return "", errUpload
+			}
+			select {
+			case <-ctx.Done():
+				canceled.Add(1)
+				return "", ctx.Err()
+			case <-time.After(5 * time.Second):
+				return item, nil
+			}
+		})
+	if !errors.Is(err, errUpload) {
+		t.Errorf("Map() err = %v, want the first failure", err)
+	}
+	if canceled.Load() != started.Load()-1 {
+		t.Errorf("%d of the %d other calls were canceled, want all", canceled.Load(), started.Load()-1)
+	}
+	if n := started.Load(); n == 5 {
+		t.Error("every item started, want the ones after the failure left out")
+	}
+}
+
+func TestMapCanceledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var calls atomic.Int32
+	_, err := Map(ctx, []int{1, 2}, 1, func(context.Context, int, int) (int, error) {
+		calls.Add(1)
+		return 0, nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Map() err = %v, want context.Canceled", err)
+	}
+	if n := calls.Load(); n != 0 {
+		t.Errorf("%d calls made, want none", n)
+	}
+}
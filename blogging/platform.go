@@ -1,13 +1,145 @@
 package blogging
 
-import "context"
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"slices"
+	"time"
+
+	"github.com/perrito666/chat2world/config"
+	"github.com/perrito666/chat2world/metrics"
+)
 
 type Platform interface {
-	Post(ctx context.Context, userID UserID, post *MicroblogPost) (string, error)
+	Post(ctx context.Context, userID UserID, post *MicroblogPost) (*PostResult, error)
 	Config(userID UserID) (ClientConfig, error)
 }
 
+// PostResult describes a post as published by a Platform.
+type PostResult struct {
+	// URL is where the post can be seen, it is also what Deleter takes.
+	URL string
+	// ID is the platform's identifier of the post, the first one if it was sent as a thread.
+	ID       string
+	PostedAt time.Time
+	// Parts is how many posts it took, more than one means it was sent as a thread.
+	Parts int
+	// Warnings are things the user should know about how the post went out, i.e. images that had to be re-encoded.
+	Warnings []string
+}
+
+// PostURL posts through platform and returns just the URL of the post, for callers that care for nothing else.
+func PostURL(ctx context.Context, platform Platform, userID UserID, post *MicroblogPost) (string, error) {
+	result, err := platform.Post(ctx, userID, post)
+	if err != nil {
+		return "", err
+	}
+	return result.URL, nil
+}
+
+// sendIDCtx is the context key under which the ID of a send travels to platforms.
+type sendIDCtx struct{}
+
+// WithSendID returns a context telling platforms that whatever they post with it belongs to the send id, a send is one
+// attempt of the user at posting, retries included, sending again (i.e. after /undo) is another.
+func WithSendID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, sendIDCtx{}, id)
+}
+
+// SendID returns the ID of the send ctx belongs to, empty if none was given.
+func SendID(ctx context.Context) string {
+	id, _ := ctx.Value(sendIDCtx{}).(string)
+	return id
+}
+
+// withNewSendID returns a context for a new send, see WithSendID.
+func withNewSendID(ctx context.Context) context.Context {
+	raw := make([]byte, 16)
+	// crypto/rand never fails on the platforms we run on, an empty ID still works as long as the post changes.
+	_, _ = rand.Read(raw)
+	return WithSendID(ctx, hex.EncodeToString(raw))
+}
+
+// postMeasured posts, rendered for it (see RenderFor), through platform, named pname, recording in recorder whether it
+// worked and how long it took.
+func postMeasured(ctx context.Context, recorder metrics.Recorder, pname config.AvailableBloggingPlatform,
+	platform Platform, userID UserID, post *MicroblogPost) (*PostResult, error) {
+	start := time.Now()
+	result, err := platform.Post(ctx, userID, post.renderedFor(pname))
+	platformLabel := metrics.Label{Name: "platform", Value: string(pname)}
+	recorder.Observe(metrics.PostDurationSeconds, time.Since(start).Seconds(), platformLabel)
+	outcome := metrics.ResultSuccess
+	if err != nil {
+		outcome = metrics.ResultFailure
+	}
+	recorder.Count(metrics.PostsTotal, platformLabel, metrics.Label{Name: "result", Value: outcome})
+	return result, err
+}
+
 type AuthedPlatform interface {
 	Platform
 	Authorizer
 }
+
+// sortedNames returns the names of platforms in a stable order to show them to the user.
+func sortedNames(platforms map[config.AvailableBloggingPlatform]AuthedPlatform) []config.AvailableBloggingPlatform {
+	names := make([]config.AvailableBloggingPlatform, 0, len(platforms))
+	for pname := range platforms {
+		names = append(names, pname)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Fetcher is implemented by platforms that can retrieve an already published post given its public URL, the
+// returned MicroblogPost carries the text and images (with their alt text) so it can be posted elsewhere.
+type Fetcher interface {
+	Fetch(ctx context.Context, userID UserID, postURL string) (*MicroblogPost, error)
+}
+
+// Deleter is implemented by platforms that can delete a post they published, given the URL Post returned for it. If
+// the post was sent as a thread the whole thread is deleted.
+type Deleter interface {
+	Delete(ctx context.Context, userID UserID, postURL string) error
+}
+
+// Forgetter is implemented by platforms that can drop what they store to post as a user (tokens, passwords, keys),
+// the user is then no longer authorized and has to authorize again to post.
+type Forgetter interface {
+	Forget(userID UserID) error
+}
+
+// SessionExpirer is implemented by platforms whose authorization is a session that expires, SessionExpiry returns
+// when the current one does, without asking the platform.
+type SessionExpirer interface {
+	SessionExpiry(userID UserID) (time.Time, error)
+}
+
+// ContextAuthorizer is implemented by platforms whose IsAuthorized might log in over the network,
+// IsAuthorizedContext does the same but gives up once ctx is done.
+type ContextAuthorizer interface {
+	IsAuthorizedContext(ctx context.Context, userID UserID) bool
+}
+
+// isAuthorized tells if userID is authorized in platform, honoring ctx if the platform is a ContextAuthorizer.
+func isAuthorized(ctx context.Context, platform AuthedPlatform, userID UserID) bool {
+	if authorizer, ok := platform.(ContextAuthorizer); ok {
+		return authorizer.IsAuthorizedContext(ctx, userID)
+	}
+	return platform.IsAuthorized(userID)
+}
+
+// NativeScheduler is implemented by platforms that can hold a post server side and publish it at a given time.
+// PostAt returns the platform's ID for the scheduled post, as there is no URL until it is published, which is what
+// CancelScheduled takes. PostAt returns ErrCannotScheduleNatively for posts the platform can't schedule.
+type NativeScheduler interface {
+	PostAt(ctx context.Context, userID UserID, post *MicroblogPost, at time.Time) (string, error)
+	CancelScheduled(ctx context.Context, userID UserID, scheduledID string) error
+}
+
+// Previewer is implemented by platforms that can show exactly what they would send for a post without sending it, it
+// must not publish nor upload anything.
+type Previewer interface {
+	Preview(ctx context.Context, userID UserID, post *MicroblogPost) (string, error)
+}
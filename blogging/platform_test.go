@@ -0,0 +1,67 @@
+package blogging
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/perrito666/chat2world/config"
+	"github.com/perrito666/chat2world/metrics"
+)
+
+// fakeRecorder is a metrics.Recorder keeping what it is given, rendering each metric as name{labels}.
+type fakeRecorder struct {
+	mu       sync.Mutex
+	counts   []string
+	observed []string
+}
+
+// series renders name and labels as name{label=value,...}.
+func series(name string, labels []metrics.Label) string {
+	pairs := make([]string, len(labels))
+	for i, label := range labels {
+		pairs[i] = label.Name + "=" + label.Value
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (r *fakeRecorder) Count(name string, labels ...metrics.Label) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counts = append(r.counts, series(name, labels))
+}
+
+func (r *fakeRecorder) Observe(name string, _ float64, labels ...metrics.Label) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.observed = append(r.observed, series(name, labels))
+}
+
+func TestPostsAreMeasured(t *testing.T) {
+	recorder := &fakeRecorder{}
+	poster := NewPoster(map[config.AvailableBloggingPlatform]AuthedPlatform{
+		config.MBPMastodon: &fakePlatform{err: errors.New("instance down")},
+		config.MBPBsky:     &fakePlatform{},
+	}, WithPosterMetrics(recorder), WithPosterLogger(discardLogger))
+	if _, err := poster.Post(context.Background(), 1, &MicroblogPost{Text: "hello"}); err == nil {
+		t.Fatal("Post succeeded, want the mastodon failure")
+	}
+
+	wantCounts := []string{
+		"chat2world_posts_total{platform=bluesky,result=success}",
+		"chat2world_posts_total{platform=mastodon,result=failure}",
+	}
+	if !slices.Equal(recorder.counts, wantCounts) {
+		t.Errorf("counted %q, want %q", recorder.counts, wantCounts)
+	}
+	wantObserved := []string{
+		"chat2world_post_duration_seconds{platform=bluesky}",
+		"chat2world_post_duration_seconds{platform=mastodon}",
+	}
+	if !slices.Equal(recorder.observed, wantObserved) {
+		t.Errorf("observed %q, want %q", recorder.observed, wantObserved)
+	}
+}
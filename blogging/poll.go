@@ -0,0 +1,149 @@
+package blogging
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/perrito666/chat2world/im"
+)
+
+// Poll limits, the defaults of mastodon instances which are, for now, the only ones taking polls.
+const (
+	MinPollOptions      = 2
+	MaxPollOptions      = 4
+	MaxPollOptionLength = 50
+	MinPollDuration     = 5 * time.Minute
+	MaxPollDuration     = 30 * 24 * time.Hour
+	// DefaultPollDuration is how long polls created without expires= are open.
+	DefaultPollDuration = 24 * time.Hour
+)
+
+// Poll is a question attached to a post, its text is the post's.
+type Poll struct {
+	Options   []string      `json:"options"`
+	ExpiresIn time.Duration `json:"expires_in"`
+	Multiple  bool          `json:"multiple,omitempty"`
+}
+
+// Validate returns ErrInvalidPoll if the poll can not be posted.
+func (poll *Poll) Validate() error {
+	if len(poll.Options) < MinPollOptions || len(poll.Options) > MaxPollOptions {
+		return fmt.Errorf("%d options, between %d and %d are needed: %w", len(poll.Options), MinPollOptions,
+			MaxPollOptions, ErrInvalidPoll)
+	}
+	for i, option := range poll.Options {
+		if option == "" || utf8.RuneCountInString(option) > MaxPollOptionLength {
+			return fmt.Errorf("option %d must have between 1 and %d characters: %w", i+1, MaxPollOptionLength,
+				ErrInvalidPoll)
+		}
+	}
+	if poll.ExpiresIn < MinPollDuration || poll.ExpiresIn > MaxPollDuration {
+		return fmt.Errorf("expires in %s, it must be between %s and %s: %w", poll.ExpiresIn, MinPollDuration,
+			MaxPollDuration, ErrInvalidPoll)
+	}
+	return nil
+}
+
+// splitQuoted splits s at spaces except within double quotes, which are removed, so "Option A" is a single argument.
+// Typographic quotes, which phones like to type, count as double quotes too.
+func splitQuoted(s string) []string {
+	var args []string
+	var current strings.Builder
+	quoted, inArg := false, false
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '“' || r == '”':
+			quoted = !quoted
+			inArg = true
+		case r == ' ' && !quoted:
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+			}
+			inArg = false
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+// parsePoll reads the arguments of /poll: the quoted options and, optionally, expires=<seconds> and multiple=true.
+func parsePoll(rest string) (*Poll, error) {
+	poll := &Poll{ExpiresIn: DefaultPollDuration}
+	for _, arg := range splitQuoted(rest) {
+		key, value, _ := strings.Cut(arg, "=")
+		switch key {
+		case "expires":
+			seconds, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("expires=%s is not a number of seconds: %w", value, ErrInvalidPoll)
+			}
+			poll.ExpiresIn = time.Duration(seconds) * time.Second
+		case "multiple":
+			multiple, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("multiple=%s is not true or false: %w", value, ErrInvalidPoll)
+			}
+			poll.Multiple = multiple
+		default:
+			poll.Options = append(poll.Options, arg)
+		}
+	}
+	if err := poll.Validate(); err != nil {
+		return nil, err
+	}
+	return poll, nil
+}
+
+// pollCommandHandler handles /poll "Option A" "Option B" [expires=<seconds>] [multiple=true], attaching a poll to the
+// active post, a bare /poll removes it.
+func (p *PostingFlow) pollCommandHandler(ctx context.Context, message *im.Message, messenger im.Messenger) error {
+	userID := message.UserID
+	rest := commandRest(message, "/poll")
+
+	var poll *Poll
+	var response string
+	if rest != "" {
+		var err error
+		poll, err = parsePoll(rest)
+		if err != nil {
+			response = fmt.Sprintf("Could not add the poll: %v\nUsage: /poll \"Option A\" \"Option B\" "+
+				"[expires=<seconds>] [multiple=true]", err)
+		}
+	}
+
+	p.postsMutex.Lock()
+	post, active := p.posts[userID]
+	switch {
+	case !active:
+		response = "No active post. Use /new to start writing a new post."
+	case response != "":
+		// the poll is not valid, the post stays as it was.
+	case poll == nil:
+		post.Poll = nil
+		response = "Poll removed."
+	default:
+		post.Poll = poll
+		response = fmt.Sprintf("Poll added with %d options, open for %s, only mastodon takes polls.",
+			len(poll.Options), poll.ExpiresIn)
+	}
+	p.postsMutex.Unlock()
+	if active {
+		p.saveDraftOrLog(userID)
+	}
+
+	err := messenger.SendMessage(ctx, message.Reply(response))
+	if err != nil {
+		return fmt.Errorf("messenger send message err: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package blogging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/perrito666/chat2world/config"
+	"github.com/perrito666/chat2world/metrics"
+)
+
+// AuthAnswerer answers, for a Poster, what a platform asks while authorizing (i.e. the app password), it is what the
+// user would type in the chat. Platforms also send messages that are not questions, like why the authorization failed,
+// whatever is answered to those is dropped.
+type AuthAnswerer func(ctx context.Context, platform config.AvailableBloggingPlatform, question string) (string, error)
+
+// Poster posts to platforms straight from Go code, without a chat, so chat2world can be used as a library. Platforms
+// are built the same way as for the chat (see Registry.Platforms) and share their stored configuration with it.
+type Poster struct {
+	platforms  map[config.AvailableBloggingPlatform]AuthedPlatform
+	answer     AuthAnswerer
+	authConfig map[config.AvailableBloggingPlatform]map[string]string
+	logger     *slog.Logger
+	metrics    metrics.Recorder
+}
+
+// PosterOption configures a Poster.
+type PosterOption func(*Poster)
+
+// WithAuthAnswerer sets what answers the questions of platforms the user is not authorized on yet, without it posting
+// to those fails with ErrNotAuthorized.
+func WithAuthAnswerer(answer AuthAnswerer) PosterOption {
+	return func(p *Poster) {
+		p.answer = answer
+	}
+}
+
+// WithAuthConfig sets the configuration platform starts its authorization with, see its Config for the keys, what is
+// given there is not asked.
+func WithAuthConfig(platform config.AvailableBloggingPlatform, cfg map[string]string) PosterOption {
+	return func(p *Poster) {
+		p.authConfig[platform] = cfg
+	}
+}
+
+// WithPosterLogger sets where the Poster logs, it defaults to slog.Default().
+func WithPosterLogger(logger *slog.Logger) PosterOption {
+	return func(p *Poster) {
+		p.logger = logger
+	}
+}
+
+// WithPosterMetrics makes the Poster record how posting to each platform goes in recorder, nothing is recorded by
+// default.
+func WithPosterMetrics(recorder metrics.Recorder) PosterOption {
+	return func(p *Poster) {
+		p.metrics = recorder
+	}
+}
+
+// NewPoster creates a Poster for platforms.
+func NewPoster(platforms map[config.AvailableBloggingPlatform]AuthedPlatform, opts ...PosterOption) *Poster {
+	p := &Poster{
+		platforms:  platforms,
+		authConfig: make(map[config.AvailableBloggingPlatform]map[string]string),
+		logger:     slog.Default(),
+		metrics:    metrics.Nop{},
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Post sends post to the given platforms, or to all of them if none is given, authorizing the user first where
+// needed. The results are in the order of the platforms (alphabetical when none is given), the ones that failed are
+// left empty and their errors returned joined. Each call is a new send, to retry one pass its ID (see WithSendID).
+func (p *Poster) Post(ctx context.Context, userID UserID, post *MicroblogPost,
+	platforms ...config.AvailableBloggingPlatform) ([]PostResult, error) {
+	if len(platforms) == 0 {
+		for pname := range p.platforms {
+			platforms = append(platforms, pname)
+		}
+		slices.Sort(platforms)
+	}
+	// callers retrying a send give its ID themselves, see WithSendID.
+	if SendID(ctx) == "" {
+		ctx = withNewSendID(ctx)
+	}
+	results := make([]PostResult, len(platforms))
+	var postErrs []error
+	for i, pname := range platforms {
+		platform, ok := p.platforms[pname]
+		if !ok {
+			postErrs = append(postErrs, fmt.Errorf("%s: %w", pname, ErrClientNotFound))
+			continue
+		}
+		if err := p.authorize(ctx, userID, pname, platform); err != nil {
+			postErrs = append(postErrs, fmt.Errorf("%s: %w", pname, err))
+			continue
+		}
+		result, err := postMeasured(ctx, p.metrics, pname, platform, userID, post)
+		if err != nil {
+			p.logger.Error("posting failed", "user_id", userID, "platform", pname, "err", err)
+			postErrs = append(postErrs, fmt.Errorf("posting to %s: %w", pname, err))
+			continue
+		}
+		results[i] = *result
+	}
+	return results, errors.Join(postErrs...)
+}
+
+// authorize runs the authorization of platform for userID, answering it through the AuthAnswerer, unless the user is
+// already authorized.
+func (p *Poster) authorize(ctx context.Context, userID UserID, pname config.AvailableBloggingPlatform,
+	platform AuthedPlatform) error {
+	if platform.IsAuthorized(userID) {
+		return nil
+	}
+	if p.answer == nil {
+		return ErrNotAuthorized
+	}
+	// the authorization is abandoned, and its goroutine ended, if we can't answer it.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	comms, err := platform.StartAuthorization(ctx, userID, p.authConfig[pname])
+	if err != nil {
+		return fmt.Errorf("starting authorization: %w", err)
+	}
+	for {
+		var question string
+		var ok bool
+		select {
+		case question, ok = <-comms:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if !ok {
+			if !platform.IsAuthorized(userID) {
+				return ErrNotAuthorized
+			}
+			return nil
+		}
+		answer, err := p.answer(ctx, pname, question)
+		if err != nil {
+			return fmt.Errorf("answering %q: %w", question, err)
+		}
+		p.sendAuthAnswer(ctx, comms, answer)
+	}
+}
+
+// sendAuthAnswer sends answer through comms. Authorizers close comms when they are done and there is no telling if
+// their last message was a question, so answering a finished authorization is not an error.
+func (p *Poster) sendAuthAnswer(ctx context.Context, comms chan string, answer string) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.logger.Debug("authorization finished before taking the answer", "recovered", r)
+		}
+	}()
+	select {
+	case comms <- answer:
+	case <-ctx.Done():
+	}
+}
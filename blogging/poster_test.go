@@ -0,0 +1,120 @@
+package blogging
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/perrito666/chat2world/config"
+)
+
+// discardLogger drops everything logged to it.
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// passwordPlatform is a fakePlatform the user must authorize by answering its question with the password.
+type passwordPlatform struct {
+	fakePlatform
+	password   string
+	authorized bool
+	authMu     sync.Mutex
+}
+
+func (f *passwordPlatform) IsAuthorized(UserID) bool {
+	f.authMu.Lock()
+	defer f.authMu.Unlock()
+	return f.authorized
+}
+
+func (f *passwordPlatform) StartAuthorization(ctx context.Context, _ UserID, _ map[string]string) (chan string, error) {
+	comms := make(chan string)
+	go func() {
+		defer close(comms)
+		select {
+		case comms <- "What is the password?":
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case answer := <-comms:
+			f.authMu.Lock()
+			f.authorized = answer == f.password
+			f.authMu.Unlock()
+		case <-ctx.Done():
+		}
+	}()
+	return comms, nil
+}
+
+func TestPosterPostsWithoutAChat(t *testing.T) {
+	mastodon := &fakePlatform{result: &PostResult{URL: "https://example.com/@me/1", Parts: 1}}
+	bsky := &fakePlatform{result: &PostResult{URL: "https://bsky.app/profile/me/post/1", Parts: 1}}
+	poster := NewPoster(map[config.AvailableBloggingPlatform]AuthedPlatform{
+		config.MBPMastodon: mastodon,
+		config.MBPBsky:     bsky,
+	})
+	results, err := poster.Post(context.Background(), 1, &MicroblogPost{Text: "from a script"})
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	// with no platforms given it posts to all of them, in alphabetical order.
+	if len(results) != 2 || results[0].URL != "https://bsky.app/profile/me/post/1" ||
+		results[1].URL != "https://example.com/@me/1" {
+		t.Errorf("results = %+v, want those of bluesky and mastodon", results)
+	}
+	if len(mastodon.posted()) != 1 || mastodon.posted()[0].Text != "from a script" || len(bsky.posted()) != 1 {
+		t.Errorf("posted %d to mastodon and %d to bluesky, want the post in each", len(mastodon.posted()),
+			len(bsky.posted()))
+	}
+
+	if _, err := poster.Post(context.Background(), 1, &MicroblogPost{Text: "only here"}, config.MBPBsky); err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	if len(mastodon.posted()) != 1 || len(bsky.posted()) != 2 {
+		t.Error("posting to bluesky alone posted to mastodon too")
+	}
+}
+
+func TestPosterReportsEachFailure(t *testing.T) {
+	errDown := errors.New("instance down")
+	bsky := &fakePlatform{}
+	poster := NewPoster(map[config.AvailableBloggingPlatform]AuthedPlatform{
+		config.MBPMastodon: &fakePlatform{err: errDown},
+		config.MBPBsky:     bsky,
+	}, WithPosterLogger(discardLogger))
+	results, err := poster.Post(context.Background(), 1, &MicroblogPost{Text: "hello"}, config.MBPMastodon,
+		config.MBPBsky, config.MBPNostr)
+	if !errors.Is(err, errDown) || !errors.Is(err, ErrClientNotFound) {
+		t.Errorf("Post() err = %v, want the mastodon failure and nostr not found", err)
+	}
+	if len(results) != 3 || results[0].URL != "" || results[1].URL == "" || results[2].URL != "" {
+		t.Errorf("results = %+v, want only the one of bluesky", results)
+	}
+}
+
+func TestPosterAuthorizesThroughTheAnswerer(t *testing.T) {
+	platform := &passwordPlatform{password: "hunter2"}
+	platforms := map[config.AvailableBloggingPlatform]AuthedPlatform{config.MBPBsky: platform}
+	post := &MicroblogPost{Text: "hello"}
+
+	if _, err := NewPoster(platforms).Post(context.Background(), 1, post); !errors.Is(err, ErrNotAuthorized) {
+		t.Errorf("Post() without an answerer err = %v, want ErrNotAuthorized", err)
+	}
+
+	var asked []string
+	answer := func(_ context.Context, _ config.AvailableBloggingPlatform, question string) (string, error) {
+		asked = append(asked, question)
+		return "hunter2", nil
+	}
+	if _, err := NewPoster(platforms, WithAuthAnswerer(answer)).Post(context.Background(), 1, post); err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	if len(asked) != 1 || asked[0] != "What is the password?" {
+		t.Errorf("asked %q, want the question of the platform", asked)
+	}
+	if len(platform.posted()) != 1 {
+		t.Errorf("posted %d times, want once authorized", len(platform.posted()))
+	}
+}
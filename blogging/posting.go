@@ -2,14 +2,24 @@ package blogging
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/perrito666/chat2world/config"
 	"github.com/perrito666/chat2world/im"
+	"github.com/perrito666/chat2world/metrics"
+	"github.com/perrito666/chat2world/secrets"
 )
 
 // PostingFlow is a struct that represents the flow of posting a message to one or several blogging platforms
@@ -18,6 +28,155 @@ type PostingFlow struct {
 	posts      map[uint64]*MicroblogPost
 	// I'll mix authed and non authed platforms here for now, I would expect user to auth
 	platforms map[config.AvailableBloggingPlatform]AuthedPlatform
+	// order holds the names of the platforms in the order they are posted to and listed in replies.
+	order []config.AvailableBloggingPlatform
+	// priority is the order asked with WithPlatformOrder, order is built from it.
+	priority []config.AvailableBloggingPlatform
+	// store is where drafts are persisted so they survive restarts, it can be nil.
+	store *secrets.EncryptedStore
+	// sent remembers, per user, where the last post went so /undo can delete it.
+	sent map[uint64]*sentPost
+	now  func() time.Time
+
+	// settings are guarded by postsMutex, see userSettings.
+	settings map[uint64]*UserSettings
+	// selections are the platforms picked, per user, to /send to, guarded by postsMutex.
+	selections map[uint64]map[config.AvailableBloggingPlatform]bool
+	// editing holds the users whose next text message replaces the text of their post, see /edit, guarded by
+	// postsMutex.
+	editing map[uint64]bool
+
+	scheduledMutex  sync.Mutex
+	scheduled       map[uint64][]*ScheduledPost
+	scheduleChanged chan struct{}
+	// location is used for scheduled times given without offset.
+	location *time.Location
+	// overflow is what is done with posts too long for a platform.
+	overflow OverflowPolicy
+	// altTexts describes the images sent without caption, it can be nil.
+	altTexts AltTextProvider
+	// postHook is told about every post sent, it can be nil.
+	postHook PostHook
+	logger   *slog.Logger
+	metrics  metrics.Recorder
+}
+
+// PostingFlowOption configures optional settings of a PostingFlow.
+type PostingFlowOption func(*PostingFlow)
+
+// WithDefaultLocation sets the time zone for /schedule times given without an offset, it defaults to the local one.
+func WithDefaultLocation(loc *time.Location) PostingFlowOption {
+	return func(p *PostingFlow) {
+		p.location = loc
+	}
+}
+
+// WithPlatformOrder sets the order platforms are posted to, and listed in replies, in: the ones in order first, as
+// given, then the rest alphabetically, which is the default.
+func WithPlatformOrder(order ...config.AvailableBloggingPlatform) PostingFlowOption {
+	return func(p *PostingFlow) {
+		p.priority = order
+	}
+}
+
+// PostHook is told about each platform a post was sent to, with what the platform answered, i.e. to mirror posts to a
+// log service or trigger automation. It is called from the flow, before the user is told, so it should return
+// quickly.
+type PostHook func(ctx context.Context, userID UserID, platform config.AvailableBloggingPlatform, result *PostResult)
+
+// WithPostHook makes the flow call hook after each platform a post is sent to, failed ones are not told.
+func WithPostHook(hook PostHook) PostingFlowOption {
+	return func(p *PostingFlow) {
+		p.postHook = hook
+	}
+}
+
+// WithLogger sets where the flow logs, it defaults to slog.Default().
+func WithLogger(logger *slog.Logger) PostingFlowOption {
+	return func(p *PostingFlow) {
+		p.logger = logger
+	}
+}
+
+// WithMetrics makes the flow record how posting to each platform goes in recorder, nothing is recorded by default.
+func WithMetrics(recorder metrics.Recorder) PostingFlowOption {
+	return func(p *PostingFlow) {
+		p.metrics = recorder
+	}
+}
+
+// undoWindow is how long after sending a post it can be deleted with /undo.
+const undoWindow = 5 * time.Minute
+
+// sentPost records when a post was sent and the URL it got on each platform.
+type sentPost struct {
+	at   time.Time
+	urls map[config.AvailableBloggingPlatform]string
+}
+
+// draftPath returns the name of the file holding the draft of a user.
+func draftPath(userID uint64) string {
+	return fmt.Sprintf("%d.draft.json", userID)
+}
+
+// SaveDraft persists the active post of the user in the store, if the user has no active post any persisted draft
+// is removed.
+func (p *PostingFlow) SaveDraft(userID uint64) error {
+	if p.store == nil {
+		return nil
+	}
+	p.postsMutex.Lock()
+	defer p.postsMutex.Unlock()
+
+	post, active := p.posts[userID]
+	if !active {
+		return p.store.Remove(draftPath(userID))
+	}
+	f, err := p.store.OpenWriter(draftPath(userID))
+	if err != nil {
+		return fmt.Errorf("opening draft to write: %w", err)
+	}
+	err = json.NewEncoder(f).Encode(post)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("writing draft: %w", err)
+	}
+	return nil
+}
+
+// LoadDrafts restores the persisted drafts, if any, of the given users as their active posts.
+func (p *PostingFlow) LoadDrafts(userIDs ...uint64) error {
+	if p.store == nil {
+		return nil
+	}
+	for _, userID := range userIDs {
+		f, err := p.store.OpenReader(draftPath(userID))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return fmt.Errorf("opening draft to read: %w", err)
+		}
+		post := &MicroblogPost{}
+		err = json.NewDecoder(f).Decode(post)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("reading draft for user %d: %w", userID, err)
+		}
+		p.postsMutex.Lock()
+		p.posts[userID] = post
+		p.postsMutex.Unlock()
+	}
+	return nil
+}
+
+// saveDraftOrLog persists the draft of the user, failing to do so is not a reason to stop the flow.
+func (p *PostingFlow) saveDraftOrLog(userID uint64) {
+	if err := p.SaveDraft(userID); err != nil {
+		p.logger.Error("saving draft", "user_id", userID, "err", err)
+	}
 }
 
 // Start implements im.Flow and will start the posting flow by simply delegating to HandleMessage
@@ -35,7 +194,11 @@ func (p *PostingFlow) StartCommandParser(s string) (string, []string, error) {
 }
 
 func (p *PostingFlow) HandleMessage(ctx context.Context, message *im.Message, messenger im.Messenger) error {
-	if !message.IsCommand() {
+	if message.IsCallback() {
+		return p.callbackHandler(ctx, message, messenger)
+	}
+	// edited commands are not run again, edited text goes to defaultHandler.
+	if !message.IsCommand() || message.Edited {
 		err := p.defaultHandler(ctx, message, messenger)
 		if err != nil {
 			return fmt.Errorf("default handler: %w", err)
@@ -54,6 +217,32 @@ func (p *PostingFlow) HandleMessage(ctx context.Context, message *im.Message, me
 		return p.sendCommandHandler(ctx, message, messenger)
 	case "/cancel":
 		return p.cancelCommandHandler(ctx, message, messenger)
+	case "/cw":
+		return p.cwCommandHandler(ctx, message, messenger)
+	case "/preview":
+		return p.previewCommandHandler(ctx, message, messenger)
+	case "/alt":
+		return p.altCommandHandler(ctx, message, messenger)
+	case "/crosspost":
+		return p.crosspostCommandHandler(ctx, message, messenger)
+	case "/undo":
+		return p.undoCommandHandler(ctx, message, messenger)
+	case "/schedule":
+		return p.scheduleCommandHandler(ctx, message, messenger)
+	case "/settings":
+		return p.settingsCommandHandler(ctx, message, messenger)
+	case "/poll":
+		return p.pollCommandHandler(ctx, message, messenger)
+	case "/quote":
+		return p.quoteCommandHandler(ctx, message, messenger)
+	case "/edit":
+		return p.editCommandHandler(ctx, message, messenger)
+	case "/rmimage":
+		return p.rmimageCommandHandler(ctx, message, messenger)
+	case "/tags":
+		return p.tagsCommandHandler(ctx, message, messenger)
+	case "/template":
+		return p.templateCommandHandler(ctx, message, messenger)
 
 	}
 
@@ -86,6 +275,7 @@ func (p *PostingFlow) newCommandHandler(ctx context.Context, message *im.Message
 
 	kv, positional := argsIntoMaps(args)
 
+	// posts without languages get them from resolveLangs when sent.
 	var langs []string
 	if lang, ok := kv["langs"]; ok {
 		langs = strings.Split(lang, ",")
@@ -94,24 +284,47 @@ func (p *PostingFlow) newCommandHandler(ctx context.Context, message *im.Message
 	}
 
 	p.postsMutex.Lock()
-	defer p.postsMutex.Unlock()
+	_, exists := p.posts[userID]
+	var text string
+	templateName, fromTemplate := kv["template"]
+	knownTemplate := true
+	if fromTemplate {
+		text, knownTemplate = p.templateFor(userID, templateName)
+	}
+	if !exists && knownTemplate {
+		p.posts[userID] = &MicroblogPost{
+			Text:  text,
+			Langs: langs,
+		}
+	}
+	p.postsMutex.Unlock()
 
-	if _, exists := p.posts[userID]; exists {
+	if !knownTemplate && !exists {
+		err := messenger.SendMessage(ctx, message.Reply(fmt.Sprintf("There is no template %s, see /template.", templateName)))
+		if err != nil {
+			return fmt.Errorf("messenger send message err: %w", err)
+		}
+		return nil
+	}
+	if exists {
 		err := messenger.SendMessage(ctx, message.Reply("You already have an active post. Use /send to post it or /cancel to discard it."))
 		if err != nil {
-			log.Printf("messenger send message err: %v", err)
+			p.logger.Error("sending message", "user_id", message.UserID, "err", err)
 			return fmt.Errorf("messenger send message err: %w", err)
 		}
 		// Already have an active post, not a showstopper
 		return nil
 	}
 
-	p.posts[userID] = &MicroblogPost{
-		Langs: langs,
+	p.saveDraftOrLog(userID)
+	response := "Started a new post. Now send text or images to add content. Use /send when ready or /cancel to discard."
+	if fromTemplate {
+		response = fmt.Sprintf("Started a new post with:\n%s\nNow send text or images to add to it. Use /send when ready "+
+			"or /cancel to discard.", text)
 	}
-	err = messenger.SendMessage(ctx, message.Reply("Started a new post. Now send text or images to add content. Use /send when ready or /cancel to discard."))
+	err = messenger.SendMessage(ctx, message.Reply(response))
 	if err != nil {
-		log.Printf("messenger send message err: %v", err)
+		p.logger.Error("sending message", "user_id", message.UserID, "err", err)
 		return fmt.Errorf("messenger send message err: %w", err)
 	}
 	return nil
@@ -119,41 +332,254 @@ func (p *PostingFlow) newCommandHandler(ctx context.Context, message *im.Message
 
 // sendCommandHandler sends the message to mastodon
 func (p *PostingFlow) sendCommandHandler(ctx context.Context, message *im.Message, messenger im.Messenger) error {
-	userID := message.UserID
+	_, args, err := message.AsCommand(p.StartCommandParser)
+	if err != nil {
+		return fmt.Errorf("parsing /send message (%s): %w", message.Text, err)
+	}
+	kv, positional := argsIntoMaps(args)
+	if kv["dryrun"] == "true" || slices.Contains(positional, "--dry-run") {
+		return p.dryRun(ctx, message, messenger)
+	}
+	// better to find out before picking platforms.
+	if ok, err := p.checkSendable(ctx, message, messenger, nil); !ok {
+		return err
+	}
+	// platforms named in the command are the only ones sent to.
+	var named []config.AvailableBloggingPlatform
+	var unknown []string
+	for _, arg := range positional {
+		if arg == "all" || arg == "--dry-run" {
+			continue
+		}
+		if _, ok := p.platforms[config.AvailableBloggingPlatform(arg)]; !ok {
+			unknown = append(unknown, arg)
+			continue
+		}
+		named = append(named, config.AvailableBloggingPlatform(arg))
+	}
+	if len(unknown) > 0 {
+		err := messenger.SendMessage(ctx, message.Reply(fmt.Sprintf("Unknown platform %s, see /accounts for your platforms.",
+			strings.Join(unknown, ", "))))
+		if err != nil {
+			return fmt.Errorf("messenger send message err: %w", err)
+		}
+		return nil
+	}
+	if len(named) > 0 {
+		skip := make(map[config.AvailableBloggingPlatform]string)
+		for pname := range p.platforms {
+			if !slices.Contains(named, pname) {
+				skip[pname] = ""
+			}
+		}
+		return p.sendActive(ctx, message, messenger, skip)
+	}
+	// where we can, the user picks the platforms, unless they asked for all of them.
+	if _, ok := messenger.(im.ButtonMessenger); ok && len(p.platforms) > 1 && !slices.Contains(positional, "all") {
+		return p.askPlatforms(ctx, message, messenger)
+	}
+	return p.sendActive(ctx, message, messenger, nil)
+}
+
+// sendRefusal returns why the active post of the user can't go out to every platform but those in skip, an empty
+// string if it can or there is none. It is what every way of sending checks. The caller must hold postsMutex.
+func (p *PostingFlow) sendRefusal(userID uint64, skip map[config.AvailableBloggingPlatform]string) string {
+	if _, exists := p.posts[userID]; !exists {
+		return ""
+	}
+	if len(skip) >= len(p.platforms) {
+		return "No platform selected, your post is kept, /send it to at least one."
+	}
+	return p.missingAltText(userID)
+}
 
+// checkSendable tells the user why their active post can't go out, and returns false, if it can't (see sendRefusal).
+func (p *PostingFlow) checkSendable(ctx context.Context, message *im.Message, messenger im.Messenger,
+	skip map[config.AvailableBloggingPlatform]string) (bool, error) {
 	p.postsMutex.Lock()
+	refusal := p.sendRefusal(message.UserID, skip)
+	p.postsMutex.Unlock()
+	if refusal == "" {
+		return true, nil
+	}
+	if err := messenger.SendMessage(ctx, message.Reply(refusal)); err != nil {
+		return false, fmt.Errorf("messenger send message err: %w", err)
+	}
+	return false, nil
+}
+
+// sendActive publishes the active post of the user to every platform but those in skip.
+func (p *PostingFlow) sendActive(ctx context.Context, message *im.Message, messenger im.Messenger,
+	skip map[config.AvailableBloggingPlatform]string) error {
+	userID := message.UserID
+	p.postsMutex.Lock()
+	// checked again, the post might have changed while the user picked the platforms.
+	refusal := p.sendRefusal(userID, skip)
 	post, exists := p.posts[userID]
-	if exists {
+	if refusal == "" {
 		delete(p.posts, userID)
+		delete(p.editing, userID)
 	}
 	p.postsMutex.Unlock()
 
+	if refusal != "" {
+		if err := messenger.SendMessage(ctx, message.Reply(refusal)); err != nil {
+			return fmt.Errorf("messenger send message err: %w", err)
+		}
+		return nil
+	}
 	if !exists {
 		err := messenger.SendMessage(ctx, message.Reply("No active post to send. Use /new to start a post."))
 		if err != nil {
-			log.Printf("messenger send message err: %v", err)
+			p.logger.Error("sending message", "user_id", message.UserID, "err", err)
 			return fmt.Errorf("messenger send message err: %w", err)
 		}
 		return nil
 	}
 
-	// Here you would integrate with Mastodon.
-	log.Printf("Sending post for chat %d: %+v", userID, post)
+	p.saveDraftOrLog(userID)
+
+	return p.publish(ctx, userID, post, skip, func(text string) error {
+		return messenger.SendMessage(ctx, message.Reply(text))
+	})
+}
+
+// dryRun replies with what would be sent to each platform for the active post, which is kept as it is.
+func (p *PostingFlow) dryRun(ctx context.Context, message *im.Message, messenger im.Messenger) error {
+	p.postsMutex.Lock()
+	post, exists := p.posts[message.UserID]
+	p.postsMutex.Unlock()
+	response := "No active post to send. Use /new to start a post."
+	if exists {
+		// the draft keeps its languages unresolved, it might still change.
+		resolved := *post
+		post = &resolved
+		p.resolveLangs(message.UserID, post)
+		var sb strings.Builder
+		sb.WriteString("Dry run, nothing was sent.")
+		for _, pname := range p.orderedPlatformNames() {
+			fmt.Fprintf(&sb, "\n\n%s:\n", pname)
+			previewer, ok := p.platforms[pname].(Previewer)
+			if !ok {
+				sb.WriteString("(this platform can not show what it would send)")
+				continue
+			}
+			preview, err := previewer.Preview(ctx, UserID(message.UserID), post.renderedFor(pname))
+			if err != nil {
+				p.logger.Warn("previewing", "user_id", message.UserID, "platform", pname, "err", err)
+				fmt.Fprintf(&sb, "Would fail: %v", err)
+				continue
+			}
+			sb.WriteString(preview)
+		}
+		response = sb.String()
+	}
+	err := messenger.SendMessage(ctx, message.Reply(response))
+	if err != nil {
+		return fmt.Errorf("messenger send message err: %w", err)
+	}
+	return nil
+}
+
+// orderedPlatformNames returns the names of the platforms in the order they are posted to, see WithPlatformOrder.
+func (p *PostingFlow) orderedPlatformNames() []config.AvailableBloggingPlatform {
+	return p.order
+}
+
+// platformOrder returns the names of platforms, the ones in priority first, in that order, then the rest sorted.
+func platformOrder(platforms map[config.AvailableBloggingPlatform]AuthedPlatform,
+	priority []config.AvailableBloggingPlatform) []config.AvailableBloggingPlatform {
+	var order []config.AvailableBloggingPlatform
+	for _, pname := range priority {
+		if _, ok := platforms[pname]; ok && !slices.Contains(order, pname) {
+			order = append(order, pname)
+		}
+	}
+	for _, pname := range sortedNames(platforms) {
+		if !slices.Contains(order, pname) {
+			order = append(order, pname)
+		}
+	}
+	return order
+}
+
+// publish sends the post to every platform but those in skip, telling the user how each one went through report, and
+// how it went overall when there were several, and remembers where it went for /undo.
+func (p *PostingFlow) publish(ctx context.Context, userID uint64, post *MicroblogPost,
+	skip map[config.AvailableBloggingPlatform]string, report func(text string) error) error {
+	// only scheduled posts get here with nothing to send, the platforms scheduled them natively. Sends from the chat
+	// are refused before taking the draft (see sendRefusal).
+	if len(skip) >= len(p.platforms) {
+		return nil
+	}
+	p.resolveLangs(userID, post)
+	// every platform of this send retries under the same ID, a later send of the same post is a new post.
+	ctx = withNewSendID(ctx)
+	p.logger.Info("sending post", "user_id", userID, "text_length", len(post.Text), "images", len(post.Images),
+		"video", post.Video != nil)
 	var postErrs []error
-	for pname, platform := range p.platforms {
-		postURL, err := platform.Post(ctx, UserID(userID), post)
+	sent := &sentPost{at: p.now(), urls: make(map[config.AvailableBloggingPlatform]string)}
+	defer func() {
+		p.postsMutex.Lock()
+		p.sent[userID] = sent
+		p.postsMutex.Unlock()
+	}()
+	var attempted int
+	var failures []string
+	// fullText is where the whole post can be read, the first platform without a text limit it went to, truncated
+	// posts link to it.
+	var fullText string
+	for _, pname := range p.orderedPlatformNames() {
+		if _, ok := skip[pname]; ok {
+			continue
+		}
+		attempted++
+		fitted, err := p.fitFor(pname, post, fullText)
+		var result *PostResult
+		if err == nil {
+			result, err = postMeasured(ctx, p.metrics, pname, p.platforms[pname], UserID(userID), fitted)
+		}
 		if err != nil {
-			log.Printf("posting failed: %v", err)
-			terr := messenger.SendMessage(ctx, message.Reply(fmt.Sprintf("Post Not sent to %s: %v", pname, err)))
+			failures = append(failures, fmt.Sprintf("%s failed: %v", pname, err))
+			p.logger.Error("posting failed", "user_id", userID, "platform", pname, "err", err)
+			terr := report(fmt.Sprintf("Post Not sent to %s: %v", pname, err))
 			if terr != nil {
-				log.Printf("messenger send message err: %v", err)
+				p.logger.Error("reporting post failure", "user_id", userID, "err", terr)
 				postErrs = append(postErrs, terr)
 			}
 			continue
 		}
-		err = messenger.SendMessage(ctx, message.Reply(fmt.Sprintf("Post sent to %s (%s)", pname, postURL)))
+		sent.urls[pname] = result.URL
+		if _, limited := PlatformTextLimits[pname.Kind()]; !limited && fullText == "" {
+			fullText = result.URL
+		}
+		if fitted != post {
+			result.Warnings = append(result.Warnings, "the text was truncated to fit")
+		}
+		response := fmt.Sprintf("Post sent to %s (%s)", pname, result.URL)
+		if result.Parts > 1 {
+			response += fmt.Sprintf(" as a thread of %d posts", result.Parts)
+		}
+		for _, warning := range result.Warnings {
+			response += "\nWarning: " + warning
+		}
+		p.logger.Info("post sent", "user_id", userID, "platform", pname, "url", result.URL, "parts", result.Parts)
+		if p.postHook != nil {
+			p.postHook(ctx, UserID(userID), pname, result)
+		}
+		err = report(response)
 		if err != nil {
-			log.Printf("messenger send message err: %v", err)
+			p.logger.Error("reporting post", "user_id", userID, "err", err)
+		}
+	}
+	if attempted > 1 {
+		summary := fmt.Sprintf("Posted to %d/%d platforms", attempted-len(failures), attempted)
+		if len(failures) > 0 {
+			summary += "; " + strings.Join(failures, "; ")
+		}
+		if err := report(summary); err != nil {
+			p.logger.Error("reporting post summary", "user_id", userID, "err", err)
+			postErrs = append(postErrs, err)
 		}
 	}
 	if len(postErrs) > 0 {
@@ -162,6 +588,76 @@ func (p *PostingFlow) sendCommandHandler(ctx context.Context, message *im.Messag
 	return nil
 }
 
+// overflowOutcome says, briefly, what happens to posts too long for a platform.
+func (p *PostingFlow) overflowOutcome() string {
+	switch p.overflow {
+	case OverflowTruncate:
+		return "truncated"
+	case OverflowReject:
+		return "refused"
+	}
+	return "a thread"
+}
+
+// fitFor returns post as it goes to pname according to the overflow policy, truncated or refused with ErrTextTooLong
+// if it is too long there, link is where the full text can be read, if anywhere yet.
+func (p *PostingFlow) fitFor(pname config.AvailableBloggingPlatform, post *MicroblogPost,
+	link string) (*MicroblogPost, error) {
+	remaining, ok := post.RemainingChars(pname)
+	if !ok || remaining >= 0 {
+		return post, nil
+	}
+	switch p.overflow {
+	case OverflowTruncate:
+		return post.TruncateFor(pname, link), nil
+	case OverflowReject:
+		return nil, fmt.Errorf("%d characters over: %w", -remaining, ErrTextTooLong)
+	}
+	return post, nil
+}
+
+// undoCommandHandler deletes the last sent post from every platform that supports it, as long as it was sent less
+// than undoWindow ago.
+func (p *PostingFlow) undoCommandHandler(ctx context.Context, message *im.Message, messenger im.Messenger) error {
+	userID := message.UserID
+
+	p.postsMutex.Lock()
+	sent, ok := p.sent[userID]
+	if ok {
+		delete(p.sent, userID)
+	}
+	p.postsMutex.Unlock()
+
+	if !ok || len(sent.urls) == 0 || p.now().Sub(sent.at) > undoWindow {
+		err := messenger.SendMessage(ctx, message.Reply(fmt.Sprintf("Nothing to undo, only posts sent in the last %s can be deleted.", undoWindow)))
+		if err != nil {
+			return fmt.Errorf("messenger send message err: %w", err)
+		}
+		return nil
+	}
+
+	var lines []string
+	for pname, postURL := range sent.urls {
+		deleter, ok := p.platforms[pname].(Deleter)
+		if !ok {
+			lines = append(lines, fmt.Sprintf("%s does not support deleting, %s is still there.", pname, postURL))
+			continue
+		}
+		if err := deleter.Delete(ctx, UserID(userID), postURL); err != nil {
+			p.logger.Error("deleting post", "user_id", userID, "platform", pname, "url", postURL, "err", err)
+			lines = append(lines, fmt.Sprintf("Could not delete %s from %s: %v", postURL, pname, err))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("Post deleted from %s.", pname))
+	}
+	sort.Strings(lines)
+	err := messenger.SendMessage(ctx, message.Reply(strings.Join(lines, "\n")))
+	if err != nil {
+		return fmt.Errorf("messenger send message err: %w", err)
+	}
+	return nil
+}
+
 // cancelCommandHandler discards the pending post.
 func (p *PostingFlow) cancelCommandHandler(ctx context.Context, message *im.Message, messenger im.Messenger) error {
 	userID := message.UserID
@@ -171,7 +667,9 @@ func (p *PostingFlow) cancelCommandHandler(ctx context.Context, message *im.Mess
 	if exists {
 		delete(p.posts, userID)
 	}
+	delete(p.editing, userID)
 	p.postsMutex.Unlock()
+	p.saveDraftOrLog(userID)
 
 	var response string
 	if exists {
@@ -181,7 +679,451 @@ func (p *PostingFlow) cancelCommandHandler(ctx context.Context, message *im.Mess
 	}
 	err := messenger.SendMessage(ctx, message.Reply(response))
 	if err != nil {
-		log.Printf("messenger send message err: %v", err)
+		p.logger.Error("sending message", "user_id", message.UserID, "err", err)
+		return fmt.Errorf("messenger send message err: %w", err)
+	}
+	return nil
+}
+
+// Cancel implements im.Canceler, canceling the flow discards the active post.
+func (p *PostingFlow) Cancel(ctx context.Context, message *im.Message, messenger im.Messenger) error {
+	return p.cancelCommandHandler(ctx, message, messenger)
+}
+
+var _ im.Canceler = (*PostingFlow)(nil)
+
+// CanResume implements im.Resumer, posts being written are persisted as drafts so they survive restarts.
+func (p *PostingFlow) CanResume(userID uint64) bool {
+	p.postsMutex.Lock()
+	defer p.postsMutex.Unlock()
+	_, active := p.posts[userID]
+	return active
+}
+
+var _ im.Resumer = (*PostingFlow)(nil)
+
+// commandRest returns the text of a command message after the command itself, preserving it as typed.
+func commandRest(message *im.Message, command string) string {
+	return strings.TrimSpace(strings.TrimPrefix(message.Text, command))
+}
+
+// cwCommandHandler sets the content warning of the active post to whatever follows /cw, an empty one removes it.
+func (p *PostingFlow) cwCommandHandler(ctx context.Context, message *im.Message, messenger im.Messenger) error {
+	userID := message.UserID
+	warning := commandRest(message, "/cw")
+
+	p.postsMutex.Lock()
+	post, active := p.posts[userID]
+	if active {
+		post.ContentWarning = warning
+	}
+	p.postsMutex.Unlock()
+	if active {
+		p.saveDraftOrLog(userID)
+	}
+
+	var response string
+	switch {
+	case !active:
+		response = "No active post. Use /new to start writing a new post."
+	case warning == "":
+		response = "Content warning removed."
+	default:
+		response = fmt.Sprintf("Content warning set to: %s", warning)
+	}
+	err := messenger.SendMessage(ctx, message.Reply(response))
+	if err != nil {
+		return fmt.Errorf("messenger send message err: %w", err)
+	}
+	return nil
+}
+
+// tagsCommandHandler sets the tags of the active post to the words following /tags, a bare /tags removes them.
+func (p *PostingFlow) tagsCommandHandler(ctx context.Context, message *im.Message, messenger im.Messenger) error {
+	userID := message.UserID
+	tags, invalid := ParseTags(commandRest(message, "/tags"))
+
+	p.postsMutex.Lock()
+	post, active := p.posts[userID]
+	if active && len(invalid) == 0 {
+		post.Tags = tags
+	}
+	p.postsMutex.Unlock()
+	if active && len(invalid) == 0 {
+		p.saveDraftOrLog(userID)
+	}
+
+	var response string
+	switch {
+	case !active:
+		response = "No active post. Use /new to start writing a new post."
+	case len(invalid) > 0:
+		response = fmt.Sprintf("%s can't be tags, only letters, digits and _ are allowed.\nUsage: /tags <tag> [tag...]",
+			strings.Join(invalid, ", "))
+	case len(tags) == 0:
+		response = "Tags removed."
+	default:
+		response = fmt.Sprintf("Tags set to: %s", Hashtags(tags))
+	}
+	err := messenger.SendMessage(ctx, message.Reply(response))
+	if err != nil {
+		return fmt.Errorf("messenger send message err: %w", err)
+	}
+	return nil
+}
+
+// quoteCommandHandler makes the active post quote the post at the URL following /quote, an empty one removes the quote.
+func (p *PostingFlow) quoteCommandHandler(ctx context.Context, message *im.Message, messenger im.Messenger) error {
+	userID := message.UserID
+	quoteURL := commandRest(message, "/quote")
+
+	var response string
+	if quoteURL != "" {
+		u, err := url.Parse(quoteURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			response = fmt.Sprintf("%s is not a link to a post.\nUsage: /quote <url>", quoteURL)
+		}
+	}
+
+	p.postsMutex.Lock()
+	post, active := p.posts[userID]
+	if active && response == "" {
+		post.QuoteURL = quoteURL
+	}
+	p.postsMutex.Unlock()
+	// an invalid URL changes nothing, there is nothing to save.
+	if active && response == "" {
+		p.saveDraftOrLog(userID)
+	}
+
+	switch {
+	case !active:
+		response = "No active post. Use /new to start writing a new post."
+	case response != "":
+		// not a URL, the post stays as it was.
+	case quoteURL == "":
+		response = "Quote removed."
+	default:
+		response = fmt.Sprintf("Quoting %s, platforms without quote posts get the link instead.", quoteURL)
+	}
+	err := messenger.SendMessage(ctx, message.Reply(response))
+	if err != nil {
+		return fmt.Errorf("messenger send message err: %w", err)
+	}
+	return nil
+}
+
+// editCommandHandler replaces the text of the active post with whatever follows /edit or, if nothing does, with the
+// text of the next message.
+func (p *PostingFlow) editCommandHandler(ctx context.Context, message *im.Message, messenger im.Messenger) error {
+	userID := message.UserID
+	text := commandRest(message, "/edit")
+
+	p.postsMutex.Lock()
+	post, active := p.posts[userID]
+	var budget string
+	if active {
+		if text == "" {
+			p.editing[userID] = true
+		} else {
+			delete(p.editing, userID)
+			post.SetText(text)
+			budget = p.remainingChars(post)
+		}
+	}
+	p.postsMutex.Unlock()
+
+	var response string
+	switch {
+	case !active:
+		response = "No active post. Use /new to start writing a new post."
+	case text == "":
+		response = "Send the new text of your post, it will replace the current one."
+	default:
+		p.saveDraftOrLog(userID)
+		response = editedResponse(text, budget)
+	}
+	err := messenger.SendMessage(ctx, message.Reply(response))
+	if err != nil {
+		return fmt.Errorf("messenger send message err: %w", err)
+	}
+	return nil
+}
+
+// editedResponse confirms the new text of a post, with the characters it has left where known.
+func editedResponse(text, budget string) string {
+	response := "The text of your post is now:\n" + text
+	if budget != "" {
+		response += "\n\n(" + budget + ")"
+	}
+	return response
+}
+
+// altCommandHandler handles /alt N some text, setting the alt text of the Nth (1 based) image of the active post.
+func (p *PostingFlow) altCommandHandler(ctx context.Context, message *im.Message, messenger im.Messenger) error {
+	userID := message.UserID
+	indexAndText := strings.SplitN(commandRest(message, "/alt"), " ", 2)
+	index, err := strconv.Atoi(indexAndText[0])
+	if err != nil || len(indexAndText) < 2 || strings.TrimSpace(indexAndText[1]) == "" {
+		err := messenger.SendMessage(ctx, message.Reply("Usage: /alt <image number> <alt text>"))
+		if err != nil {
+			return fmt.Errorf("messenger send message err: %w", err)
+		}
+		return nil
+	}
+	altText := strings.TrimSpace(indexAndText[1])
+
+	p.postsMutex.Lock()
+	post, active := p.posts[userID]
+	if active {
+		err = post.SetAltText(index-1, altText)
+	}
+	p.postsMutex.Unlock()
+
+	var response string
+	switch {
+	case !active:
+		response = "No active post. Use /new to start writing a new post."
+	case errors.Is(err, ErrImageIndexOutOfRange):
+		response = fmt.Sprintf("There is no image %d, the post has %d images.", index, len(post.Images))
+	case err != nil:
+		return fmt.Errorf("setting alt text: %w", err)
+	default:
+		p.saveDraftOrLog(userID)
+		response = fmt.Sprintf("Alt text of image %d set to: %s", index, altText)
+	}
+	err = messenger.SendMessage(ctx, message.Reply(response))
+	if err != nil {
+		return fmt.Errorf("messenger send message err: %w", err)
+	}
+	return nil
+}
+
+// rmimageCommandHandler handles /rmimage N, removing the Nth (1 based) image of the active post.
+func (p *PostingFlow) rmimageCommandHandler(ctx context.Context, message *im.Message, messenger im.Messenger) error {
+	userID := message.UserID
+	index, err := strconv.Atoi(commandRest(message, "/rmimage"))
+	if err != nil {
+		err := messenger.SendMessage(ctx, message.Reply("Usage: /rmimage <image number>"))
+		if err != nil {
+			return fmt.Errorf("messenger send message err: %w", err)
+		}
+		return nil
+	}
+
+	p.postsMutex.Lock()
+	post, active := p.posts[userID]
+	var images int
+	var remaining string
+	if active {
+		err = post.RemoveImage(index - 1)
+		images = len(post.Images)
+		remaining = describeImages(post.Images)
+	}
+	p.postsMutex.Unlock()
+
+	var response string
+	switch {
+	case !active:
+		response = "No active post. Use /new to start writing a new post."
+	case errors.Is(err, ErrImageIndexOutOfRange):
+		response = fmt.Sprintf("There is no image %d, the post has %d images.", index, images)
+	case err != nil:
+		return fmt.Errorf("removing image: %w", err)
+	default:
+		p.saveDraftOrLog(userID)
+		response = fmt.Sprintf("Image %d removed, images left: %d", index, images)
+		if remaining != "" {
+			response += "\n" + remaining
+		}
+	}
+	err = messenger.SendMessage(ctx, message.Reply(response))
+	if err != nil {
+		return fmt.Errorf("messenger send message err: %w", err)
+	}
+	return nil
+}
+
+// describeImages lists images, numbered from 1, with their alt texts, one per line.
+func describeImages(images []*BlogImage) string {
+	var sb strings.Builder
+	for i, img := range images {
+		altText := img.AltText
+		if altText == "" {
+			altText = "(no alt text)"
+		}
+		fmt.Fprintf(&sb, "  %d. %s\n", i+1, altText)
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// previewCommandHandler replies with what the active post looks like so far: its text, images and their alt texts,
+// the platforms it will go to and how much room is left in each of them.
+func (p *PostingFlow) previewCommandHandler(ctx context.Context, message *im.Message, messenger im.Messenger) error {
+	userID := message.UserID
+
+	p.postsMutex.Lock()
+	post, active := p.posts[userID]
+	var preview string
+	if active {
+		preview = p.preview(post)
+	}
+	p.postsMutex.Unlock()
+
+	if !active {
+		preview = "No active post. Use /new to start writing a new post."
+	}
+	err := messenger.SendMessage(ctx, message.Reply(preview))
+	if err != nil {
+		return fmt.Errorf("messenger send message err: %w", err)
+	}
+	return nil
+}
+
+// preview renders a human readable summary of post, the caller must hold postsMutex.
+func (p *PostingFlow) preview(post *MicroblogPost) string {
+	var sb strings.Builder
+	sb.WriteString("Text:\n")
+	if post.Text == "" {
+		sb.WriteString("(empty)")
+	} else {
+		sb.WriteString(post.Text)
+	}
+	sb.WriteString("\n\n")
+	if post.ContentWarning != "" {
+		fmt.Fprintf(&sb, "Content warning: %s\n", post.ContentWarning)
+	}
+	if post.QuoteURL != "" {
+		fmt.Fprintf(&sb, "Quoting: %s\n", post.QuoteURL)
+	}
+	if len(post.Tags) > 0 {
+		fmt.Fprintf(&sb, "Tags: %s\n", Hashtags(post.Tags))
+	}
+	if len(post.Langs) > 0 {
+		fmt.Fprintf(&sb, "Languages: %s\n", strings.Join(post.Langs, ", "))
+	}
+
+	if post.Video != nil {
+		altText := post.Video.AltText
+		if altText == "" {
+			altText = "(no alt text)"
+		}
+		fmt.Fprintf(&sb, "Video: %s, %d bytes, %s\n", post.Video.Duration, len(post.Video.Data), altText)
+	}
+	fmt.Fprintf(&sb, "Images: %d\n", len(post.Images))
+	if len(post.Images) > 0 {
+		sb.WriteString(describeImages(post.Images) + "\n")
+	}
+
+	if post.Poll != nil {
+		fmt.Fprintf(&sb, "Poll, open for %s", post.Poll.ExpiresIn)
+		if post.Poll.Multiple {
+			sb.WriteString(", multiple choice")
+		}
+		sb.WriteString(":\n")
+		for i, option := range post.Poll.Options {
+			fmt.Fprintf(&sb, "  %d. %s\n", i+1, option)
+		}
+	}
+
+	sb.WriteString("Platforms:\n")
+	for _, pname := range p.orderedPlatformNames() {
+		remaining, ok := post.RemainingChars(pname)
+		if !ok {
+			fmt.Fprintf(&sb, "  %s\n", pname)
+			continue
+		}
+		if remaining >= 0 {
+			fmt.Fprintf(&sb, "  %s: %d characters left\n", pname, remaining)
+			continue
+		}
+		switch p.overflow {
+		case OverflowTruncate:
+			fmt.Fprintf(&sb, "  %s: %d characters over, will be truncated\n", pname, -remaining)
+		case OverflowReject:
+			fmt.Fprintf(&sb, "  %s: %d characters over, will not be sent\n", pname, -remaining)
+		default:
+			fmt.Fprintf(&sb, "  %s: %d characters over, will be sent as a thread of %d posts\n",
+				pname, -remaining, len(post.renderedFor(pname).ThreadChunks(PlatformTextLimits[pname.Kind()])))
+		}
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// mentionRegex finds things that look like mentions for any of the platforms, either @user, @user@instance or
+// @handle.domain, these are not portable across platforms.
+var mentionRegex = regexp.MustCompile(`(?:^|\s)@[\w.-]+(?:@[\w.-]+)?`)
+
+// crosspostCommandHandler handles /crosspost <url> to <platform>, it fetches an already published post from whichever
+// platform knows how to retrieve it and posts the same text and images to the target platform.
+func (p *PostingFlow) crosspostCommandHandler(ctx context.Context, message *im.Message, messenger im.Messenger) error {
+	userID := message.UserID
+	_, args, err := message.AsCommand(p.StartCommandParser)
+	if err != nil {
+		return fmt.Errorf("parsing /crosspost message (%s): %w", message.Text, err)
+	}
+
+	if len(args) != 3 || args[1] != "to" {
+		err := messenger.SendMessage(ctx, message.Reply("Usage: /crosspost <post url> to <platform>"))
+		if err != nil {
+			return fmt.Errorf("messenger send message err: %w", err)
+		}
+		return nil
+	}
+	sourceURL, targetName := args[0], config.AvailableBloggingPlatform(args[2])
+
+	target, ok := p.platforms[targetName]
+	if !ok {
+		err := messenger.SendMessage(ctx, message.Reply(fmt.Sprintf("Unknown platform %s.", targetName)))
+		if err != nil {
+			return fmt.Errorf("messenger send message err: %w", err)
+		}
+		return nil
+	}
+
+	var post *MicroblogPost
+	var fetchErrs []error
+	// in a stable order, so the same platform fetches the same URL every time.
+	for _, pname := range sortedNames(p.platforms) {
+		if pname == targetName {
+			continue
+		}
+		fetcher, ok := p.platforms[pname].(Fetcher)
+		if !ok {
+			continue
+		}
+		post, err = fetcher.Fetch(ctx, UserID(userID), sourceURL)
+		if err == nil {
+			break
+		}
+		p.logger.Warn("fetching post", "user_id", userID, "platform", pname, "url", sourceURL, "err", err)
+		fetchErrs = append(fetchErrs, fmt.Errorf("%s: %w", pname, err))
+	}
+	if post == nil {
+		err := messenger.SendMessage(ctx, message.Reply(fmt.Sprintf("Could not fetch %s: %v", sourceURL, errors.Join(fetchErrs...))))
+		if err != nil {
+			return fmt.Errorf("messenger send message err: %w", err)
+		}
+		return nil
+	}
+
+	p.resolveLangs(userID, post)
+	result, err := postMeasured(ctx, p.metrics, targetName, target, UserID(userID), post)
+	if err != nil {
+		p.logger.Error("crossposting failed", "user_id", userID, "platform", targetName, "err", err)
+		err = messenger.SendMessage(ctx, message.Reply(fmt.Sprintf("Post Not crossposted to %s: %v", targetName, err)))
+		if err != nil {
+			return fmt.Errorf("messenger send message err: %w", err)
+		}
+		return nil
+	}
+
+	response := fmt.Sprintf("Post crossposted to %s (%s)", targetName, result.URL)
+	if mentionRegex.MatchString(post.Text) {
+		response += fmt.Sprintf("\nWarning: the post contains mentions, they might not point to the same accounts on %s.", targetName)
+	}
+	err = messenger.SendMessage(ctx, message.Reply(response))
+	if err != nil {
 		return fmt.Errorf("messenger send message err: %w", err)
 	}
 	return nil
@@ -208,39 +1150,147 @@ func (p *PostingFlow) defaultHandler(ctx context.Context, message *im.Message, m
 		return nil
 	}
 
+	if message.Edited {
+		return p.editHandler(ctx, message, messenger, post)
+	}
+
+	// describing can take a while, it is done before taking the lock.
+	altTexts, described := p.describeUncaptioned(ctx, userID, message.Images)
+
 	added := false
-	// Append text content.
-	if message.Text != "" {
-		if len(post.Text) != 0 {
-			post.Text += "\n"
-		}
-		post.Text += message.Text
+	p.postsMutex.Lock()
+	// after a bare /edit the text of the next message replaces the text of the post instead of being appended.
+	replaced := p.editing[userID] && strings.TrimSpace(message.Text) != ""
+	if replaced {
+		delete(p.editing, userID)
+		post.SetText("")
+	}
+	// Append text content, a message can carry text and images at once.
+	if post.AppendText(message.MsgID, message.Text, p.userSettings(userID).separator()) {
 		added = true
 	}
 
-	for _, img := range message.Images {
-		post.AddImage(NewBlogImage(img.Data, img.Caption))
+	var rejected []string
+	for i, img := range message.Images {
+		if post.Video != nil {
+			rejected = append(rejected, "an image (the post has a video)")
+			continue
+		}
+		post.AddImage(NewBlogImage(img.Data, altTexts[i]))
 		added = true
 	}
+	for _, vid := range message.Videos {
+		err := post.AddVideo(&BlogVideo{
+			Data:     vid.Data,
+			MimeType: vid.MimeType,
+			Duration: vid.Duration,
+			Width:    vid.Width,
+			Height:   vid.Height,
+			AltText:  vid.Caption,
+		})
+		if err != nil {
+			p.logger.Info("adding video", "user_id", userID, "err", err)
+			rejected = append(rejected, "a video (posts carry either images or a single video)")
+			continue
+		}
+		added = true
+	}
+	budget := p.remainingChars(post)
+	text := post.Text
+	p.postsMutex.Unlock()
 
 	var err error
 	if added {
-		err = messenger.SendMessage(ctx, message.Reply("Content added to your post"))
-	} else {
+		p.saveDraftOrLog(userID)
+		response := "Content added to your post"
+		if budget != "" {
+			response += " (" + budget + ")"
+		}
+		if replaced {
+			response = editedResponse(text, budget)
+		}
+		if len(described) > 0 {
+			response += fmt.Sprintf("\nAlt text was generated for %d images without caption, check it with /preview.",
+				len(described))
+		}
+		err = messenger.SendMessage(ctx, message.Reply(response))
+	} else if len(rejected) == 0 {
 		err = messenger.SendMessage(ctx, message.Reply("Received message, but no content was added."))
 	}
+	if err == nil && len(rejected) > 0 {
+		err = messenger.SendMessage(ctx, message.Reply("Could not add "+strings.Join(rejected, ", ")+"."))
+	}
 	if err != nil {
 		return fmt.Errorf("responding after content add: %w", err)
 	}
 	return nil
 }
 
+// editHandler applies the edit of a message that added text to the active post.
+func (p *PostingFlow) editHandler(ctx context.Context, message *im.Message, messenger im.Messenger, post *MicroblogPost) error {
+	p.postsMutex.Lock()
+	err := post.ReplaceText(message.MsgID, message.Text)
+	budget := p.remainingChars(post)
+	p.postsMutex.Unlock()
+
+	response := "Your post was updated with the edit"
+	if budget != "" {
+		response += " (" + budget + ")"
+	}
+	if err != nil {
+		p.logger.Warn("applying edit", "user_id", message.UserID, "message_id", message.MsgID, "err", err)
+		response = "That message is not part of your post, only edits to text added to the active post are applied."
+	} else {
+		p.saveDraftOrLog(message.UserID)
+	}
+	if err := messenger.SendMessage(ctx, message.Reply(response)); err != nil {
+		return fmt.Errorf("responding after edit: %w", err)
+	}
+	return nil
+}
+
+// remainingChars describes how many characters the post has left in each platform with a limit, i.e.
+// "bsky: 230 left, mastodon: 430 left", the caller must hold postsMutex.
+func (p *PostingFlow) remainingChars(post *MicroblogPost) string {
+	var budgets []string
+	for _, pname := range p.orderedPlatformNames() {
+		remaining, ok := post.RemainingChars(pname)
+		if !ok {
+			continue
+		}
+		if remaining < 0 {
+			budgets = append(budgets, fmt.Sprintf("%s: %d over, it will be %s", pname, -remaining, p.overflowOutcome()))
+			continue
+		}
+		budgets = append(budgets, fmt.Sprintf("%s: %d left", pname, remaining))
+	}
+	return strings.Join(budgets, ", ")
+}
+
 var _ im.Flow = (*PostingFlow)(nil)
 
-// NewPostingFlow creates a new PostingFlow
-func NewPostingFlow(platforms map[config.AvailableBloggingPlatform]AuthedPlatform) *PostingFlow {
-	return &PostingFlow{
-		posts:     make(map[uint64]*MicroblogPost),
-		platforms: platforms,
+// NewPostingFlow creates a new PostingFlow, drafts and scheduled posts are persisted to store if it is not nil, use
+// LoadDrafts and LoadScheduled to restore them. Scheduled posts are only sent while RunScheduled runs.
+func NewPostingFlow(platforms map[config.AvailableBloggingPlatform]AuthedPlatform, store *secrets.EncryptedStore, opts ...PostingFlowOption) *PostingFlow {
+	p := &PostingFlow{
+		posts:           make(map[uint64]*MicroblogPost),
+		platforms:       platforms,
+		store:           store,
+		sent:            make(map[uint64]*sentPost),
+		now:             time.Now,
+		settings:        make(map[uint64]*UserSettings),
+		selections:      make(map[uint64]map[config.AvailableBloggingPlatform]bool),
+		editing:         make(map[uint64]bool),
+		scheduled:       make(map[uint64][]*ScheduledPost),
+		scheduleChanged: make(chan struct{}, 1),
+		location:        time.Local,
+		overflow:        OverflowThread,
+		logger:          slog.Default(),
+		metrics:         metrics.Nop{},
+	}
+	for _, opt := range opts {
+		opt(p)
 	}
+	p.order = platformOrder(platforms, p.priority)
+	return p
 }
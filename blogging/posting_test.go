@@ -0,0 +1,493 @@
+package blogging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/perrito666/chat2world/config"
+	"github.com/perrito666/chat2world/im"
+	"github.com/perrito666/chat2world/secrets"
+)
+
+// testUser is who sends the messages in the tests.
+const testUser = 1
+
+// recordingMessenger keeps what is sent through it.
+type recordingMessenger struct {
+	mu   sync.Mutex
+	sent []*im.Message
+}
+
+func (m *recordingMessenger) SendMessage(_ context.Context, message *im.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, message)
+	return nil
+}
+
+func (m *recordingMessenger) Name() string {
+	return "test"
+}
+
+// last returns the text of the last message sent.
+func (m *recordingMessenger) last() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.sent) == 0 {
+		return ""
+	}
+	return m.sent[len(m.sent)-1].Text
+}
+
+// all returns the text of every message sent, one per line.
+func (m *recordingMessenger) all() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	texts := make([]string, len(m.sent))
+	for i, message := range m.sent {
+		texts[i] = message.Text
+	}
+	return strings.Join(texts, "\n")
+}
+
+// fakePlatform is an authorized platform keeping what is posted to it, failing with err if set. Posts return a copy
+// of result if set.
+type fakePlatform struct {
+	mu     sync.Mutex
+	posts  []*MicroblogPost
+	err    error
+	result *PostResult
+}
+
+func (f *fakePlatform) Post(_ context.Context, _ UserID, post *MicroblogPost) (*PostResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.posts = append(f.posts, post)
+	if f.result != nil {
+		result := *f.result
+		return &result, nil
+	}
+	return &PostResult{URL: fmt.Sprintf("https://example.com/%d", len(f.posts)), Parts: 1}, nil
+}
+
+func (f *fakePlatform) Config(UserID) (ClientConfig, error) {
+	return nil, ErrClientNotFound
+}
+
+func (f *fakePlatform) IsAuthorized(UserID) bool {
+	return true
+}
+
+func (f *fakePlatform) StartAuthorization(context.Context, UserID, map[string]string) (chan string, error) {
+	return nil, nil
+}
+
+// posted returns what was posted.
+func (f *fakePlatform) posted() []*MicroblogPost {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.posts
+}
+
+// newTestStore returns a store keeping its files in memory, with fast key derivation.
+func newTestStore() *secrets.EncryptedStore {
+	return &secrets.EncryptedStore{Password: "test", Params: secrets.ScryptParams{N: 1024, R: 8, P: 1},
+		Backend: &secrets.MemoryBackend{}}
+}
+
+// say sends text to the flow as testUser.
+func say(t *testing.T, p *PostingFlow, messenger im.Messenger, text string) {
+	t.Helper()
+	if err := p.HandleMessage(context.Background(), &im.Message{UserID: testUser, Text: text}, messenger); err != nil {
+		t.Fatalf("handling %q: %v", text, err)
+	}
+}
+
+func TestDraftSurvivesRestart(t *testing.T) {
+	store := newTestStore()
+	platform := &fakePlatform{}
+	platforms := map[config.AvailableBloggingPlatform]AuthedPlatform{config.MBPMastodon: platform}
+	messenger := &recordingMessenger{}
+
+	before := NewPostingFlow(platforms, store)
+	say(t, before, messenger, "/new en")
+	say(t, before, messenger, "written before the restart")
+
+	// the restart is a new flow over the same store.
+	after := NewPostingFlow(platforms, store)
+	if after.CanResume(testUser) {
+		t.Fatal("the draft is there before loading drafts")
+	}
+	if err := after.LoadDrafts(testUser); err != nil {
+		t.Fatalf("LoadDrafts: %v", err)
+	}
+	if !after.CanResume(testUser) {
+		t.Fatal("the draft was not restored")
+	}
+	say(t, after, messenger, "and after it")
+	say(t, after, messenger, "/send")
+
+	posted := platform.posted()
+	if len(posted) != 1 {
+		t.Fatalf("%d posts sent, want 1: %s", len(posted), messenger.all())
+	}
+	if want := "written before the restart\nand after it"; posted[0].Text != want {
+		t.Errorf("posted %q, want %q", posted[0].Text, want)
+	}
+	if len(posted[0].Langs) != 1 || posted[0].Langs[0] != "en" {
+		t.Errorf("posted with languages %q, want [en]", posted[0].Langs)
+	}
+
+	// once sent the draft is gone for good.
+	again := NewPostingFlow(platforms, store)
+	if err := again.LoadDrafts(testUser); err != nil {
+		t.Fatalf("LoadDrafts: %v", err)
+	}
+	if again.CanResume(testUser) {
+		t.Error("the sent post came back as a draft")
+	}
+}
+
+func TestAltCommandOutOfRange(t *testing.T) {
+	p := NewPostingFlow(map[config.AvailableBloggingPlatform]AuthedPlatform{config.MBPMastodon: &fakePlatform{}}, nil)
+	messenger := &recordingMessenger{}
+	say(t, p, messenger, "/new")
+	if err := p.HandleMessage(context.Background(), &im.Message{UserID: testUser,
+		Images: []*im.Image{{Data: []byte("image")}}}, messenger); err != nil {
+		t.Fatalf("adding an image: %v", err)
+	}
+
+	say(t, p, messenger, "/alt 2 a dog")
+	if want := "There is no image 2, the post has 1 images."; messenger.last() != want {
+		t.Errorf("/alt 2 answered %q, want %q", messenger.last(), want)
+	}
+	say(t, p, messenger, "/alt 0 a dog")
+	if want := "There is no image 0, the post has 1 images."; messenger.last() != want {
+		t.Errorf("/alt 0 answered %q, want %q", messenger.last(), want)
+	}
+	say(t, p, messenger, "/alt 1 a cat")
+	if got := p.posts[testUser].Images[0].AltText; got != "a cat" {
+		t.Errorf("alt text = %q, want \"a cat\"", got)
+	}
+}
+
+func TestSendReportsThePostResult(t *testing.T) {
+	platform := &fakePlatform{result: &PostResult{URL: "https://example.com/thread", ID: "1", Parts: 3,
+		Warnings: []string{"image 1 was re-encoded to fit"}}}
+	p := NewPostingFlow(map[config.AvailableBloggingPlatform]AuthedPlatform{config.MBPMastodon: platform}, nil)
+	messenger := &recordingMessenger{}
+	say(t, p, messenger, "/new")
+	say(t, p, messenger, "a long post")
+	say(t, p, messenger, "/send")
+
+	all := messenger.all()
+	want := "Post sent to mastodon (https://example.com/thread) as a thread of 3 posts\n" +
+		"Warning: image 1 was re-encoded to fit"
+	if !strings.Contains(all, want) {
+		t.Errorf("messages = %q, want them to hold %q", all, want)
+	}
+	if got := p.sent[testUser].urls[config.MBPMastodon]; got != "https://example.com/thread" {
+		t.Errorf("remembered URL = %q, want the one of the result", got)
+	}
+}
+
+func TestEditUpdatesTheDraft(t *testing.T) {
+	platform := &fakePlatform{}
+	p := NewPostingFlow(map[config.AvailableBloggingPlatform]AuthedPlatform{config.MBPMastodon: platform}, nil)
+	messenger := &recordingMessenger{}
+	ctx := context.Background()
+	say(t, p, messenger, "/new")
+	for i, text := range []string{"first line", "second lien", "third line"} {
+		if err := p.HandleMessage(ctx, &im.Message{UserID: testUser, MsgID: uint64(i + 1), Text: text},
+			messenger); err != nil {
+			t.Fatalf("handling %q: %v", text, err)
+		}
+	}
+
+	if err := p.HandleMessage(ctx, &im.Message{UserID: testUser, MsgID: 2, Text: "second line", Edited: true},
+		messenger); err != nil {
+		t.Fatalf("handling the edit: %v", err)
+	}
+	if !strings.HasPrefix(messenger.last(), "Your post was updated with the edit") {
+		t.Errorf("the edit was answered %q, want it confirmed", messenger.last())
+	}
+	// edits of messages that are not in the post change nothing.
+	if err := p.HandleMessage(ctx, &im.Message{UserID: testUser, MsgID: 40, Text: "unrelated", Edited: true},
+		messenger); err != nil {
+		t.Fatalf("handling the edit: %v", err)
+	}
+	if !strings.HasPrefix(messenger.last(), "That message is not part of your post") {
+		t.Errorf("an unrelated edit was answered %q, want it refused", messenger.last())
+	}
+
+	say(t, p, messenger, "/send")
+	posted := platform.posted()
+	if len(posted) != 1 {
+		t.Fatalf("%d posts sent, want 1: %s", len(posted), messenger.all())
+	}
+	if want := "first line\nsecond line\nthird line"; posted[0].Text != want {
+		t.Errorf("posted %q, want %q", posted[0].Text, want)
+	}
+}
+
+func TestMixedTextAndImageMessages(t *testing.T) {
+	platform := &fakePlatform{}
+	p := NewPostingFlow(map[config.AvailableBloggingPlatform]AuthedPlatform{config.MBPMastodon: platform}, nil)
+	messenger := &recordingMessenger{}
+	ctx := context.Background()
+	say(t, p, messenger, "/new")
+	for _, message := range []*im.Message{
+		{UserID: testUser, MsgID: 1, Text: "look at these"},
+		// the caption of an image is its alt text, not part of the post.
+		{UserID: testUser, MsgID: 2, Images: []*im.Image{{Data: []byte("cat"), Caption: "a cat"}}},
+		{UserID: testUser, MsgID: 3, Text: "  and this one\n", Images: []*im.Image{{Data: []byte("dog")}}},
+		{UserID: testUser, MsgID: 4, Text: "\nbye"},
+	} {
+		if err := p.HandleMessage(ctx, message, messenger); err != nil {
+			t.Fatalf("handling message %d: %v", message.MsgID, err)
+		}
+	}
+	say(t, p, messenger, "/send")
+
+	posted := platform.posted()
+	if len(posted) != 1 {
+		t.Fatalf("posted %d times, want 1", len(posted))
+	}
+	if want := "look at these\n  and this one\nbye"; posted[0].Text != want {
+		t.Errorf("Text = %q, want %q", posted[0].Text, want)
+	}
+	if len(posted[0].Images) != 2 || posted[0].Images[0].AltText != "a cat" {
+		t.Errorf("images = %+v, want both, the first with its caption as alt text", posted[0].Images)
+	}
+}
+
+func TestEditCommandReplacesTheText(t *testing.T) {
+	platform := &fakePlatform{}
+	p := NewPostingFlow(map[config.AvailableBloggingPlatform]AuthedPlatform{config.MBPMastodon: platform}, nil)
+	messenger := &recordingMessenger{}
+	say(t, p, messenger, "/edit all new")
+	if want := "No active post. Use /new to start writing a new post."; messenger.last() != want {
+		t.Errorf("/edit without a post answered %q, want %q", messenger.last(), want)
+	}
+
+	say(t, p, messenger, "/new")
+	say(t, p, messenger, "a first try")
+	say(t, p, messenger, "/edit a second try")
+	if !strings.HasPrefix(messenger.last(), "The text of your post is now:\na second try") {
+		t.Errorf("/edit answered %q, want it to show the new text", messenger.last())
+	}
+	if got := p.posts[testUser].Text; got != "a second try" {
+		t.Errorf("text after /edit = %q, want %q", got, "a second try")
+	}
+
+	// without text the next message is the new text, instead of being appended.
+	say(t, p, messenger, "/edit")
+	if want := "Send the new text of your post, it will replace the current one."; messenger.last() != want {
+		t.Errorf("/edit answered %q, want %q", messenger.last(), want)
+	}
+	say(t, p, messenger, "a third try")
+	say(t, p, messenger, "and more")
+	say(t, p, messenger, "/send")
+	posted := platform.posted()
+	if len(posted) != 1 {
+		t.Fatalf("%d posts sent, want 1: %s", len(posted), messenger.all())
+	}
+	if want := "a third try\nand more"; posted[0].Text != want {
+		t.Errorf("posted %q, want %q", posted[0].Text, want)
+	}
+}
+
+func TestRmimageRemovesTheImage(t *testing.T) {
+	platform := &fakePlatform{}
+	p := NewPostingFlow(map[config.AvailableBloggingPlatform]AuthedPlatform{config.MBPMastodon: platform}, nil)
+	messenger := &recordingMessenger{}
+	say(t, p, messenger, "/new")
+	if err := p.HandleMessage(context.Background(), &im.Message{UserID: testUser, Images: []*im.Image{
+		{Data: []byte("cat"), Caption: "a cat"},
+		{Data: []byte("dog"), Caption: "a dog"},
+		{Data: []byte("bird"), Caption: "a bird"},
+	}}, messenger); err != nil {
+		t.Fatalf("adding images: %v", err)
+	}
+
+	for _, command := range []string{"/rmimage 4", "/rmimage 0"} {
+		say(t, p, messenger, command)
+		if want := "There is no image " + command[len("/rmimage "):] + ", the post has 3 images."; messenger.last() != want {
+			t.Errorf("%s answered %q, want %q", command, messenger.last(), want)
+		}
+	}
+	say(t, p, messenger, "/rmimage two")
+	if want := "Usage: /rmimage <image number>"; messenger.last() != want {
+		t.Errorf("/rmimage two answered %q, want %q", messenger.last(), want)
+	}
+
+	say(t, p, messenger, "/rmimage 2")
+	if want := "Image 2 removed, images left: 2\n  1. a cat\n  2. a bird"; messenger.last() != want {
+		t.Errorf("/rmimage 2 answered %q, want %q", messenger.last(), want)
+	}
+	say(t, p, messenger, "/send")
+	posted := platform.posted()
+	if len(posted) != 1 {
+		t.Fatalf("%d posts sent, want 1: %s", len(posted), messenger.all())
+	}
+	var left []string
+	for _, img := range posted[0].Images {
+		left = append(left, string(img.Data))
+	}
+	if strings.Join(left, ",") != "cat,bird" {
+		t.Errorf("posted images %q, want the cat and the bird", left)
+	}
+}
+
+func TestSendSumsUpPartialSuccess(t *testing.T) {
+	mastodon, bsky := &fakePlatform{}, &fakePlatform{err: errors.New("service unavailable")}
+	p := NewPostingFlow(map[config.AvailableBloggingPlatform]AuthedPlatform{
+		config.MBPMastodon: mastodon,
+		config.MBPBsky:     bsky,
+	}, nil)
+	messenger := &buttonMessenger{}
+	say(t, p, messenger, "/new")
+	say(t, p, messenger, "to both")
+	say(t, p, messenger, "/send")
+	press(t, p, messenger, selectConfirm)
+
+	if want := "Posted to 1/2 platforms; bluesky failed: service unavailable"; messenger.last() != want {
+		t.Errorf("the last message is %q, want the summary %q", messenger.last(), want)
+	}
+	all := messenger.all()
+	for _, want := range []string{"Post Not sent to bluesky: service unavailable", "Post sent to mastodon"} {
+		if !strings.Contains(all, want) {
+			t.Errorf("messages = %q, want them to hold %q", all, want)
+		}
+	}
+	if len(mastodon.posted()) != 1 {
+		t.Errorf("posted %d times to mastodon, want 1 despite bluesky failing", len(mastodon.posted()))
+	}
+}
+
+// orderedPlatform is a fakePlatform that adds its name to a log shared with others when posted to.
+type orderedPlatform struct {
+	fakePlatform
+	name config.AvailableBloggingPlatform
+	log  *[]config.AvailableBloggingPlatform
+}
+
+func (o *orderedPlatform) Post(ctx context.Context, userID UserID, post *MicroblogPost) (*PostResult, error) {
+	*o.log = append(*o.log, o.name)
+	return o.fakePlatform.Post(ctx, userID, post)
+}
+
+func TestPlatformsArePostedToInOrder(t *testing.T) {
+	var log []config.AvailableBloggingPlatform
+	platforms := map[config.AvailableBloggingPlatform]AuthedPlatform{}
+	for _, pname := range []config.AvailableBloggingPlatform{config.MBPBsky, config.MBPMastodon, config.MBPNostr,
+		config.BPHugo} {
+		platforms[pname] = &orderedPlatform{name: pname, log: &log}
+	}
+	// the platforms not given go last, sorted.
+	p := NewPostingFlow(platforms, nil, WithPlatformOrder(config.MBPNostr, config.MBPMastodon))
+	want := []config.AvailableBloggingPlatform{config.MBPNostr, config.MBPMastodon, config.MBPBsky, config.BPHugo}
+	messenger := &buttonMessenger{}
+	for range 3 {
+		log = nil
+		say(t, p, messenger, "/new")
+		say(t, p, messenger, "in order")
+		say(t, p, messenger, "/send")
+		press(t, p, messenger, selectConfirm)
+		if !slices.Equal(log, want) {
+			t.Fatalf("posted to %q, want %q", log, want)
+		}
+	}
+}
+
+func TestPostHookIsToldOfEachSuccess(t *testing.T) {
+	mastodon, nostr := &fakePlatform{}, &fakePlatform{}
+	bsky := &fakePlatform{err: errors.New("service unavailable")}
+	var told []string
+	hook := func(_ context.Context, userID UserID, platform config.AvailableBloggingPlatform, result *PostResult) {
+		told = append(told, fmt.Sprintf("%d %s %s", userID, platform, result.URL))
+	}
+	p := NewPostingFlow(map[config.AvailableBloggingPlatform]AuthedPlatform{
+		config.MBPMastodon: mastodon,
+		config.MBPBsky:     bsky,
+		config.MBPNostr:    nostr,
+	}, nil, WithPostHook(hook), WithLogger(discardLogger))
+	messenger := &recordingMessenger{}
+	say(t, p, messenger, "/new")
+	say(t, p, messenger, "hooked")
+	say(t, p, messenger, "/send")
+
+	want := []string{
+		fmt.Sprintf("%d mastodon https://example.com/1", testUser),
+		fmt.Sprintf("%d nostr https://example.com/1", testUser),
+	}
+	if !slices.Equal(told, want) {
+		t.Errorf("hook told %q, want %q, bluesky failed", told, want)
+	}
+}
+
+// fetchingPlatform is a fakePlatform that can also fetch posts, the fetched text names the platform it came from.
+type fetchingPlatform struct {
+	fakePlatform
+	name string
+}
+
+func (f *fetchingPlatform) Fetch(_ context.Context, _ UserID, postURL string) (*MicroblogPost, error) {
+	return &MicroblogPost{Text: fmt.Sprintf("%s fetched from %s", postURL, f.name)}, nil
+}
+
+func TestCrosspostFetchesInAStableOrder(t *testing.T) {
+	target := &fakePlatform{}
+	p := NewPostingFlow(map[config.AvailableBloggingPlatform]AuthedPlatform{
+		config.MBPMastodon: &fetchingPlatform{name: "mastodon"},
+		config.MBPBsky:     &fetchingPlatform{name: "bluesky"},
+		config.MBPNostr:    target,
+	}, nil)
+	messenger := &recordingMessenger{}
+	for range 5 {
+		say(t, p, messenger, "/crosspost https://example.com/post to nostr")
+	}
+
+	posted := target.posted()
+	if len(posted) != 5 {
+		t.Fatalf("%d posts crossposted, want 5: %s", len(posted), messenger.all())
+	}
+	for _, post := range posted {
+		if want := "https://example.com/post fetched from bluesky"; post.Text != want {
+			t.Errorf("crossposted %q, want %q", post.Text, want)
+		}
+	}
+}
+
+func TestInvalidQuoteDoesNotSaveTheDraft(t *testing.T) {
+	backend := &secrets.MemoryBackend{}
+	store := newTestStore()
+	store.Backend = backend
+	p := NewPostingFlow(map[config.AvailableBloggingPlatform]AuthedPlatform{config.MBPMastodon: &fakePlatform{}}, store)
+	messenger := &recordingMessenger{}
+	say(t, p, messenger, "/new")
+	say(t, p, messenger, "quoting")
+	say(t, p, messenger, "/quote https://example.com/quoted")
+	if err := backend.Remove(draftPath(testUser)); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+
+	say(t, p, messenger, "/quote not a link")
+	if keys := backend.Keys(); len(keys) != 0 {
+		t.Errorf("an invalid /quote saved %q", keys)
+	}
+	p.postsMutex.Lock()
+	quoteURL := p.posts[testUser].QuoteURL
+	p.postsMutex.Unlock()
+	if quoteURL != "https://example.com/quoted" {
+		t.Errorf("QuoteURL = %q, want %q", quoteURL, "https://example.com/quoted")
+	}
+}
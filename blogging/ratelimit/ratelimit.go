@@ -0,0 +1,141 @@
+// Package ratelimit keeps the platform clients under the request rate the servers allow, both with a local token
+// bucket and by backing off when the server says (through its rate limit headers) that we are out of requests.
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket holding up to burst requests, refilled at rate requests per second. It also holds every
+// request until a time the server asked for, see BlockUntil.
+type Limiter struct {
+	mu           sync.Mutex
+	rate         float64
+	burst        float64
+	tokens       float64
+	last         time.Time
+	blockedUntil time.Time
+	now          func() time.Time
+}
+
+// NewLimiter returns a Limiter allowing perSecond requests per second with bursts of up to burst requests, it starts
+// full.
+func NewLimiter(perSecond float64, burst int) *Limiter {
+	burst = max(burst, 1)
+	return &Limiter{
+		rate:   perSecond,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+	}
+}
+
+// reserve takes a token and returns how long the caller must wait before using it.
+func (l *Limiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if !l.last.IsZero() && l.rate > 0 {
+		l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+	l.tokens--
+
+	var wait time.Duration
+	if l.tokens < 0 && l.rate > 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	return max(wait, l.blockedUntil.Sub(now))
+}
+
+// Wait blocks until a request can be made, or ctx is done.
+func (l *Limiter) Wait(ctx context.Context) error {
+	wait := l.reserve()
+	if wait <= 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		// we are not using it after all.
+		l.mu.Lock()
+		l.tokens = min(l.burst, l.tokens+1)
+		l.mu.Unlock()
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// BlockUntil holds every request until t.
+func (l *Limiter) BlockUntil(t time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if t.After(l.blockedUntil) {
+		l.blockedUntil = t
+	}
+}
+
+// Observe reads the rate limit headers of a response, if the server says we have no requests left the Limiter holds
+// the following ones until the limit resets. Both the bluesky (ratelimit-*, reset in unix seconds) and the mastodon
+// (x-ratelimit-*, reset as an ISO 8601 date) flavours are understood.
+func (l *Limiter) Observe(h http.Header) {
+	for _, prefix := range []string{"Ratelimit-", "X-Ratelimit-"} {
+		remaining, err := strconv.Atoi(h.Get(prefix + "Remaining"))
+		if err != nil || remaining > 0 {
+			continue
+		}
+		if reset, ok := parseReset(h.Get(prefix + "Reset")); ok {
+			l.BlockUntil(reset)
+		}
+	}
+}
+
+// parseReset reads a rate limit reset time, either unix seconds or an RFC 3339 date.
+func parseReset(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), true
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// Transport is an http.RoundTripper that waits on Limiter before each request and feeds it the responses' rate limit
+// headers.
+type Transport struct {
+	// Base is the RoundTripper doing the actual work, http.DefaultTransport if nil.
+	Base    http.RoundTripper
+	Limiter *Limiter
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.Limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.Limiter.Observe(resp.Header)
+	return resp, nil
+}
+
+// NewHTTPClient returns an http.Client whose requests go through l.
+func NewHTTPClient(l *Limiter) *http.Client {
+	return &http.Client{Transport: &Transport{Limiter: l}}
+}
@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestLimiter returns a Limiter whose clock only moves when told.
+func newTestLimiter(perSecond float64, burst int) (*Limiter, *time.Time) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewLimiter(perSecond, burst)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestLimiterDelaysOnceTheBurstIsUsed(t *testing.T) {
+	l, now := newTestLimiter(2, 3)
+	for i := range 3 {
+		if wait := l.reserve(); wait != 0 {
+			t.Errorf("call %d waits %s, want none within the burst", i+1, wait)
+		}
+	}
+	if wait := l.reserve(); wait != 500*time.Millisecond {
+		t.Errorf("call 4 waits %s, want 500ms", wait)
+	}
+	if wait := l.reserve(); wait != time.Second {
+		t.Errorf("call 5 waits %s, want 1s", wait)
+	}
+	// waiting refills the bucket.
+	*now = now.Add(5 * time.Second)
+	if wait := l.reserve(); wait != 0 {
+		t.Errorf("a call after a while waits %s, want none", wait)
+	}
+}
+
+func TestLimiterHonorsServerLimits(t *testing.T) {
+	l, now := newTestLimiter(100, 10)
+	// bluesky says the reset in unix seconds, mastodon as a date.
+	l.Observe(http.Header{
+		"Ratelimit-Remaining": {"0"},
+		"Ratelimit-Reset":     {strconv.FormatInt(now.Add(30*time.Second).Unix(), 10)},
+	})
+	if wait := l.reserve(); wait != 30*time.Second {
+		t.Errorf("waits %s, want 30s until the limit resets", wait)
+	}
+	l.Observe(http.Header{
+		"X-Ratelimit-Remaining": {"0"},
+		"X-Ratelimit-Reset":     {now.Add(time.Minute).Format(time.RFC3339)},
+	})
+	if wait := l.reserve(); wait != time.Minute {
+		t.Errorf("waits %s, want 1m until the limit resets", wait)
+	}
+	// requests left are no reason to wait.
+	l2, _ := newTestLimiter(100, 10)
+	l2.Observe(http.Header{"Ratelimit-Remaining": {"5"}, "Ratelimit-Reset": {"9999999999"}})
+	if wait := l2.reserve(); wait != 0 {
+		t.Errorf("waits %s with requests left, want none", wait)
+	}
+}
+
+func TestLimiterWaitGivesUpWithTheContext(t *testing.T) {
+	l := NewLimiter(0.001, 1)
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() = %v, want the context's error", err)
+	}
+}
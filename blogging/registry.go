@@ -0,0 +1,220 @@
+package blogging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/perrito666/chat2world/config"
+	"github.com/perrito666/chat2world/im"
+	"github.com/perrito666/chat2world/secrets"
+)
+
+// PlatformRegistration describes how to build a platform and the Flow to authorize it.
+type PlatformRegistration struct {
+	Name config.AvailableBloggingPlatform
+	// New builds the platform for a user, it is called once per user.
+	New func() (AuthedPlatform, error)
+	// NewAccount builds, for platforms users can have several accounts on, the platform for one of them, it is called
+	// once per user and account enabled (see config.PlatformAccount).
+	NewAccount func(account string) (AuthedPlatform, error)
+	// AuthFlow names the authorization Flow, it is started with /<AuthFlow>, i.e. mastodon_auth, accounts get theirs
+	// with the account appended, i.e. mastodon_auth_fosstodon.
+	AuthFlow        string
+	AuthDescription string
+}
+
+// forAccount returns the registration of account, built from one taking accounts.
+func (registration PlatformRegistration) forAccount(account string) PlatformRegistration {
+	return PlatformRegistration{
+		Name:            config.PlatformAccount(registration.Name, account),
+		New:             func() (AuthedPlatform, error) { return registration.NewAccount(account) },
+		AuthFlow:        registration.AuthFlow + "_" + account,
+		AuthDescription: fmt.Sprintf("%s (%s)", registration.AuthDescription, account),
+	}
+}
+
+// ErrPlatformAlreadyRegistered is returned when registering a platform twice.
+var ErrPlatformAlreadyRegistered = errors.New("platform already registered")
+
+// ErrPlatformNotRegistered is returned when the config enables a platform nobody registered.
+var ErrPlatformNotRegistered = errors.New("platform not registered")
+
+// ErrNoAccounts is returned when the config enables an account of a platform registered without NewAccount.
+var ErrNoAccounts = errors.New("platform takes no accounts")
+
+// Registry holds the platforms we know how to build, the config decides which of them users get.
+type Registry struct {
+	platforms map[config.AvailableBloggingPlatform]PlatformRegistration
+	status    *Status
+	logger    *slog.Logger
+	// authFlowOpts configure the authorization Flows registered by RegisterFlows.
+	authFlowOpts []AuthorizerFlowOption
+}
+
+// RegistryOption configures optional settings of a Registry.
+type RegistryOption func(*Registry)
+
+// WithRegistryLogger sets where the registry, and the authorization Flows it registers, log. It defaults to
+// slog.Default().
+func WithRegistryLogger(logger *slog.Logger) RegistryOption {
+	return func(r *Registry) {
+		r.logger = logger
+	}
+}
+
+// WithStatus makes the /status of the users of the registry report status, i.e. to share it with the registries of
+// other IMs. Each registry has its own by default.
+func WithStatus(status *Status) RegistryOption {
+	return func(r *Registry) {
+		r.status = status
+	}
+}
+
+// WithAuthFlowOptions configures the authorization Flows the registry registers, i.e. WithCancelCommands.
+func WithAuthFlowOptions(opts ...AuthorizerFlowOption) RegistryOption {
+	return func(r *Registry) {
+		r.authFlowOpts = append(r.authFlowOpts, opts...)
+	}
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry(opts ...RegistryOption) *Registry {
+	r := &Registry{
+		platforms: make(map[config.AvailableBloggingPlatform]PlatformRegistration),
+		status:    NewStatus(),
+		logger:    slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Status returns the Status the /status of every user built by SchedulerFactory reports.
+func (r *Registry) Status() *Status {
+	return r.status
+}
+
+// Register adds a platform to the registry.
+func (r *Registry) Register(registration PlatformRegistration) error {
+	if _, ok := r.platforms[registration.Name]; ok {
+		return fmt.Errorf("%s: %w", registration.Name, ErrPlatformAlreadyRegistered)
+	}
+	r.platforms[registration.Name] = registration
+	return nil
+}
+
+// registration returns how to build the platform name, which might be an account of a registered one.
+func (r *Registry) registration(name config.AvailableBloggingPlatform) (PlatformRegistration, error) {
+	if registration, ok := r.platforms[name]; ok {
+		return registration, nil
+	}
+	registration, ok := r.platforms[name.Kind()]
+	switch {
+	case !ok:
+		return PlatformRegistration{}, fmt.Errorf("%s: %w", name, ErrPlatformNotRegistered)
+	case name.Account() == "" || registration.NewAccount == nil:
+		return PlatformRegistration{}, fmt.Errorf("%s: %w", name, ErrNoAccounts)
+	}
+	return registration.forAccount(name.Account()), nil
+}
+
+// Platforms builds the named platforms, i.e. for a Poster use cfg.EnabledBloggingPlatforms.
+func (r *Registry) Platforms(names ...config.AvailableBloggingPlatform) (map[config.AvailableBloggingPlatform]AuthedPlatform, error) {
+	platforms := make(map[config.AvailableBloggingPlatform]AuthedPlatform)
+	for _, name := range names {
+		registration, err := r.registration(name)
+		if err != nil {
+			return nil, err
+		}
+		platform, err := registration.New()
+		if err != nil {
+			return nil, fmt.Errorf("%s new client: %w", name, err)
+		}
+		platforms[name] = platform
+	}
+	return platforms, nil
+}
+
+// RegisterFlows builds the platforms cfg enables for the IM of messenger and registers their authorization Flows in
+// sched, it returns the built platforms for the posting Flow. Platforms that log in when loading the user's config
+// give up once ctx is done.
+func (r *Registry) RegisterFlows(ctx context.Context, cfg *config.Config, userID uint64, messenger im.Messenger,
+	sched *im.FlowScheduler) (map[config.AvailableBloggingPlatform]AuthedPlatform, error) {
+	names := cfg.PlatformsFor(config.AvailableIM(messenger.Name()))
+	platforms, err := r.Platforms(names...)
+	if err != nil {
+		return nil, err
+	}
+	// in config order, which is how they are listed to users.
+	for _, name := range names {
+		platform := platforms[name]
+		// it was found building the platforms.
+		registration, _ := r.registration(name)
+		authFlow := NewAuthorizerFlow(platform, r.authFlowOpts...)
+		authFlow.logger = r.logger.With("platform", name)
+		if err := sched.RegisterFlow(authFlow, registration.AuthFlow, []string{"/" + registration.AuthFlow},
+			im.WithDescription(registration.AuthDescription)); err != nil {
+			return nil, fmt.Errorf("%s auth flow: %w", name, err)
+		}
+		// done only for effect, this will trigger a load of user config
+		isAuthorized(ctx, platform, UserID(userID))
+	}
+	return platforms, nil
+}
+
+// SchedulerFactory returns an im.SchedulerFactoryFN building, for each user, the authorization Flows of the platforms
+// cfg enables and the posting Flow for them, which runs its scheduled posts until ctx is done. Everything is kept in
+// store, which, like the platforms of the registry, must be used by a single IM, user IDs of different IMs could be
+// the same number (see secrets.EncryptedStore.Sub).
+func (r *Registry) SchedulerFactory(ctx context.Context, cfg *config.Config, store *secrets.EncryptedStore,
+	schedulerOpts []im.SchedulerOption, postingOpts ...PostingFlowOption) im.SchedulerFactoryFN {
+	return func(userID uint64, messenger im.Messenger) (*im.FlowScheduler, error) {
+		opts := schedulerOpts
+		if store != nil {
+			// the active flows are restored after a restart, the posting one resumes with its drafts.
+			opts = append(slices.Clone(schedulerOpts), im.WithFlowStore(NewActiveFlowStore(store), userID))
+		}
+		sched := im.NewScheduler(opts...)
+		platforms, err := r.RegisterFlows(ctx, cfg, userID, messenger, sched)
+		if err != nil {
+			r.logger.Error("registering platform flows", "user_id", userID, "err", err)
+			return nil, err
+		}
+
+		// platforms are posted to in config order, unless postingOpts say otherwise.
+		order := WithPlatformOrder(cfg.PlatformsFor(config.AvailableIM(messenger.Name()))...)
+		postingFlow := NewPostingFlow(platforms, store, append([]PostingFlowOption{order}, postingOpts...)...)
+		r.status.addPostingFlow(config.AvailableIM(messenger.Name()), userID, postingFlow)
+		if err = postingFlow.LoadDrafts(userID); err != nil {
+			r.logger.Error("loading drafts", "user_id", userID, "err", err)
+		}
+		if err = postingFlow.LoadScheduled(userID); err != nil {
+			r.logger.Error("loading scheduled posts", "user_id", userID, "err", err)
+		}
+		go postingFlow.RunScheduled(ctx, messenger)
+		// /send and /preview start the flow too, the draft outlives the flow closing for being idle and they must not
+		// be dropped when it does.
+		if err = sched.RegisterFlow(postingFlow, "microblog_post", []string{"/new", "/crosspost", "/undo", "/schedule", "/settings", "/template", "/send", "/preview"},
+			im.WithDescription("write a post (then /preview, /alt, /cw, /send, /schedule or /cancel), crosspost an existing one, /undo the last one or change your /settings")); err != nil {
+			r.logger.Error("registering microblog post flow", "user_id", userID, "err", err)
+			return nil, fmt.Errorf("microblog post flow: %w", err)
+		}
+		if err = sched.RegisterFlow(NewStatusFlow(r.status, platforms), "status", []string{"/status"},
+			im.WithDescription("how the bot is doing and which platforms you are connected to")); err != nil {
+			r.logger.Error("registering status flow", "user_id", userID, "err", err)
+			return nil, fmt.Errorf("status flow: %w", err)
+		}
+		accountsFlow := NewAccountsFlow(platforms)
+		accountsFlow.logger = r.logger
+		if err = sched.RegisterFlow(accountsFlow, "accounts", []string{"/accounts", "/disconnect"},
+			im.WithDescription("list the platforms you are connected to (/accounts) or /disconnect from one")); err != nil {
+			r.logger.Error("registering accounts flow", "user_id", userID, "err", err)
+			return nil, fmt.Errorf("accounts flow: %w", err)
+		}
+		return sched, nil
+	}
+}
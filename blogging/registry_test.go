@@ -0,0 +1,111 @@
+package blogging
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/perrito666/chat2world/config"
+	"github.com/perrito666/chat2world/im"
+)
+
+// newTestRegistry returns a registry of fake mastodon and bluesky platforms, built tells how many times each was.
+func newTestRegistry(t *testing.T) (*Registry, map[config.AvailableBloggingPlatform]*atomic.Int32) {
+	t.Helper()
+	r := NewRegistry()
+	built := make(map[config.AvailableBloggingPlatform]*atomic.Int32)
+	for _, name := range []config.AvailableBloggingPlatform{config.MBPMastodon, config.MBPBsky} {
+		built[name] = &atomic.Int32{}
+		err := r.Register(PlatformRegistration{
+			Name: name,
+			New: func() (AuthedPlatform, error) {
+				built[name].Add(1)
+				return &fakePlatform{}, nil
+			},
+			AuthFlow:        string(name) + "_auth",
+			AuthDescription: "connect your " + string(name) + " account",
+		})
+		if err != nil {
+			t.Fatalf("registering %s: %v", name, err)
+		}
+	}
+	return r, built
+}
+
+func TestSchedulerFactoryBuildsOnlyEnabledPlatforms(t *testing.T) {
+	r, built := newTestRegistry(t)
+	cfg := config.NewConfig()
+	cfg.EnabledBloggingPlatforms = []config.AvailableBloggingPlatform{config.MBPBsky}
+	cfg.AvailableInteractions = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	messenger := &recordingMessenger{}
+	sched, err := r.SchedulerFactory(ctx, cfg, nil, nil)(testUser, messenger)
+	if err != nil {
+		t.Fatalf("building the scheduler: %v", err)
+	}
+	if n := built[config.MBPBsky].Load(); n != 1 {
+		t.Errorf("bluesky was built %d times, want once", n)
+	}
+	if n := built[config.MBPMastodon].Load(); n != 0 {
+		t.Errorf("mastodon was built %d times, it is not enabled", n)
+	}
+
+	if err := sched.HandleMessage(ctx, &im.Message{UserID: testUser, Text: "/help"}, messenger); err != nil {
+		t.Fatalf("/help: %v", err)
+	}
+	help := messenger.last()
+	if !strings.Contains(help, "/bluesky_auth") {
+		t.Errorf("/help = %q, want /bluesky_auth in it", help)
+	}
+	if strings.Contains(help, "/mastodon_auth") {
+		t.Errorf("/help = %q, it offers to authorize mastodon, which is not enabled", help)
+	}
+}
+
+func TestRegistryRefusesUnknownPlatforms(t *testing.T) {
+	r, _ := newTestRegistry(t)
+	if _, err := r.Platforms(config.MBPNostr); !errors.Is(err, ErrPlatformNotRegistered) {
+		t.Errorf("building nostr: err = %v, want ErrPlatformNotRegistered", err)
+	}
+	if _, err := r.Platforms(config.PlatformAccount(config.MBPBsky, "work")); !errors.Is(err, ErrNoAccounts) {
+		t.Errorf("building an account of bluesky: err = %v, want ErrNoAccounts", err)
+	}
+	if err := r.Register(PlatformRegistration{Name: config.MBPBsky}); !errors.Is(err, ErrPlatformAlreadyRegistered) {
+		t.Errorf("registering bluesky again: err = %v, want ErrPlatformAlreadyRegistered", err)
+	}
+}
+
+func TestDraftCanBeSentAfterThePostingFlowIdles(t *testing.T) {
+	r, _ := newTestRegistry(t)
+	cfg := config.NewConfig()
+	cfg.EnabledBloggingPlatforms = []config.AvailableBloggingPlatform{config.MBPMastodon}
+	cfg.AvailableInteractions = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	now := time.Now()
+	opts := []im.SchedulerOption{im.WithIdleTimeout(time.Minute), im.WithClock(func() time.Time { return now }),
+		im.WithConcurrentFlows()}
+
+	messenger := &recordingMessenger{}
+	sched, err := r.SchedulerFactory(ctx, cfg, nil, opts)(testUser, messenger)
+	if err != nil {
+		t.Fatalf("building the scheduler: %v", err)
+	}
+	for _, text := range []string{"/new", "still here"} {
+		if err := sched.HandleMessage(ctx, &im.Message{UserID: testUser, Text: text}, messenger); err != nil {
+			t.Fatalf("handling %q: %v", text, err)
+		}
+	}
+	now = now.Add(2 * time.Minute)
+	if err := sched.HandleMessage(ctx, &im.Message{UserID: testUser, Text: "/preview"}, messenger); err != nil {
+		t.Fatalf("/preview: %v", err)
+	}
+	if got := messenger.last(); !strings.Contains(got, "still here") {
+		t.Errorf("/preview after the flow idled = %q, want the draft", got)
+	}
+}
@@ -0,0 +1,361 @@
+package blogging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/perrito666/chat2world/config"
+	"github.com/perrito666/chat2world/im"
+)
+
+// ScheduledPost is a post waiting to be sent at a given time, it remembers the chat it was scheduled from so the
+// user can be told how it went. Native holds the platforms that took the post to publish it themselves, with the ID
+// they gave it, those are left out when the time comes.
+type ScheduledPost struct {
+	ID     string                                      `json:"id"`
+	At     time.Time                                   `json:"at"`
+	Post   *MicroblogPost                              `json:"post"`
+	UserID uint64                                      `json:"user_id"`
+	ChatID int64                                       `json:"chat_id"`
+	Native map[config.AvailableBloggingPlatform]string `json:"native,omitempty"`
+}
+
+// scheduleTimeLayouts are the accepted formats for /schedule, the ones without offset are in the default location.
+var (
+	scheduleTimeLayoutsWithOffset = []string{time.RFC3339, "2006-01-02T15:04Z07:00"}
+	scheduleTimeLayouts           = []string{"2006-01-02T15:04:05", "2006-01-02T15:04"}
+)
+
+// ErrScheduleInThePast is returned when trying to schedule a post for a time that already passed.
+var ErrScheduleInThePast = errors.New("scheduled time is in the past")
+
+// parseScheduleTime parses the time given to /schedule, if it carries no offset it is taken to be in loc.
+func parseScheduleTime(value string, loc *time.Location) (time.Time, error) {
+	for _, layout := range scheduleTimeLayoutsWithOffset {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range scheduleTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a time like 2025-01-02T15:04 or 2025-01-02T15:04-03:00", value)
+}
+
+// scheduledPath returns the name of the file holding the scheduled posts of a user.
+func scheduledPath(userID uint64) string {
+	return fmt.Sprintf("%d.scheduled.json", userID)
+}
+
+// newScheduleID returns a short random identifier for a scheduled post.
+func newScheduleID() string {
+	b := make([]byte, 3)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// saveScheduled persists the scheduled posts of the user, the caller must hold scheduledMutex.
+func (p *PostingFlow) saveScheduled(userID uint64) error {
+	if p.store == nil {
+		return nil
+	}
+	if len(p.scheduled[userID]) == 0 {
+		return p.store.Remove(scheduledPath(userID))
+	}
+	f, err := p.store.OpenWriter(scheduledPath(userID))
+	if err != nil {
+		return fmt.Errorf("opening scheduled posts to write: %w", err)
+	}
+	err = json.NewEncoder(f).Encode(p.scheduled[userID])
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("writing scheduled posts: %w", err)
+	}
+	return nil
+}
+
+// LoadScheduled restores the persisted scheduled posts, if any, of the given users.
+func (p *PostingFlow) LoadScheduled(userIDs ...uint64) error {
+	if p.store == nil {
+		return nil
+	}
+	for _, userID := range userIDs {
+		f, err := p.store.OpenReader(scheduledPath(userID))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return fmt.Errorf("opening scheduled posts to read: %w", err)
+		}
+		var scheduled []*ScheduledPost
+		err = json.NewDecoder(f).Decode(&scheduled)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("reading scheduled posts for user %d: %w", userID, err)
+		}
+		p.scheduledMutex.Lock()
+		p.scheduled[userID] = scheduled
+		p.scheduledMutex.Unlock()
+	}
+	p.wakeDispatcher()
+	return nil
+}
+
+// wakeDispatcher tells RunScheduled the scheduled posts changed.
+func (p *PostingFlow) wakeDispatcher() {
+	select {
+	case p.scheduleChanged <- struct{}{}:
+	default:
+	}
+}
+
+// takeDue removes and returns the scheduled posts that are due, it also returns when the next one is.
+func (p *PostingFlow) takeDue(now time.Time) ([]*ScheduledPost, time.Time) {
+	p.scheduledMutex.Lock()
+	defer p.scheduledMutex.Unlock()
+
+	var due []*ScheduledPost
+	var next time.Time
+	for userID, scheduled := range p.scheduled {
+		var pending []*ScheduledPost
+		for _, sp := range scheduled {
+			if !sp.At.After(now) {
+				due = append(due, sp)
+				continue
+			}
+			pending = append(pending, sp)
+			if next.IsZero() || sp.At.Before(next) {
+				next = sp.At
+			}
+		}
+		if len(pending) != len(scheduled) {
+			p.scheduled[userID] = pending
+			if err := p.saveScheduled(userID); err != nil {
+				p.logger.Error("saving scheduled posts", "user_id", userID, "err", err)
+			}
+		}
+	}
+	return due, next
+}
+
+// maxDispatcherSleep bounds how long RunScheduled sleeps, so a clock jump does not delay posts too much.
+const maxDispatcherSleep = time.Minute
+
+// RunScheduled sends the scheduled posts when their time comes, telling the user through messenger how it went. It
+// runs until ctx is canceled, posts that came due while it was not running are sent as soon as it starts.
+func (p *PostingFlow) RunScheduled(ctx context.Context, messenger im.Messenger) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.scheduleChanged:
+		case <-timer.C:
+		}
+		due, next := p.takeDue(p.now())
+		for _, sp := range due {
+			notice := &im.Message{ChatID: sp.ChatID, UserID: sp.UserID}
+			err := p.publish(ctx, sp.UserID, sp.Post, sp.Native, func(text string) error {
+				notice.Text = fmt.Sprintf("Scheduled post %s: %s", sp.ID, text)
+				return messenger.SendMessage(ctx, notice)
+			})
+			if err != nil {
+				p.logger.Error("sending scheduled post", "user_id", sp.UserID, "scheduled_id", sp.ID, "err", err)
+			}
+		}
+		sleep := maxDispatcherSleep
+		if !next.IsZero() {
+			sleep = min(max(next.Sub(p.now()), 0), maxDispatcherSleep)
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(sleep)
+	}
+}
+
+// scheduleCommandHandler handles /schedule <time> [tz=<location>], which schedules the active post, /schedule list
+// and /schedule cancel <id>.
+func (p *PostingFlow) scheduleCommandHandler(ctx context.Context, message *im.Message, messenger im.Messenger) error {
+	_, args, err := message.AsCommand(p.StartCommandParser)
+	if err != nil {
+		return fmt.Errorf("parsing /schedule message (%s): %w", message.Text, err)
+	}
+	var response string
+	switch {
+	case len(args) == 0:
+		response = "Usage: /schedule <2025-01-02T15:04[-03:00]> [tz=America/Buenos_Aires], /schedule list or /schedule cancel <id>"
+	case args[0] == "list":
+		response = p.listScheduled(message.UserID)
+	case args[0] == "cancel":
+		if len(args) != 2 {
+			response = "Usage: /schedule cancel <id>"
+			break
+		}
+		response = p.cancelScheduled(ctx, message.UserID, args[1])
+	default:
+		response, err = p.schedule(ctx, message, args)
+		if err != nil {
+			return err
+		}
+	}
+	err = messenger.SendMessage(ctx, message.Reply(response))
+	if err != nil {
+		return fmt.Errorf("messenger send message err: %w", err)
+	}
+	return nil
+}
+
+// schedule moves the active post of the user to the scheduled ones and returns the response for the user.
+func (p *PostingFlow) schedule(ctx context.Context, message *im.Message, args []string) (string, error) {
+	userID := message.UserID
+	kv, positional := argsIntoMaps(args)
+	loc := p.location
+	if tz, ok := kv["tz"]; ok {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			return fmt.Sprintf("Unknown time zone %s.", tz), nil
+		}
+	}
+	if len(positional) != 1 {
+		return "Usage: /schedule <2025-01-02T15:04[-03:00]> [tz=America/Buenos_Aires]", nil
+	}
+	at, err := parseScheduleTime(positional[0], loc)
+	if err != nil {
+		return fmt.Sprintf("Could not schedule: %v", err), nil
+	}
+	if !at.After(p.now()) {
+		return fmt.Sprintf("Could not schedule: %v.", ErrScheduleInThePast), nil
+	}
+
+	p.postsMutex.Lock()
+	if missing := p.missingAltText(userID); missing != "" {
+		p.postsMutex.Unlock()
+		return missing, nil
+	}
+	post, exists := p.posts[userID]
+	if exists {
+		delete(p.posts, userID)
+	}
+	p.postsMutex.Unlock()
+	if !exists {
+		return "No active post to schedule. Use /new to start a post.", nil
+	}
+	p.saveDraftOrLog(userID)
+	p.resolveLangs(userID, post)
+
+	sp := &ScheduledPost{
+		ID:     newScheduleID(),
+		At:     at,
+		Post:   post,
+		UserID: userID,
+		ChatID: message.ChatID,
+		Native: make(map[config.AvailableBloggingPlatform]string),
+	}
+	var response strings.Builder
+	fmt.Fprintf(&response, "Post scheduled for %s (id %s).", at.Format(time.RFC1123Z), sp.ID)
+	// Platforms that can hold the post themselves get it now, the rest get it from RunScheduled.
+	for pname, platform := range p.platforms {
+		ns, ok := platform.(NativeScheduler)
+		if !ok {
+			continue
+		}
+		fitted, err := p.fitFor(pname, post, "")
+		if err != nil {
+			fmt.Fprintf(&response, "\n%s will not get it: %v", pname, err)
+			continue
+		}
+		scheduledID, err := ns.PostAt(ctx, UserID(userID), fitted.renderedFor(pname), at)
+		if err != nil {
+			p.logger.Warn("scheduling natively", "user_id", userID, "platform", pname, "err", err)
+			continue
+		}
+		sp.Native[pname] = scheduledID
+		fmt.Fprintf(&response, "\n%s holds it as scheduled status %s, it gets a URL once published.", pname, scheduledID)
+	}
+	p.scheduledMutex.Lock()
+	p.scheduled[userID] = append(p.scheduled[userID], sp)
+	err = p.saveScheduled(userID)
+	p.scheduledMutex.Unlock()
+	if err != nil {
+		p.logger.Error("saving scheduled posts", "user_id", userID, "err", err)
+	}
+	p.wakeDispatcher()
+	return response.String(), nil
+}
+
+// listScheduled describes the scheduled posts of the user, soonest first.
+func (p *PostingFlow) listScheduled(userID uint64) string {
+	p.scheduledMutex.Lock()
+	scheduled := append([]*ScheduledPost(nil), p.scheduled[userID]...)
+	p.scheduledMutex.Unlock()
+	if len(scheduled) == 0 {
+		return "No scheduled posts."
+	}
+	sort.Slice(scheduled, func(i, j int) bool { return scheduled[i].At.Before(scheduled[j].At) })
+	var sb strings.Builder
+	sb.WriteString("Scheduled posts:")
+	for _, sp := range scheduled {
+		summary := []rune(strings.ReplaceAll(sp.Post.Text, "\n", " "))
+		if len(summary) > 40 {
+			summary = append(summary[:40], '…')
+		}
+		fmt.Fprintf(&sb, "\n%s %s: %s", sp.ID, sp.At.In(p.location).Format(time.RFC1123Z), string(summary))
+		for pname, scheduledID := range sp.Native {
+			fmt.Fprintf(&sb, " (%s: scheduled status %s)", pname, scheduledID)
+		}
+	}
+	return sb.String()
+}
+
+// cancelScheduled discards the scheduled post with the given id, also from the platforms holding it.
+func (p *PostingFlow) cancelScheduled(ctx context.Context, userID uint64, id string) string {
+	p.scheduledMutex.Lock()
+	var canceled *ScheduledPost
+	scheduled := p.scheduled[userID]
+	for i, sp := range scheduled {
+		if sp.ID != id {
+			continue
+		}
+		canceled = sp
+		p.scheduled[userID] = append(scheduled[:i], scheduled[i+1:]...)
+		if err := p.saveScheduled(userID); err != nil {
+			p.logger.Error("saving scheduled posts", "user_id", userID, "err", err)
+		}
+		break
+	}
+	p.scheduledMutex.Unlock()
+	if canceled == nil {
+		return fmt.Sprintf("No scheduled post with id %s.", id)
+	}
+
+	response := fmt.Sprintf("Scheduled post %s canceled.", id)
+	for pname, scheduledID := range canceled.Native {
+		ns, ok := p.platforms[pname].(NativeScheduler)
+		if !ok {
+			continue
+		}
+		if err := ns.CancelScheduled(ctx, UserID(userID), scheduledID); err != nil {
+			p.logger.Error("canceling scheduled status", "user_id", userID, "platform", pname, "scheduled_id", scheduledID,
+				"err", err)
+			response += fmt.Sprintf("\nCould not cancel it on %s (scheduled status %s): %v", pname, scheduledID, err)
+		}
+	}
+	return response
+}
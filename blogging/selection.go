@@ -0,0 +1,129 @@
+package blogging
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/perrito666/chat2world/config"
+	"github.com/perrito666/chat2world/im"
+)
+
+// Data of the buttons /send offers to pick the platforms, toggling one is selectTogglePrefix followed by its name.
+const (
+	selectTogglePrefix = "send:toggle:"
+	selectConfirm      = "send:confirm"
+	selectAbort        = "send:abort"
+)
+
+// selectionButtons returns the buttons to pick platforms from, one per row showing whether it is picked, followed by
+// the ones to send and to give up.
+func (p *PostingFlow) selectionButtons(selected map[config.AvailableBloggingPlatform]bool) [][]im.Button {
+	var buttons [][]im.Button
+	for _, pname := range p.orderedPlatformNames() {
+		mark := "⬜"
+		if selected[pname] {
+			mark = "✅"
+		}
+		buttons = append(buttons, []im.Button{{Text: mark + " " + string(pname), Data: selectTogglePrefix + string(pname)}})
+	}
+	return append(buttons, []im.Button{{Text: "Send", Data: selectConfirm}, {Text: "Cancel", Data: selectAbort}})
+}
+
+// askPlatforms offers the user buttons to pick which platforms to send the active post to, all of them to begin
+// with, the answer comes to callbackHandler.
+func (p *PostingFlow) askPlatforms(ctx context.Context, message *im.Message, messenger im.Messenger) error {
+	userID := message.UserID
+	p.postsMutex.Lock()
+	_, exists := p.posts[userID]
+	var buttons [][]im.Button
+	if exists {
+		selected := make(map[config.AvailableBloggingPlatform]bool, len(p.platforms))
+		for pname := range p.platforms {
+			selected[pname] = true
+		}
+		p.selections[userID] = selected
+		buttons = p.selectionButtons(selected)
+	}
+	p.postsMutex.Unlock()
+
+	reply := message.Reply("No active post to send. Use /new to start a post.")
+	if exists {
+		reply = message.Reply("Pick the platforms to send to:")
+		reply.Buttons = buttons
+	}
+	if err := messenger.SendMessage(ctx, reply); err != nil {
+		return fmt.Errorf("messenger send message err: %w", err)
+	}
+	return nil
+}
+
+// callbackHandler handles the presses of the buttons offered by askPlatforms, editing the message that holds them to
+// reflect the choice.
+func (p *PostingFlow) callbackHandler(ctx context.Context, message *im.Message, messenger im.Messenger) error {
+	bm, ok := messenger.(im.ButtonMessenger)
+	if !ok {
+		return nil
+	}
+	userID := message.UserID
+	edit := &im.Message{IM: message.IM, ChatID: message.ChatID, UserID: userID, MsgID: message.MsgID}
+
+	p.postsMutex.Lock()
+	selected, asked := p.selections[userID]
+	switch {
+	case !asked:
+		edit.Text = "This choice is no longer valid, use /send again."
+	case strings.HasPrefix(message.CallbackData, selectTogglePrefix):
+		pname := config.AvailableBloggingPlatform(strings.TrimPrefix(message.CallbackData, selectTogglePrefix))
+		if _, known := p.platforms[pname]; known {
+			selected[pname] = !selected[pname]
+		}
+		edit.Text = "Pick the platforms to send to:"
+		edit.Buttons = p.selectionButtons(selected)
+	case message.CallbackData == selectAbort:
+		delete(p.selections, userID)
+		edit.Text = "Not sent, your post is kept, /send it when ready."
+	case message.CallbackData != selectConfirm:
+		// not one of our buttons.
+		p.postsMutex.Unlock()
+		return nil
+	}
+	p.postsMutex.Unlock()
+
+	if message.CallbackData != selectConfirm || !asked {
+		if err := bm.EditMessage(ctx, edit); err != nil {
+			return fmt.Errorf("messenger edit message err: %w", err)
+		}
+		return nil
+	}
+
+	skip := make(map[config.AvailableBloggingPlatform]string)
+	var picked []string
+	p.postsMutex.Lock()
+	for _, pname := range p.orderedPlatformNames() {
+		if selected[pname] {
+			picked = append(picked, string(pname))
+			continue
+		}
+		skip[pname] = ""
+	}
+	if len(picked) > 0 {
+		delete(p.selections, userID)
+	} else {
+		edit.Buttons = p.selectionButtons(selected)
+	}
+	p.postsMutex.Unlock()
+
+	if len(picked) == 0 {
+		edit.Text = "Pick at least one platform to send to:"
+		if err := bm.EditMessage(ctx, edit); err != nil {
+			return fmt.Errorf("messenger edit message err: %w", err)
+		}
+		return nil
+	}
+	edit.Text = "Sending to " + strings.Join(picked, ", ") + "."
+	if err := bm.EditMessage(ctx, edit); err != nil {
+		return fmt.Errorf("messenger edit message err: %w", err)
+	}
+	return p.sendActive(ctx, message, messenger, skip)
+}
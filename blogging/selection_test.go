@@ -0,0 +1,141 @@
+package blogging
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/perrito666/chat2world/config"
+	"github.com/perrito666/chat2world/im"
+)
+
+// buttonMessenger is a recordingMessenger that can also edit messages, keeping the edits.
+type buttonMessenger struct {
+	recordingMessenger
+	editsMu sync.Mutex
+	edits   []*im.Message
+}
+
+func (m *buttonMessenger) EditMessage(_ context.Context, message *im.Message) error {
+	m.editsMu.Lock()
+	defer m.editsMu.Unlock()
+	m.edits = append(m.edits, message)
+	return nil
+}
+
+// lastEdit returns the last edit made.
+func (m *buttonMessenger) lastEdit() *im.Message {
+	m.editsMu.Lock()
+	defer m.editsMu.Unlock()
+	if len(m.edits) == 0 {
+		return &im.Message{}
+	}
+	return m.edits[len(m.edits)-1]
+}
+
+// press sends the press of the button with data to the flow as testUser.
+func press(t *testing.T, p *PostingFlow, messenger im.Messenger, data string) {
+	t.Helper()
+	if err := p.HandleMessage(context.Background(), &im.Message{UserID: testUser, MsgID: 10, CallbackData: data},
+		messenger); err != nil {
+		t.Fatalf("pressing %q: %v", data, err)
+	}
+}
+
+// buttonLabels returns the text of every button, in order.
+func buttonLabels(buttons [][]im.Button) []string {
+	var labels []string
+	for _, row := range buttons {
+		for _, button := range row {
+			labels = append(labels, button.Text)
+		}
+	}
+	return labels
+}
+
+func TestSendSelectsPlatformsWithButtons(t *testing.T) {
+	mastodon, bsky, nostr := &fakePlatform{}, &fakePlatform{}, &fakePlatform{}
+	p := NewPostingFlow(map[config.AvailableBloggingPlatform]AuthedPlatform{
+		config.MBPMastodon: mastodon,
+		config.MBPBsky:     bsky,
+		config.MBPNostr:    nostr,
+	}, nil)
+	messenger := &buttonMessenger{}
+	say(t, p, messenger, "/new")
+	say(t, p, messenger, "only for some")
+	say(t, p, messenger, "/send")
+
+	messenger.mu.Lock()
+	asked := messenger.sent[len(messenger.sent)-1]
+	messenger.mu.Unlock()
+	want := []string{"✅ bluesky", "✅ mastodon", "✅ nostr", "Send", "Cancel"}
+	if got := buttonLabels(asked.Buttons); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("/send offered %q, want %q", got, want)
+	}
+	if len(mastodon.posted())+len(bsky.posted())+len(nostr.posted()) != 0 {
+		t.Fatal("posted before the platforms were picked")
+	}
+
+	press(t, p, messenger, selectTogglePrefix+string(config.MBPBsky))
+	want = []string{"⬜ bluesky", "✅ mastodon", "✅ nostr", "Send", "Cancel"}
+	if got := buttonLabels(messenger.lastEdit().Buttons); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("after unpicking bluesky the buttons are %q, want %q", got, want)
+	}
+	if messenger.lastEdit().MsgID != 10 {
+		t.Errorf("edited message %d, want the one holding the buttons", messenger.lastEdit().MsgID)
+	}
+
+	press(t, p, messenger, selectConfirm)
+	if got := messenger.lastEdit().Text; got != "Sending to mastodon, nostr." {
+		t.Errorf("confirming edited the message to %q, want it to say where it goes", got)
+	}
+	if len(mastodon.posted()) != 1 || len(nostr.posted()) != 1 {
+		t.Errorf("posted %d to mastodon and %d to nostr, want 1 each", len(mastodon.posted()), len(nostr.posted()))
+	}
+	if len(bsky.posted()) != 0 {
+		t.Error("posted to bluesky, which was not picked")
+	}
+
+	// the choice is gone with the post.
+	press(t, p, messenger, selectConfirm)
+	if got := messenger.lastEdit().Text; got != "This choice is no longer valid, use /send again." {
+		t.Errorf("pressing again edited the message to %q, want it to say the choice is gone", got)
+	}
+}
+
+func TestSendToNamedPlatforms(t *testing.T) {
+	mastodon, bsky, nostr := &fakePlatform{}, &fakePlatform{}, &fakePlatform{}
+	p := NewPostingFlow(map[config.AvailableBloggingPlatform]AuthedPlatform{
+		config.MBPMastodon: mastodon,
+		config.MBPBsky:     bsky,
+		config.MBPNostr:    nostr,
+	}, nil)
+	messenger := &buttonMessenger{}
+	say(t, p, messenger, "/new")
+	say(t, p, messenger, "only for some")
+
+	say(t, p, messenger, "/send mastodon myspace")
+	if want := "Unknown platform myspace, see /accounts for your platforms."; messenger.last() != want {
+		t.Errorf("/send to an unknown platform answered %q, want %q", messenger.last(), want)
+	}
+	if len(mastodon.posted())+len(bsky.posted())+len(nostr.posted()) != 0 {
+		t.Fatal("posted while a platform named was unknown")
+	}
+
+	// naming the platforms skips asking for them.
+	say(t, p, messenger, "/send mastodon nostr")
+	if len(mastodon.posted()) != 1 || len(nostr.posted()) != 1 {
+		t.Errorf("posted %d to mastodon and %d to nostr, want 1 each", len(mastodon.posted()), len(nostr.posted()))
+	}
+	if len(bsky.posted()) != 0 {
+		t.Error("posted to bluesky, which was not named")
+	}
+	messenger.mu.Lock()
+	defer messenger.mu.Unlock()
+	for _, sent := range messenger.sent {
+		if len(sent.Buttons) != 0 {
+			t.Errorf("asked %q, want the named platforms used", sent.Text)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package blogging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/perrito666/chat2world/im"
+)
+
+// UserSettings are the preferences of a user for the posting flow, they are changed with /settings.
+type UserSettings struct {
+	// DefaultLangs are the languages of posts started without langs=.
+	DefaultLangs []string `json:"default_langs,omitempty"`
+	// SkipLangDetection makes posts without langs= get the default languages instead of detected ones.
+	SkipLangDetection bool `json:"skip_lang_detection,omitempty"`
+	// RequireAltText refuses to send or schedule posts with images, or a video, without alt text.
+	RequireAltText bool `json:"require_alt_text,omitempty"`
+	// ParagraphPerMessage puts a blank line between the text of each message added to a post instead of a line break.
+	ParagraphPerMessage bool `json:"paragraph_per_message,omitempty"`
+	// Templates are texts posts can start with, by name, see /template.
+	Templates map[string]string `json:"templates,omitempty"`
+}
+
+// separator returns what goes between the texts of the messages added to a post, see AppendText.
+func (s *UserSettings) separator() string {
+	if s.ParagraphPerMessage {
+		return ParagraphSeparator
+	}
+	return LineSeparator
+}
+
+// settingsPath returns the name of the file holding the settings of a user.
+func settingsPath(userID uint64) string {
+	return fmt.Sprintf("%d.settings.json", userID)
+}
+
+// userSettings returns the settings of the user, loading them from the store the first time, the caller must hold
+// postsMutex. Users that never changed them get the zero UserSettings.
+func (p *PostingFlow) userSettings(userID uint64) *UserSettings {
+	if settings, ok := p.settings[userID]; ok {
+		return settings
+	}
+	settings := &UserSettings{}
+	p.settings[userID] = settings
+	if p.store == nil {
+		return settings
+	}
+	f, err := p.store.OpenReader(settingsPath(userID))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			p.logger.Error("opening settings", "user_id", userID, "err", err)
+		}
+		return settings
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(settings); err != nil {
+		p.logger.Error("reading settings", "user_id", userID, "err", err)
+	}
+	return settings
+}
+
+// saveSettings persists the settings of the user, the caller must hold postsMutex.
+func (p *PostingFlow) saveSettings(userID uint64) error {
+	if p.store == nil {
+		return nil
+	}
+	f, err := p.store.OpenWriter(settingsPath(userID))
+	if err != nil {
+		return fmt.Errorf("opening settings to write: %w", err)
+	}
+	err = json.NewEncoder(f).Encode(p.userSettings(userID))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("writing settings: %w", err)
+	}
+	return nil
+}
+
+// parseLangs reads a comma separated list of languages, empty means none.
+func parseLangs(value string) []string {
+	var langs []string
+	for _, lang := range strings.Split(value, ",") {
+		if lang = strings.TrimSpace(lang); lang != "" {
+			langs = append(langs, lang)
+		}
+	}
+	return langs
+}
+
+// describeSettings shows the settings to the user.
+func describeSettings(settings *UserSettings) string {
+	langs := "none, platforms will guess"
+	if len(settings.DefaultLangs) > 0 {
+		langs = strings.Join(settings.DefaultLangs, ",")
+	}
+	return fmt.Sprintf("Settings:\nlangs=%s (languages of posts started without langs=, when they can't be detected)\n"+
+		"detectlang=%t (detect the language of posts started without langs=)\n"+
+		"requirealt=%t (refuse to send posts with media lacking alt text)\n"+
+		"paragraphs=%t (put a blank line, instead of a line break, between the text of each message)", langs,
+		!settings.SkipLangDetection, settings.RequireAltText, settings.ParagraphPerMessage)
+}
+
+// missingAltText returns why the active post of the user can't go out yet if they require alt text and some of its
+// media has none, an empty string if it can. The caller must hold postsMutex.
+func (p *PostingFlow) missingAltText(userID uint64) string {
+	post, exists := p.posts[userID]
+	if !exists || !p.userSettings(userID).RequireAltText {
+		return ""
+	}
+	var missing []string
+	for i, img := range post.Images {
+		if strings.TrimSpace(img.AltText) == "" {
+			missing = append(missing, strconv.Itoa(i+1))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Sprintf("Images without alt text: %s. Add it with /alt <image number> <alt text> "+
+			"(or turn this check off with /settings requirealt=false).", strings.Join(missing, ", "))
+	}
+	if post.Video != nil && strings.TrimSpace(post.Video.AltText) == "" {
+		return "The video has no alt text, send it again with a caption " +
+			"(or turn this check off with /settings requirealt=false)."
+	}
+	return ""
+}
+
+// settingsCommandHandler handles /settings, which shows the settings, and /settings key=value... which changes them.
+func (p *PostingFlow) settingsCommandHandler(ctx context.Context, message *im.Message, messenger im.Messenger) error {
+	userID := message.UserID
+	_, args, err := message.AsCommand(p.StartCommandParser)
+	if err != nil {
+		return fmt.Errorf("parsing /settings message (%s): %w", message.Text, err)
+	}
+	kv, positional := argsIntoMaps(args)
+
+	var response string
+	p.postsMutex.Lock()
+	settings := p.userSettings(userID)
+	var unknown []string
+	for key, value := range kv {
+		switch key {
+		case "langs", "lang":
+			settings.DefaultLangs = parseLangs(value)
+		case "detectlang":
+			detect, err := strconv.ParseBool(value)
+			if err != nil {
+				unknown = append(unknown, key+"="+value)
+				continue
+			}
+			settings.SkipLangDetection = !detect
+		case "requirealt":
+			require, err := strconv.ParseBool(value)
+			if err != nil {
+				unknown = append(unknown, key+"="+value)
+				continue
+			}
+			settings.RequireAltText = require
+		case "paragraphs":
+			paragraphs, err := strconv.ParseBool(value)
+			if err != nil {
+				unknown = append(unknown, key+"="+value)
+				continue
+			}
+			settings.ParagraphPerMessage = paragraphs
+		default:
+			unknown = append(unknown, key)
+		}
+	}
+	unknown = append(unknown, positional...)
+	switch {
+	case len(unknown) > 0:
+		response = fmt.Sprintf("Unknown settings: %s\n%s", strings.Join(unknown, ", "), describeSettings(settings))
+	case len(kv) > 0:
+		if err := p.saveSettings(userID); err != nil {
+			p.logger.Error("saving settings", "user_id", userID, "err", err)
+		}
+		response = "Saved. " + describeSettings(settings)
+	default:
+		response = describeSettings(settings)
+	}
+	p.postsMutex.Unlock()
+
+	err = messenger.SendMessage(ctx, message.Reply(response))
+	if err != nil {
+		return fmt.Errorf("messenger send message err: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package blogging
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/perrito666/chat2world/config"
+	"github.com/perrito666/chat2world/im"
+)
+
+func TestLanguagePrecedence(t *testing.T) {
+	store := newTestStore()
+	platform := &fakePlatform{}
+	platforms := map[config.AvailableBloggingPlatform]AuthedPlatform{config.MBPMastodon: platform}
+	messenger := &recordingMessenger{}
+	p := NewPostingFlow(platforms, store)
+	// send posts text started with newCommand and returns the languages it went out with.
+	send := func(newCommand, text string) []string {
+		t.Helper()
+		say(t, p, messenger, newCommand)
+		say(t, p, messenger, text)
+		say(t, p, messenger, "/send")
+		posted := platform.posted()
+		return posted[len(posted)-1].Langs
+	}
+
+	say(t, p, messenger, "/settings detectlang=false")
+	if got := send("/new", "no languages anywhere"); len(got) != 0 {
+		t.Errorf("without defaults posted with %q, want none", got)
+	}
+	say(t, p, messenger, "/settings langs=de,en")
+	if got := send("/new", "the defaults apply"); !slices.Equal(got, []string{"de", "en"}) {
+		t.Errorf("posted with %q, want the defaults [de en]", got)
+	}
+	if got := send("/new langs=pt", "langs= wins over the defaults"); !slices.Equal(got, []string{"pt"}) {
+		t.Errorf("posted with %q, want [pt] as given", got)
+	}
+
+	// the defaults are the user's, they outlive the flow.
+	p = NewPostingFlow(platforms, store)
+	if got := send("/new", "after a restart"); !slices.Equal(got, []string{"de", "en"}) {
+		t.Errorf("after a restart posted with %q, want the defaults [de en]", got)
+	}
+}
+
+func TestRequireAltTextBlocksSend(t *testing.T) {
+	platform := &fakePlatform{}
+	p := NewPostingFlow(map[config.AvailableBloggingPlatform]AuthedPlatform{config.MBPMastodon: platform}, nil)
+	messenger := &recordingMessenger{}
+	say(t, p, messenger, "/settings requirealt=true")
+	say(t, p, messenger, "/new")
+	if err := p.HandleMessage(context.Background(), &im.Message{UserID: testUser,
+		Images: []*im.Image{{Data: []byte("image"), Caption: "a cat"}, {Data: []byte("image")}}}, messenger); err != nil {
+		t.Fatalf("adding images: %v", err)
+	}
+
+	say(t, p, messenger, "/send")
+	if got := messenger.last(); !strings.HasPrefix(got, "Images without alt text: 2.") {
+		t.Errorf("/send answered %q, want it to name the image without alt text", got)
+	}
+	if len(platform.posted()) != 0 {
+		t.Fatal("posted images without alt text")
+	}
+
+	say(t, p, messenger, "/alt 2 another cat")
+	say(t, p, messenger, "/send")
+	if len(platform.posted()) != 1 {
+		t.Errorf("posted %d times once every image had alt text, want 1", len(platform.posted()))
+	}
+}
+
+func TestAltTextIsNotRequiredByDefault(t *testing.T) {
+	platform := &fakePlatform{}
+	p := NewPostingFlow(map[config.AvailableBloggingPlatform]AuthedPlatform{config.MBPMastodon: platform}, nil)
+	messenger := &recordingMessenger{}
+	say(t, p, messenger, "/new")
+	if err := p.HandleMessage(context.Background(), &im.Message{UserID: testUser,
+		Images: []*im.Image{{Data: []byte("image")}}}, messenger); err != nil {
+		t.Fatalf("adding an image: %v", err)
+	}
+	say(t, p, messenger, "/send")
+	if len(platform.posted()) != 1 {
+		t.Errorf("posted %d times, want the image to go out without alt text", len(platform.posted()))
+	}
+}
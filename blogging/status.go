@@ -0,0 +1,137 @@
+package blogging
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/perrito666/chat2world/config"
+	"github.com/perrito666/chat2world/im"
+)
+
+// Status gathers what the bot reports about itself as a whole, it is shared by the flows of every user.
+type Status struct {
+	started time.Time
+	now     func() time.Time
+
+	mu sync.Mutex
+	// postingFlows are the posting Flows of every user of every IM, they hold the drafts.
+	postingFlows map[imUser]*PostingFlow
+}
+
+// imUser tells apart users of different IMs, their IDs could be the same number.
+type imUser struct {
+	im     config.AvailableIM
+	userID uint64
+}
+
+// NewStatus creates a Status, the bot is considered up since now.
+func NewStatus() *Status {
+	return &Status{
+		started:      time.Now(),
+		now:          time.Now,
+		postingFlows: make(map[imUser]*PostingFlow),
+	}
+}
+
+// Uptime returns for how long the bot has been up.
+func (s *Status) Uptime() time.Duration {
+	return s.now().Sub(s.started)
+}
+
+// addPostingFlow makes the drafts of the posting Flow of userID, of the IM imName, count.
+func (s *Status) addPostingFlow(imName config.AvailableIM, userID uint64, p *PostingFlow) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.postingFlows[imUser{im: imName, userID: userID}] = p
+}
+
+// ActiveDrafts returns how many posts are being written, among every user.
+func (s *Status) ActiveDrafts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var drafts int
+	for _, p := range s.postingFlows {
+		p.postsMutex.Lock()
+		drafts += len(p.posts)
+		p.postsMutex.Unlock()
+	}
+	return drafts
+}
+
+// StatusFlow answers /status with how the bot is doing and which platforms the user is connected to. It is answered
+// right away, the flow finishes as soon as it starts.
+type StatusFlow struct {
+	status    *Status
+	platforms map[config.AvailableBloggingPlatform]AuthedPlatform
+}
+
+var _ im.Flow = (*StatusFlow)(nil)
+
+// NewStatusFlow creates a StatusFlow reporting status and the platforms of a user.
+func NewStatusFlow(status *Status, platforms map[config.AvailableBloggingPlatform]AuthedPlatform) *StatusFlow {
+	return &StatusFlow{
+		status:    status,
+		platforms: platforms,
+	}
+}
+
+// StartCommandParser implements im.Flow and will do a simple split.
+func (s *StatusFlow) StartCommandParser(str string) (string, []string, error) {
+	parts := strings.Fields(str)
+	if len(parts) < 1 {
+		return "", nil, im.ErrNotACommand
+	}
+	return parts[0], parts[1:], nil
+}
+
+// Start implements im.Flow, it answers /status and finishes.
+func (s *StatusFlow) Start(ctx context.Context, message *im.Message, messenger im.Messenger) error {
+	if err := messenger.SendMessage(ctx, message.Reply(s.describe(UserID(message.UserID)))); err != nil {
+		return fmt.Errorf("messenger send message err: %w", err)
+	}
+	return im.ErrFlowFinished
+}
+
+// HandleMessage implements im.Flow, the flow is over once started so there is nothing left to handle.
+func (s *StatusFlow) HandleMessage(context.Context, *im.Message, im.Messenger) error {
+	return im.ErrFlowFinished
+}
+
+// describe reports the status to userID.
+func (s *StatusFlow) describe(userID UserID) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Up for %s.\n", s.status.Uptime().Round(time.Second))
+	fmt.Fprintf(&sb, "Posts being written: %d.\n", s.status.ActiveDrafts())
+	if len(s.platforms) == 0 {
+		sb.WriteString("No platforms available.")
+		return sb.String()
+	}
+	sb.WriteString("Platforms:\n")
+	for _, pname := range sortedNames(s.platforms) {
+		fmt.Fprintf(&sb, "  %s: %s\n", pname, s.platformStatus(userID, s.platforms[pname]))
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// platformStatus describes whether userID is connected to platform and, for those with sessions, until when.
+func (s *StatusFlow) platformStatus(userID UserID, platform AuthedPlatform) string {
+	if !platform.IsAuthorized(userID) {
+		return "not connected"
+	}
+	expirer, ok := platform.(SessionExpirer)
+	if !ok {
+		return "connected"
+	}
+	expiry, err := expirer.SessionExpiry(userID)
+	if err != nil {
+		return fmt.Sprintf("connected, session unknown (%v)", err)
+	}
+	left := expiry.Sub(s.status.now())
+	if left <= 0 {
+		return "connected, session expired, it is renewed when needed"
+	}
+	return fmt.Sprintf("connected, session valid for %s", left.Round(time.Minute))
+}
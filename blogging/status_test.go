@@ -0,0 +1,60 @@
+package blogging
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/perrito666/chat2world/config"
+	"github.com/perrito666/chat2world/im"
+)
+
+// sessionPlatform is an authorized fakePlatform whose session expires at expiry, unless err is set.
+type sessionPlatform struct {
+	fakePlatform
+	expiry time.Time
+	err    error
+}
+
+func (f *sessionPlatform) SessionExpiry(UserID) (time.Time, error) {
+	return f.expiry, f.err
+}
+
+func TestStatusReportsPlatformAuthorization(t *testing.T) {
+	started := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+	now := started.Add(90 * time.Minute)
+	status := NewStatus()
+	status.started, status.now = started, func() time.Time { return now }
+
+	// a draft being written by someone else counts too.
+	drafting := NewPostingFlow(map[config.AvailableBloggingPlatform]AuthedPlatform{config.MBPMastodon: &fakePlatform{}},
+		nil)
+	status.addPostingFlow(config.IMTelegram, 2, drafting)
+	say(t, drafting, &recordingMessenger{}, "/new")
+
+	platforms := map[config.AvailableBloggingPlatform]AuthedPlatform{
+		config.MBPMastodon: &fakePlatform{},
+		config.MBPNostr:    &forgettingPlatform{forgotten: true},
+		config.MBPBsky:     &sessionPlatform{expiry: now.Add(2 * time.Hour)},
+		"bluesky:work":     &sessionPlatform{err: errors.New("no session")},
+		"bluesky:old":      &sessionPlatform{expiry: now.Add(-time.Minute)},
+	}
+	messenger := &recordingMessenger{}
+	err := NewStatusFlow(status, platforms).Start(context.Background(), &im.Message{UserID: testUser, Text: "/status"},
+		messenger)
+	if !errors.Is(err, im.ErrFlowFinished) {
+		t.Fatalf("Start() = %v, want ErrFlowFinished", err)
+	}
+	want := "Up for 1h30m0s.\n" +
+		"Posts being written: 1.\n" +
+		"Platforms:\n" +
+		"  bluesky: connected, session valid for 2h0m0s\n" +
+		"  bluesky:old: connected, session expired, it is renewed when needed\n" +
+		"  bluesky:work: connected, session unknown (no session)\n" +
+		"  mastodon: connected\n" +
+		"  nostr: not connected"
+	if got := messenger.last(); got != want {
+		t.Errorf("/status answered %q, want %q", got, want)
+	}
+}
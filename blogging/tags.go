@@ -0,0 +1,67 @@
+package blogging
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/perrito666/chat2world/config"
+)
+
+// hashtagPlatforms are the platforms that get the tags of a post as hashtags at the end of its text, the rest have a
+// place of their own for them (i.e. front matter).
+var hashtagPlatforms = []config.AvailableBloggingPlatform{config.MBPMastodon, config.MBPBsky}
+
+var (
+	// tagRegex is what a tag can be, what every platform recognizes as a hashtag after a #.
+	tagRegex = regexp.MustCompile(`^\w+$`)
+	// textHashtagRegex finds the hashtags already in a text.
+	textHashtagRegex = regexp.MustCompile(`(?:^|\s)#(\w+)`)
+)
+
+// ParseTags turns the words of s into tags, dropping the leading # and repetitions, it returns the words that are not
+// valid tags apart.
+func ParseTags(s string) (tags []string, invalid []string) {
+	for _, word := range strings.Fields(s) {
+		tag := strings.TrimLeft(word, "#＃")
+		if !tagRegex.MatchString(tag) {
+			invalid = append(invalid, word)
+			continue
+		}
+		if !slices.ContainsFunc(tags, func(t string) bool { return strings.EqualFold(t, tag) }) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, invalid
+}
+
+// Hashtags renders tags as hashtags, i.e. "#foo #bar".
+func Hashtags(tags []string) string {
+	hashtags := make([]string, len(tags))
+	for i, tag := range tags {
+		hashtags[i] = "#" + tag
+	}
+	return strings.Join(hashtags, " ")
+}
+
+// appendHashtags adds the tags that text does not already have as hashtags at its end, in a paragraph of their own.
+func appendHashtags(text string, tags []string) string {
+	present := map[string]bool{}
+	for _, m := range textHashtagRegex.FindAllStringSubmatch(text, -1) {
+		present[strings.ToLower(m[1])] = true
+	}
+	var missing []string
+	for _, tag := range tags {
+		if !present[strings.ToLower(tag)] {
+			present[strings.ToLower(tag)] = true
+			missing = append(missing, tag)
+		}
+	}
+	if len(missing) == 0 {
+		return text
+	}
+	if strings.TrimSpace(text) == "" {
+		return Hashtags(missing)
+	}
+	return strings.TrimRight(text, " \n") + "\n\n" + Hashtags(missing)
+}
@@ -0,0 +1,42 @@
+package blogging
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/perrito666/chat2world/config"
+)
+
+func TestParseTags(t *testing.T) {
+	tags, invalid := ParseTags("#golang go #Golang ＃fediverse not-a-tag #")
+	if want := []string{"golang", "go", "fediverse"}; !slices.Equal(tags, want) {
+		t.Errorf("ParseTags() tags = %q, want %q", tags, want)
+	}
+	if want := []string{"not-a-tag", "#"}; !slices.Equal(invalid, want) {
+		t.Errorf("ParseTags() invalid = %q, want %q", invalid, want)
+	}
+}
+
+func TestTagsAreAppendedOnce(t *testing.T) {
+	post := &MicroblogPost{Text: "writing some #golang today", Tags: []string{"GoLang", "fediverse", "Fediverse"}}
+	want := "writing some #golang today\n\n#fediverse"
+	for _, platform := range hashtagPlatforms {
+		if got := post.RenderFor(platform); got != want {
+			t.Errorf("RenderFor(%s) = %q, want %q", platform, got, want)
+		}
+		// rendering the rendered post again adds nothing.
+		again := &MicroblogPost{Text: post.RenderFor(platform), Tags: post.Tags}
+		if got := again.RenderFor(platform); got != want {
+			t.Errorf("RenderFor(%s) of a rendered post = %q, want %q", platform, got, want)
+		}
+	}
+	if got := (&MicroblogPost{Tags: []string{"golang"}}).RenderFor(config.MBPMastodon); got != "#golang" {
+		t.Errorf("RenderFor() without text = %q, want just the hashtags", got)
+	}
+	// the rest of the platforms have a place of their own for tags.
+	for _, platform := range []config.AvailableBloggingPlatform{config.BPHugo, config.MBPNostr} {
+		if got := post.RenderFor(platform); got != post.Text {
+			t.Errorf("RenderFor(%s) = %q, want the text alone", platform, got)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package blogging
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/perrito666/chat2world/im"
+)
+
+// templateNameRegex is what template names can be made of, they are typed after template= in /new.
+var templateNameRegex = regexp.MustCompile(`^[\w-]+$`)
+
+// expandTemplate returns the text of template with its placeholders replaced for a post started at now: {{date}}
+// (2006-01-02), {{time}} (15:04) and {{weekday}} (Monday).
+func expandTemplate(template string, now time.Time) string {
+	return strings.NewReplacer(
+		"{{date}}", now.Format(time.DateOnly),
+		"{{time}}", now.Format("15:04"),
+		"{{weekday}}", now.Weekday().String(),
+	).Replace(template)
+}
+
+// templateFor returns the expanded text of the named template of the user, ok is false if there is no such template.
+// The caller must hold postsMutex.
+func (p *PostingFlow) templateFor(userID uint64, name string) (string, bool) {
+	template, ok := p.userSettings(userID).Templates[name]
+	if !ok {
+		return "", false
+	}
+	return expandTemplate(template, p.now().In(p.location)), true
+}
+
+// templateCommandHandler manages the templates of the user: /template lists them, /template save <name> <text> saves
+// one, replacing any with the same name, and /template delete <name> deletes one. Posts start from them with
+// /new template=<name>.
+func (p *PostingFlow) templateCommandHandler(ctx context.Context, message *im.Message, messenger im.Messenger) error {
+	userID := message.UserID
+	action, rest := cutWord(commandRest(message, "/template"))
+	name, text := cutWord(rest)
+
+	var response string
+	var changed bool
+	p.postsMutex.Lock()
+	settings := p.userSettings(userID)
+	switch {
+	case action == "":
+		response = describeTemplates(settings.Templates)
+	case action == "save" && templateNameRegex.MatchString(name) && text != "":
+		if settings.Templates == nil {
+			settings.Templates = make(map[string]string)
+		}
+		settings.Templates[name] = text
+		changed = true
+		response = fmt.Sprintf("Template %s saved, start a post with it with /new template=%s", name, name)
+	case action == "delete" && settings.Templates[name] != "":
+		delete(settings.Templates, name)
+		changed = true
+		response = fmt.Sprintf("Template %s deleted.", name)
+	case action == "delete":
+		response = fmt.Sprintf("There is no template %s, see /template.", name)
+	default:
+		response = "Usage: /template save <name> <text>, /template delete <name> or /template to list them.\n" +
+			"Names are made of letters, digits, _ and -, texts can have {{date}}, {{time}} and {{weekday}}."
+	}
+	if changed {
+		if err := p.saveSettings(userID); err != nil {
+			p.logger.Error("saving settings", "user_id", userID, "err", err)
+			response = fmt.Sprintf("Could not save your templates: %v", err)
+		}
+	}
+	p.postsMutex.Unlock()
+
+	if err := messenger.SendMessage(ctx, message.Reply(response)); err != nil {
+		return fmt.Errorf("messenger send message err: %w", err)
+	}
+	return nil
+}
+
+// cutWord returns the first word of s and what follows it, which can span several lines, without the space between
+// them.
+func cutWord(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, unicode.IsSpace)
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], strings.TrimSpace(s[i:])
+}
+
+// describeTemplates lists templates to the user, by name.
+func describeTemplates(templates map[string]string) string {
+	if len(templates) == 0 {
+		return "No templates, save one with /template save <name> <text>."
+	}
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var sb strings.Builder
+	sb.WriteString("Templates:")
+	for _, name := range names {
+		fmt.Fprintf(&sb, "\n%s: %s", name, templates[name])
+	}
+	sb.WriteString("\nStart a post with one with /new template=<name>.")
+	return sb.String()
+}
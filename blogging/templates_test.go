@@ -0,0 +1,69 @@
+package blogging
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/perrito666/chat2world/config"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	now := time.Date(2026, 3, 2, 9, 5, 0, 0, time.UTC)
+	got := expandTemplate("{{weekday}} {{date}} at {{time}}: {{unknown}} stays", now)
+	if want := "Monday 2026-03-02 at 09:05: {{unknown}} stays"; got != want {
+		t.Errorf("expandTemplate() = %q, want %q", got, want)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	store := newTestStore()
+	platform := &fakePlatform{}
+	platforms := map[config.AvailableBloggingPlatform]AuthedPlatform{config.MBPMastodon: platform}
+	newFlow := func() *PostingFlow {
+		p := NewPostingFlow(platforms, store, WithDefaultLocation(time.UTC))
+		p.now = func() time.Time { return time.Date(2026, 3, 2, 9, 5, 0, 0, time.UTC) }
+		return p
+	}
+	p := newFlow()
+	messenger := &recordingMessenger{}
+
+	say(t, p, messenger, "/template save bad/name some text")
+	if !strings.HasPrefix(messenger.last(), "Usage: /template") {
+		t.Errorf("saving a template with a bad name answered %q, want the usage", messenger.last())
+	}
+	say(t, p, messenger, "/template save weekly Week of {{date}}\n#weeknotes")
+	if want := "Template weekly saved, start a post with it with /new template=weekly"; messenger.last() != want {
+		t.Errorf("saving answered %q, want %q", messenger.last(), want)
+	}
+
+	// templates are the user's, they outlive the flow.
+	p = newFlow()
+	say(t, p, messenger, "/template")
+	if !strings.Contains(messenger.last(), "weekly: Week of {{date}}\n#weeknotes") {
+		t.Errorf("/template answered %q, want the saved template listed", messenger.last())
+	}
+	say(t, p, messenger, "/new template=monthly")
+	if want := "There is no template monthly, see /template."; messenger.last() != want {
+		t.Errorf("/new with an unknown template answered %q, want %q", messenger.last(), want)
+	}
+	say(t, p, messenger, "/new template=weekly")
+	say(t, p, messenger, "did things")
+	say(t, p, messenger, "/send")
+	posted := platform.posted()
+	if len(posted) != 1 {
+		t.Fatalf("%d posts sent, want 1: %s", len(posted), messenger.all())
+	}
+	if want := "Week of 2026-03-02\n#weeknotes\ndid things"; posted[0].Text != want {
+		t.Errorf("posted %q, want %q", posted[0].Text, want)
+	}
+
+	say(t, p, messenger, "/template delete weekly")
+	if want := "Template weekly deleted."; messenger.last() != want {
+		t.Errorf("deleting answered %q, want %q", messenger.last(), want)
+	}
+	say(t, p, messenger, "/template delete weekly")
+	if want := "There is no template weekly, see /template."; messenger.last() != want {
+		t.Errorf("deleting again answered %q, want %q", messenger.last(), want)
+	}
+}
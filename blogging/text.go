@@ -0,0 +1,197 @@
+package blogging
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+
+	"github.com/perrito666/chat2world/config"
+)
+
+// TextLimit describes how long the text of a post can be in a given platform and how that platform measures it.
+type TextLimit struct {
+	MaxLen int
+	Count  func(string) int
+}
+
+// CountRunes counts the unicode code points in s.
+func CountRunes(s string) int {
+	return utf8.RuneCountInString(s)
+}
+
+// CountGraphemes counts the user perceived characters in s, this is how bluesky measures posts.
+func CountGraphemes(s string) int {
+	return uniseg.GraphemeClusterCount(s)
+}
+
+// mastodonURLLength is how many characters mastodon counts for a URL, no matter how long it is.
+const mastodonURLLength = 23
+
+// urlRegex finds the URLs in a text the way platforms would link them.
+var urlRegex = regexp.MustCompile(`https?://\S+`)
+
+// CountMastodon counts s the way mastodon does, in unicode code points but with every URL taking
+// mastodonURLLength of them.
+func CountMastodon(s string) int {
+	count := utf8.RuneCountInString(s)
+	for _, url := range urlRegex.FindAllString(s, -1) {
+		count += mastodonURLLength - utf8.RuneCountInString(url)
+	}
+	return count
+}
+
+// PlatformTextLimits holds the text limits for each of the platforms that have one.
+var PlatformTextLimits = map[config.AvailableBloggingPlatform]TextLimit{
+	config.MBPMastodon: {MaxLen: 500, Count: CountMastodon},
+	config.MBPBsky:     {MaxLen: 300, Count: CountGraphemes},
+}
+
+// RemainingChars returns how many more characters the text of the post can take before it no longer fits in a single
+// post of platform, negative if it is already over, measured as platform does once rendered for it (see RenderFor).
+// It returns false for platforms without a limit.
+func (b *MicroblogPost) RemainingChars(platform config.AvailableBloggingPlatform) (int, bool) {
+	limit, ok := PlatformTextLimits[platform.Kind()]
+	if !ok {
+		return 0, false
+	}
+	return limit.MaxLen - limit.Count(strings.TrimSpace(b.RenderFor(platform))), true
+}
+
+// textUnit is a piece of text that we would rather not break and the separator that precedes it in the original text.
+type textUnit struct {
+	sep  string
+	text string
+}
+
+// unitBoundaryRegex matches the places where we would break a text: sentence ends, line breaks and paragraphs.
+var unitBoundaryRegex = regexp.MustCompile(`[.!?…]+[ \t]+|\n[ \t]*\n\s*|\n`)
+
+// unbreakableRegex matches words that must never be split across posts, URLs and mentions.
+var unbreakableRegex = regexp.MustCompile(`^(?:https?://|@)`)
+
+// splitTextUnits breaks text into sentences, lines and paragraphs remembering what separated them.
+func splitTextUnits(text string) []textUnit {
+	var units []textUnit
+	sep := ""
+	last := 0
+	for _, m := range unitBoundaryRegex.FindAllStringIndex(text, -1) {
+		boundary := text[m[0]:m[1]]
+		// punctuation belongs to the sentence it ends.
+		end := m[0] + len(strings.TrimRightFunc(boundary, unicode.IsSpace))
+		if unitText := text[last:end]; strings.TrimSpace(unitText) != "" {
+			units = append(units, textUnit{sep: sep, text: unitText})
+		}
+		switch strings.Count(boundary, "\n") {
+		case 0:
+			sep = " "
+		case 1:
+			sep = "\n"
+		default:
+			sep = "\n\n"
+		}
+		last = m[1]
+	}
+	if last < len(text) {
+		units = append(units, textUnit{sep: sep, text: text[last:]})
+	}
+	return units
+}
+
+// splitGraphemes breaks a word into pieces no longer than budget, never breaking a grapheme cluster.
+func splitGraphemes(word string, budget int, count func(string) int) []string {
+	var pieces []string
+	var current strings.Builder
+	graphemes := uniseg.NewGraphemes(word)
+	for graphemes.Next() {
+		g := graphemes.Str()
+		if current.Len() > 0 && count(current.String()+g) > budget {
+			pieces = append(pieces, current.String())
+			current.Reset()
+		}
+		current.WriteString(g)
+	}
+	if current.Len() > 0 {
+		pieces = append(pieces, current.String())
+	}
+	return pieces
+}
+
+// fitUnits returns units where each one fits in budget, sentences that are too long are broken into words and words
+// that are too long are broken into graphemes, except for URLs and mentions which are kept whole no matter what.
+func fitUnits(units []textUnit, budget int, count func(string) int) []textUnit {
+	var fitted []textUnit
+	for _, unit := range units {
+		if count(unit.text) <= budget {
+			fitted = append(fitted, unit)
+			continue
+		}
+		for i, word := range strings.Fields(unit.text) {
+			sep := " "
+			if i == 0 {
+				sep = unit.sep
+			}
+			if count(word) <= budget || unbreakableRegex.MatchString(word) {
+				fitted = append(fitted, textUnit{sep: sep, text: word})
+				continue
+			}
+			for j, piece := range splitGraphemes(word, budget, count) {
+				if j != 0 {
+					sep = ""
+				}
+				fitted = append(fitted, textUnit{sep: sep, text: piece})
+			}
+		}
+	}
+	return fitted
+}
+
+// packUnits greedily joins units into chunks no longer than budget.
+func packUnits(units []textUnit, budget int, count func(string) int) []string {
+	var chunks []string
+	current := ""
+	for _, unit := range units {
+		if current != "" && count(current+unit.sep+unit.text) <= budget {
+			current += unit.sep + unit.text
+			continue
+		}
+		if current != "" {
+			chunks = append(chunks, current)
+		}
+		current = strings.TrimSpace(unit.text)
+	}
+	if current != "" {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
+
+// ThreadChunks splits the text of the post into chunks that fit in the given limit, suitable to be posted as a
+// thread. Splits happen at paragraph, line or sentence boundaries when possible and at word boundaries otherwise,
+// URLs and mentions are never broken. When more than one chunk is needed each one gets a (i/n) counter appended.
+func (b *MicroblogPost) ThreadChunks(limit TextLimit) []string {
+	text := strings.TrimSpace(b.Text)
+	if limit.Count(text) <= limit.MaxLen {
+		return []string{text}
+	}
+
+	units := splitTextUnits(text)
+	var chunks []string
+	// We need room for the counter, which depends on how many chunks we end with, so we try with the smallest counter
+	// and grow it until the amount of chunks fits it.
+	for digits := 1; ; digits++ {
+		counter := fmt.Sprintf(" (%[1]s/%[1]s)", strings.Repeat("9", digits))
+		budget := limit.MaxLen - limit.Count(counter)
+		chunks = packUnits(fitUnits(units, budget, limit.Count), budget, limit.Count)
+		if len(fmt.Sprint(len(chunks))) <= digits {
+			break
+		}
+	}
+	for i := range chunks {
+		chunks[i] = fmt.Sprintf("%s (%d/%d)", chunks[i], i+1, len(chunks))
+	}
+	return chunks
+}
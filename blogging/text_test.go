@@ -0,0 +1,125 @@
+package blogging
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/perrito666/chat2world/config"
+)
+
+func TestThreadChunksShortTextIsOneChunk(t *testing.T) {
+	post := &MicroblogPost{Text: "  hello world \n"}
+	chunks := post.ThreadChunks(TextLimit{MaxLen: 20, Count: CountRunes})
+	if len(chunks) != 1 || chunks[0] != "hello world" {
+		t.Errorf("ThreadChunks() = %q, want [\"hello world\"]", chunks)
+	}
+}
+
+func TestThreadChunksFitTheLimit(t *testing.T) {
+	limit := TextLimit{MaxLen: 50, Count: CountRunes}
+	var sentences []string
+	for i := range 12 {
+		sentences = append(sentences, fmt.Sprintf("This is sentence number %d.", i))
+	}
+	post := &MicroblogPost{Text: strings.Join(sentences, " ")}
+	chunks := post.ThreadChunks(limit)
+	if len(chunks) < 2 {
+		t.Fatalf("ThreadChunks() = %q, want several chunks", chunks)
+	}
+	for i, chunk := range chunks {
+		if n := limit.Count(chunk); n > limit.MaxLen {
+			t.Errorf("chunk %d is %d long, over %d: %q", i, n, limit.MaxLen, chunk)
+		}
+		if counter := fmt.Sprintf(" (%d/%d)", i+1, len(chunks)); !strings.HasSuffix(chunk, counter) {
+			t.Errorf("chunk %d = %q, want it to end with %q", i, chunk, counter)
+		}
+	}
+	// every sentence makes it whole, sentences are never broken when they fit.
+	joined := strings.Join(chunks, " ")
+	for _, sentence := range sentences {
+		if !strings.Contains(joined, sentence) {
+			t.Errorf("sentence %q is missing or broken in %q", sentence, chunks)
+		}
+	}
+}
+
+func TestThreadChunksKeepURLsWhole(t *testing.T) {
+	url := "https://example.com/" + strings.Repeat("a", 40)
+	post := &MicroblogPost{Text: strings.Repeat("word ", 10) + url + strings.Repeat(" word", 10)}
+	chunks := post.ThreadChunks(TextLimit{MaxLen: 60, Count: CountRunes})
+	found := false
+	for _, chunk := range chunks {
+		if strings.Contains(chunk, url) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("ThreadChunks() = %q, want %s whole in a chunk", chunks, url)
+	}
+}
+
+func TestThreadChunksSplitLongWordsAtGraphemes(t *testing.T) {
+	// a family emoji is several code points but a single grapheme, it must not be split.
+	family := "👨‍👩‍👧"
+	post := &MicroblogPost{Text: strings.Repeat(family, 30)}
+	limit := TextLimit{MaxLen: 20, Count: CountGraphemes}
+	chunks := post.ThreadChunks(limit)
+	total := 0
+	for i, chunk := range chunks {
+		if n := limit.Count(chunk); n > limit.MaxLen {
+			t.Errorf("chunk %d is %d long, over %d", i, n, limit.MaxLen)
+		}
+		total += strings.Count(chunk, family)
+	}
+	if total != 30 {
+		t.Errorf("chunks hold %d whole families, want 30", total)
+	}
+}
+
+func TestCountsDifferFromLen(t *testing.T) {
+	for _, tc := range []struct {
+		text             string
+		runes, graphemes int
+	}{
+		{"日本語のテキスト", 8, 8},
+		{"👍🏽", 2, 1},
+		{"👨‍👩‍👧 family", 12, 8},
+		{"🇦🇷", 2, 1},
+	} {
+		if got := CountRunes(tc.text); got != tc.runes {
+			t.Errorf("CountRunes(%q) = %d, want %d", tc.text, got, tc.runes)
+		}
+		if got := CountGraphemes(tc.text); got != tc.graphemes {
+			t.Errorf("CountGraphemes(%q) = %d, want %d", tc.text, got, tc.graphemes)
+		}
+		if len(tc.text) == tc.graphemes {
+			t.Errorf("len(%q) = %d, the same as its graphemes", tc.text, len(tc.text))
+		}
+	}
+}
+
+func TestCountMastodonURLs(t *testing.T) {
+	text := "read https://example.com/" + strings.Repeat("a", 100)
+	if got, want := CountMastodon(text), len("read ")+23; got != want {
+		t.Errorf("CountMastodon() = %d, want %d", got, want)
+	}
+}
+
+func TestRemainingChars(t *testing.T) {
+	// 100 CJK characters are 300 bytes, they still leave room in a bluesky post.
+	post := &MicroblogPost{Text: strings.Repeat("語", 100)}
+	if remaining, ok := post.RemainingChars(config.MBPBsky); !ok || remaining != 200 {
+		t.Errorf("RemainingChars(bsky) = %d, %t, want 200, true", remaining, ok)
+	}
+	post = &MicroblogPost{Text: strings.Repeat("👨‍👩‍👧", 301)}
+	if remaining, ok := post.RemainingChars(config.MBPBsky); !ok || remaining != -1 {
+		t.Errorf("RemainingChars(bsky) = %d, %t, want -1, true", remaining, ok)
+	}
+	if remaining, ok := post.RemainingChars(config.MBPMastodon); !ok || remaining != 500-301*5 {
+		t.Errorf("RemainingChars(mastodon) = %d, %t, want %d, true", remaining, ok, 500-301*5)
+	}
+	if _, ok := post.RemainingChars(config.BPHugo); ok {
+		t.Error("RemainingChars(hugo) has a limit")
+	}
+}
@@ -0,0 +1,62 @@
+package blogging
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/perrito666/chat2world/config"
+)
+
+// BlogVideo holds the data and metadata of a video (that we care about), animations (GIFs as telegram sends them) are
+// videos too.
+type BlogVideo struct {
+	Data     []byte        `json:"data"`
+	MimeType string        `json:"mime_type"`
+	Duration time.Duration `json:"duration"`
+	Width    int           `json:"width,omitempty"`
+	Height   int           `json:"height,omitempty"`
+	AltText  string        `json:"alt_text"`
+}
+
+// Reader returns the raw video bytes wrapped in a reader.
+func (v *BlogVideo) Reader() io.Reader {
+	return bytes.NewReader(v.Data)
+}
+
+// VideoLimit describes the largest video a platform accepts, a zero MaxDuration means there is no duration limit.
+type VideoLimit struct {
+	MaxBytes    int
+	MaxDuration time.Duration
+}
+
+// PlatformVideoLimits holds the video limits of the platforms that can post videos.
+var PlatformVideoLimits = map[config.AvailableBloggingPlatform]VideoLimit{
+	config.MBPMastodon: {MaxBytes: 99 << 20},
+	config.MBPBsky:     {MaxBytes: 100 << 20, MaxDuration: 3 * time.Minute},
+}
+
+// CheckLimit returns ErrVideoTooLarge or ErrVideoTooLong if the video does not fit limit.
+func (v *BlogVideo) CheckLimit(limit VideoLimit) error {
+	if limit.MaxBytes > 0 && len(v.Data) > limit.MaxBytes {
+		return fmt.Errorf("%d bytes, limit is %d: %w", len(v.Data), limit.MaxBytes, ErrVideoTooLarge)
+	}
+	if limit.MaxDuration > 0 && v.Duration > limit.MaxDuration {
+		return fmt.Errorf("%s, limit is %s: %w", v.Duration, limit.MaxDuration, ErrVideoTooLong)
+	}
+	return nil
+}
+
+// AddVideo sets the video of the post, platforms take either images or a single video so it fails with ErrMixedMedia
+// if the post already has any of them.
+func (b *MicroblogPost) AddVideo(video *BlogVideo) error {
+	if b.Video != nil {
+		return fmt.Errorf("post already has a video: %w", ErrMixedMedia)
+	}
+	if len(b.Images) > 0 {
+		return fmt.Errorf("post has %d images: %w", len(b.Images), ErrMixedMedia)
+	}
+	b.Video = video
+	return nil
+}
@@ -2,8 +2,12 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"regexp"
+	"slices"
+	"strings"
 )
 
 type AvailableIM string
@@ -19,8 +23,33 @@ const (
 	MBPMastodon AvailableBloggingPlatform = "mastodon"
 	MBPBsky     AvailableBloggingPlatform = "bluesky"
 	BPHugo      AvailableBloggingPlatform = "hugo.io"
+	MBPNostr    AvailableBloggingPlatform = "nostr"
 )
 
+// AccountSeparator separates, in the name of a platform, its kind from the account, i.e. mastodon:fosstodon, for
+// users with more than one account on it.
+const AccountSeparator = ":"
+
+// PlatformAccount names account of platform, i.e. mastodon:fosstodon.
+func PlatformAccount(platform AvailableBloggingPlatform, account string) AvailableBloggingPlatform {
+	if account == "" {
+		return platform
+	}
+	return platform + AccountSeparator + AvailableBloggingPlatform(account)
+}
+
+// Kind returns the platform p is an account of, p itself if it names no account.
+func (p AvailableBloggingPlatform) Kind() AvailableBloggingPlatform {
+	kind, _, _ := strings.Cut(string(p), AccountSeparator)
+	return AvailableBloggingPlatform(kind)
+}
+
+// Account returns the account p names, empty if it names none.
+func (p AvailableBloggingPlatform) Account() string {
+	_, account, _ := strings.Cut(string(p), AccountSeparator)
+	return account
+}
+
 type Config struct {
 	EnabledUIDs              map[AvailableIM][]uint64
 	EnabledIMs               []AvailableIM
@@ -45,7 +74,124 @@ func NewConfig() *Config {
 	}
 }
 
-// LoadFromFile reads a json serialized version of a config from a file
+// knownIMs and knownBloggingPlatforms are the ones a Config can refer to.
+var (
+	knownIMs               = []AvailableIM{IMTelegram, IMSignal}
+	knownBloggingPlatforms = []AvailableBloggingPlatform{MBPMastodon, MBPBsky, BPHugo, MBPNostr}
+	// multiAccountPlatforms are the ones that can be enabled more than once, one per account.
+	multiAccountPlatforms = []AvailableBloggingPlatform{MBPMastodon}
+)
+
+// accountRegex matches valid account names, they become part of a command (i.e. /mastodon_auth_fosstodon) so only
+// what IMs take in commands goes.
+var accountRegex = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// Keys of IMAuth, an IM given auth settings must have the required ones set.
+const (
+	IMAuthTelegramToken  = "TELEGRAM_BOT_TOKEN"
+	IMAuthTelegramSecret = "TELEGRAM_WEBHOOK_SECRET"
+	IMAuthTelegramListen = "TELEGRAM_LISTEN_ADDR"
+	IMAuthTelegramURL    = "CHAT2WORLD_URL"
+	IMAuthTelegramPath   = "TELEGRAM_WEBHOOK_PATH"
+	IMAuthSignalSocket   = "SIGNAL_CLI_SOCKET"
+	IMAuthSignalAttach   = "SIGNAL_CLI_ATTACHMENTS_DIR"
+)
+
+// requiredIMAuth lists, per IM, the IMAuth keys that can't be left empty.
+var requiredIMAuth = map[AvailableIM][]string{
+	IMTelegram: {IMAuthTelegramToken},
+	IMSignal:   {IMAuthSignalSocket},
+}
+
+// ErrInvalidConfig is returned by Validate for configs referring to unknown or not enabled IMs and platforms.
+var ErrInvalidConfig = errors.New("invalid config")
+
+// Validate checks that the config enables some IM, only refers to known IMs and platforms, that everything else
+// (interactions, users, auth) only refers to enabled ones and that IMs given auth have the required keys set. It
+// reports every problem it finds, joined.
+func (c *Config) Validate() error {
+	var problems []error
+	if len(c.EnabledIMs) == 0 {
+		problems = append(problems, fmt.Errorf("no IM enabled: %w", ErrInvalidConfig))
+	}
+	for _, imName := range c.EnabledIMs {
+		if !slices.Contains(knownIMs, imName) {
+			problems = append(problems, fmt.Errorf("unknown IM %q enabled: %w", imName, ErrInvalidConfig))
+		}
+	}
+	for _, platform := range c.EnabledBloggingPlatforms {
+		if !slices.Contains(knownBloggingPlatforms, platform.Kind()) {
+			problems = append(problems, fmt.Errorf("unknown blogging platform %q enabled: %w", platform, ErrInvalidConfig))
+			continue
+		}
+		if !strings.Contains(string(platform), AccountSeparator) {
+			continue
+		}
+		if !slices.Contains(multiAccountPlatforms, platform.Kind()) {
+			problems = append(problems, fmt.Errorf("blogging platform %q takes no accounts: %w", platform.Kind(), ErrInvalidConfig))
+		} else if !accountRegex.MatchString(platform.Account()) {
+			problems = append(problems, fmt.Errorf("account of blogging platform %q must be lowercase letters, digits or _: %w",
+				platform, ErrInvalidConfig))
+		}
+	}
+	for imName, platforms := range c.AvailableInteractions {
+		if !slices.Contains(c.EnabledIMs, imName) {
+			problems = append(problems, fmt.Errorf("interactions for IM %q which is not enabled: %w", imName, ErrInvalidConfig))
+		}
+		for _, platform := range platforms {
+			if !slices.Contains(c.EnabledBloggingPlatforms, platform) {
+				problems = append(problems, fmt.Errorf("IM %q interacts with blogging platform %q which is not enabled: %w",
+					imName, platform, ErrInvalidConfig))
+			}
+		}
+	}
+	for imName, auth := range c.IMAuth {
+		if !slices.Contains(c.EnabledIMs, imName) {
+			problems = append(problems, fmt.Errorf("auth for IM %q which is not enabled: %w", imName, ErrInvalidConfig))
+			continue
+		}
+		for _, key := range requiredIMAuth[imName] {
+			if auth[key] == "" {
+				problems = append(problems, fmt.Errorf("auth for IM %q is missing %s: %w", imName, key, ErrInvalidConfig))
+			}
+		}
+	}
+	for platform := range c.BPAuth {
+		if !slices.Contains(c.EnabledBloggingPlatforms, platform) {
+			problems = append(problems, fmt.Errorf("auth for blogging platform %q which is not enabled: %w", platform, ErrInvalidConfig))
+		}
+	}
+	for userID, platforms := range c.PerUserBloggingConfig {
+		for platform := range platforms {
+			if !slices.Contains(c.EnabledBloggingPlatforms, platform) {
+				problems = append(problems, fmt.Errorf("user %d configures blogging platform %q which is not enabled: %w",
+					userID, platform, ErrInvalidConfig))
+			}
+		}
+	}
+	for imName := range c.EnabledUIDs {
+		if !slices.Contains(c.EnabledIMs, imName) {
+			problems = append(problems, fmt.Errorf("users allowed for IM %q which is not enabled: %w", imName, ErrInvalidConfig))
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// PlatformsFor returns the enabled blogging platforms users can post to through the given IM, in the order they were
+// enabled. An IM without AvailableInteractions gets every enabled platform.
+func (c *Config) PlatformsFor(imName AvailableIM) []AvailableBloggingPlatform {
+	allowed, restricted := c.AvailableInteractions[imName]
+	var platforms []AvailableBloggingPlatform
+	for _, platform := range c.EnabledBloggingPlatforms {
+		if restricted && !slices.Contains(allowed, platform) {
+			continue
+		}
+		platforms = append(platforms, platform)
+	}
+	return platforms
+}
+
+// LoadFromFile reads a json serialized version of a config from a file and validates it, see Validate.
 func (c *Config) LoadFromFile(path string) error {
 	if _, err := os.Stat(path); err != nil {
 		return fmt.Errorf("checking readability of file: %w", err)
@@ -55,10 +201,12 @@ func (c *Config) LoadFromFile(path string) error {
 		return fmt.Errorf("opening file: %w", err)
 	}
 	defer f.Close()
-	json.NewDecoder(f)
 	if err := json.NewDecoder(f).Decode(c); err != nil {
 		return fmt.Errorf("decoding config: %w", err)
 	}
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("validating config: %w", err)
+	}
 	return nil
 }
 
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// validConfig returns a config enabling telegram and signal, mastodon (with two accounts) and bluesky, that Validate
+// accepts.
+func validConfig() *Config {
+	return &Config{
+		EnabledUIDs:              map[AvailableIM][]uint64{IMTelegram: {42}},
+		EnabledIMs:               []AvailableIM{IMTelegram, IMSignal},
+		EnabledBloggingPlatforms: []AvailableBloggingPlatform{MBPMastodon, "mastodon:fosstodon", MBPBsky},
+		AvailableInteractions:    map[AvailableIM][]AvailableBloggingPlatform{IMSignal: {MBPBsky}},
+		BPAuth:                   map[AvailableBloggingPlatform]map[string]string{MBPBsky: {"user": "me"}},
+		IMAuth: map[AvailableIM]map[string]string{
+			IMTelegram: {IMAuthTelegramToken: "token"},
+			IMSignal:   {IMAuthSignalSocket: "/run/signal-cli/socket"},
+		},
+		PerUserBloggingConfig: map[uint64]map[AvailableBloggingPlatform]map[string]string{42: {MBPMastodon: {}}},
+	}
+}
+
+func TestValidateAcceptsAValidConfig(t *testing.T) {
+	if err := validConfig().Validate(); err != nil {
+		t.Errorf("Validate() = %v, want nil", err)
+	}
+}
+
+func TestValidateRefusesInvalidConfigs(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		change func(*Config)
+		// want is part of the message of the error.
+		want string
+	}{
+		{"no IM", func(c *Config) {
+			c.EnabledIMs = nil
+			c.AvailableInteractions, c.IMAuth, c.EnabledUIDs = nil, nil, nil
+		}, "no IM enabled"},
+		{"unknown IM", func(c *Config) { c.EnabledIMs = append(c.EnabledIMs, "irc") }, `unknown IM "irc"`},
+		{"unknown platform", func(c *Config) {
+			c.EnabledBloggingPlatforms = append(c.EnabledBloggingPlatforms, "myspace")
+		}, `unknown blogging platform "myspace"`},
+		{"account of a single account platform", func(c *Config) {
+			c.EnabledBloggingPlatforms = append(c.EnabledBloggingPlatforms, "bluesky:work")
+		}, `"bluesky" takes no accounts`},
+		{"account that can't be a command", func(c *Config) {
+			c.EnabledBloggingPlatforms = append(c.EnabledBloggingPlatforms, "mastodon:My Account")
+		}, "must be lowercase letters, digits or _"},
+		{"interactions of a disabled IM", func(c *Config) {
+			c.EnabledIMs = []AvailableIM{IMTelegram}
+			delete(c.IMAuth, IMSignal)
+		}, `interactions for IM "signal" which is not enabled`},
+		{"interaction with a disabled platform", func(c *Config) {
+			c.AvailableInteractions[IMSignal] = []AvailableBloggingPlatform{MBPNostr}
+		}, `blogging platform "nostr" which is not enabled`},
+		{"auth missing a required key", func(c *Config) {
+			c.IMAuth[IMTelegram] = map[string]string{IMAuthTelegramSecret: "secret"}
+		}, "missing " + IMAuthTelegramToken},
+		{"auth of a disabled platform", func(c *Config) {
+			c.BPAuth[BPHugo] = map[string]string{}
+		}, `auth for blogging platform "hugo.io"`},
+		{"user config of a disabled platform", func(c *Config) {
+			c.PerUserBloggingConfig[42][MBPNostr] = map[string]string{}
+		}, `user 42 configures blogging platform "nostr"`},
+		{"users of a disabled IM", func(c *Config) {
+			c.EnabledUIDs["irc"] = []uint64{1}
+		}, `users allowed for IM "irc"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := validConfig()
+			tc.change(cfg)
+			err := cfg.Validate()
+			if !errors.Is(err, ErrInvalidConfig) {
+				t.Fatalf("Validate() = %v, want ErrInvalidConfig", err)
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("Validate() = %q, want it to say %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	cfg := validConfig()
+	cfg.EnabledIMs = append(cfg.EnabledIMs, "irc")
+	cfg.EnabledBloggingPlatforms = append(cfg.EnabledBloggingPlatforms, "myspace")
+	err := cfg.Validate()
+	for _, want := range []string{`unknown IM "irc"`, `unknown blogging platform "myspace"`} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() = %v, want it to say %q", err, want)
+		}
+	}
+}
@@ -3,13 +3,21 @@ module github.com/perrito666/chat2world
 go 1.23
 
 require (
+	github.com/btcsuite/btcd/btcec/v2 v2.3.4
 	github.com/go-telegram/bot v1.13.3
 	github.com/hashicorp/vault/api v1.15.0
 	github.com/mattn/go-mastodon v0.0.9
+	github.com/rivo/uniseg v0.4.7
+	golang.org/x/crypto v0.23.0
+	golang.org/x/image v0.23.0
+	golang.org/x/net v0.25.0
 )
 
 require (
+	github.com/btcsuite/btcd/chaincfg/chainhash v1.0.1 // indirect
 	github.com/cenkalti/backoff/v4 v4.3.0 // indirect
+	github.com/decred/dcrd/crypto/blake256 v1.0.0 // indirect
+	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.0.1 // indirect
 	github.com/go-jose/go-jose/v4 v4.0.1 // indirect
 	github.com/gorilla/websocket v1.5.1 // indirect
 	github.com/hashicorp/errwrap v1.1.0 // indirect
@@ -25,8 +33,6 @@ require (
 	github.com/mitchellh/mapstructure v1.5.0 // indirect
 	github.com/ryanuber/go-glob v1.0.0 // indirect
 	github.com/tomnomnom/linkheader v0.0.0-20180905144013-02ca5825eb80 // indirect
-	golang.org/x/crypto v0.23.0 // indirect
-	golang.org/x/net v0.25.0 // indirect
-	golang.org/x/text v0.15.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
 	golang.org/x/time v0.0.0-20200416051211-89c76fbcd5d1 // indirect
 )
@@ -4,8 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 // ErrFlowFinished should be returned by any Flow method to indicate the Flow is done, users of the Flow should handle
@@ -22,25 +26,295 @@ type Flow interface {
 	StartCommandParser(string) (string, []string, error)
 }
 
+// Canceler is optionally implemented by Flows that need to clean up when the user cancels them with /cancel while
+// running concurrently with other Flows.
+type Canceler interface {
+	Cancel(ctx context.Context, message *Message, messenger Messenger) error
+}
+
+// Resumer is optionally implemented by Flows that can go on after a restart, with nothing but what they persisted
+// themselves (i.e. drafts), CanResume tells if they can for the given user. Flows that were active before a restart and
+// can't resume are aborted telling the user.
+type Resumer interface {
+	CanResume(userID uint64) bool
+}
+
+// FlowStore persists the names of the active Flows of each user, so a restart does not silently drop users out of
+// what they were doing.
+type FlowStore interface {
+	LoadActiveFlows(userID uint64) ([]string, error)
+	SaveActiveFlows(userID uint64, flows []string) error
+}
+
+// activeFlow is a Flow that has been started and not finished yet.
+type activeFlow struct {
+	name string
+	// cancel cancels the context the Flow was started with.
+	cancel context.CancelFunc
+}
+
 // FlowScheduler is a struct that holds a map of Flows and a map of commands that start each Flow, it will handle
 // messages and route them to the correct Flow.
+// By default only one Flow is active at a time and it receives every message until it finishes. With
+// WithConcurrentFlows the active Flows form a stack and messages are routed like this:
+//   - A command that starts a Flow starts it on top of the stack (restarting it if it was already active).
+//   - /cancel, unless a Flow registered it, cancels the Flow on top of the stack and returns to the previous one.
+//   - /help, unless a Flow registered it, is answered by the scheduler.
+//   - /whoami, with WithWhoami and unless a Flow registered it, is answered by the scheduler whatever the mode.
+//   - Anything else, including commands no Flow registered, goes to the Flow on top of the stack.
 type FlowScheduler struct {
+	// mu serializes HandleMessage, messengers handle each update in its own goroutine and a user can write faster than
+	// we answer. It guards the active Flows, the idle timeout and the store.
+	mu sync.Mutex
+
 	flows                  map[string]Flow
 	flowCommandEntryPoints map[string]string
-	currentFlow            string
+	flowDescriptions       map[string]string
+	activeFlows            []activeFlow
+	concurrentFlows        bool
+
+	// whoami enables answering /whoami, see WithWhoami.
+	whoami bool
+
+	idleTimeout  time.Duration
+	lastActivity time.Time
+	now          func() time.Time
+	logger       *slog.Logger
+
+	// store, if set, persists the active Flows of userID, which are restored with the first message after a restart.
+	store    FlowStore
+	userID   uint64
+	restored bool
+}
+
+// SchedulerOption configures optional settings of a FlowScheduler.
+type SchedulerOption func(*FlowScheduler)
+
+// WithIdleTimeout makes the scheduler close the current Flow when no message arrived for the given time, the user is
+// told about it when they come back. Zero, the default, disables it.
+func WithIdleTimeout(timeout time.Duration) SchedulerOption {
+	return func(fs *FlowScheduler) {
+		fs.idleTimeout = timeout
+	}
+}
+
+// WithConcurrentFlows lets a user start a Flow while others are active, see FlowScheduler for how messages are routed.
+func WithConcurrentFlows() SchedulerOption {
+	return func(fs *FlowScheduler) {
+		fs.concurrentFlows = true
+	}
+}
+
+// WithWhoami makes the scheduler answer /whoami, whatever Flow is active, with the IM, user and chat IDs of the
+// message, which is what goes in allowlists and what to share when troubleshooting.
+func WithWhoami() SchedulerOption {
+	return func(fs *FlowScheduler) {
+		fs.whoami = true
+	}
+}
+
+// WithClock replaces the function used to tell the time, it is meant for tests.
+func WithClock(now func() time.Time) SchedulerOption {
+	return func(fs *FlowScheduler) {
+		fs.now = now
+	}
+}
+
+// WithLogger sets where the scheduler logs, it defaults to slog.Default().
+func WithLogger(logger *slog.Logger) SchedulerOption {
+	return func(fs *FlowScheduler) {
+		fs.logger = logger
+	}
+}
+
+// WithFlowStore makes the scheduler, which must be the one of userID, persist its active Flows in store. After a
+// restart they are restored when the user first writes, before handling the message, Flows that can't resume (see
+// Resumer) are aborted telling the user.
+func WithFlowStore(store FlowStore, userID uint64) SchedulerOption {
+	return func(fs *FlowScheduler) {
+		fs.store = store
+		fs.userID = userID
+	}
 }
 
 // NewScheduler creates a new FlowScheduler.
-func NewScheduler() *FlowScheduler {
-	return &FlowScheduler{
+func NewScheduler(opts ...SchedulerOption) *FlowScheduler {
+	fs := &FlowScheduler{
 		flows:                  make(map[string]Flow),
 		flowCommandEntryPoints: make(map[string]string),
-		currentFlow:            "",
+		flowDescriptions:       make(map[string]string),
+		now:                    time.Now,
+		logger:                 slog.Default(),
 	}
+	for _, opt := range opts {
+		opt(fs)
+	}
+	return fs
+}
+
+// currentFlow returns the name of the Flow on top of the stack, or an empty string if none is active.
+func (fs *FlowScheduler) currentFlow() string {
+	if len(fs.activeFlows) == 0 {
+		return ""
+	}
+	return fs.activeFlows[len(fs.activeFlows)-1].name
 }
 
-// SchedulerFactoryFN describes a function capable of building a FlowScheduler with registered Flows.
-type SchedulerFactoryFN func(userID uint64) (*FlowScheduler, error)
+// activeFlowNames returns the names of the active Flows, bottom of the stack first.
+func (fs *FlowScheduler) activeFlowNames() []string {
+	names := make([]string, len(fs.activeFlows))
+	for i, active := range fs.activeFlows {
+		names[i] = active.name
+	}
+	return names
+}
+
+// saveActiveFlows persists the active Flows if they changed from previous.
+func (fs *FlowScheduler) saveActiveFlows(previous []string) {
+	current := fs.activeFlowNames()
+	if fs.store == nil || slices.Equal(previous, current) {
+		return
+	}
+	if err := fs.store.SaveActiveFlows(fs.userID, current); err != nil {
+		fs.logger.Error("saving active flows", "user_id", fs.userID, "err", err)
+	}
+}
+
+// restoreActiveFlows brings back, the first time it is called, the Flows that were active before a restart. The ones
+// that can't resume are aborted and the user told, in reply to message.
+func (fs *FlowScheduler) restoreActiveFlows(ctx context.Context, message *Message, messenger Messenger) error {
+	if fs.store == nil || fs.restored {
+		return nil
+	}
+	fs.restored = true
+	names, err := fs.store.LoadActiveFlows(fs.userID)
+	if err != nil {
+		fs.logger.Error("loading active flows", "user_id", fs.userID, "err", err)
+		return nil
+	}
+	var aborted []string
+	for _, name := range names {
+		resumer, ok := fs.flows[name].(Resumer)
+		if !ok || !resumer.CanResume(fs.userID) {
+			aborted = append(aborted, name)
+			continue
+		}
+		// the context the Flow was started with died with the previous process, there is nothing to cancel.
+		fs.activeFlows = append(fs.activeFlows, activeFlow{name: name, cancel: func() {}})
+	}
+	// the restart is not idleness.
+	fs.lastActivity = fs.now()
+	fs.logger.Info("restored active flows", "user_id", fs.userID, "flows", fs.activeFlowNames(), "aborted", aborted)
+	if len(aborted) == 0 {
+		return nil
+	}
+	fs.saveActiveFlows(names)
+	notice := fmt.Sprintf("%s interrupted by a restart, start again with the command you need.",
+		strings.Join(aborted, ", "))
+	if err := messenger.SendMessage(ctx, message.Reply(notice)); err != nil {
+		return fmt.Errorf("sending restored flows notice: %w", err)
+	}
+	return nil
+}
+
+// finishFlow removes the named Flow from the active ones and cancels its context, so anything it left waiting is
+// released.
+func (fs *FlowScheduler) finishFlow(name string) {
+	for i, active := range fs.activeFlows {
+		if active.name == name {
+			active.cancel()
+			fs.activeFlows = append(fs.activeFlows[:i], fs.activeFlows[i+1:]...)
+			return
+		}
+	}
+}
+
+// finishAllFlows finishes every active Flow.
+func (fs *FlowScheduler) finishAllFlows() {
+	for _, active := range fs.activeFlows {
+		active.cancel()
+	}
+	fs.activeFlows = nil
+}
+
+// startFlow starts the named Flow on top of the active ones, if it was already active it is restarted. Flows with
+// nothing left to do once started (i.e. a command answered right away) return ErrFlowFinished from Start.
+func (fs *FlowScheduler) startFlow(ctx context.Context, name string, message *Message, messenger Messenger) error {
+	fs.finishFlow(name)
+	// the flow gets its own context so it can be canceled if it is abandoned.
+	flowCtx, cancel := context.WithCancel(ctx)
+	fs.activeFlows = append(fs.activeFlows, activeFlow{name: name, cancel: cancel})
+	fs.logger.Debug("starting flow", "flow", name, "user_id", message.UserID)
+	err := fs.flows[name].Start(flowCtx, message, messenger)
+	if errors.Is(err, ErrFlowFinished) {
+		fs.finishFlow(name)
+		return nil
+	}
+	return err
+}
+
+// cancelCurrentFlow finishes the Flow on top of the stack, letting it clean up first if it is a Canceler.
+func (fs *FlowScheduler) cancelCurrentFlow(ctx context.Context, message *Message, messenger Messenger) error {
+	name := fs.currentFlow()
+	defer fs.finishFlow(name)
+	if canceler, ok := fs.flows[name].(Canceler); ok {
+		if err := canceler.Cancel(ctx, message, messenger); err != nil {
+			return fmt.Errorf("canceling %s: %w", name, err)
+		}
+		return nil
+	}
+	notice := fmt.Sprintf("%s canceled.", name)
+	if previous := fs.activeFlows[:len(fs.activeFlows)-1]; len(previous) > 0 {
+		notice += fmt.Sprintf(" Back to %s.", previous[len(previous)-1].name)
+	}
+	if err := messenger.SendMessage(ctx, message.Reply(notice)); err != nil {
+		return fmt.Errorf("sending cancel notice: %w", err)
+	}
+	return nil
+}
+
+// expireIdleFlows closes the active Flows if there was no activity for longer than the idle timeout and tells the
+// user.
+func (fs *FlowScheduler) expireIdleFlows(ctx context.Context, message *Message, messenger Messenger) error {
+	now := fs.now()
+	defer func() { fs.lastActivity = now }()
+	if len(fs.activeFlows) == 0 || fs.idleTimeout <= 0 || now.Sub(fs.lastActivity) <= fs.idleTimeout {
+		return nil
+	}
+	expired := make([]string, len(fs.activeFlows))
+	for i, active := range fs.activeFlows {
+		expired[i] = active.name
+	}
+	fs.logger.Info("closing idle flows", "flows", expired, "idle_since", fs.lastActivity, "user_id", message.UserID)
+	fs.finishAllFlows()
+	notice := fmt.Sprintf("%s closed after %s without activity.", strings.Join(expired, ", "), fs.idleTimeout)
+	if !message.IsCommand() {
+		notice += " Your message was not used, start again with the command you need."
+	}
+	if err := messenger.SendMessage(ctx, message.Reply(notice)); err != nil {
+		return fmt.Errorf("sending idle flow notice: %w", err)
+	}
+	return nil
+}
+
+// flowRegistration holds the optional settings of a Flow being registered.
+type flowRegistration struct {
+	description string
+}
+
+// FlowOption configures optional settings of a Flow when registering it.
+type FlowOption func(*flowRegistration)
+
+// WithDescription sets a short, human readable, description of what the Flow does, it is shown by /help.
+func WithDescription(description string) FlowOption {
+	return func(r *flowRegistration) {
+		r.description = description
+	}
+}
+
+// SchedulerFactoryFN describes a function capable of building a FlowScheduler with registered Flows, messenger is the
+// one the user talks to us through, for Flows that need to reach the user on their own.
+type SchedulerFactoryFN func(userID uint64, messenger Messenger) (*FlowScheduler, error)
 
 // ErrFlowTriggerConflict is returned when a command is already registered as a trigger for a Flow.
 var ErrFlowTriggerConflict = errors.New("flow trigger conflict")
@@ -49,7 +323,11 @@ var ErrFlowTriggerConflict = errors.New("flow trigger conflict")
 var ErrFlowAlreadyRegistered = errors.New("flow already registered")
 
 // RegisterFlow will take a Flow and a list of commands (with leading /) that initiate the Flow.
-func (fs *FlowScheduler) RegisterFlow(f Flow, name string, commands []string) error {
+func (fs *FlowScheduler) RegisterFlow(f Flow, name string, commands []string, opts ...FlowOption) error {
+	registration := &flowRegistration{}
+	for _, opt := range opts {
+		opt(registration)
+	}
 	if _, ok := fs.flows[name]; ok {
 		return fmt.Errorf("%s: %w", name, ErrFlowAlreadyRegistered)
 	}
@@ -61,9 +339,54 @@ func (fs *FlowScheduler) RegisterFlow(f Flow, name string, commands []string) er
 		fs.flowCommandEntryPoints[c] = name
 	}
 	fs.flows[name] = f
+	fs.flowDescriptions[name] = registration.description
 	return nil
 }
 
+// helpCommand is handled by the scheduler itself, unless a Flow registers it.
+const helpCommand = "/help"
+
+// whoamiCommand is handled by the scheduler itself with WithWhoami, unless a Flow registers it.
+const whoamiCommand = "/whoami"
+
+// whoamiText describes who sent message and from where.
+func whoamiText(message *Message) string {
+	return fmt.Sprintf("IM: %s\nUser ID: %d\nChat ID: %d", message.IM, message.UserID, message.ChatID)
+}
+
+// cancelCommand is handled by the scheduler itself when running concurrent flows, unless a Flow registers it.
+const cancelCommand = "/cancel"
+
+// helpText lists the registered Flows, with their descriptions, and the commands that start each of them.
+func (fs *FlowScheduler) helpText() string {
+	commandsByFlow := make(map[string][]string, len(fs.flows))
+	for command, name := range fs.flowCommandEntryPoints {
+		commandsByFlow[name] = append(commandsByFlow[name], command)
+	}
+	names := make([]string, 0, len(fs.flows))
+	for name := range fs.flows {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString("Available commands:\n")
+	for _, name := range names {
+		commands := commandsByFlow[name]
+		sort.Strings(commands)
+		sb.WriteString("\n" + name)
+		if description := fs.flowDescriptions[name]; description != "" {
+			sb.WriteString(": " + description)
+		}
+		sb.WriteString("\n  " + strings.Join(commands, ", ") + "\n")
+	}
+	if fs.whoami {
+		sb.WriteString("\n" + whoamiCommand + " shows your user and chat IDs.")
+	}
+	sb.WriteString("\n" + helpCommand + " shows this message.")
+	return sb.String()
+}
+
 // ErrEmptyMessage is returned when a message is empty.
 var ErrEmptyMessage = errors.New("empty message")
 
@@ -78,16 +401,63 @@ func messageToFlowParams(message string) (string, []string, error) {
 }
 
 // HandleMessage will receive a message and either pas it to the active handler's HandleMessage or,if no active handler
-// is found, will use the command to Flow map to set a current one and invoke start on it with the same message
+// is found, will use the command to Flow map to set a current one and invoke start on it with the same message.
+// Messages are handled one at a time, it is safe to call it from several goroutines.
 func (fs *FlowScheduler) HandleMessage(ctx context.Context, message *Message, messenger Messenger) error {
-	log.Printf("when entering handler, current Flow is: %s", fs.currentFlow)
-	defer log.Printf("when exiting handler, current Flow is: %s", fs.currentFlow)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.logger.Debug("entering handler", "flow", fs.currentFlow(), "user_id", message.UserID)
+	defer func() { fs.logger.Debug("exiting handler", "flow", fs.currentFlow(), "user_id", message.UserID) }()
+
+	if err := fs.restoreActiveFlows(ctx, message, messenger); err != nil {
+		return err
+	}
+	defer fs.saveActiveFlows(fs.activeFlowNames())
+
+	if err := fs.expireIdleFlows(ctx, message, messenger); err != nil {
+		return err
+	}
+
+	// /whoami is answered whatever flow is active, it must work when troubleshooting any of them.
+	if fs.whoami && message.IsCommand() && !message.Edited {
+		command, _, err := message.AsCommand(nil)
+		if err != nil {
+			return fmt.Errorf("parsing message: %w", err)
+		}
+		if _, registered := fs.flowCommandEntryPoints[command]; command == whoamiCommand && !registered {
+			if err := messenger.SendMessage(ctx, message.Reply(whoamiText(message))); err != nil {
+				return fmt.Errorf("sending whoami: %w", err)
+			}
+			return nil
+		}
+	}
+
+	// With concurrent flows some commands are handled before routing to the current flow, edits of them are not
+	// commands again.
+	if fs.concurrentFlows && message.IsCommand() && !message.Edited {
+		command, _, err := message.AsCommand(nil)
+		if err != nil {
+			return fmt.Errorf("parsing message: %w", err)
+		}
+		flowName, registered := fs.flowCommandEntryPoints[command]
+		switch {
+		case registered:
+			return fs.startFlow(ctx, flowName, message, messenger)
+		case command == cancelCommand && fs.currentFlow() != "":
+			return fs.cancelCurrentFlow(ctx, message, messenger)
+		case command == helpCommand:
+			if err := messenger.SendMessage(ctx, message.Reply(fs.helpText())); err != nil {
+				return fmt.Errorf("sending help: %w", err)
+			}
+			return nil
+		}
+	}
 
 	// We have a running flow, let it handle the message
-	if fs.currentFlow != "" {
-		if err := fs.flows[fs.currentFlow].HandleMessage(ctx, message, messenger); err != nil {
+	if current := fs.currentFlow(); current != "" {
+		if err := fs.flows[current].HandleMessage(ctx, message, messenger); err != nil {
 			if errors.Is(err, ErrFlowFinished) {
-				fs.currentFlow = ""
+				fs.finishFlow(current)
 				return nil
 			}
 			return fmt.Errorf("handling message: %w", err)
@@ -96,25 +466,29 @@ func (fs *FlowScheduler) HandleMessage(ctx context.Context, message *Message, me
 	}
 
 	// We do not, let's see if this is a trigger for a flow
-	if !message.IsCommand() {
+	if !message.IsCommand() || message.Edited {
 		return nil
 	}
 
 	command, _, err := message.AsCommand(nil)
 	if err != nil {
 		if errors.Is(err, ErrNotACommand) {
-			log.Printf("message is not a command we know how to handle: %s", message.Text)
+			fs.logger.Debug("message is not a command we know how to handle", "user_id", message.UserID)
 			return nil
 		}
 		return fmt.Errorf("parsing message: %w", err)
 	}
 
-	log.Printf("telegram handle message: command: %s", command)
+	fs.logger.Debug("handling command", "command", command, "user_id", message.UserID)
 	if flowName, ok := fs.flowCommandEntryPoints[command]; ok {
-		fs.currentFlow = flowName
-		log.Printf("telegram handle message: starting Flow: %s", flowName)
-		return fs.flows[flowName].Start(ctx, message, messenger)
+		return fs.startFlow(ctx, flowName, message, messenger)
+	}
+	if command == helpCommand {
+		if err := messenger.SendMessage(ctx, message.Reply(fs.helpText())); err != nil {
+			return fmt.Errorf("sending help: %w", err)
+		}
+		return nil
 	}
-	fmt.Printf("telegram handle message: command not recognized: %s", command)
+	fs.logger.Debug("command not recognized", "command", command, "user_id", message.UserID)
 	return nil
 }
@@ -0,0 +1,386 @@
+package im
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/perrito666/chat2world/config"
+)
+
+// recordingMessenger keeps what is sent through it.
+type recordingMessenger struct {
+	mu   sync.Mutex
+	sent []*Message
+}
+
+func (m *recordingMessenger) SendMessage(_ context.Context, message *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, message)
+	return nil
+}
+
+func (m *recordingMessenger) Name() string {
+	return "test"
+}
+
+// texts returns the text of every message sent, in order.
+func (m *recordingMessenger) texts() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	texts := make([]string, len(m.sent))
+	for i, message := range m.sent {
+		texts[i] = message.Text
+	}
+	return texts
+}
+
+// countingFlow counts what it is given, finishing when told /done.
+type countingFlow struct {
+	started  int
+	handled  int
+	messages []string
+}
+
+func (f *countingFlow) Start(_ context.Context, message *Message, _ Messenger) error {
+	f.started++
+	return nil
+}
+
+func (f *countingFlow) HandleMessage(_ context.Context, message *Message, _ Messenger) error {
+	if message.Text == "/done" {
+		return ErrFlowFinished
+	}
+	f.handled++
+	f.messages = append(f.messages, message.Text)
+	return nil
+}
+
+func (f *countingFlow) StartCommandParser(string) (string, []string, error) {
+	return "", nil, nil
+}
+
+func TestFlowSchedulerHandlesConcurrentMessagesOneAtATime(t *testing.T) {
+	fs := NewScheduler()
+	flow := &countingFlow{}
+	if err := fs.RegisterFlow(flow, "count", []string{"/count"}); err != nil {
+		t.Fatalf("RegisterFlow: %v", err)
+	}
+	ctx := context.Background()
+	messenger := &recordingMessenger{}
+	if err := fs.HandleMessage(ctx, &Message{UserID: 1, Text: "/count"}, messenger); err != nil {
+		t.Fatalf("starting the flow: %v", err)
+	}
+
+	const messages = 100
+	var wg sync.WaitGroup
+	for range messages {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fs.HandleMessage(ctx, &Message{UserID: 1, Text: "hi"}, messenger); err != nil {
+				t.Errorf("HandleMessage: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if flow.handled != messages {
+		t.Errorf("the flow handled %d messages, want %d", flow.handled, messages)
+	}
+}
+
+// helloFlow is a Flow that only answers the command starting it.
+type helloFlow struct{}
+
+func (helloFlow) Start(ctx context.Context, message *Message, messenger Messenger) error {
+	return ErrFlowFinished
+}
+
+func (helloFlow) HandleMessage(context.Context, *Message, Messenger) error {
+	return nil
+}
+
+func (helloFlow) StartCommandParser(string) (string, []string, error) {
+	return "", nil, nil
+}
+
+func TestHelpListsRegisteredFlows(t *testing.T) {
+	fs := NewScheduler()
+	if err := fs.RegisterFlow(&countingFlow{}, "posting", []string{"/new", "/post"},
+		WithDescription("write a post")); err != nil {
+		t.Fatalf("RegisterFlow: %v", err)
+	}
+	if err := fs.RegisterFlow(helloFlow{}, "greeting", []string{"/hello"}); err != nil {
+		t.Fatalf("RegisterFlow: %v", err)
+	}
+	messenger := &recordingMessenger{}
+	if err := fs.HandleMessage(context.Background(), &Message{UserID: 1, Text: "/help"}, messenger); err != nil {
+		t.Fatalf("HandleMessage: %v", err)
+	}
+	texts := messenger.texts()
+	if len(texts) != 1 {
+		t.Fatalf("sent %q, want the help", texts)
+	}
+	for _, want := range []string{"posting: write a post", "/new, /post", "greeting", "/hello", "/help"} {
+		if !strings.Contains(texts[0], want) {
+			t.Errorf("help does not mention %q:\n%s", want, texts[0])
+		}
+	}
+}
+
+func TestIdleFlowIsClosed(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	fs := NewScheduler(WithIdleTimeout(10*time.Minute), WithClock(func() time.Time { return now }))
+	flow := &countingFlow{}
+	if err := fs.RegisterFlow(flow, "count", []string{"/count"}); err != nil {
+		t.Fatalf("RegisterFlow: %v", err)
+	}
+	ctx := context.Background()
+	messenger := &recordingMessenger{}
+	send := func(text string) {
+		t.Helper()
+		if err := fs.HandleMessage(ctx, &Message{UserID: 1, Text: text}, messenger); err != nil {
+			t.Fatalf("HandleMessage(%q): %v", text, err)
+		}
+	}
+
+	send("/count")
+	now = now.Add(9 * time.Minute)
+	send("still here")
+	// the timeout counts from the last message, not from the start.
+	now = now.Add(9 * time.Minute)
+	send("and here")
+	if flow.handled != 2 {
+		t.Fatalf("the flow handled %d messages, want 2", flow.handled)
+	}
+
+	now = now.Add(11 * time.Minute)
+	send("too late")
+	if flow.handled != 2 {
+		t.Errorf("the flow handled a message after being idle: %q", flow.messages)
+	}
+	if fs.currentFlow() != "" {
+		t.Errorf("flow %q is still active", fs.currentFlow())
+	}
+	texts := messenger.texts()
+	if len(texts) != 1 || !strings.Contains(texts[0], "count closed after 10m0s without activity") ||
+		!strings.Contains(texts[0], "Your message was not used") {
+		t.Errorf("sent %q, want the idle notice", texts)
+	}
+}
+
+func TestNestedFlows(t *testing.T) {
+	fs := NewScheduler(WithConcurrentFlows())
+	outer, inner := &countingFlow{}, &countingFlow{}
+	if err := fs.RegisterFlow(outer, "outer", []string{"/outer"}); err != nil {
+		t.Fatalf("RegisterFlow: %v", err)
+	}
+	if err := fs.RegisterFlow(inner, "inner", []string{"/inner"}); err != nil {
+		t.Fatalf("RegisterFlow: %v", err)
+	}
+	ctx := context.Background()
+	messenger := &recordingMessenger{}
+	send := func(text string) {
+		t.Helper()
+		if err := fs.HandleMessage(ctx, &Message{UserID: 1, Text: text}, messenger); err != nil {
+			t.Fatalf("HandleMessage(%q): %v", text, err)
+		}
+	}
+
+	send("/outer")
+	send("to outer")
+	send("/inner")
+	if got := fs.activeFlowNames(); !slices.Equal(got, []string{"outer", "inner"}) {
+		t.Fatalf("active flows = %q, want [outer inner]", got)
+	}
+	send("to inner")
+	// the inner flow finishing goes back to the outer one.
+	send("/done")
+	send("to outer again")
+	// a command no flow registered goes to the flow on top.
+	send("/unknown")
+	send("/inner")
+	send("/cancel")
+	if texts := messenger.texts(); len(texts) != 1 || texts[0] != "inner canceled. Back to outer." {
+		t.Errorf("sent %q, want the cancel notice", texts)
+	}
+	send("/done")
+
+	if !slices.Equal(outer.messages, []string{"to outer", "to outer again", "/unknown"}) {
+		t.Errorf("outer got %q", outer.messages)
+	}
+	if !slices.Equal(inner.messages, []string{"to inner"}) {
+		t.Errorf("inner got %q", inner.messages)
+	}
+	if inner.started != 2 {
+		t.Errorf("inner started %d times, want 2", inner.started)
+	}
+	if fs.currentFlow() != "" {
+		t.Errorf("flow %q is still active", fs.currentFlow())
+	}
+}
+
+func TestSchedulerLogsAtDebugLevel(t *testing.T) {
+	// run handles /count with the scheduler logging at level and returns the records logged.
+	run := func(level slog.Level) []map[string]any {
+		t.Helper()
+		var logs bytes.Buffer
+		fs := NewScheduler(WithLogger(slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: level}))))
+		if err := fs.RegisterFlow(&countingFlow{}, "count", []string{"/count"}); err != nil {
+			t.Fatalf("RegisterFlow: %v", err)
+		}
+		if err := fs.HandleMessage(context.Background(), &Message{UserID: 7, Text: "/count"},
+			&recordingMessenger{}); err != nil {
+			t.Fatalf("HandleMessage: %v", err)
+		}
+		var records []map[string]any
+		dec := json.NewDecoder(&logs)
+		for dec.More() {
+			var record map[string]any
+			if err := dec.Decode(&record); err != nil {
+				t.Fatalf("decoding log: %v", err)
+			}
+			records = append(records, record)
+		}
+		return records
+	}
+
+	records := run(slog.LevelDebug)
+	var handling map[string]any
+	for _, record := range records {
+		if record["msg"] == "handling command" {
+			handling = record
+		}
+	}
+	if handling == nil {
+		t.Fatalf("logged %v, want the command handled", records)
+	}
+	// numbers decode as float64.
+	if handling["level"] != "DEBUG" || handling["command"] != "/count" || handling["user_id"] != float64(7) {
+		t.Errorf("logged %v, want the command and the user as attributes at debug level", handling)
+	}
+
+	if records := run(slog.LevelInfo); len(records) != 0 {
+		t.Errorf("logged %v at info level, want nothing", records)
+	}
+}
+
+// memFlowStore is a FlowStore keeping the active flows in memory.
+type memFlowStore map[uint64][]string
+
+func (s memFlowStore) LoadActiveFlows(userID uint64) ([]string, error) {
+	return s[userID], nil
+}
+
+func (s memFlowStore) SaveActiveFlows(userID uint64, flows []string) error {
+	s[userID] = flows
+	return nil
+}
+
+// resumableFlow is a countingFlow that can always resume.
+type resumableFlow struct {
+	countingFlow
+}
+
+func (*resumableFlow) CanResume(uint64) bool {
+	return true
+}
+
+func TestActiveFlowsSurviveARestart(t *testing.T) {
+	store := memFlowStore{}
+	ctx := context.Background()
+	// newScheduler returns the scheduler of user 1 as built after a (re)start, with a flow that can resume and one that
+	// can't.
+	newScheduler := func() (*FlowScheduler, *resumableFlow) {
+		fs := NewScheduler(WithFlowStore(store, 1), WithConcurrentFlows())
+		resumable := &resumableFlow{}
+		if err := fs.RegisterFlow(resumable, "post", []string{"/post"}); err != nil {
+			t.Fatalf("RegisterFlow: %v", err)
+		}
+		if err := fs.RegisterFlow(&countingFlow{}, "count", []string{"/count"}); err != nil {
+			t.Fatalf("RegisterFlow: %v", err)
+		}
+		return fs, resumable
+	}
+
+	fs, _ := newScheduler()
+	for _, text := range []string{"/post", "/count"} {
+		if err := fs.HandleMessage(ctx, &Message{UserID: 1, Text: text}, &recordingMessenger{}); err != nil {
+			t.Fatalf("handling %q: %v", text, err)
+		}
+	}
+	if want := []string{"post", "count"}; !slices.Equal(store[1], want) {
+		t.Fatalf("stored %q, want %q", store[1], want)
+	}
+
+	fs, resumable := newScheduler()
+	messenger := &recordingMessenger{}
+	if err := fs.HandleMessage(ctx, &Message{UserID: 1, Text: "where was I"}, messenger); err != nil {
+		t.Fatalf("HandleMessage: %v", err)
+	}
+	if resumable.started != 0 || !slices.Equal(resumable.messages, []string{"where was I"}) {
+		t.Errorf("the post flow was started %d times and handled %q, want it resumed with the message",
+			resumable.started, resumable.messages)
+	}
+	want := "count interrupted by a restart, start again with the command you need."
+	if texts := messenger.texts(); len(texts) != 1 || texts[0] != want {
+		t.Errorf("sent %q, want %q", texts, want)
+	}
+	if want := []string{"post"}; !slices.Equal(store[1], want) {
+		t.Errorf("stored %q after the restart, want %q", store[1], want)
+	}
+}
+
+func TestWhoamiRepliesWithTheIDs(t *testing.T) {
+	fs := NewScheduler(WithWhoami())
+	flow := &countingFlow{}
+	if err := fs.RegisterFlow(flow, "posting", []string{"/new"}); err != nil {
+		t.Fatalf("RegisterFlow: %v", err)
+	}
+	messenger := &recordingMessenger{}
+	ctx := context.Background()
+	whoami := &Message{IM: config.IMTelegram, UserID: 42, ChatID: 99, Text: "/whoami"}
+	if err := fs.HandleMessage(ctx, whoami, messenger); err != nil {
+		t.Fatalf("HandleMessage: %v", err)
+	}
+	// it is answered also while a flow is active, without reaching it.
+	if err := fs.HandleMessage(ctx, &Message{UserID: 42, ChatID: 99, Text: "/new"}, messenger); err != nil {
+		t.Fatalf("HandleMessage: %v", err)
+	}
+	if err := fs.HandleMessage(ctx, whoami, messenger); err != nil {
+		t.Fatalf("HandleMessage: %v", err)
+	}
+	texts := messenger.texts()
+	if len(texts) != 2 {
+		t.Fatalf("sent %q, want two answers", texts)
+	}
+	for _, text := range texts {
+		if want := "IM: telegram\nUser ID: 42\nChat ID: 99"; text != want {
+			t.Errorf("/whoami answered %q, want %q", text, want)
+		}
+	}
+	if flow.handled != 0 {
+		t.Errorf("the active flow handled %d messages, want /whoami kept from it", flow.handled)
+	}
+}
+
+func TestWhoamiIsOptIn(t *testing.T) {
+	fs := NewScheduler()
+	messenger := &recordingMessenger{}
+	if err := fs.HandleMessage(context.Background(), &Message{UserID: 42, ChatID: 99, Text: "/whoami"},
+		messenger); err != nil {
+		t.Fatalf("HandleMessage: %v", err)
+	}
+	for _, text := range messenger.texts() {
+		if strings.Contains(text, "User ID: 42") {
+			t.Errorf("answered %q without WithWhoami", text)
+		}
+	}
+}
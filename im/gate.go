@@ -0,0 +1,134 @@
+package im
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrUserNotAllowed is returned by Gate.Admit for messages from users not in the allowlist.
+var ErrUserNotAllowed = errors.New("user not allowed")
+
+// ErrRateLimited is returned by Gate.Admit for messages from users that sent too many too quickly.
+var ErrRateLimited = errors.New("too many messages")
+
+// rateWindow is the window the rate limit of a Gate counts messages over.
+const rateWindow = time.Minute
+
+// Gate decides which messages reach the FlowSchedulers, every IM puts one in front of them: only users in the allowlist
+// get through and, optionally, only so many messages per minute each.
+type Gate struct {
+	allowed map[uint64]bool
+	// perMinute is how many messages each user can send per rateWindow, 0 means no limit.
+	perMinute int
+
+	mu sync.Mutex
+	// received holds, per user, when the messages let through in the last rateWindow arrived, oldest first.
+	received map[uint64][]time.Time
+	// warned holds the users told they are over the limit, so they are told once until they are back under it.
+	warned map[uint64]bool
+	now    func() time.Time
+	logger *slog.Logger
+}
+
+// GateOption configures optional settings of a Gate.
+type GateOption func(*Gate)
+
+// WithRateLimit lets each user send up to perMinute messages per minute, the rest are dropped telling the user once.
+// Zero, the default, means no limit.
+func WithRateLimit(perMinute int) GateOption {
+	return func(g *Gate) {
+		g.perMinute = perMinute
+	}
+}
+
+// WithGateClock replaces the function used to tell the time, it is meant for tests.
+func WithGateClock(now func() time.Time) GateOption {
+	return func(g *Gate) {
+		g.now = now
+	}
+}
+
+// WithGateLogger sets where the gate logs, it defaults to slog.Default().
+func WithGateLogger(logger *slog.Logger) GateOption {
+	return func(g *Gate) {
+		g.logger = logger
+	}
+}
+
+// NewGate creates a Gate letting through the messages of allowedUsers.
+func NewGate(allowedUsers []uint64, opts ...GateOption) *Gate {
+	g := &Gate{
+		allowed:  make(map[uint64]bool, len(allowedUsers)),
+		received: make(map[uint64][]time.Time),
+		warned:   make(map[uint64]bool),
+		now:      time.Now,
+		logger:   slog.Default(),
+	}
+	for _, u := range allowedUsers {
+		g.allowed[u] = true
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// Users returns the allowed users, sorted.
+func (g *Gate) Users() []uint64 {
+	return slices.Sorted(maps.Keys(g.allowed))
+}
+
+// Allowed tells if userID is in the allowlist, IMs can use it to drop messages of strangers before doing any work on
+// them (i.e. downloading their media).
+func (g *Gate) Allowed(userID uint64) bool {
+	return g.allowed[userID]
+}
+
+// Admit returns nil if message can go on to the FlowScheduler of its user, ErrUserNotAllowed if the user is not in the
+// allowlist and ErrRateLimited if they are over the rate limit, in which case the first message dropped is answered
+// through messenger so the user knows.
+func (g *Gate) Admit(ctx context.Context, message *Message, messenger Messenger) error {
+	if !g.Allowed(message.UserID) {
+		return fmt.Errorf("user %d: %w", message.UserID, ErrUserNotAllowed)
+	}
+	if g.perMinute <= 0 {
+		return nil
+	}
+
+	g.mu.Lock()
+	now := g.now()
+	received := g.received[message.UserID]
+	// only the messages still in the window count.
+	for len(received) > 0 && now.Sub(received[0]) >= rateWindow {
+		received = received[1:]
+	}
+	limited := len(received) >= g.perMinute
+	warn := limited && !g.warned[message.UserID]
+	if limited {
+		g.warned[message.UserID] = true
+	} else {
+		received = append(received, now)
+		delete(g.warned, message.UserID)
+	}
+	g.received[message.UserID] = received
+	g.mu.Unlock()
+
+	if !limited {
+		return nil
+	}
+	if warn {
+		g.logger.Warn("user rate limited", "user_id", message.UserID, "per_minute", g.perMinute)
+		reply := message.Reply(fmt.Sprintf("You are sending messages too fast, only %d per minute are taken, the "+
+			"rest are dropped. Wait a moment and try again.", g.perMinute))
+		if err := messenger.SendMessage(ctx, reply); err != nil {
+			g.logger.Error("telling user they are rate limited", "user_id", message.UserID, "err", err)
+		}
+	}
+	return fmt.Errorf("user %d: %w", message.UserID, ErrRateLimited)
+}
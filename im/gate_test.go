@@ -0,0 +1,65 @@
+package im
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGateOnlyAdmitsAllowedUsers(t *testing.T) {
+	g := NewGate([]uint64{42, 7})
+	messenger := &recordingMessenger{}
+	ctx := context.Background()
+	if err := g.Admit(ctx, &Message{UserID: 42, Text: "hi"}, messenger); err != nil {
+		t.Errorf("Admit(allowed) = %v, want nil", err)
+	}
+	if err := g.Admit(ctx, &Message{UserID: 13, Text: "hi"}, messenger); !errors.Is(err, ErrUserNotAllowed) {
+		t.Errorf("Admit(stranger) = %v, want ErrUserNotAllowed", err)
+	}
+	// strangers are not told anything.
+	if got := messenger.texts(); len(got) != 0 {
+		t.Errorf("sent %q, want nothing", got)
+	}
+	if got := g.Users(); !slices.Equal(got, []uint64{7, 42}) {
+		t.Errorf("Users() = %v, want [7 42]", got)
+	}
+}
+
+func TestGateRateLimit(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	g := NewGate([]uint64{42, 7}, WithRateLimit(2), WithGateClock(func() time.Time { return now }),
+		WithGateLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	messenger := &recordingMessenger{}
+	ctx := context.Background()
+	admit := func(userID uint64) error {
+		return g.Admit(ctx, &Message{UserID: userID, Text: "hi"}, messenger)
+	}
+
+	for i := range 2 {
+		if err := admit(42); err != nil {
+			t.Fatalf("message %d: Admit = %v, want nil", i+1, err)
+		}
+	}
+	for i := range 2 {
+		if err := admit(42); !errors.Is(err, ErrRateLimited) {
+			t.Errorf("message over the limit %d: Admit = %v, want ErrRateLimited", i+1, err)
+		}
+	}
+	// the user is told once, and others are not limited by them.
+	if got := messenger.texts(); len(got) != 1 || !strings.HasPrefix(got[0], "You are sending messages too fast") {
+		t.Errorf("sent %q, want one warning", got)
+	}
+	if err := admit(7); err != nil {
+		t.Errorf("another user: Admit = %v, want nil", err)
+	}
+
+	now = now.Add(rateWindow)
+	if err := admit(42); err != nil {
+		t.Errorf("a minute later: Admit = %v, want nil", err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/perrito666/chat2world/config"
 )
@@ -15,6 +16,16 @@ type Image struct {
 	Caption string
 }
 
+// Video holds the data and metadata of a video (or animation) as we receive it from chats.
+type Video struct {
+	Data     []byte
+	MimeType string
+	Duration time.Duration
+	Width    int
+	Height   int
+	Caption  string
+}
+
 // Message holds the kind of messages we send and receive from chats.
 type Message struct {
 	IM        config.AvailableIM
@@ -25,6 +36,36 @@ type Message struct {
 
 	Text   string
 	Images []*Image
+	Videos []*Video
+	// Format tells how Messengers that can show formatting read Text, the rest show it as is.
+	Format Format
+
+	// Buttons are offered to the user along the message, by rows, by Messengers that are ButtonMessengers.
+	Buttons [][]Button
+	// Edited is true when the message is a new version of the already received one with ID MsgID.
+	Edited bool
+	// CallbackData is the Data of the Button the user pressed, for such messages MsgID is the one holding the button.
+	CallbackData string
+}
+
+// Format is how the Text of a message we send is written.
+type Format int
+
+const (
+	// FormatPlain is text shown as is, the default.
+	FormatPlain Format = iota
+	// FormatMarkdown is text with **bold**, _italic_, ~~strikethrough~~, `code` and [links](https://example.com),
+	// anything else is shown as is, Messengers take care of escaping it.
+	FormatMarkdown
+	// FormatHTML is text in the HTML the messenger takes, i.e. <b>, <i> and <a href="">, escaping is up to the sender.
+	FormatHTML
+)
+
+// Button is a choice offered to the user with a message, pressing it sends us a Message with its Data as
+// CallbackData. Keep Data short, Telegram allows 64 bytes.
+type Button struct {
+	Text string
+	Data string
 }
 
 // Reply takes a new text and images and returns a new message replying to the original message.
@@ -46,7 +87,12 @@ func (m *Message) IsCommand() bool {
 
 // IsEmpty returns true if the message is empty
 func (m *Message) IsEmpty() bool {
-	return m.Text == "" && len(m.Images) == 0
+	return m.Text == "" && len(m.Images) == 0 && len(m.Videos) == 0 && m.CallbackData == ""
+}
+
+// IsCallback returns true if the message is the press of a Button.
+func (m *Message) IsCallback() bool {
+	return m.CallbackData != ""
 }
 
 // CommandParser represents a function that knows how to parse a given command.
@@ -76,3 +122,11 @@ type Messenger interface {
 	SendMessage(ctx context.Context, message *Message) error
 	Name() string
 }
+
+// ButtonMessenger is implemented by Messengers that can show the Buttons of the messages they send and tell us when
+// they are pressed, other Messengers ignore Buttons.
+type ButtonMessenger interface {
+	Messenger
+	// EditMessage replaces the text and buttons of the already sent message with ID message.MsgID.
+	EditMessage(ctx context.Context, message *Message) error
+}
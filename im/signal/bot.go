@@ -0,0 +1,279 @@
+package signal
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/perrito666/chat2world/im"
+)
+
+// maxLineSize is the largest JSON-RPC message we accept from signal-cli.
+const maxLineSize = 16 << 20
+
+// Bot talks to a signal-cli daemon and routes the messages it receives to the per user FlowScheduler.
+type Bot struct {
+	socketPath     string
+	attachmentsDir string
+
+	connMutex sync.Mutex
+	conn      net.Conn
+	nextID    uint64
+	pending   map[string]chan *rpcMessage
+
+	flowSchedulersMutex  sync.Mutex
+	flowSchedulers       map[uint64]*schedulerEntry
+	flowSchedulerFactory im.SchedulerFactoryFN
+	// gate lets through only the messages of allowed users, at the rate they are allowed.
+	gate *im.Gate
+
+	logger *slog.Logger
+}
+
+func (sb *Bot) Name() string {
+	return "signal"
+}
+
+// Option configures optional settings of a Bot.
+type Option func(*Bot)
+
+// WithLogger sets where the bot logs, it defaults to slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(sb *Bot) {
+		sb.logger = logger
+	}
+}
+
+// New creates a new Signal bot that will talk to the signal-cli daemon listening on socketPath, attachmentsDir is
+// where signal-cli stores received attachments (usually ~/.local/share/signal-cli/attachments). Only the messages gate
+// admits reach the flows, users are phone numbers without the leading +.
+func New(socketPath, attachmentsDir string, gate *im.Gate, schedulerFn im.SchedulerFactoryFN,
+	opts ...Option) (*Bot, error) {
+	if socketPath == "" {
+		return nil, fmt.Errorf("signal-cli socket path is empty")
+	}
+	sb := &Bot{
+		socketPath:           socketPath,
+		attachmentsDir:       attachmentsDir,
+		pending:              make(map[string]chan *rpcMessage),
+		flowSchedulers:       make(map[uint64]*schedulerEntry),
+		flowSchedulerFactory: schedulerFn,
+		gate:                 gate,
+		logger:               slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(sb)
+	}
+	sb.logger = sb.logger.With("im", "signal")
+	return sb, nil
+}
+
+// call sends a JSON-RPC request to signal-cli and waits for its response.
+func (sb *Bot) call(ctx context.Context, method string, params any) (*rpcMessage, error) {
+	responseChan := make(chan *rpcMessage, 1)
+
+	sb.connMutex.Lock()
+	if sb.conn == nil {
+		sb.connMutex.Unlock()
+		return nil, fmt.Errorf("signal-cli not connected")
+	}
+	sb.nextID++
+	id := strconv.FormatUint(sb.nextID, 10)
+	req, err := json.Marshal(&rpcRequest{JSONRPC: "2.0", Method: method, Params: params, ID: id})
+	if err != nil {
+		sb.connMutex.Unlock()
+		return nil, fmt.Errorf("marshaling %s request: %w", method, err)
+	}
+	sb.pending[id] = responseChan
+	_, err = sb.conn.Write(append(req, '\n'))
+	if err != nil {
+		delete(sb.pending, id)
+	}
+	sb.connMutex.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("writing %s request: %w", method, err)
+	}
+
+	select {
+	case res, ok := <-responseChan:
+		if !ok {
+			return nil, fmt.Errorf("signal-cli connection closed waiting for %s", method)
+		}
+		if res.Error != nil {
+			return nil, fmt.Errorf("%s: %w", method, res.Error)
+		}
+		return res, nil
+	case <-ctx.Done():
+		sb.connMutex.Lock()
+		delete(sb.pending, id)
+		sb.connMutex.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// SendMessage sends a im.Message to signal (with all the needed translation), images go as data URI attachments.
+func (sb *Bot) SendMessage(ctx context.Context, message *im.Message) error {
+	number := numberFromUserID(uint64(message.ChatID))
+	params := &sendParams{
+		Recipient: []string{number},
+		Message:   message.Text,
+	}
+	if message.InReplyTo != 0 {
+		params.QuoteTimestamp = message.InReplyTo
+		params.QuoteAuthor = number
+	}
+	for _, img := range message.Images {
+		params.Attachments = append(params.Attachments,
+			fmt.Sprintf("data:%s;base64,%s", http.DetectContentType(img.Data), base64.StdEncoding.EncodeToString(img.Data)))
+	}
+	if _, err := sb.call(ctx, "send", params); err != nil {
+		return fmt.Errorf("signal send message: %w", err)
+	}
+	return nil
+}
+
+var _ im.Messenger = (*Bot)(nil)
+
+// Start connects to signal-cli and handles incoming messages until the given context is canceled or the connection
+// is lost.
+func (sb *Bot) Start(ctx context.Context) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", sb.socketPath)
+	if err != nil {
+		return fmt.Errorf("connecting to signal-cli: %w", err)
+	}
+	sb.connMutex.Lock()
+	sb.conn = conn
+	sb.connMutex.Unlock()
+	sb.logger.Info("connected to signal-cli", "socket", sb.socketPath)
+
+	// Build the schedulers of the known users right away, their flows might have work to do (i.e. scheduled posts)
+	// before they talk to us again.
+	for _, userID := range sb.gate.Users() {
+		if _, err := sb.schedulerFor(userID); err != nil {
+			sb.logger.Error("building flow scheduler", "user_id", userID, "err", err)
+		}
+	}
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	defer func() {
+		sb.connMutex.Lock()
+		sb.conn = nil
+		for id, responseChan := range sb.pending {
+			close(responseChan)
+			delete(sb.pending, id)
+		}
+		sb.connMutex.Unlock()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		var msg rpcMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			sb.logger.Error("unmarshaling message from signal-cli", "err", err)
+			continue
+		}
+		if msg.ID != "" {
+			sb.connMutex.Lock()
+			responseChan, ok := sb.pending[msg.ID]
+			delete(sb.pending, msg.ID)
+			sb.connMutex.Unlock()
+			if ok {
+				responseChan <- &msg
+			}
+			continue
+		}
+		if msg.Method == "receive" {
+			go sb.receiveHandler(ctx, msg.Params)
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading from signal-cli: %w", err)
+	}
+	return fmt.Errorf("signal-cli closed the connection")
+}
+
+// Stop is wishful thinking for now.
+func (sb *Bot) Stop() {
+}
+
+// schedulerEntry is the FlowScheduler of a user, built by the first update that asks for it while the rest wait.
+type schedulerEntry struct {
+	once  sync.Once
+	sched *im.FlowScheduler
+	err   error
+}
+
+// schedulerFor returns the FlowScheduler for the given user, creating it the first time we hear from them.
+// Handlers run concurrently so the map is guarded, but the lock is only held to find or add the user's entry, the
+// factory (which might log in to platforms) runs once per user outside of it, so other users are not kept waiting.
+// If it fails the entry is dropped and the next update tries again.
+func (sb *Bot) schedulerFor(userID uint64) (*im.FlowScheduler, error) {
+	sb.flowSchedulersMutex.Lock()
+	entry, ok := sb.flowSchedulers[userID]
+	if !ok {
+		entry = &schedulerEntry{}
+		sb.flowSchedulers[userID] = entry
+	}
+	sb.flowSchedulersMutex.Unlock()
+
+	entry.once.Do(func() {
+		entry.sched, entry.err = sb.flowSchedulerFactory(userID, sb)
+	})
+	if entry.err != nil {
+		sb.flowSchedulersMutex.Lock()
+		if sb.flowSchedulers[userID] == entry {
+			delete(sb.flowSchedulers, userID)
+		}
+		sb.flowSchedulersMutex.Unlock()
+		return nil, entry.err
+	}
+	return entry.sched, nil
+}
+
+// receiveHandler processes a receive notification, anything that is not a message the gate admits is ignored.
+func (sb *Bot) receiveHandler(ctx context.Context, rawParams json.RawMessage) {
+	var params receiveParams
+	if err := json.Unmarshal(rawParams, &params); err != nil {
+		sb.logger.Error("unmarshaling receive notification", "err", err)
+		return
+	}
+	// receipts, typing indicators and the like have no data message.
+	if params.Envelope.DataMessage == nil {
+		return
+	}
+	message, err := messageFromEnvelope(&params.Envelope, sb.attachmentsDir)
+	if err != nil {
+		sb.logger.Error("translating envelope", "err", err)
+		return
+	}
+	if err := sb.gate.Admit(ctx, message, sb); err != nil {
+		sb.logger.Warn("message not admitted", "user_id", message.UserID, "err", err)
+		return
+	}
+
+	sched, err := sb.schedulerFor(message.UserID)
+	if err != nil {
+		sb.logger.Error("building flow scheduler", "user_id", message.UserID, "err", err)
+		return
+	}
+
+	err = sched.HandleMessage(ctx, message, sb)
+	if err != nil {
+		sb.logger.Error("handling message", "user_id", message.UserID, "err", err)
+	}
+}
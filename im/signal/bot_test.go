@@ -0,0 +1,93 @@
+package signal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/perrito666/chat2world/im"
+)
+
+// echoFlow answers the message that starts it with its own text.
+type echoFlow struct{}
+
+func (echoFlow) Start(ctx context.Context, message *im.Message, messenger im.Messenger) error {
+	if err := messenger.SendMessage(ctx, message.Reply("echo: "+message.Text)); err != nil {
+		return err
+	}
+	return im.ErrFlowFinished
+}
+
+func (echoFlow) HandleMessage(context.Context, *im.Message, im.Messenger) error {
+	return nil
+}
+
+func (echoFlow) StartCommandParser(string) (string, []string, error) {
+	return "", nil, nil
+}
+
+func TestBotAnswersThroughSignalCLISocket(t *testing.T) {
+	const number = "+5491112345678"
+	socketPath := filepath.Join(t.TempDir(), "signal.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("listening on %s: %v", socketPath, err)
+	}
+	defer listener.Close()
+
+	gate := im.NewGate([]uint64{5491112345678})
+	bot, err := New(socketPath, t.TempDir(), gate, func(userID uint64, messenger im.Messenger) (*im.FlowScheduler, error) {
+		sched := im.NewScheduler()
+		return sched, sched.RegisterFlow(echoFlow{}, "echo", []string{"/echo"})
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	started := make(chan error, 1)
+	go func() { started <- bot.Start(ctx) }()
+
+	// from here on we are signal-cli.
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("accepting the bot: %v", err)
+	}
+	defer conn.Close()
+	notification := `{"jsonrpc":"2.0","method":"receive","params":{"account":"+10000000000","envelope":{` +
+		`"sourceNumber":"` + number + `","timestamp":1700000000000,` +
+		`"dataMessage":{"timestamp":1700000000000,"message":"/echo hi"}}}}` + "\n"
+	if _, err := conn.Write([]byte(notification)); err != nil {
+		t.Fatalf("sending the notification: %v", err)
+	}
+
+	lines := bufio.NewScanner(conn)
+	if !lines.Scan() {
+		t.Fatalf("the bot sent nothing: %v", lines.Err())
+	}
+	var req struct {
+		rpcRequest
+		Params sendParams `json:"params"`
+	}
+	if err := json.Unmarshal(lines.Bytes(), &req); err != nil {
+		t.Fatalf("unmarshaling %s: %v", lines.Bytes(), err)
+	}
+	if req.Method != "send" || len(req.Params.Recipient) != 1 || req.Params.Recipient[0] != number {
+		t.Errorf("request = %s, want a send to %s", lines.Bytes(), number)
+	}
+	if req.Params.Message != "echo: /echo hi" || req.Params.QuoteTimestamp != 1700000000000 {
+		t.Errorf("sent %q quoting %d, want the echo quoting the message", req.Params.Message, req.Params.QuoteTimestamp)
+	}
+	if _, err := conn.Write([]byte(`{"jsonrpc":"2.0","id":"` + req.ID + `","result":{"timestamp":1}}` + "\n")); err != nil {
+		t.Fatalf("answering the send: %v", err)
+	}
+
+	cancel()
+	if err := <-started; err != nil {
+		t.Errorf("Start = %v, want nil once canceled", err)
+	}
+}
@@ -0,0 +1,79 @@
+package signal
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// signal-cli, when run as `signal-cli -a <number> daemon --socket <path>`, speaks line delimited JSON-RPC 2.0 over a
+// unix socket, incoming messages arrive as "receive" notifications, see
+// https://github.com/AsamK/signal-cli/blob/master/man/signal-cli-jsonrpc.5.adoc
+
+// rpcRequest is a JSON-RPC request we send to signal-cli.
+type rpcRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+	ID      string `json:"id"`
+}
+
+// rpcError is the error member of a JSON-RPC response.
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("signal-cli error %d: %s", e.Code, e.Message)
+}
+
+// rpcMessage is anything signal-cli sends us, either a response to one of our requests (it has an ID) or a
+// notification (it has a method).
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      string          `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// sendParams are the params of the send method.
+type sendParams struct {
+	Recipient      []string `json:"recipient"`
+	Message        string   `json:"message"`
+	Attachments    []string `json:"attachments,omitempty"`
+	QuoteTimestamp uint64   `json:"quoteTimestamp,omitempty"`
+	QuoteAuthor    string   `json:"quoteAuthor,omitempty"`
+}
+
+// attachment describes a file attached to a signal message, signal-cli stores it in its attachments directory under
+// the name in ID.
+type attachment struct {
+	ContentType string `json:"contentType"`
+	Filename    string `json:"filename"`
+	ID          string `json:"id"`
+	Size        int64  `json:"size"`
+}
+
+// dataMessage is the content of a regular signal message.
+type dataMessage struct {
+	Timestamp   uint64       `json:"timestamp"`
+	Message     string       `json:"message"`
+	Attachments []attachment `json:"attachments"`
+}
+
+// envelope wraps everything signal-cli receives, we only care about the ones carrying a dataMessage.
+type envelope struct {
+	Source       string       `json:"source"`
+	SourceNumber string       `json:"sourceNumber"`
+	SourceName   string       `json:"sourceName"`
+	Timestamp    uint64       `json:"timestamp"`
+	DataMessage  *dataMessage `json:"dataMessage"`
+}
+
+// receiveParams are the params of the receive notification.
+type receiveParams struct {
+	Envelope envelope `json:"envelope"`
+	Account  string   `json:"account"`
+}
@@ -0,0 +1,75 @@
+package signal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/perrito666/chat2world/config"
+	"github.com/perrito666/chat2world/im"
+)
+
+// ErrNotAPhoneNumber is returned when a signal sender has no phone number we can use as user ID.
+var ErrNotAPhoneNumber = errors.New("not a phone number")
+
+// userIDFromNumber turns a phone number in E.164 format (+5491112345678) into the numeric user ID used everywhere
+// else (5491112345678), so signal users can be allowed and stored like telegram ones.
+func userIDFromNumber(number string) (uint64, error) {
+	digits := strings.TrimPrefix(number, "+")
+	id, err := strconv.ParseUint(digits, 10, 64)
+	if err != nil || digits == "" {
+		return 0, fmt.Errorf("%q: %w", number, ErrNotAPhoneNumber)
+	}
+	return id, nil
+}
+
+// numberFromUserID is the inverse of userIDFromNumber.
+func numberFromUserID(id uint64) string {
+	return "+" + strconv.FormatUint(id, 10)
+}
+
+// ErrNoDataMessage is returned for envelopes that carry no message, i.e. receipts or typing indicators.
+var ErrNoDataMessage = errors.New("signal envelope without data message")
+
+// messageFromEnvelope builds an im.Message out of a received signal message, image attachments are read from the
+// signal-cli attachments directory. Signal has no separate chat ID for direct messages, the sender is the chat.
+func messageFromEnvelope(env *envelope, attachmentsDir string) (*im.Message, error) {
+	if env.DataMessage == nil {
+		return nil, ErrNoDataMessage
+	}
+	number := env.SourceNumber
+	if number == "" {
+		number = env.Source
+	}
+	userID, err := userIDFromNumber(number)
+	if err != nil {
+		return nil, fmt.Errorf("signal sender: %w", err)
+	}
+	msg := &im.Message{
+		IM:     config.IMSignal,
+		ChatID: int64(userID),
+		UserID: userID,
+		MsgID:  env.Timestamp,
+		Text:   env.DataMessage.Message,
+	}
+	for _, a := range env.DataMessage.Attachments {
+		if !strings.HasPrefix(a.ContentType, "image/") {
+			continue
+		}
+		// the ID is a file name chosen by signal-cli, never let it point outside of the directory.
+		data, err := os.ReadFile(filepath.Join(attachmentsDir, filepath.Base(a.ID)))
+		if err != nil {
+			return nil, fmt.Errorf("signal reading attachment %s: %w", a.ID, err)
+		}
+		msg.Images = append(msg.Images, &im.Image{Data: data})
+	}
+	// signal has no per image captions, the text of a message with a single image is its caption.
+	if len(msg.Images) == 1 && msg.Text != "" && !msg.IsCommand() {
+		msg.Images[0].Caption = msg.Text
+		msg.Text = ""
+	}
+	return msg, nil
+}
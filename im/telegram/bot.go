@@ -2,30 +2,62 @@ package telegram
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
 	"regexp"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
 
-	"github.com/perrito666/chat2world/config"
 	"github.com/perrito666/chat2world/im"
 )
 
 // Bot wraps the underlying bot.Bot and holds state.
 type Bot struct {
 	bot                  *bot.Bot
-	postsMutex           sync.Mutex
 	commands             map[string]bot.HandlerFunc
-	flowSchedulers       map[uint64]*im.FlowScheduler
+	flowSchedulersMutex  sync.Mutex
+	flowSchedulers       map[uint64]*schedulerEntry
 	flowSchedulerFactory im.SchedulerFactoryFN
-	allowedUsers         map[uint64]bool
+	// gate lets through only the messages of allowed users, at the rate they are allowed.
+	gate        *im.Gate
+	mediaGroups *mediaGroupBuffer
+	// webhookSecret is what telegram sends along every webhook request, see requireSecret.
+	webhookSecret string
+	// polling is true when updates are fetched with getUpdates instead of received through a webhook.
+	polling bool
+	// mux serves the webhook, on webhookPath, and the probes (see mountHandlers), webhookPath is relative to the
+	// webhook URL until New makes it the full path.
+	mux         *http.ServeMux
+	webhookPath string
+	// ready is true while the webhook is set and its server listening, see readyz.
+	ready atomic.Bool
+	// maxDownloadSize is the largest file, in bytes, we download from the messages we receive.
+	maxDownloadSize int64
+	// forwardAttribution puts who forwarded messages come from before their text.
+	forwardAttribution bool
+	// apiServer is the bot API we talk to, empty for telegram's.
+	apiServer string
+	logger    *slog.Logger
 
-	authFlowOngoing map[int64]map[config.AvailableBloggingPlatform]bool
+	// inFlight tracks the messages being handled, draining is set once Start is stopping and no more are taken, both
+	// guarded by drainMutex so nothing is added to inFlight while it is being waited for.
+	drainMutex   sync.Mutex
+	draining     bool
+	inFlight     sync.WaitGroup
+	drainTimeout time.Duration
+	// handlersCtx is what messages are handled with, unlike the context given to Start it lives on while draining so
+	// posts being sent can finish, it is canceled once draining is over.
+	handlersCtx    context.Context
+	cancelHandlers context.CancelFunc
 }
 
 func (tb *Bot) Name() string {
@@ -36,119 +68,431 @@ func (tb *Bot) Name() string {
 func (tb *Bot) SendMessage(ctx context.Context, message *im.Message) error {
 	params := &bot.SendMessageParams{
 		ChatID: message.ChatID,
-		Text:   message.Text,
 	}
+	params.ParseMode, params.Text = parseMode(message.Format, message.Text)
 	if message.InReplyTo != 0 {
 		params.ReplyParameters = &models.ReplyParameters{
 			MessageID: int(message.InReplyTo),
 		}
 	}
+	if len(message.Buttons) > 0 {
+		params.ReplyMarkup = inlineKeyboard(message.Buttons)
+	}
 	_, err := tb.bot.SendMessage(ctx, params)
+	if isParseError(params.ParseMode, err) {
+		// better unformatted than not at all.
+		params.ParseMode, params.Text = "", message.Text
+		_, err = tb.bot.SendMessage(ctx, params)
+	}
 	if err != nil {
 		return fmt.Errorf("telegram send message: %w", err)
 	}
 	return nil
 }
 
-var _ im.Messenger = (*Bot)(nil)
-
-// New creates a new Telegram bot instance.
-// You can pass additional bot.Options if needed.
-func New(ctx context.Context,
-	token string, webhookSecret string, webhookURL *url.URL, allowedUsers []uint64,
-	schedulerFn im.SchedulerFactoryFN) (*Bot, error) {
-	// Create the underlying bot.
-	opt := bot.WithWebhookSecretToken(webhookSecret)
-	b, err := bot.New(token, opt)
+// EditMessage replaces the text and buttons of a message we sent, a message without buttons removes them.
+func (tb *Bot) EditMessage(ctx context.Context, message *im.Message) error {
+	params := &bot.EditMessageTextParams{
+		ChatID:    message.ChatID,
+		MessageID: int(message.MsgID),
+	}
+	params.ParseMode, params.Text = parseMode(message.Format, message.Text)
+	if len(message.Buttons) > 0 {
+		params.ReplyMarkup = inlineKeyboard(message.Buttons)
+	}
+	_, err := tb.bot.EditMessageText(ctx, params)
+	if isParseError(params.ParseMode, err) {
+		params.ParseMode, params.Text = "", message.Text
+		_, err = tb.bot.EditMessageText(ctx, params)
+	}
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("telegram edit message: %w", err)
+	}
+	return nil
+}
+
+// inlineKeyboard translates buttons into a telegram inline keyboard.
+func inlineKeyboard(buttons [][]im.Button) *models.InlineKeyboardMarkup {
+	keyboard := &models.InlineKeyboardMarkup{InlineKeyboard: make([][]models.InlineKeyboardButton, len(buttons))}
+	for i, row := range buttons {
+		keyboard.InlineKeyboard[i] = make([]models.InlineKeyboardButton, len(row))
+		for j, button := range row {
+			keyboard.InlineKeyboard[i][j] = models.InlineKeyboardButton{Text: button.Text, CallbackData: button.Data}
+		}
+	}
+	return keyboard
+}
+
+var _ im.ButtonMessenger = (*Bot)(nil)
+
+// ErrNoWebhookSecret is returned when asked to use a webhook without a secret, every request to it would be rejected.
+var ErrNoWebhookSecret = errors.New("telegram webhook secret is required")
+
+// DefaultDrainTimeout is how long, once stopping, the bot waits for the messages being handled (i.e. a post being
+// sent) before canceling them.
+const DefaultDrainTimeout = 30 * time.Second
+
+// ErrDrainTimeout is returned by Start when messages were still being handled after the drain timeout.
+var ErrDrainTimeout = errors.New("telegram messages still being handled after the drain timeout")
+
+// Option configures optional settings of a Bot.
+type Option func(*Bot)
+
+// WithMaxDownloadSize sets the largest file, in bytes, downloaded from the messages we receive, larger ones are refused
+// telling the user. It defaults to DefaultMaxDownloadSize, only a local bot API server lets bots download more.
+func WithMaxDownloadSize(size int64) Option {
+	return func(tb *Bot) {
+		if size > 0 {
+			tb.maxDownloadSize = size
+		}
+	}
+}
+
+// WithDrainTimeout sets how long, once stopping, the bot waits for the messages being handled, it defaults to
+// DefaultDrainTimeout.
+func WithDrainTimeout(timeout time.Duration) Option {
+	return func(tb *Bot) {
+		if timeout > 0 {
+			tb.drainTimeout = timeout
+		}
+	}
+}
+
+// WithForwardAttribution makes the text of forwarded messages start with a line telling who they come from (and, for
+// public channels, a link to the original), so posts made of them credit the author. Forwarded commands are left alone.
+func WithForwardAttribution() Option {
+	return func(tb *Bot) {
+		tb.forwardAttribution = true
 	}
+}
 
-	allowedUsersMap := make(map[uint64]bool, len(allowedUsers))
-	for _, u := range allowedUsers {
-		allowedUsersMap[u] = true
+// WithAPIServer makes the bot talk to the bot API at serverURL instead of telegram's, i.e. a local bot API server,
+// which lets bots download files larger than DefaultMaxDownloadSize.
+func WithAPIServer(serverURL string) Option {
+	return func(tb *Bot) {
+		tb.apiServer = serverURL
 	}
+}
+
+// WithLogger sets where the bot logs, it defaults to slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(tb *Bot) {
+		tb.logger = logger
+	}
+}
+
+// New creates a new Telegram bot instance, only the messages gate admits reach the flows.
+// Updates are received through a webhook at webhookURL, if it is nil they are polled for instead, which needs no
+// public URL and suits local development.
+func New(ctx context.Context,
+	token string, webhookSecret string, webhookURL *url.URL, gate *im.Gate,
+	schedulerFn im.SchedulerFactoryFN, opts ...Option) (*Bot, error) {
 	tb := &Bot{
-		bot:                  b,
+		webhookSecret:        webhookSecret,
 		flowSchedulerFactory: schedulerFn,
-		flowSchedulers:       make(map[uint64]*im.FlowScheduler),
-		allowedUsers:         allowedUsersMap,
+		flowSchedulers:       make(map[uint64]*schedulerEntry),
+		gate:                 gate,
+		maxDownloadSize:      DefaultMaxDownloadSize,
+		drainTimeout:         DefaultDrainTimeout,
+		mux:                  http.NewServeMux(),
+		logger:               slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(tb)
 	}
+	tb.handlersCtx, tb.cancelHandlers = context.WithCancel(context.WithoutCancel(ctx))
+	tb.logger = tb.logger.With("im", "telegram")
 
-	wasSet, err := tb.bot.SetWebhook(ctx, &bot.SetWebhookParams{
-		URL:         webhookURL.String(),
-		SecretToken: webhookSecret,
-	})
+	// Create the underlying bot, updates no registered handler matches (i.e. edited messages) also go through
+	// defaultHandler.
+	botOpts := []bot.Option{bot.WithWebhookSecretToken(webhookSecret), bot.WithDefaultHandler(tb.defaultHandler)}
+	if tb.apiServer != "" {
+		botOpts = append(botOpts, bot.WithServerURL(tb.apiServer))
+	}
+	b, err := bot.New(token, botOpts...)
 	if err != nil {
-		return nil, fmt.Errorf("telegram set https webhook: %w", err)
+		return nil, err
 	}
-	if !wasSet {
-		return nil, fmt.Errorf("telegram set webhook")
+	tb.bot = b
+	tb.mediaGroups = newMediaGroupBuffer(mediaGroupDebounce, tb.dispatch)
+
+	if webhookURL == nil {
+		// telegram refuses getUpdates while a webhook is set, i.e. from a previous run.
+		tb.polling = true
+		if _, err := tb.bot.DeleteWebhook(ctx, &bot.DeleteWebhookParams{}); err != nil {
+			return nil, fmt.Errorf("telegram delete webhook: %w", err)
+		}
+	} else {
+		if webhookSecret == "" {
+			return nil, ErrNoWebhookSecret
+		}
+		if tb.webhookPath != "" {
+			webhookURL = webhookURL.JoinPath(tb.webhookPath)
+		}
+		tb.webhookPath = webhookURL.Path
+		if tb.webhookPath == "" {
+			tb.webhookPath = "/"
+		}
+		wasSet, err := tb.bot.SetWebhook(ctx, &bot.SetWebhookParams{
+			URL:         webhookURL.String(),
+			SecretToken: webhookSecret,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("telegram set https webhook: %w", err)
+		}
+		if !wasSet {
+			return nil, fmt.Errorf("telegram set webhook")
+		}
+		tb.mountHandlers()
 	}
 	re := regexp.MustCompile(".*")
 	tb.bot.RegisterHandlerRegexp(bot.HandlerTypeMessageText, re, tb.defaultHandler)
 	tb.bot.RegisterHandlerRegexp(bot.HandlerTypePhotoCaption, re, tb.defaultHandler)
 	tb.bot.RegisterHandlerRegexp(bot.HandlerTypeCallbackQueryData, re, tb.defaultHandler)
 	tb.bot.RegisterHandlerRegexp(bot.HandlerTypeCallbackQueryGameShortName, re, tb.defaultHandler)
-	log.Printf("telegram bot created")
+	tb.logger.Info("bot created", "polling", tb.polling)
 	return tb, nil
 }
 
-// Start runs the bot until the given context is canceled.
+// Start runs the bot until the given context is canceled, addr is where the webhook, and the probes (see HealthPath
+// and ReadyPath), listen, unused when polling.
+// Once ctx is canceled no more updates are taken and Start waits, up to the drain timeout, for the messages being
+// handled before returning, ErrDrainTimeout if some did not finish in time.
 func (tb *Bot) Start(ctx context.Context, addr string) error {
+	// Build the schedulers of the known users right away, their flows might have work to do (i.e. scheduled posts)
+	// before they talk to us again.
+	for _, userID := range tb.gate.Users() {
+		if _, err := tb.schedulerFor(userID); err != nil {
+			tb.logger.Error("building flow scheduler", "user_id", userID, "err", err)
+		}
+	}
+
+	if tb.polling {
+		tb.logger.Info("polling for updates")
+		tb.bot.Start(ctx)
+		return tb.drain()
+	}
+
+	server := &http.Server{Addr: addr, Handler: tb.mux}
 	go func() {
-		log.Printf("telegram http listen on %s", addr)
-		err := http.ListenAndServe(addr, tb.bot.WebhookHandler())
+		listener, err := net.Listen("tcp", addr)
 		if err != nil {
-			log.Printf("telegram http listen err: %v", err)
+			tb.logger.Error("webhook listen", "addr", addr, "err", err)
+			return
+		}
+		tb.logger.Info("webhook listening", "addr", addr, "path", tb.webhookPath)
+		// the webhook was set when the bot was created.
+		tb.ready.Store(true)
+		err = server.Serve(listener)
+		tb.ready.Store(false)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			tb.logger.Error("webhook serve", "addr", addr, "err", err)
 		}
 	}()
 
 	// Use StartWebhook instead of Start
 	tb.bot.StartWebhook(ctx)
-	return nil
+
+	// updates we don't take are retried by telegram, they will be handled once we are back.
+	tb.ready.Store(false)
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), tb.drainTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		tb.logger.Warn("webhook shutdown", "addr", addr, "err", err)
+	}
+	return tb.drain()
 }
 
-// Stop is wishful thinking for now.
-func (tb *Bot) Stop() {
+// track counts a message as being handled, done must be called once it is. It returns false, and the message must be
+// dropped, if the bot is draining.
+func (tb *Bot) track() bool {
+	tb.drainMutex.Lock()
+	defer tb.drainMutex.Unlock()
+	if tb.draining {
+		return false
+	}
+	tb.inFlight.Add(1)
+	return true
 }
 
-// defaultHandler processes any non-command (or unmatched) messages.
-// If a chat is in "writing mode", the message content is appended to the post.
-func (tb *Bot) defaultHandler(ctx context.Context, b *bot.Bot, u *models.Update) {
-	log.Printf("telegram default handler from chat ID %d", u.Message.Chat.ID)
-	if u.Message == nil {
+// done marks a message counted by track as handled.
+func (tb *Bot) done() {
+	tb.inFlight.Done()
+}
+
+// drain stops taking messages and waits, up to the drain timeout, for the ones being handled, then cancels their
+// context.
+func (tb *Bot) drain() error {
+	tb.drainMutex.Lock()
+	tb.draining = true
+	tb.drainMutex.Unlock()
+	defer tb.cancelHandlers()
+
+	finished := make(chan struct{})
+	go func() {
+		tb.inFlight.Wait()
+		close(finished)
+	}()
+	tb.logger.Info("waiting for messages being handled", "timeout", tb.drainTimeout)
+	select {
+	case <-finished:
+		return nil
+	case <-time.After(tb.drainTimeout):
+		return ErrDrainTimeout
+	}
+}
+
+// secretTokenHeader is where telegram puts the secret token given to SetWebhook.
+const secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// requireSecret rejects, with 403, webhook requests that don't carry our secret token, anyone can find the URL but
+// only telegram knows the secret.
+func (tb *Bot) requireSecret(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(secretTokenHeader)
+		if tb.webhookSecret == "" || subtle.ConstantTimeCompare([]byte(token), []byte(tb.webhookSecret)) != 1 {
+			tb.logger.Warn("webhook request without the right secret rejected", "remote_addr", r.RemoteAddr,
+				"forwarded_for", r.Header.Get("X-Forwarded-For"))
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// schedulerEntry is the FlowScheduler of a user, built by the first update that asks for it while the rest wait.
+type schedulerEntry struct {
+	once  sync.Once
+	sched *im.FlowScheduler
+	err   error
+}
+
+// schedulerFor returns the FlowScheduler for the given user, creating it the first time we hear from them.
+// Handlers run concurrently so the map is guarded, but the lock is only held to find or add the user's entry, the
+// factory (which might log in to platforms) runs once per user outside of it, so other users are not kept waiting.
+// If it fails the entry is dropped and the next update tries again.
+func (tb *Bot) schedulerFor(userID uint64) (*im.FlowScheduler, error) {
+	tb.flowSchedulersMutex.Lock()
+	entry, ok := tb.flowSchedulers[userID]
+	if !ok {
+		entry = &schedulerEntry{}
+		tb.flowSchedulers[userID] = entry
+	}
+	tb.flowSchedulersMutex.Unlock()
+
+	entry.once.Do(func() {
+		entry.sched, entry.err = tb.flowSchedulerFactory(userID, tb)
+	})
+	if entry.err != nil {
+		tb.flowSchedulersMutex.Lock()
+		if tb.flowSchedulers[userID] == entry {
+			delete(tb.flowSchedulers, userID)
+		}
+		tb.flowSchedulersMutex.Unlock()
+		return nil, entry.err
+	}
+	return entry.sched, nil
+}
+
+// defaultHandler processes any message or button press, messages go to the flow scheduler of the user, albums once
+// they are complete.
+func (tb *Bot) defaultHandler(_ context.Context, b *bot.Bot, u *models.Update) {
+	if !tb.track() {
+		tb.logger.Warn("update dropped while stopping", "update_id", u.ID)
+		return
+	}
+	defer tb.done()
+	// the context handlers are given ends as soon as we are asked to stop, we want to finish what we started.
+	ctx := tb.handlersCtx
+
+	from := updateSender(u)
+	if from == nil {
+		// nothing we handle, i.e. channel posts or game callbacks.
+		return
+	}
+	// strangers are dropped before downloading anything they sent.
+	if !tb.gate.Allowed(uint64(from.ID)) {
+		tb.logger.Warn("user not allowed", "user_id", from.ID)
 		return
 	}
-	if u.Message.From == nil {
+	if u.CallbackQuery != nil {
+		// telegram shows the button as loading until we answer.
+		_, err := b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: u.CallbackQuery.ID})
+		if err != nil {
+			tb.logger.Error("answering callback query", "user_id", from.ID, "err", err)
+		}
+	}
+
+	message, err := messageFromTelegramMessage(ctx, b, u, tb.maxDownloadSize)
+	switch {
+	case errors.Is(err, ErrUnsupportedMedia):
+		tb.logger.Info("unsupported media", "user_id", from.ID, "err", err)
+		tb.replyToUpdate(ctx, u, fmt.Sprintf("Sorry, %s files are not supported, send images or videos.",
+			documentMimeType(u.Message.Document)))
+		return
+	case errors.Is(err, ErrFileTooLarge):
+		tb.logger.Info("file too large", "user_id", from.ID, "err", err)
+		tb.replyToUpdate(ctx, u, fmt.Sprintf("Sorry, that file is too large, the limit is %dMB.",
+			tb.maxDownloadSize>>20))
+		return
+	case err != nil:
+		tb.logger.Error("translating telegram message", "user_id", from.ID, "err", err)
 		return
 	}
-	if !tb.allowedUsers[uint64(u.Message.From.ID)] {
-		log.Printf("telegram default handler: user not allowed: %d", u.Message.From.ID)
+	tb.logger.Debug("message received", "user_id", message.UserID, "chat_id", message.ChatID)
+	if tb.forwardAttribution && u.Message != nil && message.Text != "" && !message.IsCommand() {
+		if attribution := forwardAttribution(u.Message); attribution != "" {
+			message.Text = attribution + "\n" + message.Text
+		}
+	}
+
+	// albums arrive as one update per item, we want them as a single message.
+	if u.Message != nil && u.Message.MediaGroupID != "" {
+		tb.mediaGroups.add(ctx, u.Message.MediaGroupID, message)
 		return
 	}
+	tb.dispatch(ctx, message)
+}
 
-	message, err := messageFromTelegramMessage(ctx, b, u)
+// replyToUpdate answers the message of u with text, for when we can't make an im.Message out of it.
+func (tb *Bot) replyToUpdate(ctx context.Context, u *models.Update, text string) {
+	if u.Message == nil {
+		return
+	}
+	err := tb.SendMessage(ctx, &im.Message{
+		ChatID:    u.Message.Chat.ID,
+		InReplyTo: uint64(u.Message.ID),
+		Text:      text,
+	})
 	if err != nil {
-		log.Printf("telegram message from telegram message err: %v", err)
+		tb.logger.Error("replying to update", "chat_id", u.Message.Chat.ID, "err", err)
+	}
+}
+
+// dispatch hands message to the flow scheduler of its user.
+func (tb *Bot) dispatch(ctx context.Context, message *im.Message) {
+	// albums are dispatched once complete, long after the handler of their last item returned.
+	if !tb.track() {
+		tb.logger.Warn("message dropped while stopping", "user_id", message.UserID)
 		return
 	}
+	defer tb.done()
 
-	var sched = tb.flowSchedulers[message.UserID]
+	// albums count as a single message.
+	if err := tb.gate.Admit(ctx, message, tb); err != nil {
+		tb.logger.Warn("message not admitted", "user_id", message.UserID, "err", err)
+		return
+	}
 
-	if sched == nil {
-		sched, err = tb.flowSchedulerFactory(message.UserID)
-		if err != nil {
-			log.Printf("telegram flow scheduler factory err: %v", err)
-			return
-		}
-		tb.flowSchedulers[message.UserID] = sched
+	sched, err := tb.schedulerFor(message.UserID)
+	if err != nil {
+		tb.logger.Error("building flow scheduler", "user_id", message.UserID, "err", err)
+		return
 	}
 
 	err = sched.HandleMessage(ctx, message, tb)
 	if err != nil {
-		log.Printf("telegram handle message err: %v", err)
+		tb.logger.Error("handling message", "user_id", message.UserID, "err", err)
 		return
 	}
-	return
 }
@@ -0,0 +1,556 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot"
+
+	"github.com/perrito666/chat2world/blogging"
+	"github.com/perrito666/chat2world/config"
+	"github.com/perrito666/chat2world/im"
+)
+
+// fakeAPI is a telegram bot API that succeeds at everything, keeping which methods were called, the URL of the
+// webhook set and the text and parse mode of the messages sent, it serves files with the contents in files by their
+// id.
+type fakeAPI struct {
+	mu         sync.Mutex
+	methods    []string
+	webhookURL string
+	sent       []sentMessage
+	// refuseFormatting makes sendMessage fail, as telegram does with broken formatting, when a parse mode is given.
+	refuseFormatting bool
+	files            map[string][]byte
+	// hideFileSizes makes getFile not tell the size of the files, as telegram sometimes does.
+	hideFileSizes bool
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if fileID, ok := strings.CutPrefix(r.URL.Path, "/file/bottoken/documents/"); ok {
+		data, found := f.files[fileID]
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(data)
+		return
+	}
+	method := path.Base(r.URL.Path)
+	f.mu.Lock()
+	f.methods = append(f.methods, method)
+	if method == "setWebhook" {
+		f.webhookURL = r.FormValue("url")
+	}
+	refused := f.refuseFormatting && method == "sendMessage" && r.FormValue("parse_mode") != ""
+	if method == "sendMessage" && !refused {
+		f.sent = append(f.sent, sentMessage{text: r.FormValue("text"), parseMode: r.FormValue("parse_mode")})
+	}
+	f.mu.Unlock()
+	if refused {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"ok":false,"error_code":400,"description":"Bad Request: can't parse entities: unclosed tag"}`)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	switch method {
+	case "getFile":
+		fileID := r.FormValue("file_id")
+		size := len(f.files[fileID])
+		if f.hideFileSizes {
+			size = 0
+		}
+		fmt.Fprintf(w, `{"ok":true,"result":{"file_id":%q,"file_unique_id":%q,"file_size":%d,"file_path":"documents/%s"}}`,
+			fileID, fileID, size, fileID)
+	case "getMe":
+		fmt.Fprint(w, `{"ok":true,"result":{"id":1,"is_bot":true,"first_name":"chat2world","username":"chat2world_bot"}}`)
+	case "sendMessage":
+		fmt.Fprint(w, `{"ok":true,"result":{"message_id":1,"date":1700000000,"chat":{"id":99,"type":"private"}}}`)
+	case "getUpdates":
+		fmt.Fprint(w, `{"ok":true,"result":[]}`)
+	default:
+		fmt.Fprint(w, `{"ok":true,"result":true}`)
+	}
+}
+
+// sentMessage is the text of a message sent through a fakeAPI with the parse mode it was sent with.
+type sentMessage struct {
+	text, parseMode string
+}
+
+// called returns the methods called, in order.
+func (f *fakeAPI) called() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.methods...)
+}
+
+// newTestAPI returns a fake bot API and a client of it.
+func newTestAPI(t *testing.T) (*fakeAPI, *bot.Bot) {
+	t.Helper()
+	api := &fakeAPI{}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	b, err := bot.New("token", bot.WithServerURL(server.URL), bot.WithSkipGetMe())
+	if err != nil {
+		t.Fatalf("bot.New: %v", err)
+	}
+	return api, b
+}
+
+// newTestBot returns a bot of user 42 talking to a fake bot API, receiving updates through a webhook at webhookURL or,
+// if it is nil, polling for them, with opts on top.
+func newTestBot(t *testing.T, webhookSecret string, webhookURL *url.URL, opts ...Option) (*Bot, *fakeAPI) {
+	t.Helper()
+	api := &fakeAPI{}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	factory := func(uint64, im.Messenger) (*im.FlowScheduler, error) { return im.NewScheduler(), nil }
+	opts = append([]Option{WithAPIServer(server.URL), WithLogger(discardLogger)}, opts...)
+	tb, err := New(context.Background(), "token", webhookSecret, webhookURL, im.NewGate([]uint64{42}), factory, opts...)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return tb, api
+}
+
+// discardLogger drops everything logged to it.
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestSchedulerForCreatesOneSchedulerPerUser(t *testing.T) {
+	var created atomic.Int32
+	tb := &Bot{
+		flowSchedulers: make(map[uint64]*schedulerEntry),
+		flowSchedulerFactory: func(userID uint64, messenger im.Messenger) (*im.FlowScheduler, error) {
+			created.Add(1)
+			return im.NewScheduler(), nil
+		},
+	}
+
+	const updates = 50
+	schedulers := make([]*im.FlowScheduler, updates)
+	var wg sync.WaitGroup
+	for i := range updates {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sched, err := tb.schedulerFor(42)
+			if err != nil {
+				t.Errorf("schedulerFor: %v", err)
+			}
+			schedulers[i] = sched
+		}()
+	}
+	wg.Wait()
+	if n := created.Load(); n != 1 {
+		t.Errorf("the factory ran %d times, want once", n)
+	}
+	for i, sched := range schedulers {
+		if sched != schedulers[0] {
+			t.Errorf("update %d got a different scheduler", i)
+		}
+	}
+}
+
+func TestSchedulerForDoesNotWaitForOtherUsers(t *testing.T) {
+	release := make(chan struct{})
+	tb := &Bot{
+		flowSchedulers: make(map[uint64]*schedulerEntry),
+		flowSchedulerFactory: func(userID uint64, messenger im.Messenger) (*im.FlowScheduler, error) {
+			// user 1 takes long to build, i.e. logging in to a platform that hangs.
+			if userID == 1 {
+				<-release
+			}
+			return im.NewScheduler(), nil
+		},
+	}
+	slow := make(chan error)
+	go func() {
+		_, err := tb.schedulerFor(1)
+		slow <- err
+	}()
+
+	fast := make(chan error)
+	go func() {
+		_, err := tb.schedulerFor(2)
+		fast <- err
+	}()
+	select {
+	case err := <-fast:
+		if err != nil {
+			t.Errorf("schedulerFor(2): %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("building the scheduler of a user waited for that of another")
+	}
+	close(release)
+	if err := <-slow; err != nil {
+		t.Errorf("schedulerFor(1): %v", err)
+	}
+}
+
+func TestSchedulerForRetriesAfterAFailure(t *testing.T) {
+	var calls atomic.Int32
+	tb := &Bot{
+		flowSchedulers: make(map[uint64]*schedulerEntry),
+		flowSchedulerFactory: func(userID uint64, messenger im.Messenger) (*im.FlowScheduler, error) {
+			if calls.Add(1) == 1 {
+				return nil, errors.New("platform down")
+			}
+			return im.NewScheduler(), nil
+		},
+	}
+	if _, err := tb.schedulerFor(42); err == nil {
+		t.Fatal("schedulerFor() succeeded with a failing factory")
+	}
+	if sched, err := tb.schedulerFor(42); err != nil || sched == nil {
+		t.Errorf("schedulerFor() = %v, %v after a failure, want a new scheduler", sched, err)
+	}
+}
+
+func TestUpdatesWithoutMessageAreDropped(t *testing.T) {
+	api, b := newTestAPI(t)
+	tb := &Bot{
+		bot:            b,
+		gate:           im.NewGate([]uint64{42}),
+		flowSchedulers: make(map[uint64]*schedulerEntry),
+		flowSchedulerFactory: func(userID uint64, messenger im.Messenger) (*im.FlowScheduler, error) {
+			t.Errorf("an update without message reached the flows of user %d", userID)
+			return im.NewScheduler(), nil
+		},
+		logger:      discardLogger,
+		handlersCtx: context.Background(),
+	}
+	for name, raw := range map[string]string{
+		"empty":          `{"update_id":1}`,
+		"channel post":   `{"update_id":2,"channel_post":{"message_id":1,"date":1,"chat":{"id":-100,"type":"channel"},"text":"hi"}}`,
+		"channel edit":   `{"update_id":3,"edited_channel_post":{"message_id":1,"date":1,"chat":{"id":-100,"type":"channel"}}}`,
+		"edit by nobody": `{"update_id":4,"edited_message":{"message_id":1,"date":1,"chat":{"id":99,"type":"private"},"text":"hi"}}`,
+		"caption edit": `{"update_id":5,"edited_message":{"message_id":1,"date":1,"chat":{"id":99,"type":"private"},
+			"from":{"id":42,"first_name":"Me"},"caption":"new caption"}}`,
+		"game callback": `{"update_id":6,"callback_query":{"id":"q1","from":{"id":42,"first_name":"Me"},
+			"chat_instance":"c","game_short_name":"game"}}`,
+		"inline callback": `{"update_id":7,"callback_query":{"id":"q2","from":{"id":42,"first_name":"Me"},
+			"inline_message_id":"i","chat_instance":"c","data":"send:confirm"}}`,
+		"member update": `{"update_id":8,"my_chat_member":{"chat":{"id":99,"type":"private"},"from":{"id":42,"first_name":"Me"},
+			"date":1,"old_chat_member":{"status":"member","user":{"id":1,"is_bot":true,"first_name":"bot"}},
+			"new_chat_member":{"status":"kicked","user":{"id":1,"is_bot":true,"first_name":"bot"},"until_date":0}}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			tb.defaultHandler(context.Background(), b, decodeUpdate(t, raw))
+		})
+	}
+	// the button of the inline callback stops loading even if we can't do anything with it.
+	if calls := api.called(); len(calls) != 1 || calls[0] != "answerCallbackQuery" {
+		t.Errorf("called %q, want only answerCallbackQuery", calls)
+	}
+}
+
+func TestPollingDoesNotSetAWebhook(t *testing.T) {
+	tb, api := newTestBot(t, "", nil)
+	if !tb.polling {
+		t.Error("a bot without webhook URL is not polling")
+	}
+	for _, method := range api.called() {
+		if method == "setWebhook" {
+			t.Error("setWebhook was called for a polling bot")
+		}
+	}
+	// a webhook left by an earlier run would make getUpdates fail.
+	if !slices.Contains(api.called(), "deleteWebhook") {
+		t.Errorf("called %q, want deleteWebhook", api.called())
+	}
+}
+
+func TestWebhookIsSet(t *testing.T) {
+	webhookURL, _ := url.Parse("https://example.com/telegram")
+	tb, api := newTestBot(t, "secret", webhookURL)
+	if tb.polling {
+		t.Error("a bot with a webhook URL is polling")
+	}
+	if !slices.Contains(api.called(), "setWebhook") {
+		t.Errorf("called %q, want setWebhook", api.called())
+	}
+}
+
+func TestWebhookRequiresTheSecret(t *testing.T) {
+	webhookURL, _ := url.Parse("https://example.com/telegram")
+	tb, _ := newTestBot(t, "secret", webhookURL)
+	// updates that make it through are taken by the bot.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tb.bot.StartWebhook(ctx)
+
+	for _, tc := range []struct {
+		name, secret string
+		want         int
+	}{
+		{"no secret", "", http.StatusForbidden},
+		{"wrong secret", "guessed", http.StatusForbidden},
+		{"a prefix of the secret", "sec", http.StatusForbidden},
+		{"right secret", "secret", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/telegram", strings.NewReader(`{"update_id":1}`))
+		if tc.secret != "" {
+			req.Header.Set(secretTokenHeader, tc.secret)
+		}
+		rec := httptest.NewRecorder()
+		tb.mux.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, rec.Code, tc.want)
+		}
+	}
+}
+
+// slowFlow is started with /slow and takes until released, or until its context is canceled, to do so.
+type slowFlow struct {
+	started  chan struct{}
+	release  chan struct{}
+	canceled atomic.Bool
+}
+
+func (f *slowFlow) Start(ctx context.Context, _ *im.Message, _ im.Messenger) error {
+	close(f.started)
+	select {
+	case <-f.release:
+	case <-ctx.Done():
+		f.canceled.Store(true)
+	}
+	return nil
+}
+
+func (f *slowFlow) HandleMessage(context.Context, *im.Message, im.Messenger) error {
+	return nil
+}
+
+func (f *slowFlow) StartCommandParser(string) (string, []string, error) {
+	return "", nil, nil
+}
+
+// startSlowMessage returns a polling bot, with the given drain timeout, already handling /slow from user 42 and the
+// flow handling it.
+func startSlowMessage(t *testing.T, drainTimeout time.Duration) (*Bot, *slowFlow) {
+	t.Helper()
+	api := &fakeAPI{}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	flow := &slowFlow{started: make(chan struct{}), release: make(chan struct{})}
+	factory := func(uint64, im.Messenger) (*im.FlowScheduler, error) {
+		fs := im.NewScheduler()
+		return fs, fs.RegisterFlow(flow, "slow", []string{"/slow"})
+	}
+	tb, err := New(context.Background(), "token", "", nil, im.NewGate([]uint64{42}), factory,
+		WithAPIServer(server.URL), WithLogger(discardLogger), WithDrainTimeout(drainTimeout))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	u := decodeUpdate(t, `{"update_id":1,"message":{"message_id":7,"date":1,"chat":{"id":42,"type":"private"},
+		"from":{"id":42,"first_name":"Me"},"text":"/slow"}}`)
+	go tb.defaultHandler(context.Background(), tb.bot, u)
+	<-flow.started
+	return tb, flow
+}
+
+func TestStopWaitsForMessagesBeingHandled(t *testing.T) {
+	tb, flow := startSlowMessage(t, 5*time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() { stopped <- tb.Start(ctx, "") }()
+	cancel()
+
+	select {
+	case err := <-stopped:
+		t.Fatalf("Start returned %v while a message was being handled", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(flow.release)
+	if err := <-stopped; err != nil {
+		t.Errorf("Start() = %v, want nil once the message was handled", err)
+	}
+	if flow.canceled.Load() {
+		t.Error("the message was canceled, want it finished")
+	}
+	if tb.track() {
+		t.Error("a stopped bot takes new messages")
+	}
+}
+
+func TestStopGivesUpAfterTheDrainTimeout(t *testing.T) {
+	tb, flow := startSlowMessage(t, 50*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := tb.Start(ctx, ""); !errors.Is(err, ErrDrainTimeout) {
+		t.Errorf("Start() = %v, want ErrDrainTimeout", err)
+	}
+	// once given up on, the message being handled is canceled.
+	deadline := time.Now().Add(5 * time.Second)
+	for !flow.canceled.Load() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if !flow.canceled.Load() {
+		t.Error("the message still being handled was not canceled")
+	}
+}
+
+// postedPlatform is an authorized blogging platform keeping what is posted to it.
+type postedPlatform struct {
+	mu    sync.Mutex
+	posts []*blogging.MicroblogPost
+}
+
+func (p *postedPlatform) Post(_ context.Context, _ blogging.UserID, post *blogging.MicroblogPost) (*blogging.PostResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.posts = append(p.posts, post)
+	return &blogging.PostResult{URL: "https://example.com/1", Parts: 1}, nil
+}
+
+func (p *postedPlatform) Config(blogging.UserID) (blogging.ClientConfig, error) {
+	return nil, blogging.ErrClientNotFound
+}
+
+func (p *postedPlatform) IsAuthorized(blogging.UserID) bool {
+	return true
+}
+
+func (p *postedPlatform) StartAuthorization(context.Context, blogging.UserID, map[string]string) (chan string, error) {
+	return nil, nil
+}
+
+func TestPhotosArePostedEndToEnd(t *testing.T) {
+	api := &fakeAPI{files: map[string][]byte{"cat": []byte("a cat photo"), "dog": []byte("a dog photo")}}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	platform := &postedPlatform{}
+	factory := func(uint64, im.Messenger) (*im.FlowScheduler, error) {
+		fs := im.NewScheduler()
+		posting := blogging.NewPostingFlow(map[config.AvailableBloggingPlatform]blogging.AuthedPlatform{
+			config.MBPMastodon: platform,
+		}, nil)
+		return fs, fs.RegisterFlow(posting, "microblog_post", []string{"/new"})
+	}
+	tb, err := New(context.Background(), "token", "", nil, im.NewGate([]uint64{42}), factory,
+		WithAPIServer(server.URL), WithLogger(discardLogger))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	for i, message := range []string{
+		`"text":"/new"`,
+		`"text":"two pets"`,
+		`"caption":"a cat","photo":[{"file_id":"cat","file_unique_id":"c","width":900,"height":900}]`,
+		`"photo":[{"file_id":"dog","file_unique_id":"d","width":900,"height":900}]`,
+		`"text":"/send"`,
+	} {
+		u := decodeUpdate(t, fmt.Sprintf(`{"update_id":%d,"message":{"message_id":%d,"date":1,
+			"chat":{"id":42,"type":"private"},"from":{"id":42,"first_name":"Me"},%s}}`, i+1, i+1, message))
+		tb.defaultHandler(context.Background(), tb.bot, u)
+	}
+
+	platform.mu.Lock()
+	defer platform.mu.Unlock()
+	if len(platform.posts) != 1 {
+		t.Fatalf("posted %d times, want 1", len(platform.posts))
+	}
+	post := platform.posts[0]
+	if post.Text != "two pets" || len(post.Images) != 2 {
+		t.Fatalf("posted %q with %d images, want the text and both photos", post.Text, len(post.Images))
+	}
+	if string(post.Images[0].Data) != "a cat photo" || post.Images[0].AltText != "a cat" ||
+		string(post.Images[1].Data) != "a dog photo" {
+		t.Errorf("images = %q (%q), %q, want the photos in order, the cat described", post.Images[0].Data,
+			post.Images[0].AltText, post.Images[1].Data)
+	}
+}
+
+func TestConflictingCommandsAreRefused(t *testing.T) {
+	api := &fakeAPI{}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	first, second := &countingFlow{}, &countingFlow{}
+	factory := func(uint64, im.Messenger) (*im.FlowScheduler, error) {
+		fs := im.NewScheduler()
+		if err := fs.RegisterFlow(first, "first", []string{"/new"}); err != nil {
+			return nil, err
+		}
+		return fs, fs.RegisterFlow(second, "second", []string{"/edit", "/new"})
+	}
+	tb, err := New(context.Background(), "token", "", nil, im.NewGate([]uint64{42}), factory,
+		WithAPIServer(server.URL), WithLogger(discardLogger))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if _, err := tb.schedulerFor(42); !errors.Is(err, im.ErrFlowTriggerConflict) {
+		t.Errorf("schedulerFor() err = %v, want ErrFlowTriggerConflict", err)
+	}
+	tb.defaultHandler(context.Background(), tb.bot, decodeUpdate(t, `{"update_id":1,"message":{"message_id":1,"date":1,
+		"chat":{"id":42,"type":"private"},"from":{"id":42,"first_name":"Me"},"text":"/new"}}`))
+	if first.started+second.started != 0 {
+		t.Error("a flow was started by a scheduler with conflicting commands")
+	}
+	if slices.Contains(api.called(), "sendMessage") {
+		t.Errorf("called %q, want nothing sent", api.called())
+	}
+}
+
+// countingFlow counts how many times it is started.
+type countingFlow struct {
+	started int
+}
+
+func (f *countingFlow) Start(context.Context, *im.Message, im.Messenger) error {
+	f.started++
+	return nil
+}
+
+func (f *countingFlow) HandleMessage(context.Context, *im.Message, im.Messenger) error {
+	return nil
+}
+
+func (f *countingFlow) StartCommandParser(string) (string, []string, error) {
+	return "", nil, nil
+}
+
+func TestOnlyAllowedUsersReachTheFlows(t *testing.T) {
+	api := &fakeAPI{}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	flow := &countingFlow{}
+	factory := func(uint64, im.Messenger) (*im.FlowScheduler, error) {
+		fs := im.NewScheduler()
+		return fs, fs.RegisterFlow(flow, "new", []string{"/new"})
+	}
+	tb, err := New(context.Background(), "token", "", nil, im.NewGate([]uint64{42}), factory,
+		WithAPIServer(server.URL), WithLogger(discardLogger))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	// newMessage returns an update of a /new sent by userID.
+	newMessage := func(userID int) string {
+		return fmt.Sprintf(`{"update_id":1,"message":{"message_id":1,"date":1,"chat":{"id":%d,"type":"private"},
+			"from":{"id":%d,"first_name":"Someone"},"text":"/new"}}`, userID, userID)
+	}
+
+	tb.defaultHandler(context.Background(), tb.bot, decodeUpdate(t, newMessage(13)))
+	if flow.started != 0 {
+		t.Error("the message of a stranger started a flow")
+	}
+	tb.defaultHandler(context.Background(), tb.bot, decodeUpdate(t, newMessage(42)))
+	if flow.started != 1 {
+		t.Errorf("the message of an allowed user started %d flows, want 1", flow.started)
+	}
+}
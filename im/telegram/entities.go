@@ -0,0 +1,171 @@
+package telegram
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/go-telegram/bot/models"
+)
+
+// entityMarkers are the markdown around the text of each kind of telegram formatting we keep, the rest (i.e. links
+// and mentions typed as such, underline) is already in the text or has no markdown.
+var entityMarkers = map[models.MessageEntityType][2]string{
+	models.MessageEntityTypeBold:          {"**", "**"},
+	models.MessageEntityTypeItalic:        {"_", "_"},
+	models.MessageEntityTypeStrikethrough: {"~~", "~~"},
+	models.MessageEntityTypeCode:          {"`", "`"},
+}
+
+// marker is markdown to insert at pos, a position of the text in UTF-16 code units as telegram counts them.
+type marker struct {
+	pos int
+	// closing markers go before opening ones at the same position, closing the last opened first.
+	closing bool
+	// start and end are those of the span the marker belongs to, to tell how to nest markers at the same position.
+	start, end int
+	text       string
+	// literal tells if the marker belongs to a code span or a URL, whose text is taken as is.
+	literal bool
+}
+
+// markdownEscaper escapes the markdown markers in the text of formatted spans, so they are not taken for the markers
+// around it.
+var markdownEscaper = strings.NewReplacer("*", `\*`, "_", `\_`, "~", `\~`, "`", "\\`")
+
+// entitiesToMarkdown writes the formatting telegram sends apart from the text, as entities, in markdown into text,
+// the way it is typed for platforms that take markdown and stripped for the rest (see blogging.MicroblogPost.RenderFor).
+// Markdown can't span lines so formatting of several lines is applied to each, without the spaces around it. Markers
+// already in formatted text are escaped, except in code and URLs.
+func entitiesToMarkdown(text string, entities []models.MessageEntity) string {
+	if len(entities) == 0 {
+		return text
+	}
+	units := utf16.Encode([]rune(text))
+	var markers []marker
+	for _, entity := range entities {
+		start, end := entity.Offset, entity.Offset+entity.Length
+		if start < 0 || end > len(units) || start >= end {
+			continue
+		}
+		open, closing := "", ""
+		switch entity.Type {
+		case models.MessageEntityTypeTextLink:
+			open, closing = "[", "]("+entity.URL+")"
+		case models.MessageEntityTypeURL, models.MessageEntityTypeEmail:
+			// nothing to add, but escaping a URL in formatted text would break it.
+		default:
+			m, ok := entityMarkers[entity.Type]
+			if !ok {
+				continue
+			}
+			open, closing = m[0], m[1]
+		}
+		literal := entity.Type == models.MessageEntityTypeCode || entity.Type == models.MessageEntityTypeURL ||
+			entity.Type == models.MessageEntityTypeEmail
+		for _, span := range lineSpans(units, start, end) {
+			markers = append(markers,
+				marker{pos: span[0], start: span[0], end: span[1], text: open, literal: literal},
+				marker{pos: span[1], closing: true, start: span[0], end: span[1], text: closing, literal: literal})
+		}
+	}
+	slices.SortStableFunc(markers, func(a, b marker) int {
+		if c := cmp.Compare(a.pos, b.pos); c != 0 {
+			return c
+		}
+		switch {
+		case a.closing && !b.closing:
+			return -1
+		case !a.closing && b.closing:
+			return 1
+		case a.closing:
+			// the span opened last closes first.
+			return cmp.Compare(b.start, a.start)
+		}
+		// the span closing last opens first.
+		return cmp.Compare(b.end, a.end)
+	})
+
+	var sb strings.Builder
+	last, formatted, literal := 0, 0, 0
+	for _, m := range markers {
+		segment := string(utf16.Decode(units[last:m.pos]))
+		if formatted > 0 && literal == 0 {
+			segment = markdownEscaper.Replace(segment)
+		}
+		sb.WriteString(segment)
+		sb.WriteString(m.text)
+		last = m.pos
+		depth := 1
+		if m.closing {
+			depth = -1
+		}
+		formatted += depth
+		if m.literal {
+			literal += depth
+		}
+	}
+	sb.WriteString(string(utf16.Decode(units[last:])))
+	return sb.String()
+}
+
+// lineSpans splits the span [start, end) of units at line breaks and trims the spaces around each part, parts left
+// empty are dropped.
+func lineSpans(units []uint16, start, end int) [][2]int {
+	var spans [][2]int
+	isSpace := func(u uint16) bool { return unicode.IsSpace(rune(u)) }
+	for lineStart := start; lineStart < end; {
+		lineEnd := lineStart
+		for lineEnd < end && units[lineEnd] != '\n' {
+			lineEnd++
+		}
+		s, e := lineStart, lineEnd
+		for s < e && isSpace(units[s]) {
+			s++
+		}
+		for e > s && isSpace(units[e-1]) {
+			e--
+		}
+		if s < e {
+			spans = append(spans, [2]int{s, e})
+		}
+		lineStart = lineEnd + 1
+	}
+	return spans
+}
+
+// forwardAttribution returns who the forwarded message m comes from, as a line to put before its text, empty if it is
+// not forwarded.
+func forwardAttribution(m *models.Message) string {
+	origin := m.ForwardOrigin
+	if origin == nil {
+		return ""
+	}
+	var from string
+	switch {
+	case origin.MessageOriginUser != nil:
+		user := origin.MessageOriginUser.SenderUser
+		from = strings.TrimSpace(user.FirstName + " " + user.LastName)
+		// not @username, platforms would take it for a mention of one of their users.
+		if user.Username != "" {
+			from += " (https://t.me/" + user.Username + ")"
+		}
+	case origin.MessageOriginHiddenUser != nil:
+		from = origin.MessageOriginHiddenUser.SenderUserName
+	case origin.MessageOriginChat != nil:
+		from = origin.MessageOriginChat.SenderChat.Title
+	case origin.MessageOriginChannel != nil:
+		channel := origin.MessageOriginChannel
+		from = channel.Chat.Title
+		if channel.Chat.Username != "" {
+			from += fmt.Sprintf(" (https://t.me/%s/%d)", channel.Chat.Username, channel.MessageID)
+		}
+	}
+	if from == "" {
+		return ""
+	}
+	return "Forwarded from " + from + ":"
+}
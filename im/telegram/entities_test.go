@@ -0,0 +1,115 @@
+package telegram
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-telegram/bot/models"
+
+	"github.com/perrito666/chat2world/blogging"
+	"github.com/perrito666/chat2world/config"
+)
+
+func TestEntitiesToMarkdown(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		text     string
+		entities []models.MessageEntity
+		want     string
+	}{
+		{"none", "plain", nil, "plain"},
+		{"bold and italic", "very important", []models.MessageEntity{
+			{Type: models.MessageEntityTypeBold, Offset: 0, Length: 4},
+			{Type: models.MessageEntityTypeItalic, Offset: 5, Length: 9},
+		}, "**very** _important_"},
+		{"nested", "all bold partly italic", []models.MessageEntity{
+			{Type: models.MessageEntityTypeBold, Offset: 0, Length: 22},
+			{Type: models.MessageEntityTypeItalic, Offset: 16, Length: 6},
+		}, "**all bold partly _italic_**"},
+		// offsets count UTF-16 code units, the emoji is two.
+		{"after an emoji", "🎉 party", []models.MessageEntity{
+			{Type: models.MessageEntityTypeStrikethrough, Offset: 3, Length: 5},
+		}, "🎉 ~~party~~"},
+		{"text link", "read this", []models.MessageEntity{
+			{Type: models.MessageEntityTypeTextLink, Offset: 5, Length: 4, URL: "https://example.com"},
+		}, "read [this](https://example.com)"},
+		{"url", "see https://example.com", []models.MessageEntity{
+			{Type: models.MessageEntityTypeURL, Offset: 4, Length: 19},
+		}, "see https://example.com"},
+		{"over lines", "two\n lines ", []models.MessageEntity{
+			{Type: models.MessageEntityTypeBold, Offset: 0, Length: 11},
+		}, "**two**\n **lines** "},
+		{"markers in formatted text", "2*3 and snake_case", []models.MessageEntity{
+			{Type: models.MessageEntityTypeBold, Offset: 0, Length: 18},
+		}, `**2\*3 and snake\_case**`},
+		{"markers in code and URLs", "run *_test at https://example.com/a_b", []models.MessageEntity{
+			{Type: models.MessageEntityTypeItalic, Offset: 0, Length: 37},
+			{Type: models.MessageEntityTypeCode, Offset: 4, Length: 6},
+			{Type: models.MessageEntityTypeURL, Offset: 14, Length: 23},
+		}, "_run `*_test` at https://example.com/a_b_"},
+		{"out of range", "short", []models.MessageEntity{
+			{Type: models.MessageEntityTypeBold, Offset: 3, Length: 10},
+		}, "short"},
+	} {
+		if got := entitiesToMarkdown(tc.text, tc.entities); got != tc.want {
+			t.Errorf("%s: entitiesToMarkdown() = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestEntitiesSurviveRendering(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		text     string
+		entities []models.MessageEntity
+	}{
+		{"markers in bold", "2*3*4 is ~about~ 24 in `math`", []models.MessageEntity{
+			{Type: models.MessageEntityTypeBold, Offset: 0, Length: 29},
+		}},
+		{"adjacent spans", "one two three", []models.MessageEntity{
+			{Type: models.MessageEntityTypeItalic, Offset: 0, Length: 3},
+			{Type: models.MessageEntityTypeItalic, Offset: 4, Length: 3},
+			{Type: models.MessageEntityTypeBold, Offset: 8, Length: 5},
+		}},
+		{"identifiers", "call __init__ from my_module", []models.MessageEntity{
+			{Type: models.MessageEntityTypeItalic, Offset: 0, Length: 28},
+		}},
+	} {
+		markdown := entitiesToMarkdown(tc.text, tc.entities)
+		if got := (&blogging.MicroblogPost{Text: markdown}).RenderFor(config.MBPMastodon); got != tc.text {
+			t.Errorf("%s: RenderFor(%q) = %q, want %q back", tc.name, markdown, got, tc.text)
+		}
+	}
+}
+
+func TestFormattingSurvivesIntoTheMessage(t *testing.T) {
+	u := decodeUpdate(t, `{"update_id":1,"message":{"message_id":7,"date":1,"chat":{"id":99,"type":"private"},
+		"from":{"id":42,"first_name":"Me"},"text":"big news at https://example.com",
+		"entities":[{"type":"bold","offset":0,"length":8},{"type":"url","offset":12,"length":19}]}}`)
+	msg, err := messageFromTelegramMessage(context.Background(), nil, u, DefaultMaxDownloadSize)
+	if err != nil {
+		t.Fatalf("messageFromTelegramMessage: %v", err)
+	}
+	if want := "**big news** at https://example.com"; msg.Text != want {
+		t.Errorf("Text = %q, want %q", msg.Text, want)
+	}
+}
+
+func TestForwardAttribution(t *testing.T) {
+	for _, tc := range []struct {
+		name, raw, want string
+	}{
+		{"not forwarded", `{"message_id":1,"date":1,"chat":{"id":99,"type":"private"},"text":"hi"}`, ""},
+		{"user", `{"message_id":1,"date":1,"chat":{"id":99,"type":"private"},"text":"hi",
+			"forward_origin":{"type":"user","date":1,"sender_user":{"id":5,"is_bot":false,"first_name":"Ada",
+			"last_name":"Lovelace","username":"ada"}}}`, "Forwarded from Ada Lovelace (https://t.me/ada):"},
+		{"channel", `{"message_id":1,"date":1,"chat":{"id":99,"type":"private"},"text":"hi",
+			"forward_origin":{"type":"channel","date":1,"chat":{"id":-100,"type":"channel","title":"News",
+			"username":"news"},"message_id":12}}`, "Forwarded from News (https://t.me/news/12):"},
+	} {
+		u := decodeUpdate(t, `{"update_id":1,"message":`+tc.raw+`}`)
+		if got := forwardAttribution(u.Message); got != tc.want {
+			t.Errorf("%s: forwardAttribution() = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
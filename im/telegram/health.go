@@ -0,0 +1,53 @@
+package telegram
+
+import (
+	"net/http"
+)
+
+// Paths of the probes served along the webhook, for load balancers and orchestrators.
+const (
+	// HealthPath answers 200 as long as the webhook server is up.
+	HealthPath = "/healthz"
+	// ReadyPath answers 200 once the webhook is set and listening, and 503 before that and while stopping.
+	ReadyPath = "/readyz"
+)
+
+// WithMux makes the bot serve its webhook and probes on mux, so other handlers can be served along them, by default
+// it gets one of its own. Their paths must be free in mux, registering them again panics.
+func WithMux(mux *http.ServeMux) Option {
+	return func(tb *Bot) {
+		tb.mux = mux
+	}
+}
+
+// WithWebhookPath makes the webhook be served on path, appended to the path of the webhook URL, i.e.
+// /telegram/<something random> to keep it apart from other services on the same host, or hard to guess. By default the
+// webhook is served on the path of its URL.
+func WithWebhookPath(path string) Option {
+	return func(tb *Bot) {
+		tb.webhookPath = path
+	}
+}
+
+// mountHandlers mounts the webhook, on the path of its URL, and the probes on the mux.
+func (tb *Bot) mountHandlers() {
+	tb.mux.Handle(tb.webhookPath, tb.requireSecret(tb.bot.WebhookHandler()))
+	tb.mux.HandleFunc("GET "+HealthPath, tb.healthz)
+	tb.mux.HandleFunc("GET "+ReadyPath, tb.readyz)
+}
+
+// healthz answers the liveness probe, anything answering is alive.
+func (tb *Bot) healthz(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// readyz answers the readiness probe, only while updates are being taken.
+func (tb *Bot) readyz(w http.ResponseWriter, _ *http.Request) {
+	if !tb.ready.Load() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ready\n"))
+}
@@ -0,0 +1,103 @@
+package telegram
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// freeAddr returns a local address nothing listens on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// probe returns the status the webhook server at addr answers path with, 0 if it does not answer.
+func probe(addr, path string) int {
+	resp, err := http.Get("http://" + addr + path)
+	if err != nil {
+		return 0
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestProbesWhileRunning(t *testing.T) {
+	webhookURL, _ := url.Parse("https://example.com/telegram")
+	tb, _ := newTestBot(t, "secret", webhookURL)
+	addr := freeAddr(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() { stopped <- tb.Start(ctx, addr) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for probe(addr, ReadyPath) != http.StatusOK {
+		if time.Now().After(deadline) {
+			cancel()
+			t.Fatalf("%s never answered 200", ReadyPath)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := probe(addr, HealthPath); got != http.StatusOK {
+		t.Errorf("%s = %d while running, want 200", HealthPath, got)
+	}
+
+	cancel()
+	if err := <-stopped; err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if tb.ready.Load() {
+		t.Error("still ready after stopping")
+	}
+	if got := probe(addr, HealthPath); got != 0 {
+		t.Errorf("%s = %d after stopping, want nothing listening", HealthPath, got)
+	}
+}
+
+func TestReadyzIsUnavailableBeforeListening(t *testing.T) {
+	webhookURL, _ := url.Parse("https://example.com/telegram")
+	tb, _ := newTestBot(t, "secret", webhookURL)
+	for path, want := range map[string]int{HealthPath: http.StatusOK, ReadyPath: http.StatusServiceUnavailable} {
+		rec := httptest.NewRecorder()
+		tb.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("%s = %d before Start, want %d", path, rec.Code, want)
+		}
+	}
+}
+
+func TestWebhookPath(t *testing.T) {
+	webhookURL, _ := url.Parse("https://example.com/telegram")
+	tb, api := newTestBot(t, "secret", webhookURL, WithWebhookPath("hook/s3cr3t"))
+	if want := "https://example.com/telegram/hook/s3cr3t"; api.webhookURL != want {
+		t.Errorf("webhook set to %q, want %q", api.webhookURL, want)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tb.bot.StartWebhook(ctx)
+
+	for path, want := range map[string]int{
+		"/telegram/hook/s3cr3t": http.StatusOK,
+		"/telegram":             http.StatusNotFound,
+		"/":                     http.StatusNotFound,
+		"/telegram/hook/other":  http.StatusNotFound,
+	} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"update_id":1}`))
+		req.Header.Set(secretTokenHeader, "secret")
+		rec := httptest.NewRecorder()
+		tb.mux.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("POST %s = %d, want %d", path, rec.Code, want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package telegram
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-telegram/bot/models"
+
+	"github.com/perrito666/chat2world/im"
+)
+
+// markdownV2Special are the characters telegram MarkdownV2 wants escaped anywhere outside formatting.
+const markdownV2Special = "_*[]()~`>#+-=|{}.!\\"
+
+// markdownSpan matches the formatting im.FormatMarkdown has, in order: code, links, bold, strikethrough and italic
+// with either _ or *, none of them spans lines.
+var markdownSpan = regexp.MustCompile("`([^`\\n]+)`" +
+	`|\[([^\]\n]+)\]\((https?://[^)\s]+)\)` +
+	`|\*\*([^*\n]+)\*\*` +
+	`|~~([^~\n]+)~~` +
+	`|_([^_\s](?:[^_\n]*[^_\s])?)_` +
+	`|\*([^*\s](?:[^*\n]*[^*\s])?)\*`)
+
+// parseMode returns the telegram parse mode for format and text written the way telegram reads it with that mode.
+func parseMode(format im.Format, text string) (models.ParseMode, string) {
+	switch format {
+	case im.FormatMarkdown:
+		return models.ParseModeMarkdown, markdownToV2(text)
+	case im.FormatHTML:
+		return models.ParseModeHTML, text
+	}
+	return "", text
+}
+
+// markdownToV2 rewrites markdown in telegram MarkdownV2, which marks bold with a single * and wants every special
+// character escaped, be it in the text or the formatting.
+// Italic markers inside words (i.e. snake_case) are left as text.
+func markdownToV2(text string) string {
+	var sb strings.Builder
+	last := 0
+	for _, m := range markdownSpan.FindAllStringSubmatchIndex(text, -1) {
+		start, end := m[0], m[1]
+		group := func(i int) string { return text[m[2*i]:m[2*i+1]] }
+		var formatted string
+		switch {
+		case m[2] >= 0:
+			formatted = "`" + escapeMarkdownV2(group(1), "`\\") + "`"
+		case m[4] >= 0:
+			formatted = "[" + escapeMarkdownV2(group(2), markdownV2Special) + "](" + escapeMarkdownV2(group(3), ")\\") + ")"
+		case m[8] >= 0:
+			formatted = "*" + escapeMarkdownV2(group(4), markdownV2Special) + "*"
+		case m[10] >= 0:
+			formatted = "~" + escapeMarkdownV2(group(5), markdownV2Special) + "~"
+		case !inWord(text, start, end):
+			inner := 6
+			if m[12] < 0 {
+				inner = 7
+			}
+			formatted = "_" + escapeMarkdownV2(group(inner), markdownV2Special) + "_"
+		default:
+			continue
+		}
+		sb.WriteString(escapeMarkdownV2(text[last:start], markdownV2Special))
+		sb.WriteString(formatted)
+		last = end
+	}
+	sb.WriteString(escapeMarkdownV2(text[last:], markdownV2Special))
+	return sb.String()
+}
+
+// inWord tells if the span [start, end) of text is glued to a letter or digit on either side.
+func inWord(text string, start, end int) bool {
+	isWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }
+	before, _ := utf8.DecodeLastRuneInString(text[:start])
+	after, _ := utf8.DecodeRuneInString(text[end:])
+	return isWord(before) || isWord(after)
+}
+
+// escapeMarkdownV2 puts a backslash before every character of text in special.
+func escapeMarkdownV2(text, special string) string {
+	var sb strings.Builder
+	for _, r := range text {
+		if strings.ContainsRune(special, r) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// isParseError tells if err is telegram refusing the formatting of a message sent with mode, i.e. HTML with an unclosed
+// tag.
+func isParseError(mode models.ParseMode, err error) bool {
+	return mode != "" && err != nil && strings.Contains(err.Error(), "can't parse entities")
+}
@@ -0,0 +1,72 @@
+package telegram
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-telegram/bot/models"
+
+	"github.com/perrito666/chat2world/im"
+)
+
+func TestMarkdownToV2(t *testing.T) {
+	for _, tc := range []struct {
+		text, want string
+	}{
+		{"plain text.", `plain text\.`},
+		{"**bold** and _italic_ and *italic*", `*bold* and _italic_ and _italic_`},
+		{"~~gone~~ (for now)", `~gone~ \(for now\)`},
+		{"`a_b*c` stays", "`a_b*c` stays"},
+		{"[the docs](https://example.com/a_b) now", `[the docs](https://example.com/a_b) now`},
+		{"snake_case_name", `snake\_case\_name`},
+		{"1+1=2 #math!", `1\+1\=2 \#math\!`},
+	} {
+		if got := markdownToV2(tc.text); got != tc.want {
+			t.Errorf("markdownToV2(%q) = %q, want %q", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for _, tc := range []struct {
+		format   im.Format
+		text     string
+		wantMode models.ParseMode
+		wantText string
+	}{
+		{im.FormatPlain, "**as is**.", "", "**as is**."},
+		{im.FormatMarkdown, "**bold**.", models.ParseModeMarkdown, `*bold*\.`},
+		{im.FormatHTML, "<b>bold</b> & co", models.ParseModeHTML, "<b>bold</b> & co"},
+	} {
+		mode, text := parseMode(tc.format, tc.text)
+		if mode != tc.wantMode || text != tc.wantText {
+			t.Errorf("parseMode(%d, %q) = %q, %q, want %q, %q", tc.format, tc.text, mode, text, tc.wantMode,
+				tc.wantText)
+		}
+	}
+}
+
+func TestSendMessageFormats(t *testing.T) {
+	tb, api := newTestBot(t, "", nil)
+	ctx := context.Background()
+	if err := tb.SendMessage(ctx, &im.Message{ChatID: 99, Text: "**sent**!", Format: im.FormatMarkdown}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if err := tb.SendMessage(ctx, &im.Message{ChatID: 99, Text: "**sent**!"}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	want := []sentMessage{{`*sent*\!`, "MarkdownV2"}, {"**sent**!", ""}}
+	if len(api.sent) != len(want) || api.sent[0] != want[0] || api.sent[1] != want[1] {
+		t.Errorf("sent %+v, want %+v", api.sent, want)
+	}
+
+	// what telegram can't parse goes out unformatted.
+	api.refuseFormatting = true
+	api.sent = nil
+	if err := tb.SendMessage(ctx, &im.Message{ChatID: 99, Text: "<b>unclosed", Format: im.FormatHTML}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if want := (sentMessage{"<b>unclosed", ""}); len(api.sent) != 1 || api.sent[0] != want {
+		t.Errorf("sent %+v, want only %+v", api.sent, want)
+	}
+}
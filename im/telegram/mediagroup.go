@@ -0,0 +1,88 @@
+package telegram
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/perrito666/chat2world/im"
+)
+
+// mediaGroupDebounce is how long we wait for more items of an album after the last one arrived.
+const mediaGroupDebounce = 1500 * time.Millisecond
+
+// pendingMediaGroup holds the messages of an album received so far.
+type pendingMediaGroup struct {
+	parts []*im.Message
+	timer *time.Timer
+}
+
+// mediaGroupBuffer collects the messages telegram sends for each item of an album (all sharing a media group ID) and
+// dispatches them as a single message once no new item arrived for a while.
+type mediaGroupBuffer struct {
+	mu       sync.Mutex
+	groups   map[string]*pendingMediaGroup
+	window   time.Duration
+	dispatch func(ctx context.Context, message *im.Message)
+}
+
+// newMediaGroupBuffer returns a mediaGroupBuffer that calls dispatch with each coalesced album.
+func newMediaGroupBuffer(window time.Duration, dispatch func(ctx context.Context, message *im.Message)) *mediaGroupBuffer {
+	return &mediaGroupBuffer{
+		groups:   make(map[string]*pendingMediaGroup),
+		window:   window,
+		dispatch: dispatch,
+	}
+}
+
+// add buffers message as part of the album groupID, restarting the wait for the rest of it.
+func (m *mediaGroupBuffer) add(ctx context.Context, groupID string, message *im.Message) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	group, ok := m.groups[groupID]
+	if !ok {
+		group = &pendingMediaGroup{}
+		m.groups[groupID] = group
+		group.timer = time.AfterFunc(m.window, func() { m.flush(ctx, groupID) })
+	} else {
+		group.timer.Reset(m.window)
+	}
+	group.parts = append(group.parts, message)
+}
+
+// flush dispatches the album groupID as one message.
+func (m *mediaGroupBuffer) flush(ctx context.Context, groupID string) {
+	m.mu.Lock()
+	group, ok := m.groups[groupID]
+	delete(m.groups, groupID)
+	m.mu.Unlock()
+	if !ok || len(group.parts) == 0 {
+		return
+	}
+	m.dispatch(ctx, coalesceMessages(group.parts))
+}
+
+// coalesceMessages merges the parts of an album, in the order they were sent, into one message with all the images
+// and videos, replies go to the first of them.
+func coalesceMessages(parts []*im.Message) *im.Message {
+	// handlers run concurrently so the parts might not have been added in order.
+	sort.Slice(parts, func(i, j int) bool { return parts[i].MsgID < parts[j].MsgID })
+	merged := *parts[0]
+	merged.Images = nil
+	merged.Videos = nil
+	var text string
+	for _, part := range parts {
+		merged.Images = append(merged.Images, part.Images...)
+		merged.Videos = append(merged.Videos, part.Videos...)
+		if part.Text != "" {
+			if text != "" {
+				text += "\n"
+			}
+			text += part.Text
+		}
+	}
+	merged.Text = text
+	return &merged
+}
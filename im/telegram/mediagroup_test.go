@@ -0,0 +1,43 @@
+package telegram
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/perrito666/chat2world/im"
+)
+
+func TestMediaGroupBufferCoalescesAnAlbum(t *testing.T) {
+	dispatched := make(chan *im.Message, 3)
+	buffer := newMediaGroupBuffer(20*time.Millisecond, func(_ context.Context, message *im.Message) {
+		dispatched <- message
+	})
+	ctx := context.Background()
+	// handlers run concurrently, the parts can arrive out of order.
+	buffer.add(ctx, "album", &im.Message{MsgID: 11, Images: []*im.Image{{Data: []byte("second")}}})
+	buffer.add(ctx, "album", &im.Message{MsgID: 10, Text: "caption", Images: []*im.Image{{Data: []byte("first")}}})
+	buffer.add(ctx, "album", &im.Message{MsgID: 12, Images: []*im.Image{{Data: []byte("third")}}})
+
+	var message *im.Message
+	select {
+	case message = <-dispatched:
+	case <-time.After(time.Second):
+		t.Fatal("the album was never dispatched")
+	}
+	if message.MsgID != 10 || message.Text != "caption" {
+		t.Errorf("message %d %q, want the first one, 10, with its caption", message.MsgID, message.Text)
+	}
+	var images []string
+	for _, img := range message.Images {
+		images = append(images, string(img.Data))
+	}
+	if len(images) != 3 || images[0] != "first" || images[1] != "second" || images[2] != "third" {
+		t.Errorf("images = %q, want [first second third]", images)
+	}
+	select {
+	case extra := <-dispatched:
+		t.Errorf("dispatched another message: %+v", extra)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
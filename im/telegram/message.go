@@ -2,10 +2,15 @@ package telegram
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
@@ -13,42 +18,109 @@ import (
 	"github.com/perrito666/chat2world/im"
 )
 
-const apiTelegramFileURL = "https://api.telegram.org/file"
+// DefaultMaxDownloadSize is the largest file downloaded from telegram by bots created without WithMaxDownloadSize, it
+// is what the telegram bot API lets bots download anyway.
+const DefaultMaxDownloadSize = 20 << 20
 
-func getFileContents(ctx context.Context, b *bot.Bot, fileID string) ([]byte, error) {
+// fileDownloadTimeout bounds how long downloading a single file from telegram can take.
+const fileDownloadTimeout = 2 * time.Minute
+
+// downloadClient is used to download files from telegram.
+var downloadClient = &http.Client{Timeout: fileDownloadTimeout}
+
+// ErrFileTooLarge is returned when a file sent to us is larger than what we are willing to download.
+var ErrFileTooLarge = errors.New("file too large")
+
+// getFileContents downloads the telegram file fileID, it fails with ErrFileTooLarge if it is larger than maxSize.
+func getFileContents(ctx context.Context, b *bot.Bot, fileID string, maxSize int64) ([]byte, error) {
 	fLink, err := b.GetFile(ctx, &bot.GetFileParams{
 		FileID: fileID,
 	})
 	if err != nil {
+		// the bot API refuses files over 20MB itself, unless it is a local server.
+		if strings.Contains(err.Error(), "file is too big") {
+			return nil, fmt.Errorf("telegram get photo file: %v: %w", err, ErrFileTooLarge)
+		}
 		return nil, fmt.Errorf("telegram get photo file: %w", err)
 	}
 	if fLink.FilePath == "" {
 		return nil, fmt.Errorf("telegram get photo file path is empty")
 	}
-	tgURI, _ := url.Parse(apiTelegramFileURL)
-	tgURI = tgURI.JoinPath("bot"+b.Token(), fLink.FilePath)
-
-	// https://api.telegram.org/file/bot<token>/<file_path>
-	res, err := http.Get(tgURI.String())
+	// telegram usually tells the size, no need to start downloading what we will not keep.
+	if fLink.FileSize > maxSize {
+		return nil, fmt.Errorf("%d bytes, limit is %d: %w", fLink.FileSize, maxSize, ErrFileTooLarge)
+	}
+	// <server>/file/bot<token>/<file_path>, the server is telegram's unless the bot uses its own.
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.FileDownloadLink(fLink), nil)
 	if err != nil {
+		return nil, fmt.Errorf("telegram creating file request: %w", err)
+	}
+	res, err := downloadClient.Do(req)
+	if err != nil {
+		// the error carries the URL, which carries the token.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
 		return nil, fmt.Errorf("telegram GET photo file: %w", err)
 	}
 	defer res.Body.Close()
-	return io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("telegram GET photo file returned non-OK status: %s", res.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("telegram reading file: %w", err)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("more than %d bytes: %w", maxSize, ErrFileTooLarge)
+	}
+	return data, nil
 }
 
-func messageFromTelegramMessage(ctx context.Context, b *bot.Bot, u *models.Update) (*im.Message, error) {
+// ErrUnsupportedUpdate is returned for telegram updates that carry nothing we can turn into an im.Message.
+var ErrUnsupportedUpdate = errors.New("unsupported telegram update")
+
+// ErrUnsupportedMedia is returned for messages carrying files that are neither images nor videos.
+var ErrUnsupportedMedia = errors.New("unsupported media")
+
+// updateSender returns who sent the message or pressed the button of the update, nil for updates we don't handle.
+func updateSender(u *models.Update) *models.User {
+	switch {
+	case u.Message != nil:
+		return u.Message.From
+	case u.CallbackQuery != nil && u.CallbackQuery.Data != "":
+		return &u.CallbackQuery.From
+	case u.EditedMessage != nil:
+		return u.EditedMessage.From
+	}
+	return nil
+}
+
+// messageFromTelegramMessage translates a telegram update, either a message, an edit of one or the press of a button,
+// into an im.Message, files larger than maxDownload are refused with ErrFileTooLarge.
+func messageFromTelegramMessage(ctx context.Context, b *bot.Bot, u *models.Update, maxDownload int64) (*im.Message, error) {
+	if u.CallbackQuery != nil {
+		return messageFromCallbackQuery(u.CallbackQuery)
+	}
+	if u.EditedMessage != nil {
+		return messageFromEditedMessage(u.EditedMessage)
+	}
+	if u.Message == nil || u.Message.From == nil {
+		return nil, ErrUnsupportedUpdate
+	}
 	msg := im.Message{
 		ChatID: u.Message.Chat.ID,
 		UserID: uint64(u.Message.From.ID),
 		MsgID:  uint64(u.Message.ID),
 	}
-	msg.Text = u.Message.Text
+	// captions are alt texts, their formatting is dropped.
+	msg.Text = entitiesToMarkdown(u.Message.Text, u.Message.Entities)
 	// Append photo content (if any).
 	if len(u.Message.Photo) > 0 {
 		// Use the largest photo available (the last element).
 		photo := u.Message.Photo[len(u.Message.Photo)-1]
-		rawPhotoBytes, err := getFileContents(ctx, b, photo.FileID)
+		rawPhotoBytes, err := getFileContents(ctx, b, photo.FileID, maxDownload)
 		if err != nil {
 			return nil, fmt.Errorf("telegram getting file: %w", err)
 		}
@@ -58,6 +130,125 @@ func messageFromTelegramMessage(ctx context.Context, b *bot.Bot, u *models.Updat
 			Caption: u.Message.Caption,
 		}
 	}
+	// Animations (GIFs as telegram sends them) are short mp4 videos with no sound.
+	switch {
+	case u.Message.Video != nil:
+		video, err := videoFromTelegram(ctx, b, u.Message.Video.FileID, u.Message.Video.MimeType, u.Message.Video.Duration,
+			u.Message.Video.Width, u.Message.Video.Height, maxDownload)
+		if err != nil {
+			return nil, err
+		}
+		video.Caption = u.Message.Caption
+		msg.Videos = []*im.Video{video}
+	case u.Message.Animation != nil:
+		video, err := videoFromTelegram(ctx, b, u.Message.Animation.FileID, u.Message.Animation.MimeType,
+			u.Message.Animation.Duration, u.Message.Animation.Width, u.Message.Animation.Height, maxDownload)
+		if err != nil {
+			return nil, err
+		}
+		video.Caption = u.Message.Caption
+		msg.Videos = []*im.Video{video}
+	case u.Message.Document != nil:
+		// files sent as such keep their quality, animations also come with a Document so they must be checked first.
+		if err := addDocument(ctx, b, &msg, u.Message.Document, u.Message.Caption, maxDownload); err != nil {
+			return nil, err
+		}
+	}
 
 	return &msg, nil
 }
+
+// documentMimeType returns the type of the document, as telegram says or, failing that, guessed from its name.
+func documentMimeType(doc *models.Document) string {
+	if doc.MimeType != "" {
+		return doc.MimeType
+	}
+	if guessed := mime.TypeByExtension(path.Ext(doc.FileName)); guessed != "" {
+		return guessed
+	}
+	return "unknown"
+}
+
+// addDocument adds the image or video in doc to msg, it fails with ErrUnsupportedMedia for any other kind of file.
+func addDocument(ctx context.Context, b *bot.Bot, msg *im.Message, doc *models.Document, caption string,
+	maxDownload int64) error {
+	mimeType := documentMimeType(doc)
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		data, err := getFileContents(ctx, b, doc.FileID, maxDownload)
+		if err != nil {
+			return fmt.Errorf("telegram getting document file: %w", err)
+		}
+		msg.Images = []*im.Image{{Data: data, Caption: caption}}
+	case strings.HasPrefix(mimeType, "video/"):
+		// documents don't tell the duration, platforms with a duration limit will find out.
+		video, err := videoFromTelegram(ctx, b, doc.FileID, mimeType, 0, 0, 0, maxDownload)
+		if err != nil {
+			return err
+		}
+		video.Caption = caption
+		msg.Videos = []*im.Video{video}
+	default:
+		return fmt.Errorf("document %q of type %s: %w", doc.FileName, mimeType, ErrUnsupportedMedia)
+	}
+	return nil
+}
+
+// videoFromTelegram downloads the video fileID, telegram gives its duration in seconds.
+func videoFromTelegram(ctx context.Context, b *bot.Bot, fileID, mimeType string, seconds, width, height int,
+	maxDownload int64) (*im.Video, error) {
+	data, err := getFileContents(ctx, b, fileID, maxDownload)
+	if err != nil {
+		return nil, fmt.Errorf("telegram getting video file: %w", err)
+	}
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	return &im.Video{
+		Data:     data,
+		MimeType: mimeType,
+		Duration: time.Duration(seconds) * time.Second,
+		Width:    width,
+		Height:   height,
+	}, nil
+}
+
+// messageFromCallbackQuery translates the press of a button into an im.Message with no text and the button's data as
+// CallbackData, its MsgID is the message holding the button.
+func messageFromCallbackQuery(query *models.CallbackQuery) (*im.Message, error) {
+	if query.Data == "" {
+		// game callbacks carry a short name instead.
+		return nil, fmt.Errorf("callback query without data: %w", ErrUnsupportedUpdate)
+	}
+	msg := &im.Message{
+		UserID:       uint64(query.From.ID),
+		CallbackData: query.Data,
+	}
+	switch {
+	case query.Message.Message != nil:
+		msg.ChatID = query.Message.Message.Chat.ID
+		msg.MsgID = uint64(query.Message.Message.ID)
+	case query.Message.InaccessibleMessage != nil:
+		msg.ChatID = query.Message.InaccessibleMessage.Chat.ID
+		msg.MsgID = uint64(query.Message.InaccessibleMessage.MessageID)
+	default:
+		// buttons of inline mode messages have no chat.
+		return nil, fmt.Errorf("callback query without message: %w", ErrUnsupportedUpdate)
+	}
+	return msg, nil
+}
+
+// messageFromEditedMessage translates the edit of a text message into an im.Message with the new text, edits of
+// captions are not supported.
+func messageFromEditedMessage(m *models.Message) (*im.Message, error) {
+	if m.From == nil || m.Text == "" {
+		return nil, fmt.Errorf("edit of message %d without text: %w", m.ID, ErrUnsupportedUpdate)
+	}
+	return &im.Message{
+		ChatID: m.Chat.ID,
+		UserID: uint64(m.From.ID),
+		MsgID:  uint64(m.ID),
+		Text:   entitiesToMarkdown(m.Text, m.Entities),
+		Edited: true,
+	}, nil
+}
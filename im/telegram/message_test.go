@@ -0,0 +1,184 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-telegram/bot/models"
+)
+
+// decodeUpdate returns the update telegram would send as raw.
+func decodeUpdate(t *testing.T, raw string) *models.Update {
+	t.Helper()
+	var u models.Update
+	if err := json.Unmarshal([]byte(raw), &u); err != nil {
+		t.Fatalf("decoding update: %v", err)
+	}
+	return &u
+}
+
+func TestMessageFromCallbackQuery(t *testing.T) {
+	u := decodeUpdate(t, `{"update_id":1,"callback_query":{"id":"q1","from":{"id":42,"first_name":"Me"},
+		"message":{"message_id":7,"date":1700000000,"chat":{"id":99,"type":"private"},"text":"Pick the platforms"},
+		"chat_instance":"c","data":"send:toggle:bluesky"}}`)
+	if sender := updateSender(u); sender == nil || sender.ID != 42 {
+		t.Fatalf("updateSender() = %+v, want the user who pressed the button", sender)
+	}
+	msg, err := messageFromTelegramMessage(context.Background(), nil, u, DefaultMaxDownloadSize)
+	if err != nil {
+		t.Fatalf("messageFromTelegramMessage: %v", err)
+	}
+	if !msg.IsCallback() || msg.CallbackData != "send:toggle:bluesky" {
+		t.Errorf("CallbackData = %q, want the data of the button", msg.CallbackData)
+	}
+	if msg.UserID != 42 || msg.ChatID != 99 || msg.MsgID != 7 {
+		t.Errorf("user, chat, message = %d, %d, %d, want 42, 99, 7", msg.UserID, msg.ChatID, msg.MsgID)
+	}
+	if msg.Text != "" {
+		t.Errorf("Text = %q, want none, the text is that of the message holding the button", msg.Text)
+	}
+}
+
+func TestMessageFromCallbackQueryOfAnOldMessage(t *testing.T) {
+	// messages older than 48 hours come with a date of 0.
+	u := decodeUpdate(t, `{"update_id":1,"callback_query":{"id":"q1","from":{"id":42,"first_name":"Me"},
+		"message":{"message_id":7,"date":0,"chat":{"id":99,"type":"private"}},"chat_instance":"c","data":"send:confirm"}}`)
+	msg, err := messageFromTelegramMessage(context.Background(), nil, u, DefaultMaxDownloadSize)
+	if err != nil {
+		t.Fatalf("messageFromTelegramMessage: %v", err)
+	}
+	if msg.ChatID != 99 || msg.MsgID != 7 || msg.CallbackData != "send:confirm" {
+		t.Errorf("message = %+v, want chat 99, message 7 and the data of the button", msg)
+	}
+}
+
+func TestUnsupportedCallbackQueries(t *testing.T) {
+	for name, raw := range map[string]string{
+		"game": `{"update_id":1,"callback_query":{"id":"q1","from":{"id":42,"first_name":"Me"},
+			"message":{"message_id":7,"date":1,"chat":{"id":99,"type":"private"}},"game_short_name":"game"}}`,
+		"inline": `{"update_id":1,"callback_query":{"id":"q1","from":{"id":42,"first_name":"Me"},
+			"inline_message_id":"i","data":"send:confirm"}}`,
+	} {
+		u := decodeUpdate(t, raw)
+		if _, err := messageFromTelegramMessage(context.Background(), nil, u, DefaultMaxDownloadSize); !errors.Is(err,
+			ErrUnsupportedUpdate) {
+			t.Errorf("%s callback: err = %v, want ErrUnsupportedUpdate", name, err)
+		}
+	}
+}
+
+func TestMessageFromEditedMessage(t *testing.T) {
+	u := decodeUpdate(t, `{"update_id":1,"edited_message":{"message_id":7,"date":1,"edit_date":2,
+		"chat":{"id":99,"type":"private"},"from":{"id":42,"first_name":"Me"},"text":"fixed typo"}}`)
+	msg, err := messageFromTelegramMessage(context.Background(), nil, u, DefaultMaxDownloadSize)
+	if err != nil {
+		t.Fatalf("messageFromTelegramMessage: %v", err)
+	}
+	if !msg.Edited || msg.Text != "fixed typo" || msg.MsgID != 7 || msg.UserID != 42 {
+		t.Errorf("message = %+v, want the edit of message 7 by 42 with its new text", msg)
+	}
+}
+
+// documentUpdate returns the update of a message from user 42 carrying the document fileID with the given mime type
+// and name, captioned "a cat".
+func documentUpdate(t *testing.T, fileID, mimeType, name string) *models.Update {
+	t.Helper()
+	return decodeUpdate(t, fmt.Sprintf(`{"update_id":1,"message":{"message_id":7,"date":1,"chat":{"id":99,"type":"private"},
+		"from":{"id":42,"first_name":"Me"},"caption":"a cat",
+		"document":{"file_id":%q,"file_unique_id":%q,"mime_type":%q,"file_name":%q}}}`, fileID, fileID, mimeType, name))
+}
+
+func TestDocumentsWithImagesAreImages(t *testing.T) {
+	api, b := newTestAPI(t)
+	png := []byte("\x89PNG\r\n\x1a\nnot much of a cat")
+	api.files = map[string][]byte{"doc1": png}
+	// telegram does not always know the mime type, the name tells then.
+	for _, u := range []*models.Update{
+		documentUpdate(t, "doc1", "image/png", "cat.png"),
+		documentUpdate(t, "doc1", "", "cat.png"),
+	} {
+		msg, err := messageFromTelegramMessage(context.Background(), b, u, DefaultMaxDownloadSize)
+		if err != nil {
+			t.Fatalf("messageFromTelegramMessage: %v", err)
+		}
+		if len(msg.Images) != 1 || len(msg.Videos) != 0 {
+			t.Fatalf("message has %d images and %d videos, want the document as an image", len(msg.Images),
+				len(msg.Videos))
+		}
+		if !bytes.Equal(msg.Images[0].Data, png) || msg.Images[0].Caption != "a cat" {
+			t.Errorf("image = %q captioned %q, want the document captioned %q", msg.Images[0].Data,
+				msg.Images[0].Caption, "a cat")
+		}
+	}
+}
+
+func TestDocumentsWithVideosAreVideos(t *testing.T) {
+	api, b := newTestAPI(t)
+	api.files = map[string][]byte{"doc1": []byte("not really a video")}
+	msg, err := messageFromTelegramMessage(context.Background(), b, documentUpdate(t, "doc1", "video/mp4", "cat.mp4"),
+		DefaultMaxDownloadSize)
+	if err != nil {
+		t.Fatalf("messageFromTelegramMessage: %v", err)
+	}
+	if len(msg.Videos) != 1 || msg.Videos[0].MimeType != "video/mp4" || msg.Videos[0].Caption != "a cat" {
+		t.Errorf("videos = %+v, want the document as a captioned video/mp4", msg.Videos)
+	}
+}
+
+func TestOtherDocumentsAreRefused(t *testing.T) {
+	_, b := newTestAPI(t)
+	_, err := messageFromTelegramMessage(context.Background(), b,
+		documentUpdate(t, "doc1", "application/pdf", "cat.pdf"), DefaultMaxDownloadSize)
+	if !errors.Is(err, ErrUnsupportedMedia) {
+		t.Errorf("err = %v, want ErrUnsupportedMedia", err)
+	}
+}
+
+func TestOversizedDownloadsFail(t *testing.T) {
+	for _, hideSizes := range []bool{false, true} {
+		api, b := newTestAPI(t)
+		api.files = map[string][]byte{"doc1": bytes.Repeat([]byte("x"), 1025)}
+		// when telegram does not tell the size it is found out while downloading.
+		api.hideFileSizes = hideSizes
+		_, err := messageFromTelegramMessage(context.Background(), b, documentUpdate(t, "doc1", "image/png", "cat.png"),
+			1024)
+		if !errors.Is(err, ErrFileTooLarge) {
+			t.Errorf("size hidden %t: err = %v, want ErrFileTooLarge", hideSizes, err)
+		}
+	}
+}
+
+func TestGetFileContents(t *testing.T) {
+	api, b := newTestAPI(t)
+	api.files = map[string][]byte{"photo1": []byte("a photo"), "doc1": []byte("a document")}
+	ctx := context.Background()
+	for id, want := range api.files {
+		got, err := getFileContents(ctx, b, id, DefaultMaxDownloadSize)
+		if err != nil {
+			t.Fatalf("getFileContents(%q): %v", id, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("getFileContents(%q) = %q, want %q", id, got, want)
+		}
+	}
+	if _, err := getFileContents(ctx, b, "missing", DefaultMaxDownloadSize); err == nil {
+		t.Error("getFileContents(missing) succeeded, want the failed download reported")
+	}
+
+	// photos and documents are downloaded alike.
+	u := decodeUpdate(t, `{"update_id":1,"message":{"message_id":7,"date":1,"chat":{"id":99,"type":"private"},
+		"from":{"id":42,"first_name":"Me"},"caption":"a cat",
+		"photo":[{"file_id":"small","file_unique_id":"s","width":90,"height":90},
+			{"file_id":"photo1","file_unique_id":"p","width":900,"height":900}]}}`)
+	msg, err := messageFromTelegramMessage(ctx, b, u, DefaultMaxDownloadSize)
+	if err != nil {
+		t.Fatalf("messageFromTelegramMessage: %v", err)
+	}
+	if len(msg.Images) != 1 || string(msg.Images[0].Data) != "a photo" {
+		t.Errorf("images = %+v, want the largest photo", msg.Images)
+	}
+}
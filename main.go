@@ -7,17 +7,30 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"path"
+	"slices"
 	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/perrito666/chat2world/blogging"
 	"github.com/perrito666/chat2world/blogging/bluesky"
+	bskyclient "github.com/perrito666/chat2world/blogging/bluesky/client"
+	"github.com/perrito666/chat2world/blogging/hugo"
 	"github.com/perrito666/chat2world/blogging/mastodon"
+	"github.com/perrito666/chat2world/blogging/nostr"
+	"github.com/perrito666/chat2world/blogging/ratelimit"
 	"github.com/perrito666/chat2world/config"
 	"github.com/perrito666/chat2world/im"
+	signalim "github.com/perrito666/chat2world/im/signal"
 	"github.com/perrito666/chat2world/im/telegram" // update this import path to match your module layout
+	"github.com/perrito666/chat2world/metrics"
 	"github.com/perrito666/chat2world/secrets"
 )
 
@@ -48,10 +61,13 @@ func (s *strSlice) Set(value string) error {
 	return nil
 }
 
+// flowIdleTimeout is how long a command (i.e. writing a post) can go without activity before it is closed.
+const flowIdleTimeout = 30 * time.Minute
+
 // onlyDecryptFiles takes a slice of strings representing file paths and a store and opens each file then writes it
 // decrypted to a file with the same name but with the .clear extension.
 func onlyDecryptFiles(files []string, store *secrets.EncryptedStore) error {
-	log.Printf("files: %v", files)
+	slog.Info("decrypting files", "files", files)
 	for _, f := range files {
 		err := func() error {
 			// Open the file to read.
@@ -62,7 +78,6 @@ func onlyDecryptFiles(files []string, store *secrets.EncryptedStore) error {
 			defer r.Close()
 
 			// Open the encrypted file to write.
-			fmt.Printf("f: %s\n", f)
 			w, err := os.OpenFile(f+".clear", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
 			if err != nil {
 				return fmt.Errorf("opening encrypted file to write: %w", err)
@@ -74,7 +89,7 @@ func onlyDecryptFiles(files []string, store *secrets.EncryptedStore) error {
 			if written, err = io.Copy(w, r); err != nil {
 				return fmt.Errorf("writing to clear file: %w", err)
 			}
-			log.Printf("wrote %d bytes to %s", written, f+".clear")
+			slog.Info("file decrypted", "path", f+".clear", "bytes", written)
 			return nil
 		}()
 		if err != nil {
@@ -116,27 +131,153 @@ func onlyEncryptFiles(files []string, store *secrets.EncryptedStore) error {
 	return nil
 }
 
+// loadConfig reads and validates the config at path, without one every IM and blogging platform is enabled, signal
+// only if SIGNAL_CLI_SOCKET is set as it was optional before there was a config.
+func loadConfig(path string) (*config.Config, error) {
+	cfg := config.NewConfig()
+	if path == "" {
+		cfg.EnabledIMs = []config.AvailableIM{config.IMTelegram}
+		if os.Getenv("SIGNAL_CLI_SOCKET") != "" {
+			cfg.EnabledIMs = append(cfg.EnabledIMs, config.IMSignal)
+		}
+		cfg.EnabledBloggingPlatforms = []config.AvailableBloggingPlatform{config.MBPMastodon, config.MBPBsky, config.BPHugo, config.MBPNostr}
+		cfg.AvailableInteractions = map[config.AvailableIM][]config.AvailableBloggingPlatform{}
+		return cfg, nil
+	}
+	// a config file says what it enables, nothing is enabled by default.
+	cfg.EnabledIMs = nil
+	cfg.EnabledBloggingPlatforms = nil
+	cfg.AvailableInteractions = nil
+	if err := cfg.LoadFromFile(path); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// loadTelegramSecrets returns the telegram secrets from the environment, saving them encrypted for next time, or, if
+// none is set there, from the config auth or else from what was saved.
+func loadTelegramSecrets(store *secrets.EncryptedStore, auth map[string]string) (map[string]string, error) {
+	// Try and load the secrets from the environment.
+	telegramSecrets := map[string]string{}
+	resave := false
+	for _, k := range []string{config.IMAuthTelegramToken, config.IMAuthTelegramSecret, config.IMAuthTelegramListen, config.IMAuthTelegramURL,
+		config.IMAuthTelegramPath} {
+		telegramSecrets[k] = os.Getenv(k)
+		if telegramSecrets[k] != "" {
+			resave = true
+		}
+	}
+	if !resave && len(auth) > 0 {
+		return auth, nil
+	}
+	if resave {
+		f, err := store.OpenWriter("telegram.config")
+		if err != nil {
+			return nil, fmt.Errorf("opening encrypted file to write: %w", err)
+		}
+		err = json.NewEncoder(f).Encode(&telegramSecrets)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("writing encrypted file: %w", err)
+		}
+	} else {
+		f, err := store.OpenReader("telegram.config")
+		if err != nil {
+			return nil, fmt.Errorf("opening encrypted file to read: %w", err)
+		}
+
+		err = json.NewDecoder(f).Decode(&telegramSecrets)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading encrypted file: %w", err)
+		}
+	}
+	return telegramSecrets, nil
+}
+
+// newLogger returns the logger everything logs through, secrets are redacted from it (see secrets.RedactingHandler).
+// It writes key=value lines to stderr, with asJSON a JSON object per line instead. It is made the default so code
+// logging through slog's functions, and anything still using the log package, is redacted too.
+func newLogger(level string, asJSON bool) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+	options := &slog.HandlerOptions{Level: lvl}
+	// not wrapping slog's default handler, it writes through the log package, which the default logger takes over.
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, options)
+	if asJSON {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	}
+	logger := slog.New(secrets.NewRedactingHandler(handler))
+	slog.SetDefault(logger)
+	return logger, nil
+}
+
 func main() {
 	// Create a cancelable context that ends when an interrupt is received.
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
 	// Define and parse the allowed Telegram user ID flags.
 	var allowedTelegramUsers uint64Slice
+	var allowedSignalUsers uint64Slice
 	var encryptFiles strSlice
 	var decryptFiles strSlice
 	flag.Var(&allowedTelegramUsers, "with-allowed-telegram-user", "Allowed Telegram user ID (can be specified multiple times)")
+	flag.Var(&allowedSignalUsers, "with-allowed-signal-user", "Allowed Signal phone number, digits only (can be specified multiple times)")
 	flag.Var(&encryptFiles, "encrypt-file", "File to encrypt")
 	flag.Var(&decryptFiles, "decrypt-file", "File to decrypt")
+	secretsDir := flag.String("secrets-dir", "", "Directory holding the encrypted config and per user files (defaults to the current one)")
+	configPath := flag.String("config", "", "JSON config file choosing the IMs and blogging platforms to run, all of them if not given")
+	maxDownloadMB := flag.Int64("telegram-max-download-mb", telegram.DefaultMaxDownloadSize>>20, "Largest file, in MB, downloaded from telegram messages")
+	forwardAttribution := flag.Bool("telegram-forward-attribution", false, "Start the text of forwarded telegram messages with who they come from")
+	rotatePassword := flag.Bool("rotate-password", false, "Re-encrypt the files in --secrets-dir with the password in CHAT2WORLD_NEW_PASSWORD")
+	logLevel := flag.String("log-level", "info", "Least severe log entries written: debug, info, warn or error")
+	logJSON := flag.Bool("log-json", false, "Write logs as JSON, one object per line")
+	maxMessagesPerMinute := flag.Int("max-messages-per-minute", 0, "Messages each user can send per minute, the rest are dropped, no limit if 0")
+	overflow := flag.String("overflow", string(blogging.OverflowThread), "What to do with posts too long for a platform: thread, truncate or reject")
+	whoami := flag.Bool("whoami", false, "Answer /whoami with the IM, user and chat IDs of whoever asks")
+	metricsAddr := flag.String("metrics-addr", "", "Address (i.e. :9090) to serve Prometheus metrics on, at /metrics, none are served if not given")
 	flag.Parse()
 
+	logger, err := newLogger(*logLevel, *logJSON)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	pasword := os.Getenv("CHAT2WORLD_PASSWORD")
 	store := &secrets.EncryptedStore{Password: pasword}
+	// New files can use cheaper (i.e. on a raspberry pi) or costlier key derivation, existing ones keep theirs.
+	if rawParams := os.Getenv("CHAT2WORLD_SCRYPT_PARAMS"); rawParams != "" {
+		params, err := secrets.ParseScryptParams(rawParams)
+		if err != nil {
+			log.Fatalf("invalid CHAT2WORLD_SCRYPT_PARAMS: %v", err)
+		}
+		store.Params = params
+	}
 	if len(encryptFiles) > 0 {
 		if err := onlyEncryptFiles(encryptFiles, store); err != nil {
 			log.Fatalf("failed to encrypt files: %v", err)
 		}
-		log.Printf("files encrypted")
+		logger.Info("files encrypted")
+		return
+	}
+
+	if *rotatePassword {
+		newPassword := os.Getenv("CHAT2WORLD_NEW_PASSWORD")
+		if newPassword == "" {
+			log.Fatalf("CHAT2WORLD_NEW_PASSWORD must hold the new password")
+		}
+		// every file under the directory is rotated, walking the working directory by default could reach far more.
+		if *secretsDir == "" {
+			log.Fatalf("--rotate-password needs the --secrets-dir holding the files to rotate")
+		}
+		rotated, err := secrets.RotatePassword(store, newPassword, *secretsDir)
+		if err != nil {
+			log.Fatalf("failed to rotate password: %v", err)
+		}
+		logger.Info("files re-encrypted with the new password", "files", rotated)
 		return
 	}
 
@@ -144,108 +285,199 @@ func main() {
 		if err := onlyDecryptFiles(decryptFiles, store); err != nil {
 			log.Fatalf("failed to decrypt files: %v", err)
 		}
-		log.Printf("files decrypted")
+		logger.Info("files decrypted")
 		return
 	}
 
-	// Try and load the secrets from the environment.
-	telegramSecrets := map[string]string{}
-	resave := false
-	for _, k := range []string{"TELEGRAM_BOT_TOKEN", "TELEGRAM_WEBHOOK_SECRET", "TELEGRAM_LISTEN_ADDR", "CHAT2WORLD_URL"} {
-		telegramSecrets[k] = os.Getenv(k)
-		if telegramSecrets[k] != "" {
-			resave = true
+	// Everything we store from here on goes in the secrets directory, the tools above take paths as given.
+	if *secretsDir != "" {
+		if err := os.MkdirAll(*secretsDir, 0700); err != nil {
+			log.Fatalf("creating secrets directory: %v", err)
 		}
+		store.Backend = secrets.FSBackend{Dir: *secretsDir}
 	}
-	if resave {
-		f, err := store.OpenWriter("telegram.config")
-		if err != nil {
-			log.Fatal(fmt.Errorf("opening encrypted file to write: %w", err))
-			return
-		}
-		err = json.NewEncoder(f).Encode(&telegramSecrets)
-		f.Close()
+
+	// Times given to /schedule without offset are taken to be in CHAT2WORLD_TZ, or the local time zone if not set.
+	postingOpts := []blogging.PostingFlowOption{blogging.WithLogger(logger)}
+	if tz := os.Getenv("CHAT2WORLD_TZ"); tz != "" {
+		loc, err := time.LoadLocation(tz)
 		if err != nil {
-			log.Fatal(fmt.Errorf("writing encrypted file: %w", err))
+			log.Fatalf("invalid CHAT2WORLD_TZ: %v", err)
 		}
-	} else {
-		f, err := store.OpenReader("telegram.config")
-		if err != nil {
-			log.Fatal(fmt.Errorf("opening encrypted file to read: %w", err))
-			return
+		postingOpts = append(postingOpts, blogging.WithDefaultLocation(loc))
+	}
+	overflowPolicy, err := blogging.ParseOverflowPolicy(*overflow)
+	if err != nil {
+		log.Fatalf("invalid --overflow: %v", err)
+	}
+	postingOpts = append(postingOpts, blogging.WithOverflowPolicy(overflowPolicy))
+
+	if *metricsAddr != "" {
+		recorder := metrics.NewPrometheus()
+		postingOpts = append(postingOpts, blogging.WithMetrics(recorder))
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", recorder)
+		go func() {
+			if err := http.ListenAndServe(*metricsAddr, mux); err != nil {
+				logger.Error("metrics server stopped", "err", err)
+			}
+		}()
+	}
+
+	// bluesky limits requests per IP, so every user's client shares the same limiter.
+	bskyLimiter := ratelimit.NewLimiter(bskyclient.DefaultRequestsPerSecond, bskyclient.DefaultBurst)
+
+	// mastodonCallbacks take the mastodon authorization codes when the bot has a public URL, they are set up along the
+	// telegram webhook below, before any user shows up and gets their platforms built.
+	var mastodonCallbacks *mastodon.Callbacks
+	mastodonOpts := func(opts ...mastodon.ClientOption) []mastodon.ClientOption {
+		opts = append(opts, mastodon.WithLogger(logger))
+		if mastodonCallbacks != nil {
+			opts = append(opts, mastodon.WithCallbacks(mastodonCallbacks))
 		}
+		return opts
+	}
 
-		err = json.NewDecoder(f).Decode(&telegramSecrets)
-		f.Close()
-		if err != nil {
-			log.Fatal(fmt.Errorf("reading encrypted file: %w", err))
+	// newRegistry returns a registry that knows how to build every platform, keeping their configs in store, cfg
+	// decides which ones users get. The /status of every IM reports the same status.
+	status := blogging.NewStatus()
+	newRegistry := func(store *secrets.EncryptedStore) *blogging.Registry {
+		registry := blogging.NewRegistry(blogging.WithRegistryLogger(logger), blogging.WithStatus(status))
+		for _, registration := range []blogging.PlatformRegistration{
+			{
+				Name: config.MBPMastodon,
+				New:  func() (blogging.AuthedPlatform, error) { return mastodon.NewClient(store, mastodonOpts()...) },
+				NewAccount: func(account string) (blogging.AuthedPlatform, error) {
+					return mastodon.NewClient(store, mastodonOpts(mastodon.WithAccount(account))...)
+				},
+				AuthFlow:        "mastodon_auth",
+				AuthDescription: "connect your mastodon account",
+			},
+			{
+				Name: config.MBPBsky,
+				New: func() (blogging.AuthedPlatform, error) {
+					return bluesky.NewClient(store, bluesky.WithRateLimiter(bskyLimiter), bluesky.WithLogger(logger))
+				},
+				AuthFlow:        "bluesky_auth",
+				AuthDescription: "connect your bluesky account",
+			},
+			{
+				Name:            config.BPHugo,
+				New:             func() (blogging.AuthedPlatform, error) { return hugo.NewClient(store, hugo.WithLogger(logger)) },
+				AuthFlow:        "hugo_auth",
+				AuthDescription: "configure the hugo site to write posts to",
+			},
+			{
+				Name:            config.MBPNostr,
+				New:             func() (blogging.AuthedPlatform, error) { return nostr.NewClient(store, nostr.WithLogger(logger)) },
+				AuthFlow:        "nostr_auth",
+				AuthDescription: "set up your nostr key and relays",
+			},
+		} {
+			if err := registry.Register(registration); err != nil {
+				log.Fatalf("registering platform: %v", err)
+			}
 		}
+		return registry
 	}
 
-	u, err := url.Parse(telegramSecrets["CHAT2WORLD_URL"])
+	cfg, err := loadConfig(*configPath)
 	if err != nil {
-		log.Fatal(err)
-		return
+		log.Fatalf("loading config: %v", err)
 	}
+	allowedTelegramUsers = append(allowedTelegramUsers, cfg.EnabledUIDs[config.IMTelegram]...)
+	allowedSignalUsers = append(allowedSignalUsers, cfg.EnabledUIDs[config.IMSignal]...)
 
-	// Create the bot instance.
-	tb, err := telegram.New(ctx, telegramSecrets["TELEGRAM_BOT_TOKEN"], telegramSecrets["TELEGRAM_WEBHOOK_SECRET"], u,
-		allowedTelegramUsers,
-		func(userID uint64) (*im.FlowScheduler, error) {
-			sched := im.NewScheduler()
+	schedulerOpts := []im.SchedulerOption{im.WithIdleTimeout(flowIdleTimeout), im.WithConcurrentFlows(), im.WithLogger(logger)}
+	if *whoami {
+		schedulerOpts = append(schedulerOpts, im.WithWhoami())
+	}
+	// schedulerFactory builds the flows of the users of an IM, each IM keeps the files of its users apart, their IDs
+	// could be the same number. Telegram ones stay at the top, where they were before there were other IMs.
+	schedulerFactory := func(name config.AvailableIM) im.SchedulerFactoryFN {
+		imStore := store
+		if name != config.IMTelegram {
+			imStore = store.Sub(string(name))
+		}
+		return newRegistry(imStore).SchedulerFactory(ctx, cfg, imStore, schedulerOpts, postingOpts...)
+	}
 
-			// mastodon
-			cm, err := mastodon.NewClient(store)
+	// running tracks the IMs, we wait for them to finish what they are doing before exiting.
+	var running sync.WaitGroup
+	if slices.Contains(cfg.EnabledIMs, config.IMTelegram) {
+		telegramSecrets, err := loadTelegramSecrets(store, cfg.IMAuth[config.IMTelegram])
+		if err != nil {
+			log.Fatal(err)
+		}
+		// Without a public URL for the webhook telegram is polled for updates.
+		var u *url.URL
+		if rawURL := telegramSecrets["CHAT2WORLD_URL"]; rawURL != "" {
+			parsed, err := url.Parse(rawURL)
 			if err != nil {
-				log.Printf("mastodon new client err: %v", err)
-				return nil, fmt.Errorf("mastodon new client: %w", err)
+				log.Fatal(err)
+				return
 			}
-			maf := blogging.NewAuthorizerFlow(cm)
-			if err = sched.RegisterFlow(maf, "mastodon_auth", []string{"/mastodon_auth"}); err != nil {
-				log.Printf("mastodon auth flow err: %v", err)
-				return nil, fmt.Errorf("mastodon auth flow: %w", err)
-			}
-			// done only for effect, this will trigger a load of user config
-			cm.IsAuthorized(blogging.UserID(userID))
+			u = parsed
+		}
 
-			// bluesky
-			bskyCM, err := bluesky.NewClient(store)
-			if err != nil {
-				log.Printf("bluesky new client err: %v", err)
-				return nil, fmt.Errorf("bluesky new client: %w", err)
-			}
-			bskyAF := blogging.NewAuthorizerFlow(bskyCM)
-			if err = sched.RegisterFlow(bskyAF, "bluesky_auth", []string{"/bluesky_auth"}); err != nil {
-				log.Printf("bluesky auth flow err: %v", err)
-				return nil, fmt.Errorf("bluesky auth flow: %w", err)
-			}
-			// done only for effect, this will trigger a load of user config
-			bskyCM.IsAuthorized(blogging.UserID(userID))
+		telegramOpts := []telegram.Option{telegram.WithMaxDownloadSize(*maxDownloadMB << 20),
+			telegram.WithWebhookPath(telegramSecrets[config.IMAuthTelegramPath]), telegram.WithLogger(logger)}
+		if *forwardAttribution {
+			telegramOpts = append(telegramOpts, telegram.WithForwardAttribution())
+		}
+		if u != nil {
+			// mastodon sends users back to us once they authorize the bot, along the webhook.
+			mastodonCallbacks = mastodon.NewCallbacks(u.JoinPath(mastodon.CallbackPath).String())
+			mux := http.NewServeMux()
+			mux.Handle("GET "+path.Join("/", u.Path, mastodon.CallbackPath), mastodonCallbacks)
+			telegramOpts = append(telegramOpts, telegram.WithMux(mux))
+		}
 
-			if err = sched.RegisterFlow(blogging.NewPostingFlow(map[config.AvailableBloggingPlatform]blogging.AuthedPlatform{config.MBPMastodon: cm, config.MBPBsky: bskyCM}),
-				"microblog_post", []string{"/new"}); err != nil {
-				log.Printf("microblog post flow err: %v", err)
-				return nil, fmt.Errorf("microblog post flow: %w", err)
-			}
+		// Create the bot instance.
+		gate := im.NewGate(allowedTelegramUsers, im.WithRateLimit(*maxMessagesPerMinute), im.WithGateLogger(logger))
+		tb, err := telegram.New(ctx, telegramSecrets["TELEGRAM_BOT_TOKEN"], telegramSecrets["TELEGRAM_WEBHOOK_SECRET"], u,
+			gate, schedulerFactory(config.IMTelegram), telegramOpts...)
+		if err != nil {
+			log.Fatalf("failed to create bot: %v", err)
+		}
 
-			return sched, nil
-		})
-	if err != nil {
-		log.Fatalf("failed to create bot: %v", err)
+		// Start the bot.
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			if err := tb.Start(ctx, telegramSecrets["TELEGRAM_LISTEN_ADDR"]); err != nil {
+				logger.Error("telegram bot stopped", "err", err)
+			}
+		}()
 	}
 
-	// Start the bot.
-	go func() {
-		if err := tb.Start(ctx, telegramSecrets["TELEGRAM_LISTEN_ADDR"]); err != nil {
-			log.Printf("bot stopped with error: %v", err)
+	// Signal needs a signal-cli daemon running with --socket.
+	if slices.Contains(cfg.EnabledIMs, config.IMSignal) {
+		socketPath, attachmentsDir := os.Getenv(config.IMAuthSignalSocket), os.Getenv(config.IMAuthSignalAttach)
+		if auth, ok := cfg.IMAuth[config.IMSignal]; ok && socketPath == "" {
+			socketPath, attachmentsDir = auth[config.IMAuthSignalSocket], auth[config.IMAuthSignalAttach]
+		}
+		if socketPath == "" {
+			log.Fatalf("signal is enabled but SIGNAL_CLI_SOCKET is not set")
 		}
-	}()
+		gate := im.NewGate(allowedSignalUsers, im.WithRateLimit(*maxMessagesPerMinute), im.WithGateLogger(logger))
+		sb, err := signalim.New(socketPath, attachmentsDir, gate, schedulerFactory(config.IMSignal),
+			signalim.WithLogger(logger))
+		if err != nil {
+			log.Fatalf("failed to create signal bot: %v", err)
+		}
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			if err := sb.Start(ctx); err != nil {
+				logger.Error("signal bot stopped", "err", err)
+			}
+		}()
+	}
 
-	// Block until context is canceled.
+	// Block until context is canceled and the bots are done with the messages they were handling.
 	<-ctx.Done()
-
-	// Stop the bot (if not already stopped).
-	tb.Stop()
-	log.Println("Bot stopped.")
+	running.Wait()
+	logger.Info("bot stopped")
 
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/perrito666/chat2world/config"
+)
+
+// writeConfig writes data as a config file and returns its path.
+func writeConfig(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, `{
+		"EnabledIMs": ["telegram", "signal"],
+		"EnabledBloggingPlatforms": ["bluesky", "mastodon", "nostr"],
+		"AvailableInteractions": {"signal": ["mastodon"]},
+		"EnabledUIDs": {"telegram": [42]},
+		"IMAuth": {"signal": {"SIGNAL_CLI_SOCKET": "/run/signal-cli/socket"}}
+	}`)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if want := []config.AvailableIM{config.IMTelegram, config.IMSignal}; !slices.Equal(cfg.EnabledIMs, want) {
+		t.Errorf("IMs = %q, want %q", cfg.EnabledIMs, want)
+	}
+	// telegram has no interactions set so it gets every platform, in the order they were enabled.
+	want := []config.AvailableBloggingPlatform{config.MBPBsky, config.MBPMastodon, config.MBPNostr}
+	if got := cfg.PlatformsFor(config.IMTelegram); !slices.Equal(got, want) {
+		t.Errorf("telegram platforms = %q, want %q", got, want)
+	}
+	if got := cfg.PlatformsFor(config.IMSignal); !slices.Equal(got, []config.AvailableBloggingPlatform{config.MBPMastodon}) {
+		t.Errorf("signal platforms = %q, want [mastodon]", got)
+	}
+	if got := cfg.EnabledUIDs[config.IMTelegram]; !slices.Equal(got, []uint64{42}) {
+		t.Errorf("telegram users = %v, want [42]", got)
+	}
+}
+
+func TestLoadConfigEnablesOnlyWhatTheFileSays(t *testing.T) {
+	cfg, err := loadConfig(writeConfig(t, `{"EnabledIMs": ["telegram"]}`))
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if len(cfg.EnabledBloggingPlatforms) != 0 {
+		t.Errorf("platforms = %q, want none", cfg.EnabledBloggingPlatforms)
+	}
+}
+
+func TestLoadConfigWithoutFile(t *testing.T) {
+	t.Setenv("SIGNAL_CLI_SOCKET", "")
+	cfg, err := loadConfig("")
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if !slices.Equal(cfg.EnabledIMs, []config.AvailableIM{config.IMTelegram}) {
+		t.Errorf("IMs = %q, want only telegram", cfg.EnabledIMs)
+	}
+	want := []config.AvailableBloggingPlatform{config.MBPMastodon, config.MBPBsky, config.BPHugo, config.MBPNostr}
+	if got := cfg.PlatformsFor(config.IMTelegram); !slices.Equal(got, want) {
+		t.Errorf("telegram platforms = %q, want %q", got, want)
+	}
+
+	t.Setenv("SIGNAL_CLI_SOCKET", "/run/signal-cli/socket")
+	if cfg, err = loadConfig(""); err != nil || !slices.Contains(cfg.EnabledIMs, config.IMSignal) {
+		t.Errorf("loadConfig() = %q, %v, want signal enabled by SIGNAL_CLI_SOCKET", cfg.EnabledIMs, err)
+	}
+}
+
+func TestLoadConfigRefusesInvalidFiles(t *testing.T) {
+	if _, err := loadConfig(writeConfig(t, `{"EnabledIMs": ["irc"]}`)); !errors.Is(err, config.ErrInvalidConfig) {
+		t.Errorf("loading a config enabling an unknown IM: err = %v, want ErrInvalidConfig", err)
+	}
+	if _, err := loadConfig(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("loading a missing config succeeded")
+	}
+}
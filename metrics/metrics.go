@@ -0,0 +1,40 @@
+// Package metrics lets chat2world report how posting goes (how many posts, how many failed and how long they took) to
+// whatever the operator uses to watch it, Prometheus is supported out of the box.
+package metrics
+
+// Metric names, labeled by platform and, for PostsTotal, by result (ResultSuccess or ResultFailure).
+const (
+	PostsTotal          = "chat2world_posts_total"
+	PostDurationSeconds = "chat2world_post_duration_seconds"
+)
+
+// Values of the result label.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Label is a dimension of a metric, i.e. the platform.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Recorder receives the metrics, implementations must be safe for concurrent use.
+type Recorder interface {
+	// Count adds one to the counter name.
+	Count(name string, labels ...Label)
+	// Observe adds value to the histogram name.
+	Observe(name string, value float64, labels ...Label)
+}
+
+// Nop is a Recorder that drops everything, it is what is used when no Recorder is given.
+type Nop struct{}
+
+var _ Recorder = Nop{}
+
+// Count implements Recorder.
+func (Nop) Count(string, ...Label) {}
+
+// Observe implements Recorder.
+func (Nop) Observe(string, float64, ...Label) {}
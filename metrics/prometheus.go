@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the histogram buckets of a Prometheus made by NewPrometheus,
+// posts with images or videos easily take a few seconds.
+var DefaultBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// histogram holds the observations of a single series, counts[i] is how many fell in the i-th bucket alone.
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Prometheus is a Recorder that keeps the metrics in memory and serves them, as an http.Handler, in the Prometheus
+// text format, mount it on /metrics and point Prometheus to it.
+type Prometheus struct {
+	mu         sync.Mutex
+	buckets    []float64
+	counters   map[string]map[string]uint64
+	histograms map[string]map[string]*histogram
+}
+
+var (
+	_ Recorder     = (*Prometheus)(nil)
+	_ http.Handler = (*Prometheus)(nil)
+)
+
+// NewPrometheus creates an empty Prometheus whose histograms use buckets, DefaultBuckets if none are given.
+func NewPrometheus(buckets ...float64) *Prometheus {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+	return &Prometheus{
+		buckets:    buckets,
+		counters:   make(map[string]map[string]uint64),
+		histograms: make(map[string]map[string]*histogram),
+	}
+}
+
+// seriesLabels renders labels the way they go between braces, sorted by name so the same labels always render the
+// same.
+func seriesLabels(labels []Label) string {
+	sorted := slices.Clone(labels)
+	slices.SortFunc(sorted, func(a, b Label) int { return strings.Compare(a.Name, b.Name) })
+	pairs := make([]string, len(sorted))
+	for i, label := range sorted {
+		pairs[i] = label.Name + "=" + strconv.Quote(label.Value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Count implements Recorder.
+func (p *Prometheus) Count(name string, labels ...Label) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	series, ok := p.counters[name]
+	if !ok {
+		series = make(map[string]uint64)
+		p.counters[name] = series
+	}
+	series[seriesLabels(labels)]++
+}
+
+// Observe implements Recorder.
+func (p *Prometheus) Observe(name string, value float64, labels ...Label) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	series, ok := p.histograms[name]
+	if !ok {
+		series = make(map[string]*histogram)
+		p.histograms[name] = series
+	}
+	key := seriesLabels(labels)
+	h, ok := series[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(p.buckets))}
+		series[key] = h
+	}
+	// values above the last bucket only count for +Inf, which is count.
+	if i := sort.SearchFloat64s(p.buckets, value); i < len(p.buckets) {
+		h.counts[i]++
+	}
+	h.sum += value
+	h.count++
+}
+
+// braced returns labels between braces, or nothing if there are none.
+func braced(labels ...string) string {
+	var nonEmpty []string
+	for _, l := range labels {
+		if l != "" {
+			nonEmpty = append(nonEmpty, l)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(nonEmpty, ",") + "}"
+}
+
+// sortedKeys returns the keys of m in order, for a stable output.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// WriteTo writes every metric in the Prometheus text format.
+func (p *Prometheus) WriteTo(w io.Writer) (int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var sb strings.Builder
+	for _, name := range sortedKeys(p.counters) {
+		fmt.Fprintf(&sb, "# TYPE %s counter\n", name)
+		for _, labels := range sortedKeys(p.counters[name]) {
+			fmt.Fprintf(&sb, "%s%s %d\n", name, braced(labels), p.counters[name][labels])
+		}
+	}
+	for _, name := range sortedKeys(p.histograms) {
+		fmt.Fprintf(&sb, "# TYPE %s histogram\n", name)
+		for _, labels := range sortedKeys(p.histograms[name]) {
+			h := p.histograms[name][labels]
+			var cumulative uint64
+			for i, bound := range p.buckets {
+				cumulative += h.counts[i]
+				le := "le=" + strconv.Quote(strconv.FormatFloat(bound, 'g', -1, 64))
+				fmt.Fprintf(&sb, "%s_bucket%s %d\n", name, braced(labels, le), cumulative)
+			}
+			fmt.Fprintf(&sb, "%s_bucket%s %d\n", name, braced(labels, `le="+Inf"`), h.count)
+			fmt.Fprintf(&sb, "%s_sum%s %s\n", name, braced(labels), strconv.FormatFloat(h.sum, 'g', -1, 64))
+			fmt.Fprintf(&sb, "%s_count%s %d\n", name, braced(labels), h.count)
+		}
+	}
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+// ServeHTTP implements http.Handler, serving the metrics in the Prometheus text format.
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	// failing to write means the scraper is gone, there is nobody to tell.
+	_, _ = p.WriteTo(w)
+}
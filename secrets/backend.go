@@ -0,0 +1,239 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Backend is where an EncryptedStore keeps the (already encrypted) files, by key. Opening a key that was never
+// written must return an error wrapping os.ErrNotExist.
+type Backend interface {
+	OpenReaderAt(key string) (io.ReadCloser, error)
+	// OpenWriterAt creates, or truncates, the file at key.
+	OpenWriterAt(key string) (io.WriteCloser, error)
+	// Remove deletes the file at key, removing a key that does not exist is not an error.
+	Remove(key string) error
+}
+
+// FSBackend keeps files in the filesystem, under Dir if set, keys are paths relative to it. An FSBackend without Dir
+// is what an EncryptedStore without Backend uses, keys are then taken as they are, relative to the working directory.
+type FSBackend struct {
+	Dir string
+}
+
+// ErrKeyOutsideDir is returned for keys that would end up outside the directory of an FSBackend.
+var ErrKeyOutsideDir = errors.New("key is outside the backend directory")
+
+// path returns where the file for key is.
+func (fb FSBackend) path(key string) (string, error) {
+	if fb.Dir == "" {
+		return key, nil
+	}
+	if !filepath.IsLocal(key) {
+		return "", fmt.Errorf("%s: %w", key, ErrKeyOutsideDir)
+	}
+	return filepath.Join(fb.Dir, key), nil
+}
+
+// OpenReaderAt implements Backend.
+func (fb FSBackend) OpenReaderAt(key string) (io.ReadCloser, error) {
+	path, err := fb.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// OpenWriterAt implements Backend, files are only readable by their owner. The file is written to a temporary one
+// next to it which replaces it on Close, so a crash or a failed write never leaves it half written.
+func (fb FSBackend) OpenWriterAt(key string) (io.WriteCloser, error) {
+	path, err := fb.path(key)
+	if err != nil {
+		return nil, err
+	}
+	// keys can be in subdirectories, i.e. those of a SubBackend.
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	// CreateTemp makes the file only readable by its owner.
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &atomicFile{File: f, path: path}, nil
+}
+
+// Remove implements Backend.
+func (fb FSBackend) Remove(key string) error {
+	path, err := fb.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+var _ Backend = FSBackend{}
+
+// atomicFile is a temporary file that replaces the one at path when closed, unless writing it failed, in which case
+// it is removed and path left as it was.
+type atomicFile struct {
+	*os.File
+	path string
+	err  error
+}
+
+// Write implements io.Writer.
+func (af *atomicFile) Write(p []byte) (int, error) {
+	n, err := af.File.Write(p)
+	if err != nil && af.err == nil {
+		af.err = err
+	}
+	return n, err
+}
+
+// Close syncs and closes the temporary file and moves it to path.
+func (af *atomicFile) Close() error {
+	err := af.err
+	if err == nil {
+		err = af.Sync()
+	}
+	if cerr := af.File.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(af.Name(), af.path)
+	}
+	if err != nil {
+		os.Remove(af.Name())
+		return fmt.Errorf("writing %s: %w", af.path, err)
+	}
+	return nil
+}
+
+// SubBackend keeps files in Backend under Dir, keys are relative to it, i.e. to keep apart files that would otherwise
+// have the same key.
+type SubBackend struct {
+	Backend Backend
+	Dir     string
+}
+
+// key returns the key in Backend of the file at key.
+func (sb SubBackend) key(key string) (string, error) {
+	if !filepath.IsLocal(key) {
+		return "", fmt.Errorf("%s: %w", key, ErrKeyOutsideDir)
+	}
+	return filepath.Join(sb.Dir, key), nil
+}
+
+// OpenReaderAt implements Backend.
+func (sb SubBackend) OpenReaderAt(key string) (io.ReadCloser, error) {
+	key, err := sb.key(key)
+	if err != nil {
+		return nil, err
+	}
+	return sb.Backend.OpenReaderAt(key)
+}
+
+// OpenWriterAt implements Backend.
+func (sb SubBackend) OpenWriterAt(key string) (io.WriteCloser, error) {
+	key, err := sb.key(key)
+	if err != nil {
+		return nil, err
+	}
+	return sb.Backend.OpenWriterAt(key)
+}
+
+// Remove implements Backend.
+func (sb SubBackend) Remove(key string) error {
+	key, err := sb.key(key)
+	if err != nil {
+		return err
+	}
+	return sb.Backend.Remove(key)
+}
+
+var _ Backend = SubBackend{}
+
+// MemoryBackend keeps files in memory, i.e. for tests or secrets that must not outlive the process. A file written
+// becomes visible once its writer is closed. The zero value is ready to use.
+type MemoryBackend struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+// OpenReaderAt implements Backend.
+func (mb *MemoryBackend) OpenReaderAt(key string) (io.ReadCloser, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	data, ok := mb.files[key]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: key, Err: fs.ErrNotExist}
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// OpenWriterAt implements Backend.
+func (mb *MemoryBackend) OpenWriterAt(key string) (io.WriteCloser, error) {
+	return &memoryWriter{backend: mb, key: key}, nil
+}
+
+// Remove implements Backend.
+func (mb *MemoryBackend) Remove(key string) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	delete(mb.files, key)
+	return nil
+}
+
+// Keys returns the keys of the files in the backend, in no particular order.
+func (mb *MemoryBackend) Keys() []string {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	keys := make([]string, 0, len(mb.files))
+	for key := range mb.files {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+var _ Backend = (*MemoryBackend)(nil)
+
+// memoryWriter buffers a file of a MemoryBackend until it is closed.
+type memoryWriter struct {
+	backend *MemoryBackend
+	key     string
+	buf     bytes.Buffer
+	closed  bool
+}
+
+// Write implements io.Writer.
+func (mw *memoryWriter) Write(p []byte) (int, error) {
+	if mw.closed {
+		return 0, fmt.Errorf("writing %s: %w", mw.key, os.ErrClosed)
+	}
+	return mw.buf.Write(p)
+}
+
+// Close stores the written file in the backend.
+func (mw *memoryWriter) Close() error {
+	if mw.closed {
+		return fmt.Errorf("closing %s: %w", mw.key, os.ErrClosed)
+	}
+	mw.closed = true
+	mw.backend.mu.Lock()
+	defer mw.backend.mu.Unlock()
+	if mw.backend.files == nil {
+		mw.backend.files = make(map[string][]byte)
+	}
+	mw.backend.files[mw.key] = bytes.Clone(mw.buf.Bytes())
+	return nil
+}
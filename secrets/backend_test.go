@@ -0,0 +1,129 @@
+package secrets
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemoryBackend(t *testing.T) {
+	backend := &MemoryBackend{}
+	if _, err := backend.OpenReaderAt("missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("opening a missing key: err = %v, want os.ErrNotExist", err)
+	}
+
+	w, err := backend.OpenWriterAt("key")
+	if err != nil {
+		t.Fatalf("OpenWriterAt: %v", err)
+	}
+	if _, err := w.Write([]byte("data")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if _, err := backend.OpenReaderAt("key"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("opening a key being written: err = %v, want os.ErrNotExist until it is closed", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := w.Write([]byte("more")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("writing after closing: err = %v, want os.ErrClosed", err)
+	}
+	if got := string(rawFile(t, backend, "key")); got != "data" {
+		t.Errorf("read %q, want \"data\"", got)
+	}
+	if keys := backend.Keys(); len(keys) != 1 || keys[0] != "key" {
+		t.Errorf("Keys() = %q, want [key]", keys)
+	}
+
+	if err := backend.Remove("key"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := backend.OpenReaderAt("key"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("opening a removed key: err = %v, want os.ErrNotExist", err)
+	}
+	if err := backend.Remove("key"); err != nil {
+		t.Errorf("removing a missing key: %v", err)
+	}
+}
+
+func TestEncryptedStoreInMemory(t *testing.T) {
+	store := newTestStore("hunter2")
+	writeFile(t, store, "1.mastodon.json", []byte(`{"access_token":"abc"}`))
+	got, err := readFile(store, "1.mastodon.json")
+	if err != nil || string(got) != `{"access_token":"abc"}` {
+		t.Fatalf("reading back = %q, %v", got, err)
+	}
+	if _, err := os.Stat("1.mastodon.json"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the file was written to disk: %v", err)
+	}
+
+	if err := store.Remove("1.mastodon.json"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	r, err := store.OpenReader("1.mastodon.json")
+	if err == nil {
+		_, err = io.ReadAll(r)
+		r.Close()
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("reading a removed file: err = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestFSBackendKeepsFilesUnderDir(t *testing.T) {
+	dir := t.TempDir()
+	store := &EncryptedStore{Password: "hunter2", Params: testParams, Backend: FSBackend{Dir: dir}}
+	writeFile(t, store, "1.settings.json", []byte("settings"))
+	writeFile(t, store.Sub("mastodon"), "1.json", []byte("config"))
+
+	for _, name := range []string{"1.settings.json", filepath.Join("mastodon", "1.json")} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("%s is not under the directory: %v", name, err)
+			continue
+		}
+		if perm := info.Mode().Perm(); perm != 0600 {
+			t.Errorf("%s has permissions %o, want 0600", name, perm)
+		}
+		if _, err := os.Stat(name); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s was written to the working directory", name)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(dir, "mastodon")); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("the subdirectory is %v, %v, want it only accessible to its owner", info, err)
+	}
+
+	for _, key := range []string{"../escaped.json", "/tmp/absolute.json", "sub/../../escaped.json"} {
+		if _, err := store.OpenWriter(key); !errors.Is(err, ErrKeyOutsideDir) {
+			t.Errorf("OpenWriter(%q) err = %v, want ErrKeyOutsideDir", key, err)
+		}
+	}
+}
+
+func TestFSBackendReplacesFilesOnClose(t *testing.T) {
+	dir := t.TempDir()
+	backend := FSBackend{Dir: dir}
+	putRawFile(t, backend, "key", []byte("old"))
+
+	w, err := backend.OpenWriterAt("key")
+	if err != nil {
+		t.Fatalf("OpenWriterAt: %v", err)
+	}
+	if _, err := w.Write([]byte("new")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if got := string(rawFile(t, backend, "key")); got != "old" {
+		t.Errorf("read %q while writing, want \"old\" until it is closed", got)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := string(rawFile(t, backend, "key")); got != "new" {
+		t.Errorf("read %q, want \"new\"", got)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Errorf("directory holds %v, %v, want just the file", entries, err)
+	}
+}
@@ -0,0 +1,150 @@
+package secrets
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The authenticated format splits the plaintext in chunks of up to gcmChunkSize bytes, each one sealed with AES-GCM
+// and preceded by a 4 byte header holding its sealed length and a flag marking the last chunk. The header is used as
+// additional data so neither the length nor the flag can be tampered with, and a missing final chunk means the file
+// was truncated.
+// The nonce of each chunk is the first gcmNoncePrefixSize bytes of the salt followed by the chunk counter, since the
+// key itself is derived from the (random) salt, nonces never repeat for a given key.
+const (
+	gcmChunkSize       = 64 * 1024
+	gcmNoncePrefixSize = 4
+	gcmChunkHeaderSize = 4
+	gcmFinalChunkFlag  = 1 << 31
+)
+
+// ErrAuthenticationFailed is returned when an encrypted file was tampered with, corrupted or the password is wrong.
+var ErrAuthenticationFailed = errors.New("encrypted file authentication failed")
+
+// ErrTruncated is returned when an encrypted file ends before its last chunk.
+var ErrTruncated = errors.New("encrypted file is truncated")
+
+// chunkNonce builds the nonce for the chunk number counter.
+func chunkNonce(aead cipher.AEAD, salt []byte, counter uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, salt[:gcmNoncePrefixSize])
+	binary.BigEndian.PutUint64(nonce[gcmNoncePrefixSize:], counter)
+	return nonce
+}
+
+// gcmWriter encrypts and authenticates everything written to it, chunk by chunk.
+type gcmWriter struct {
+	w       io.WriteCloser
+	aead    cipher.AEAD
+	salt    []byte
+	counter uint64
+	buf     []byte
+}
+
+// sealChunk encrypts the buffered plaintext and writes it as a chunk.
+func (gw *gcmWriter) sealChunk(final bool) error {
+	header := make([]byte, gcmChunkHeaderSize)
+	length := uint32(len(gw.buf) + gw.aead.Overhead())
+	if final {
+		length |= gcmFinalChunkFlag
+	}
+	binary.BigEndian.PutUint32(header, length)
+	sealed := gw.aead.Seal(header, chunkNonce(gw.aead, gw.salt, gw.counter), gw.buf, header)
+	if _, err := gw.w.Write(sealed); err != nil {
+		return fmt.Errorf("writing chunk %d: %w", gw.counter, err)
+	}
+	gw.counter++
+	gw.buf = gw.buf[:0]
+	return nil
+}
+
+// Write implements io.Writer, a chunk is only sealed once we know more data follows, so Close can flag the last one.
+func (gw *gcmWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(gw.buf) == gcmChunkSize {
+			if err := gw.sealChunk(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(gw.buf[len(gw.buf):gcmChunkSize], p)
+		gw.buf = gw.buf[:len(gw.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close seals the final chunk and closes the underlying writer.
+func (gw *gcmWriter) Close() error {
+	err := gw.sealChunk(true)
+	if cerr := gw.w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// gcmReader decrypts and verifies chunks written by gcmWriter.
+type gcmReader struct {
+	r       io.ReadCloser
+	aead    cipher.AEAD
+	salt    []byte
+	counter uint64
+	buf     []byte
+	done    bool
+}
+
+// openChunk reads and verifies the next chunk.
+func (gr *gcmReader) openChunk() error {
+	header := make([]byte, gcmChunkHeaderSize)
+	if _, err := io.ReadFull(gr.r, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrTruncated
+		}
+		return fmt.Errorf("reading chunk header: %w", err)
+	}
+	length := binary.BigEndian.Uint32(header)
+	final := length&gcmFinalChunkFlag != 0
+	length &^= gcmFinalChunkFlag
+	if length < uint32(gr.aead.Overhead()) || length > uint32(gcmChunkSize+gr.aead.Overhead()) {
+		return ErrAuthenticationFailed
+	}
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(gr.r, sealed); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrTruncated
+		}
+		return fmt.Errorf("reading chunk: %w", err)
+	}
+	plain, err := gr.aead.Open(sealed[:0], chunkNonce(gr.aead, gr.salt, gr.counter), sealed, header)
+	if err != nil {
+		return fmt.Errorf("chunk %d: %w", gr.counter, ErrAuthenticationFailed)
+	}
+	gr.counter++
+	gr.buf = plain
+	gr.done = final
+	return nil
+}
+
+// Read implements io.Reader, no plaintext is returned from a chunk before it has been verified.
+func (gr *gcmReader) Read(p []byte) (int, error) {
+	for len(gr.buf) == 0 {
+		if gr.done {
+			return 0, io.EOF
+		}
+		if err := gr.openChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, gr.buf)
+	gr.buf = gr.buf[n:]
+	return n, nil
+}
+
+// Close closes the underlying reader.
+func (gr *gcmReader) Close() error {
+	return gr.r.Close()
+}
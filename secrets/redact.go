@@ -0,0 +1,135 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Redacted is what secrets are replaced with in logs.
+const Redacted = "[REDACTED]"
+
+// MaxLoggedText is how many characters of a post (attributes named text or body) make it to the logs.
+const MaxLoggedText = 80
+
+// sensitiveKeys are the attribute key fragments whose values are never logged, i.e. access_token or app_password.
+var sensitiveKeys = []string{"token", "password", "secret", "jwt", "authorization"}
+
+// textKeys are the attributes holding what users write, logged truncated.
+var textKeys = []string{"text", "body"}
+
+// secretPatterns match secrets wherever they show up, i.e. within an error message, the first group, if any, is
+// kept.
+var secretPatterns = []*regexp.Regexp{
+	// JWTs, bluesky access and refresh tokens among them.
+	regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+	// telegram bot tokens, which are part of every bot API URL.
+	regexp.MustCompile(`\d{5,}:[A-Za-z0-9_-]{30,}`),
+	// bluesky app passwords.
+	regexp.MustCompile(`\b[a-z0-9]{4}-[a-z0-9]{4}-[a-z0-9]{4}-[a-z0-9]{4}\b`),
+	// whatever follows Bearer, i.e. in a dumped Authorization header.
+	regexp.MustCompile(`(?i)(bearer\s+)[^\s"',]+`),
+}
+
+// Redact replaces anything in s that looks like a secret with Redacted.
+func Redact(s string) string {
+	for _, pattern := range secretPatterns {
+		if pattern.NumSubexp() > 0 {
+			s = pattern.ReplaceAllString(s, "${1}"+Redacted)
+			continue
+		}
+		s = pattern.ReplaceAllLiteralString(s, Redacted)
+	}
+	return s
+}
+
+// truncate shortens s to MaxLoggedText characters.
+func truncate(s string) string {
+	if utf8.RuneCountInString(s) <= MaxLoggedText {
+		return s
+	}
+	return string([]rune(s)[:MaxLoggedText]) + "…"
+}
+
+// RedactingHandler is a slog.Handler that keeps secrets out of the logs of the handler it wraps: the values of
+// sensitive attributes (see sensitiveKeys) are replaced, anything that looks like a secret (see Redact) is replaced
+// wherever it is and post bodies are truncated.
+type RedactingHandler struct {
+	next slog.Handler
+}
+
+// NewRedactingHandler returns a RedactingHandler writing to next.
+func NewRedactingHandler(next slog.Handler) *RedactingHandler {
+	return &RedactingHandler{next: next}
+}
+
+var _ slog.Handler = (*RedactingHandler)(nil)
+
+// Enabled implements slog.Handler.
+func (h *RedactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (h *RedactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, Redact(record.Message), record.PC)
+	record.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, redacted)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *RedactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = redactAttr(a)
+	}
+	return &RedactingHandler{next: h.next.WithAttrs(redacted)}
+}
+
+// WithGroup implements slog.Handler.
+func (h *RedactingHandler) WithGroup(name string) slog.Handler {
+	return &RedactingHandler{next: h.next.WithGroup(name)}
+}
+
+// redactAttr returns a with its value redacted, values that are neither strings nor groups (i.e. errors or structs)
+// are logged as their formatted string.
+func redactAttr(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	key := strings.ToLower(a.Key)
+	switch {
+	case a.Value.Kind() == slog.KindGroup:
+		group := a.Value.Group()
+		redacted := make([]any, len(group))
+		for i, member := range group {
+			redacted[i] = redactAttr(member)
+		}
+		return slog.Group(a.Key, redacted...)
+	case containsAny(key, sensitiveKeys):
+		return slog.String(a.Key, Redacted)
+	case a.Value.Kind() == slog.KindString:
+		value := Redact(a.Value.String())
+		if containsAny(key, textKeys) {
+			value = truncate(value)
+		}
+		return slog.String(a.Key, value)
+	case a.Value.Kind() == slog.KindAny:
+		return slog.String(a.Key, Redact(fmt.Sprintf("%+v", a.Value.Any())))
+	}
+	return a
+}
+
+// containsAny tells if s contains any of fragments.
+func containsAny(s string, fragments []string) bool {
+	for _, fragment := range fragments {
+		if strings.Contains(s, fragment) {
+			return true
+		}
+	}
+	return false
+}
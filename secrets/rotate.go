@@ -0,0 +1,171 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// rotatingSuffix is appended to the name of the re-encrypted copy of a file until it replaces the original.
+const rotatingSuffix = ".rotating"
+
+// isAuthenticatedFile tells if the file at path is in the authenticated format, the only one we can tell apart from
+// random bytes.
+func isAuthenticatedFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+	header := make([]byte, len(fileMagic)+1)
+	if _, err := io.ReadFull(f, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to read header: %w", err)
+	}
+	version := header[len(fileMagic)]
+	return string(header[:len(fileMagic)]) == fileMagic && (version == fileVersionGCM || version == fileVersionGCMParams), nil
+}
+
+// ErrLegacyFile is returned by RotatePassword for files in the legacy unauthenticated format.
+var ErrLegacyFile = errors.New("legacy unauthenticated encrypted file")
+
+// RotatePassword re-encrypts every file under the directory dir, subdirectories included, that oldStore's password
+// decrypts with newPassword instead, i.e. when the old one was compromised, and returns the paths, relative to dir, of
+// the rotated files. Files that are not encrypted, or not with oldStore's password, are skipped (and logged).
+// There is no telling whether legacy unauthenticated files decrypt correctly, so if there are any nothing is rotated
+// and an error wrapping ErrLegacyFile names them, rewriting them with OpenWriter (i.e. decrypting and encrypting them
+// again) upgrades them.
+// Every file is re-encrypted to a copy first and the copies only replace the originals once all of them were
+// written, if anything fails before that the directory is left as it was.
+func RotatePassword(oldStore *EncryptedStore, newPassword string, dir string) ([]string, error) {
+	// dir is read straight from the filesystem, whatever the backend of oldStore.
+	oldStore = &EncryptedStore{Password: oldStore.Password, Params: oldStore.Params}
+	newStore := &EncryptedStore{Password: newPassword, Params: oldStore.Params}
+	var paths, legacy []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %w", path, err)
+		}
+		if !entry.Type().IsRegular() || filepath.Ext(entry.Name()) == rotatingSuffix {
+			return nil
+		}
+		authenticated, err := isAuthenticatedFile(path)
+		if err != nil {
+			return fmt.Errorf("checking %s: %w", path, err)
+		}
+		if authenticated {
+			paths = append(paths, path)
+			return nil
+		}
+		isLegacy, err := isLegacyFile(path)
+		if err != nil {
+			return fmt.Errorf("checking %s: %w", path, err)
+		}
+		if isLegacy {
+			legacy = append(legacy, path)
+			return nil
+		}
+		slog.Warn("rotating password: skipping file, it is not encrypted", "path", path)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(legacy) > 0 {
+		return nil, fmt.Errorf("%s, rewrite them before rotating the password: %w", strings.Join(legacy, ", "),
+			ErrLegacyFile)
+	}
+
+	var rotated []string
+	cleanup := func() {
+		for _, name := range rotated {
+			os.Remove(filepath.Join(dir, name+rotatingSuffix))
+		}
+	}
+	for _, path := range paths {
+		plain, err := decryptFile(oldStore, path)
+		if err != nil {
+			if errors.Is(err, ErrAuthenticationFailed) || errors.Is(err, ErrTruncated) {
+				slog.Warn("rotating password: skipping file, it does not decrypt with the old password", "path", path,
+					"err", err)
+				continue
+			}
+			cleanup()
+			return nil, fmt.Errorf("decrypting %s: %w", path, err)
+		}
+		if err := encryptFile(newStore, path+rotatingSuffix, plain); err != nil {
+			os.Remove(path + rotatingSuffix)
+			cleanup()
+			return nil, fmt.Errorf("re-encrypting %s: %w", path, err)
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			os.Remove(path + rotatingSuffix)
+			cleanup()
+			return nil, fmt.Errorf("naming %s: %w", path, err)
+		}
+		rotated = append(rotated, name)
+	}
+	for i, name := range rotated {
+		path := filepath.Join(dir, name)
+		if err := os.Rename(path+rotatingSuffix, path); err != nil {
+			cleanup()
+			return rotated[:i], fmt.Errorf("replacing %s, the files before it use the new password: %w", path, err)
+		}
+	}
+	return rotated, nil
+}
+
+// isLegacyFile tells if the file at path, which is not in the authenticated format, might be in the legacy one: it is
+// long enough to hold its salt and IV and does not read as text, which a file written in the clear would.
+func isLegacyFile(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read file: %w", err)
+	}
+	if len(data) < saltSize+ivSize {
+		return false, nil
+	}
+	if !utf8.Valid(data) {
+		return true, nil
+	}
+	for _, r := range string(data) {
+		if unicode.IsControl(r) && !unicode.IsSpace(r) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// decryptFile reads the whole plaintext of the file at path.
+func decryptFile(store *EncryptedStore, path string) ([]byte, error) {
+	r, err := store.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// encryptFile writes plain encrypted to the file at path.
+func encryptFile(store *EncryptedStore, path string, plain []byte) error {
+	w, err := store.OpenWriter(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, bytes.NewReader(plain))
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
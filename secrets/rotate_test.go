@@ -0,0 +1,89 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRotatePassword(t *testing.T) {
+	dir := t.TempDir()
+	old := &EncryptedStore{Password: "old", Params: testParams, Backend: FSBackend{Dir: dir}}
+	files := map[string]string{
+		"1.mastodon.json":              `{"access_token":"abc"}`,
+		"1.bsky.session.json":          `{"refresh_jwt":"def"}`,
+		filepath.Join("sub", "c.json"): "in a subdirectory",
+	}
+	for name, data := range files {
+		writeFile(t, old, name, []byte(data))
+	}
+	// neither of these can be rotated, they must be left alone.
+	other := &EncryptedStore{Password: "someone else's", Params: testParams, Backend: FSBackend{Dir: dir}}
+	writeFile(t, other, "other.json", []byte("not ours"))
+	otherRaw := rawFile(t, FSBackend{Dir: dir}, "other.json")
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("plain"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := RotatePassword(old, "new", dir)
+	if err != nil {
+		t.Fatalf("RotatePassword: %v", err)
+	}
+	slices.Sort(rotated)
+	want := []string{"1.bsky.session.json", "1.mastodon.json", filepath.Join("sub", "c.json")}
+	if !slices.Equal(rotated, want) {
+		t.Errorf("RotatePassword() = %q, want %q", rotated, want)
+	}
+
+	rotatedStore := &EncryptedStore{Password: "new", Backend: FSBackend{Dir: dir}}
+	for name, data := range files {
+		got, err := readFile(rotatedStore, name)
+		if err != nil || string(got) != data {
+			t.Errorf("reading %s with the new password = %q, %v, want %q", name, got, err, data)
+		}
+		if _, err := readFile(old, name); !errors.Is(err, ErrAuthenticationFailed) {
+			t.Errorf("reading %s with the old password: err = %v, want ErrAuthenticationFailed", name, err)
+		}
+	}
+	if raw := rawFile(t, FSBackend{Dir: dir}, "other.json"); !bytes.Equal(raw, otherRaw) {
+		t.Error("a file encrypted with another password was changed")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), rotatingSuffix) {
+			t.Errorf("%s was left behind", entry.Name())
+		}
+	}
+}
+
+func TestRotatePasswordRefusesLegacyFiles(t *testing.T) {
+	dir := t.TempDir()
+	old := &EncryptedStore{Password: "old", Params: testParams, Backend: FSBackend{Dir: dir}}
+	writeFile(t, old, "1.mastodon.json", []byte(`{"access_token":"abc"}`))
+	before := rawFile(t, FSBackend{Dir: dir}, "1.mastodon.json")
+	// a legacy file is its salt and IV followed by the ciphertext, all of it random looking.
+	legacy := make([]byte, saltSize+ivSize+32)
+	if _, err := rand.Read(legacy); err != nil {
+		t.Fatal(err)
+	}
+	putRawFile(t, FSBackend{Dir: dir}, "legacy.json", legacy)
+
+	rotated, err := RotatePassword(old, "new", dir)
+	if !errors.Is(err, ErrLegacyFile) || !strings.Contains(err.Error(), "legacy.json") {
+		t.Fatalf("RotatePassword() = %q, %v, want an ErrLegacyFile naming the legacy file", rotated, err)
+	}
+	if raw := rawFile(t, FSBackend{Dir: dir}, "1.mastodon.json"); !bytes.Equal(raw, before) {
+		t.Error("a file was rotated although the rotation failed")
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 2 {
+		t.Errorf("directory holds %v, %v, want just the two files", entries, err)
+	}
+}
@@ -1,12 +1,14 @@
 package secrets
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
-	"os"
 
 	"golang.org/x/crypto/scrypt"
 )
@@ -16,6 +18,79 @@ import (
 // EncryptedStore stores an encryption password used to derive keys for encryption and decryption.
 type EncryptedStore struct {
 	Password string
+	// Params are the scrypt parameters used to derive the key of the files it writes, the zero value means
+	// DefaultScryptParams. Files are always read with the parameters they were written with.
+	Params ScryptParams
+	// Backend is where the files are kept, nil means the filesystem, see FSBackend.
+	Backend Backend
+}
+
+// backend returns where the files of the store are kept.
+func (es *EncryptedStore) backend() Backend {
+	if es.Backend == nil {
+		return FSBackend{}
+	}
+	return es.Backend
+}
+
+// Sub returns a store with the same password and parameters as es keeping its files under dir, in the backend of es.
+func (es *EncryptedStore) Sub(dir string) *EncryptedStore {
+	sub := *es
+	sub.Backend = SubBackend{Backend: es.backend(), Dir: dir}
+	return &sub
+}
+
+// ScryptParams are the cost parameters of the scrypt key derivation, see scrypt.Key.
+type ScryptParams struct {
+	N int
+	R int
+	P int
+}
+
+// DefaultScryptParams are the parameters (N=32768, r=8, p=1) files were always written with before they were
+// configurable, they provide a stronger derivation than a simple hash.
+var DefaultScryptParams = ScryptParams{N: 32768, R: 8, P: 1}
+
+// Limits to the parameters we accept from a file, anything beyond would take too long or too much memory (128*N*r
+// bytes), which a tampered file could use against us.
+const (
+	maxScryptN = 1 << 21
+	maxScryptR = 32
+	maxScryptP = 16
+)
+
+// ErrInvalidScryptParams is returned for scrypt parameters out of the accepted limits.
+var ErrInvalidScryptParams = errors.New("invalid scrypt parameters")
+
+// Validate checks that the parameters are usable and within the limits we accept.
+func (sp ScryptParams) Validate() error {
+	if sp.N <= 1 || sp.N&(sp.N-1) != 0 || sp.N > maxScryptN {
+		return fmt.Errorf("N=%d must be a power of 2 up to %d: %w", sp.N, maxScryptN, ErrInvalidScryptParams)
+	}
+	if sp.R < 1 || sp.R > maxScryptR {
+		return fmt.Errorf("r=%d must be between 1 and %d: %w", sp.R, maxScryptR, ErrInvalidScryptParams)
+	}
+	if sp.P < 1 || sp.P > maxScryptP {
+		return fmt.Errorf("p=%d must be between 1 and %d: %w", sp.P, maxScryptP, ErrInvalidScryptParams)
+	}
+	return nil
+}
+
+// ParseScryptParams reads parameters written as N,r,p, i.e. 32768,8,1.
+func ParseScryptParams(s string) (ScryptParams, error) {
+	var sp ScryptParams
+	if _, err := fmt.Sscanf(s, "%d,%d,%d", &sp.N, &sp.R, &sp.P); err != nil {
+		return ScryptParams{}, fmt.Errorf("scrypt parameters %q are not N,r,p: %w", s, ErrInvalidScryptParams)
+	}
+	return sp, sp.Validate()
+}
+
+// writeParams returns the parameters for new files.
+func (es *EncryptedStore) writeParams() ScryptParams {
+	if es.Params == (ScryptParams{}) {
+		return DefaultScryptParams
+	}
+	return es.Params
 }
 
 const (
@@ -23,47 +98,131 @@ const (
 	ivSize   = aes.BlockSize // AES block size is 16 bytes.
 )
 
-// deriveKey derives a 32-byte key from the given password and salt using scrypt.
-// These parameters (N=32768, r=8, p=1) provide a stronger derivation than a simple hash.
-func deriveKey(password string, salt []byte) ([]byte, error) {
-	return scrypt.Key([]byte(password), salt, 32768, 8, 1, 32)
+// Files written by current versions start with fileMagic followed by a version byte, files without it are the legacy
+// unauthenticated AES-CTR format. A legacy file begins with its random salt, so there is a (negligible) chance of one
+// starting with the magic, which is why it is longer than the single version byte.
+const (
+	fileMagic = "C2WE"
+	// fileVersionGCM is the chunked AES-GCM format, see gcm.go, with the key derived with DefaultScryptParams.
+	fileVersionGCM byte = 1
+	// fileVersionGCMParams is the same format with the scrypt parameters, as three big endian uint32 N, r and p,
+	// between the version and the salt.
+	fileVersionGCMParams byte = 2
+	scryptParamsSize          = 12
+)
+
+// deriveKey derives a 32-byte key from the given password and salt using scrypt with params.
+func deriveKey(password string, salt []byte, params ScryptParams) ([]byte, error) {
+	return scrypt.Key([]byte(password), salt, params.N, params.R, params.P, 32)
 }
 
-// OpenReader opens an encrypted file for reading. The file is expected to have a header:
-// [salt (16 bytes)] [IV (16 bytes)] followed by the encrypted content.
-// It returns an io.ReadCloser that decrypts data on the fly.
+// newGCM derives the key for salt and returns the AES-GCM AEAD for it.
+func (es *EncryptedStore) newGCM(salt []byte, params ScryptParams) (cipher.AEAD, error) {
+	key, err := deriveKey(es.Password, salt, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return aead, nil
+}
+
+// OpenReader opens an encrypted file for reading. It returns an io.ReadCloser that decrypts data on the fly.
+// Authenticated files (see OpenWriter) return ErrAuthenticationFailed or ErrTruncated from Read if they were tampered
+// with, legacy files have a header: [salt (16 bytes)] [IV (16 bytes)] followed by the AES-CTR encrypted content and
+// can not be verified.
 func (es *EncryptedStore) OpenReader(path string) (io.ReadCloser, error) {
 	// Open the file for reading.
-	f, err := os.Open(path)
+	f, err := es.backend().OpenReaderAt(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file for reading: %w", err)
 	}
 
+	header := make([]byte, len(fileMagic)+1)
+	n, err := io.ReadFull(f, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		f.Close()
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	header = header[:n]
+	if n < len(header) || string(header[:len(fileMagic)]) != fileMagic {
+		// legacy file, what we read is the beginning of the salt.
+		return es.openCTRReader(io.MultiReader(bytes.NewReader(header), f), f)
+	}
+	params := DefaultScryptParams
+	switch version := header[len(fileMagic)]; version {
+	case fileVersionGCM:
+	case fileVersionGCMParams:
+		raw := make([]byte, scryptParamsSize)
+		if _, err := io.ReadFull(f, raw); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to read scrypt parameters: %w", err)
+		}
+		params = ScryptParams{
+			N: int(binary.BigEndian.Uint32(raw[0:4])),
+			R: int(binary.BigEndian.Uint32(raw[4:8])),
+			P: int(binary.BigEndian.Uint32(raw[8:12])),
+		}
+		if err := params.Validate(); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("file scrypt parameters: %w", err)
+		}
+	default:
+		f.Close()
+		return nil, fmt.Errorf("unknown encrypted file version %d", version)
+	}
+
 	// Read the salt.
 	salt := make([]byte, saltSize)
 	if _, err := io.ReadFull(f, salt); err != nil {
 		f.Close()
 		return nil, fmt.Errorf("failed to read salt: %w", err)
 	}
+	aead, err := es.newGCM(salt, params)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &gcmReader{
+		r:    f,
+		aead: aead,
+		salt: salt,
+	}, nil
+}
+
+// openCTRReader reads the legacy AES-CTR format from r, closing closer when done.
+func (es *EncryptedStore) openCTRReader(r io.Reader, closer io.Closer) (io.ReadCloser, error) {
+	// Read the salt.
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(r, salt); err != nil {
+		closer.Close()
+		return nil, fmt.Errorf("failed to read salt: %w", err)
+	}
 
 	// Read the IV.
 	iv := make([]byte, ivSize)
-	if _, err := io.ReadFull(f, iv); err != nil {
-		f.Close()
+	if _, err := io.ReadFull(r, iv); err != nil {
+		closer.Close()
 		return nil, fmt.Errorf("failed to read IV: %w", err)
 	}
 
 	// Derive the encryption key using scrypt.
-	key, err := deriveKey(es.Password, salt)
+	key, err := deriveKey(es.Password, salt, DefaultScryptParams)
 	if err != nil {
-		f.Close()
+		closer.Close()
 		return nil, fmt.Errorf("failed to derive key: %w", err)
 	}
 
 	// Create the AES cipher.
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		f.Close()
+		closer.Close()
 		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
 	}
 
@@ -71,7 +230,7 @@ func (es *EncryptedStore) OpenReader(path string) (io.ReadCloser, error) {
 	stream := cipher.NewCTR(block, iv)
 	streamReader := &cipher.StreamReader{
 		S: stream,
-		R: f,
+		R: r,
 	}
 
 	// Return a ReadCloser that uses the stream reader and the underlying file.
@@ -80,71 +239,68 @@ func (es *EncryptedStore) OpenReader(path string) (io.ReadCloser, error) {
 		io.Closer
 	}{
 		Reader: streamReader,
-		Closer: f,
+		Closer: closer,
 	}, nil
 }
 
 // OpenWriter opens (or creates) a file for writing encrypted data.
-// It writes a header containing a randomly generated salt and IV, then returns an io.WriteCloser
-// that encrypts data on the fly. If the file does not exist, it is created.
+// It writes a header containing the format magic and version, the scrypt parameters and a randomly generated salt,
+// then returns an io.WriteCloser that encrypts and authenticates data in chunks, the last chunk is written on Close so
+// the file is not complete until then. If the file does not exist, it is created.
 func (es *EncryptedStore) OpenWriter(path string) (io.WriteCloser, error) {
-	// Open (or create) the file with write permissions.
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open file for writing: %w", err)
-	}
-
 	// Generate a random salt.
 	salt := make([]byte, saltSize)
 	if _, err := rand.Read(salt); err != nil {
-		f.Close()
 		return nil, fmt.Errorf("failed to generate salt: %w", err)
 	}
-
-	// Generate a random IV.
-	iv := make([]byte, ivSize)
-	if _, err := rand.Read(iv); err != nil {
-		f.Close()
-		return nil, fmt.Errorf("failed to generate IV: %w", err)
+	params := es.writeParams()
+	if err := params.Validate(); err != nil {
+		return nil, err
 	}
-
-	// Write the salt and IV to the file.
-	if _, err := f.Write(salt); err != nil {
-		f.Close()
-		return nil, fmt.Errorf("failed to write salt: %w", err)
-	}
-	if _, err := f.Write(iv); err != nil {
-		f.Close()
-		return nil, fmt.Errorf("failed to write IV: %w", err)
+	// the key is derived before opening the file, so nothing is written if that fails.
+	aead, err := es.newGCM(salt, params)
+	if err != nil {
+		return nil, err
 	}
 
-	// Derive the encryption key using scrypt.
-	key, err := deriveKey(es.Password, salt)
+	// Open (or create) the file with write permissions.
+	f, err := es.backend().OpenWriterAt(path)
 	if err != nil {
-		f.Close()
-		return nil, fmt.Errorf("failed to derive key: %w", err)
+		return nil, fmt.Errorf("failed to open file for writing: %w", err)
 	}
 
-	// Create the AES cipher.
-	block, err := aes.NewCipher(key)
-	if err != nil {
+	// Write the header, scrypt parameters and salt to the file.
+	header := append([]byte(fileMagic), fileVersionGCMParams)
+	header = binary.BigEndian.AppendUint32(header, uint32(params.N))
+	header = binary.BigEndian.AppendUint32(header, uint32(params.R))
+	header = binary.BigEndian.AppendUint32(header, uint32(params.P))
+	if _, err := f.Write(append(header, salt...)); err != nil {
 		f.Close()
-		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+		return nil, fmt.Errorf("failed to write header: %w", err)
 	}
 
-	// Create a stream cipher (CTR mode) for encryption.
-	stream := cipher.NewCTR(block, iv)
-	streamWriter := &cipher.StreamWriter{
-		S: stream,
-		W: f,
+	return &gcmWriter{
+		w:    f,
+		aead: aead,
+		salt: salt,
+		buf:  make([]byte, 0, gcmChunkSize),
+	}, nil
+}
+
+// OpenUnencrypted opens the file at path as it is stored, without decrypting it, i.e. to migrate files written in the
+// clear by older versions.
+func (es *EncryptedStore) OpenUnencrypted(path string) (io.ReadCloser, error) {
+	f, err := es.backend().OpenReaderAt(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file for reading: %w", err)
 	}
+	return f, nil
+}
 
-	// Return a WriteCloser that encrypts data and closes the underlying file.
-	return struct {
-		io.Writer
-		io.Closer
-	}{
-		Writer: streamWriter,
-		Closer: f,
-	}, nil
+// Remove deletes the file at path, removing a file that does not exist is not an error.
+func (es *EncryptedStore) Remove(path string) error {
+	if err := es.backend().Remove(path); err != nil {
+		return fmt.Errorf("failed to remove file: %w", err)
+	}
+	return nil
 }
@@ -0,0 +1,146 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// testParams keep key derivation fast in tests.
+var testParams = ScryptParams{N: 1024, R: 8, P: 1}
+
+// newTestStore returns a store keeping its files in memory.
+func newTestStore(password string) *EncryptedStore {
+	return &EncryptedStore{Password: password, Params: testParams, Backend: &MemoryBackend{}}
+}
+
+func writeFile(t *testing.T, store *EncryptedStore, path string, data []byte) {
+	t.Helper()
+	w, err := store.OpenWriter(path)
+	if err != nil {
+		t.Fatalf("OpenWriter(%q): %v", path, err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("writing %q: %v", path, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("closing %q: %v", path, err)
+	}
+}
+
+func readFile(store *EncryptedStore, path string) ([]byte, error) {
+	r, err := store.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// rawFile returns the file at path as stored, encrypted.
+func rawFile(t *testing.T, backend Backend, path string) []byte {
+	t.Helper()
+	r, err := backend.OpenReaderAt(path)
+	if err != nil {
+		t.Fatalf("opening %q: %v", path, err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading %q: %v", path, err)
+	}
+	return data
+}
+
+// putRawFile replaces the file at path with data, as stored.
+func putRawFile(t *testing.T, backend Backend, path string, data []byte) {
+	t.Helper()
+	w, err := backend.OpenWriterAt(path)
+	if err != nil {
+		t.Fatalf("opening %q: %v", path, err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("writing %q: %v", path, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("closing %q: %v", path, err)
+	}
+}
+
+func TestEncryptedStoreRoundTrip(t *testing.T) {
+	store := newTestStore("hunter2")
+	// more than a chunk, so several are sealed.
+	data := bytes.Repeat([]byte("secret config "), gcmChunkSize/7)
+	writeFile(t, store, "config.json", data)
+
+	if raw := rawFile(t, store.Backend, "config.json"); bytes.Contains(raw, []byte("secret config")) {
+		t.Error("the stored file holds the plaintext")
+	}
+	got, err := readFile(store, "config.json")
+	if err != nil {
+		t.Fatalf("reading back: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("read %d bytes, want the %d written", len(got), len(data))
+	}
+}
+
+func TestEncryptedStoreDetectsTampering(t *testing.T) {
+	store := newTestStore("hunter2")
+	writeFile(t, store, "config.json", []byte(`{"access_token":"abc"}`))
+	raw := rawFile(t, store.Backend, "config.json")
+
+	// flip a byte of the ciphertext, past the header and salt.
+	tampered := bytes.Clone(raw)
+	tampered[len(tampered)-5] ^= 0x01
+	putRawFile(t, store.Backend, "config.json", tampered)
+	if _, err := readFile(store, "config.json"); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("reading a tampered file: err = %v, want ErrAuthenticationFailed", err)
+	}
+
+	// dropping the end of the file drops the final chunk.
+	putRawFile(t, store.Backend, "config.json", raw[:len(raw)-20])
+	if _, err := readFile(store, "config.json"); !errors.Is(err, ErrTruncated) && !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("reading a truncated file: err = %v, want ErrTruncated or ErrAuthenticationFailed", err)
+	}
+}
+
+func TestEncryptedStoreWrongPassword(t *testing.T) {
+	store := newTestStore("hunter2")
+	writeFile(t, store, "config.json", []byte("data"))
+	other := &EncryptedStore{Password: "wrong", Backend: store.Backend}
+	if _, err := readFile(other, "config.json"); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("reading with the wrong password: err = %v, want ErrAuthenticationFailed", err)
+	}
+}
+
+func TestFilesAreReadWithTheParamsTheyWereWrittenWith(t *testing.T) {
+	store := newTestStore("hunter2")
+	writeFile(t, store, "config.json", []byte("data"))
+
+	// changing the parameters only affects the files written from then on.
+	store.Params = ScryptParams{N: 2048, R: 4, P: 2}
+	got, err := readFile(store, "config.json")
+	if err != nil || string(got) != "data" {
+		t.Fatalf("reading after changing the parameters = %q, %v, want \"data\"", got, err)
+	}
+	writeFile(t, store, "new.json", []byte("new data"))
+	store.Params = ScryptParams{}
+	for name, want := range map[string]string{"config.json": "data", "new.json": "new data"} {
+		if got, err := readFile(store, name); err != nil || string(got) != want {
+			t.Errorf("reading %s with the default parameters = %q, %v, want %q", name, got, err, want)
+		}
+	}
+}
+
+func TestParseScryptParams(t *testing.T) {
+	if params, err := ParseScryptParams("16384,8,2"); err != nil || params != (ScryptParams{N: 16384, R: 8, P: 2}) {
+		t.Errorf("ParseScryptParams(\"16384,8,2\") = %+v, %v, want N=16384 r=8 p=2", params, err)
+	}
+	for _, s := range []string{"", "16384", "1000,8,1", "1,8,1", "16384,0,1", "16384,8,0", "4194304,8,1", "16384,64,1"} {
+		if _, err := ParseScryptParams(s); !errors.Is(err, ErrInvalidScryptParams) {
+			t.Errorf("ParseScryptParams(%q) err = %v, want ErrInvalidScryptParams", s, err)
+		}
+	}
+}